@@ -26,6 +26,7 @@ import (
 	"github.com/nymtech/nym-mixnet/client"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/networker"
 )
 
 const (
@@ -136,7 +137,7 @@ func (bc *BenchClient) pregeneratePacket(message string, recipient config.Client
 		return err
 	}
 
-	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
+	packetBytes, err := networker.EncodeFrame(flags.CommFlag, sphinxPacket)
 	if err != nil {
 		return err
 	}
@@ -21,7 +21,6 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"net"
 	"os"
@@ -58,9 +57,12 @@ type Client interface {
 	ReadInNetworkFromTopology(pkiName string) error
 }
 
+// ReceivedMessages holds the messages received by the client together with the SURBs attached to them,
+// with which the client can reply to their senders.
 type ReceivedMessages struct {
 	sync.Mutex
 	messages [][]byte
+	surbs    [][]byte
 }
 
 // NetClient is a queuing TCP network client for the mixnet.
@@ -77,18 +79,23 @@ type NetClient struct {
 	receivedMessages ReceivedMessages
 }
 
-func (c *NetClient) GetReceivedMessages() [][]byte {
+// GetReceivedMessages returns the messages received since the last call together with the marshaled SURBs
+// attached to them. The SURB at the index of the message is empty if the sender did not attach any.
+func (c *NetClient) GetReceivedMessages() ([][]byte, [][]byte) {
 	c.receivedMessages.Lock()
 	defer c.receivedMessages.Unlock()
 	msgsPtr := c.receivedMessages.messages
+	surbsPtr := c.receivedMessages.surbs
 	c.receivedMessages.messages = make([][]byte, 0, 20)
-	return msgsPtr
+	c.receivedMessages.surbs = make([][]byte, 0, 20)
+	return msgsPtr, surbsPtr
 }
 
-func (c *NetClient) addNewMessage(msg []byte) {
+func (c *NetClient) addNewMessage(msg []byte, surb []byte) {
 	c.receivedMessages.Lock()
 	defer c.receivedMessages.Unlock()
 	c.receivedMessages.messages = append(c.receivedMessages.messages, msg)
+	c.receivedMessages.surbs = append(c.receivedMessages.surbs, surb)
 }
 
 // OutQueue returns a reference to the client's outQueue. It's a queue
@@ -101,7 +108,7 @@ func getProvider(presences []models.MixProviderPresence, pubKey string) (models.
 	var pres models.MixProviderPresence
 
 	for _, presence := range presences {
-		if presence.PubKey == pubKey {
+		if topology.Identity(presence.PubKey) == pubKey {
 			return presence, nil
 		}
 	}
@@ -241,6 +248,44 @@ func (c *NetClient) SendMessage(message []byte, recipient config.ClientConfig) e
 	return nil
 }
 
+// SendMessageWithSURB sends a real message with a SURB attached, which allows the recipient
+// to reply without learning the identity of the sender.
+func (c *NetClient) SendMessageWithSURB(message []byte, recipient config.ClientConfig) error {
+	if err := c.checkTopology(); err != nil {
+		c.log.Errorf("error in updating topology: %v", err)
+		return err
+	}
+	sphinxPacket, err := c.EncodeMessageWithSURB(message, recipient, c.config)
+	if err != nil {
+		c.log.Errorf("Error in sending message - create sphinx packet returned an error: %v", err)
+		return err
+	}
+	packetBytes, err := networker.EncodeFrame(flags.CommFlag, sphinxPacket)
+	if err != nil {
+		c.log.Errorf("Error in sending message - encode frame returned an error: %v", err)
+		return err
+	}
+	c.outQueue <- packetBytes
+	return nil
+}
+
+// SendReply sends the reply message using the SURB attached to a received message.
+// The reply is sent to the client's provider, like any other message, which relays it to the first hop of the SURB.
+func (c *NetClient) SendReply(message []byte, surb sphinx.SURB) error {
+	firstHop, sphinxPacket, err := c.EncodeReply(message, surb)
+	if err != nil {
+		c.log.Errorf("Error in sending reply - create sphinx packet returned an error: %v", err)
+		return err
+	}
+	packetBytes, err := networker.EncodeReplyFrame(firstHop, sphinxPacket)
+	if err != nil {
+		c.log.Errorf("Error in sending reply - encode frame returned an error: %v", err)
+		return err
+	}
+	c.outQueue <- packetBytes
+	return nil
+}
+
 // encodeMessage encapsulates the given message into a sphinx packet destinated for recipient
 // and wraps with the flag pointing that it is the communication packet
 func (c *NetClient) encodeMessage(message []byte, recipient config.ClientConfig) ([]byte, error) {
@@ -250,46 +295,51 @@ func (c *NetClient) encodeMessage(message []byte, recipient config.ClientConfig)
 		return nil, err
 	}
 
-	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
+	packetBytes, err := networker.EncodeFrame(flags.CommFlag, sphinxPacket)
 	if err != nil {
-		c.log.Errorf("Error in sending message - wrap with flag returned an error: %v", err)
+		c.log.Errorf("Error in sending message - encode frame returned an error: %v", err)
 		return nil, err
 	}
 	return packetBytes, nil
 }
 
-// Send opens a connection with selected network address
+// Send opens a connection with selected network address, authenticating the server with the given key,
 // and send the passed packet. If connection failed or
 // the packet could not be send, an error is returned
 // Otherwise it returns the response sent by server
-func (c *NetClient) send(packet []byte, host string, port string) (config.ProviderResponse, error) {
+func (c *NetClient) send(packet []byte, host string, port string, pubKey []byte) ([]networker.Frame, error) {
 
-	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	rawConn, err := net.Dial("tcp", net.JoinHostPort(host, port))
 
 	if err != nil {
 		c.log.Errorf("Error in send - dial returned an error: %v", err)
-		return config.ProviderResponse{}, err
+		return nil, err
+	}
+	defer rawConn.Close()
+
+	// the client remains anonymous in the handshake
+	conn, err := networker.ClientHandshake(rawConn, nil, pubKey)
+	if err != nil {
+		c.log.Errorf("Error in send - handshake returned an error: %v", err)
+		return nil, err
 	}
-	defer conn.Close()
 
 	if _, err := conn.Write(packet); err != nil {
 		c.log.Errorf("Failed to write to connection: %v", err)
-		return config.ProviderResponse{}, err
+		return nil, err
+	}
+	// the server reads the frames until the end of the stream, so it has to be told that no more are coming
+	if err := conn.CloseWrite(); err != nil {
+		c.log.Errorf("Failed to close the writing side of the connection: %v", err)
+		return nil, err
 	}
 
-	buff, err := ioutil.ReadAll(conn)
+	packets, err := networker.ReadProviderResponse(conn)
 	if err != nil {
 		c.log.Errorf("Failed to read response: %v", err)
-		return config.ProviderResponse{}, err
-	}
-
-	var resPacket config.ProviderResponse
-	if err = proto.Unmarshal(buff, &resPacket); err != nil {
-		c.log.Errorf("Error while unmarshalling received packet: %v", err)
-		return config.ProviderResponse{}, err
+		return nil, err
 	}
-
-	return resPacket, nil
+	return packets, nil
 }
 
 // RegisterToken stores the authentication token received from the provider
@@ -300,15 +350,24 @@ func (c *NetClient) registerToken(token []byte) {
 
 // ProcessPacket processes the received sphinx packet and returns the
 // encapsulated message or error in case the processing
-// was unsuccessful.
+// was unsuccessful. Replies to the SURBs created by the client are decrypted
+// using the corresponding keys.
 func (c *NetClient) processPacket(packet []byte) ([]byte, error) {
 
-	// c.log.Debugf(" Processing packet")
-	// c.log.Tracef("Removing first 37 bytes of the message")
-	if len(packet) > 38 {
-		return packet[38:], nil
+	if message, err := c.DecodeReply(packet); err != clientcore.ErrUnknownReply {
+		if err != nil {
+			c.log.Errorf("Error in processPacket - decoding the reply failed: %v", err)
+			return nil, err
+		}
+		return message, nil
 	}
-	return packet, nil
+
+	message, err := sphinx.ExtractMessage(packet)
+	if err != nil {
+		c.log.Errorf("Error in processPacket - extracting the message failed: %v", err)
+		return nil, err
+	}
+	return message, nil
 }
 
 func (c *NetClient) startTraffic() {
@@ -342,24 +401,23 @@ func (c *NetClient) sendRegisterMessageToProvider() error {
 		return err
 	}
 
-	pktBytes, err := config.WrapWithFlag(flags.AssignFlag, confBytes)
+	pktBytes, err := networker.EncodeFrame(flags.AssignFlag, confBytes)
 	if err != nil {
-		c.log.Errorf("Error in register provider - wrap with flag returned an error: %v", err)
+		c.log.Errorf("Error in register provider - encode frame returned an error: %v", err)
 		return err
 	}
 
-	response, err := c.send(pktBytes, c.Provider.Host, c.Provider.Port)
+	packets, err := c.send(pktBytes, c.Provider.Host, c.Provider.Port, c.Provider.PubKey)
 	if err != nil {
 		c.log.Errorf("Error in register provider - send registration packet returned an error: %v", err)
 		return err
 	}
-
-	packets, err := config.UnmarshalProviderResponse(response)
-	if err != nil || len(packets) != 1 {
-		c.log.Errorf("error in register provider - failed to unmarshal response: %v", err)
+	if len(packets) != 1 {
+		c.log.Errorf("Error in register provider - expected a single packet in the response, got %d", len(packets))
+		return errors.New("invalid response of the provider to the registration")
 	}
 
-	c.registerToken(packets[0].Data)
+	c.registerToken(packets[0].Body)
 
 	return nil
 }
@@ -375,34 +433,40 @@ func (c *NetClient) getMessagesFromProvider() error {
 		return err
 	}
 
-	pktBytes, err := config.WrapWithFlag(flags.PullFlag, pullRqsBytes)
+	pktBytes, err := networker.EncodeFrame(flags.PullFlag, pullRqsBytes)
 	if err != nil {
 		c.log.Errorf("Error in register provider - marshal of provider config returned an error: %v", err)
 		return err
 	}
 
-	response, err := c.send(pktBytes, c.Provider.Host, c.Provider.Port)
+	packets, err := c.send(pktBytes, c.Provider.Host, c.Provider.Port, c.Provider.PubKey)
 	if err != nil {
 		return err
 	}
-
-	packets, err := config.UnmarshalProviderResponse(response)
-	if err != nil {
-		c.log.Errorf("error in register provider - failed to unmarshal response: %v", err)
-	}
 	for _, packet := range packets {
-		packetData, err := c.processPacket(packet.Data)
+		packetData, err := c.processPacket(packet.Body)
 		if err != nil {
 			c.log.Errorf("Error in processing received packet: %v", err)
 		}
 		packetDataStr := string(packetData)
-		switch packetDataStr {
-		case loopLoad:
+		if packetDataStr == loopLoad {
 			c.log.Debugf("Received loop cover message %v", packetDataStr)
-		default:
-			c.log.Infof("Received new message: %v", packetDataStr)
-			c.addNewMessage(packetData)
+			continue
+		}
+		message, surb, err := clientcore.ParseMessage(packetData)
+		if err != nil {
+			c.log.Errorf("Error in parsing received message: %v", err)
+			continue
+		}
+		var surbBytes []byte
+		if surb != nil {
+			if surbBytes, err = surb.MarshalBinary(); err != nil {
+				c.log.Errorf("Error in parsing received message - marshal of SURB failed: %v", err)
+				continue
+			}
 		}
+		c.log.Infof("Received new message: %v", string(message))
+		c.addNewMessage(message, surbBytes)
 	}
 
 	return nil
@@ -419,7 +483,7 @@ func (c *NetClient) controlOutQueue() error {
 			c.log.Infof("Halting controlOutQueue")
 			return nil
 		case realPacket := <-c.outQueue:
-			response, err := c.send(realPacket, c.Provider.Host, c.Provider.Port)
+			response, err := c.send(realPacket, c.Provider.Host, c.Provider.Port, c.Provider.PubKey)
 			if err != nil {
 				c.log.Errorf("Could not send real packet: %v", err)
 			}
@@ -431,7 +495,7 @@ func (c *NetClient) controlOutQueue() error {
 				if err != nil {
 					return err
 				}
-				response, err := c.send(dummyPacket, c.Provider.Host, c.Provider.Port)
+				response, err := c.send(dummyPacket, c.Provider.Host, c.Provider.Port, c.Provider.PubKey)
 				if err != nil {
 					c.log.Errorf("Could not send dummy packet: %v", err)
 				}
@@ -476,7 +540,7 @@ func (c *NetClient) createLoopCoverMessage() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
+	packetBytes, err := networker.EncodeFrame(flags.CommFlag, sphinxPacket)
 	if err != nil {
 		return nil, err
 	}
@@ -498,7 +562,7 @@ func (c *NetClient) runLoopCoverTrafficStream() error {
 			if err != nil {
 				return err
 			}
-			response, err := c.send(loopPacket, c.Provider.Host, c.Provider.Port)
+			response, err := c.send(loopPacket, c.Provider.Host, c.Provider.Port, c.Provider.PubKey)
 			if err != nil {
 				c.log.Errorf("Could not send loop cover traffic message: %v", err)
 				return err
@@ -551,6 +615,8 @@ func (c *NetClient) ReadInNetworkFromTopology(topologyData *models.Topology) err
 	}
 
 	c.Network.UpdateNetwork(mixes, clients)
+	// the nodes rotating their keys announce the keys of the key epochs, which the packets are created for
+	c.Network.EpochKeys = topology.GetEpochKeys(topologyData)
 
 	return nil
 }
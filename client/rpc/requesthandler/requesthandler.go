@@ -17,6 +17,7 @@ package requesthandler
 import (
 	"github.com/nymtech/nym-mixnet/client"
 	"github.com/nymtech/nym-mixnet/client/rpc/types"
+	"github.com/nymtech/nym-mixnet/sphinx"
 )
 
 func returnSendError() *types.Response {
@@ -32,7 +33,11 @@ func HandleSendMessage(req *types.Request_Send, c *client.NetClient) *types.Resp
 	if req == nil || sreq == nil || sreq.Message == nil || sreq.Recipient == nil {
 		return returnSendError()
 	}
-	if err := c.SendMessage(sreq.Message, *sreq.Recipient); err != nil {
+	send := c.SendMessage
+	if sreq.WithSurb {
+		send = c.SendMessageWithSURB
+	}
+	if err := send(sreq.Message, *sreq.Recipient); err != nil {
 		return &types.Response{
 			Value: &types.Response_Exception{
 				Exception: &types.ResponseException{
@@ -44,12 +49,44 @@ func HandleSendMessage(req *types.Request_Send, c *client.NetClient) *types.Resp
 	return returnSendError()
 }
 
+func HandleSendReply(req *types.Request_Reply, c *client.NetClient) *types.Response {
+	rreq := req.Reply
+	if rreq == nil || rreq.Message == nil || rreq.Surb == nil {
+		return HandleInvalidRequest()
+	}
+	var surb sphinx.SURB
+	if err := surb.UnmarshalBinary(rreq.Surb); err != nil {
+		return &types.Response{
+			Value: &types.Response_Exception{
+				Exception: &types.ResponseException{
+					Error: err.Error(),
+				},
+			},
+		}
+	}
+	if err := c.SendReply(rreq.Message, surb); err != nil {
+		return &types.Response{
+			Value: &types.Response_Exception{
+				Exception: &types.ResponseException{
+					Error: err.Error(),
+				},
+			},
+		}
+	}
+	return &types.Response{
+		Value: &types.Response_Reply{
+			Reply: &types.ResponseSendReply{},
+		},
+	}
+}
+
 func HandleFetchMessages(req *types.Request_Fetch, c *client.NetClient) *types.Response {
-	msgs := c.GetReceivedMessages()
+	msgs, surbs := c.GetReceivedMessages()
 	return &types.Response{
 		Value: &types.Response_Fetch{
 			Fetch: &types.ResponseFetchMessages{
 				Messages: msgs,
+				Surbs:    surbs,
 			},
 		},
 	}
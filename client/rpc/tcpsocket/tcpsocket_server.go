@@ -195,6 +195,9 @@ func (s *SocketServer) handleRequest(req *types.Request, responses chan<- *types
 	case *types.Request_Details:
 		s.log.Info("Details request")
 		responses <- requesthandler.HandleOwnDetails(r, s.client)
+	case *types.Request_Reply:
+		s.log.Info("Reply request")
+		responses <- requesthandler.HandleSendReply(r, s.client)
 	case *types.Request_Flush:
 		responses <- requesthandler.HandleFlush(r)
 	default:
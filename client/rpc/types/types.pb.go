@@ -28,6 +28,7 @@ type Request struct {
 	//	*Request_Clients
 	//	*Request_Details
 	//	*Request_Flush
+	//	*Request_Reply
 	Value                isRequest_Value `protobuf_oneof:"value"`
 	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
 	XXX_unrecognized     []byte          `json:"-"`
@@ -83,6 +84,10 @@ type Request_Flush struct {
 	Flush *RequestFlush `protobuf:"bytes,6,opt,name=flush,proto3,oneof"`
 }
 
+type Request_Reply struct {
+	Reply *RequestSendReply `protobuf:"bytes,7,opt,name=reply,proto3,oneof"`
+}
+
 func (*Request_Send) isRequest_Value() {}
 
 func (*Request_Fetch) isRequest_Value() {}
@@ -93,6 +98,8 @@ func (*Request_Details) isRequest_Value() {}
 
 func (*Request_Flush) isRequest_Value() {}
 
+func (*Request_Reply) isRequest_Value() {}
+
 func (m *Request) GetValue() isRequest_Value {
 	if m != nil {
 		return m.Value
@@ -135,6 +142,13 @@ func (m *Request) GetFlush() *RequestFlush {
 	return nil
 }
 
+func (m *Request) GetReply() *RequestSendReply {
+	if x, ok := m.GetValue().(*Request_Reply); ok {
+		return x.Reply
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*Request) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
@@ -143,12 +157,14 @@ func (*Request) XXX_OneofWrappers() []interface{} {
 		(*Request_Clients)(nil),
 		(*Request_Details)(nil),
 		(*Request_Flush)(nil),
+		(*Request_Reply)(nil),
 	}
 }
 
 type RequestSendMessage struct {
 	Message              []byte               `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
 	Recipient            *config.ClientConfig `protobuf:"bytes,2,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	WithSurb             bool                 `protobuf:"varint,3,opt,name=with_surb,json=withSurb,proto3" json:"with_surb,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
 	XXX_unrecognized     []byte               `json:"-"`
 	XXX_sizecache        int32                `json:"-"`
@@ -193,6 +209,13 @@ func (m *RequestSendMessage) GetRecipient() *config.ClientConfig {
 	return nil
 }
 
+func (m *RequestSendMessage) GetWithSurb() bool {
+	if m != nil {
+		return m.WithSurb
+	}
+	return false
+}
+
 type RequestFetchMessages struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -317,6 +340,53 @@ func (m *RequestFlush) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_RequestFlush proto.InternalMessageInfo
 
+type RequestSendReply struct {
+	Message              []byte   `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Surb                 []byte   `protobuf:"bytes,2,opt,name=surb,proto3" json:"surb,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RequestSendReply) Reset()         { *m = RequestSendReply{} }
+func (m *RequestSendReply) String() string { return proto.CompactTextString(m) }
+func (*RequestSendReply) ProtoMessage()    {}
+func (*RequestSendReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3ce088dbf8865287, []int{6}
+}
+
+func (m *RequestSendReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RequestSendReply.Unmarshal(m, b)
+}
+func (m *RequestSendReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RequestSendReply.Marshal(b, m, deterministic)
+}
+func (m *RequestSendReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RequestSendReply.Merge(m, src)
+}
+func (m *RequestSendReply) XXX_Size() int {
+	return xxx_messageInfo_RequestSendReply.Size(m)
+}
+func (m *RequestSendReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_RequestSendReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RequestSendReply proto.InternalMessageInfo
+
+func (m *RequestSendReply) GetMessage() []byte {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+func (m *RequestSendReply) GetSurb() []byte {
+	if m != nil {
+		return m.Surb
+	}
+	return nil
+}
+
 type Response struct {
 	// Types that are valid to be assigned to Value:
 	//	*Response_Exception
@@ -325,6 +395,7 @@ type Response struct {
 	//	*Response_Clients
 	//	*Response_Details
 	//	*Response_Flush
+	//	*Response_Reply
 	Value                isResponse_Value `protobuf_oneof:"value"`
 	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
 	XXX_unrecognized     []byte           `json:"-"`
@@ -335,7 +406,7 @@ func (m *Response) Reset()         { *m = Response{} }
 func (m *Response) String() string { return proto.CompactTextString(m) }
 func (*Response) ProtoMessage()    {}
 func (*Response) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3ce088dbf8865287, []int{6}
+	return fileDescriptor_3ce088dbf8865287, []int{7}
 }
 
 func (m *Response) XXX_Unmarshal(b []byte) error {
@@ -384,6 +455,10 @@ type Response_Flush struct {
 	Flush *ResponseFlush `protobuf:"bytes,6,opt,name=flush,proto3,oneof"`
 }
 
+type Response_Reply struct {
+	Reply *ResponseSendReply `protobuf:"bytes,7,opt,name=reply,proto3,oneof"`
+}
+
 func (*Response_Exception) isResponse_Value() {}
 
 func (*Response_Send) isResponse_Value() {}
@@ -396,6 +471,8 @@ func (*Response_Details) isResponse_Value() {}
 
 func (*Response_Flush) isResponse_Value() {}
 
+func (*Response_Reply) isResponse_Value() {}
+
 func (m *Response) GetValue() isResponse_Value {
 	if m != nil {
 		return m.Value
@@ -445,6 +522,13 @@ func (m *Response) GetFlush() *ResponseFlush {
 	return nil
 }
 
+func (m *Response) GetReply() *ResponseSendReply {
+	if x, ok := m.GetValue().(*Response_Reply); ok {
+		return x.Reply
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*Response) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
@@ -454,6 +538,7 @@ func (*Response) XXX_OneofWrappers() []interface{} {
 		(*Response_Clients)(nil),
 		(*Response_Details)(nil),
 		(*Response_Flush)(nil),
+		(*Response_Reply)(nil),
 	}
 }
 
@@ -468,7 +553,7 @@ func (m *ResponseException) Reset()         { *m = ResponseException{} }
 func (m *ResponseException) String() string { return proto.CompactTextString(m) }
 func (*ResponseException) ProtoMessage()    {}
 func (*ResponseException) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3ce088dbf8865287, []int{7}
+	return fileDescriptor_3ce088dbf8865287, []int{8}
 }
 
 func (m *ResponseException) XXX_Unmarshal(b []byte) error {
@@ -506,7 +591,7 @@ func (m *ResponseSendMessage) Reset()         { *m = ResponseSendMessage{} }
 func (m *ResponseSendMessage) String() string { return proto.CompactTextString(m) }
 func (*ResponseSendMessage) ProtoMessage()    {}
 func (*ResponseSendMessage) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3ce088dbf8865287, []int{8}
+	return fileDescriptor_3ce088dbf8865287, []int{9}
 }
 
 func (m *ResponseSendMessage) XXX_Unmarshal(b []byte) error {
@@ -538,7 +623,7 @@ func (m *ResponseGetClients) Reset()         { *m = ResponseGetClients{} }
 func (m *ResponseGetClients) String() string { return proto.CompactTextString(m) }
 func (*ResponseGetClients) ProtoMessage()    {}
 func (*ResponseGetClients) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3ce088dbf8865287, []int{9}
+	return fileDescriptor_3ce088dbf8865287, []int{10}
 }
 
 func (m *ResponseGetClients) XXX_Unmarshal(b []byte) error {
@@ -577,7 +662,7 @@ func (m *ResponseOwnDetails) Reset()         { *m = ResponseOwnDetails{} }
 func (m *ResponseOwnDetails) String() string { return proto.CompactTextString(m) }
 func (*ResponseOwnDetails) ProtoMessage()    {}
 func (*ResponseOwnDetails) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3ce088dbf8865287, []int{10}
+	return fileDescriptor_3ce088dbf8865287, []int{11}
 }
 
 func (m *ResponseOwnDetails) XXX_Unmarshal(b []byte) error {
@@ -615,7 +700,7 @@ func (m *ResponseFlush) Reset()         { *m = ResponseFlush{} }
 func (m *ResponseFlush) String() string { return proto.CompactTextString(m) }
 func (*ResponseFlush) ProtoMessage()    {}
 func (*ResponseFlush) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3ce088dbf8865287, []int{11}
+	return fileDescriptor_3ce088dbf8865287, []int{12}
 }
 
 func (m *ResponseFlush) XXX_Unmarshal(b []byte) error {
@@ -636,8 +721,40 @@ func (m *ResponseFlush) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_ResponseFlush proto.InternalMessageInfo
 
+type ResponseSendReply struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ResponseSendReply) Reset()         { *m = ResponseSendReply{} }
+func (m *ResponseSendReply) String() string { return proto.CompactTextString(m) }
+func (*ResponseSendReply) ProtoMessage()    {}
+func (*ResponseSendReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3ce088dbf8865287, []int{13}
+}
+
+func (m *ResponseSendReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ResponseSendReply.Unmarshal(m, b)
+}
+func (m *ResponseSendReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ResponseSendReply.Marshal(b, m, deterministic)
+}
+func (m *ResponseSendReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResponseSendReply.Merge(m, src)
+}
+func (m *ResponseSendReply) XXX_Size() int {
+	return xxx_messageInfo_ResponseSendReply.Size(m)
+}
+func (m *ResponseSendReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResponseSendReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResponseSendReply proto.InternalMessageInfo
+
 type ResponseFetchMessages struct {
 	Messages             [][]byte `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	Surbs                [][]byte `protobuf:"bytes,2,rep,name=surbs,proto3" json:"surbs,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -647,7 +764,7 @@ func (m *ResponseFetchMessages) Reset()         { *m = ResponseFetchMessages{} }
 func (m *ResponseFetchMessages) String() string { return proto.CompactTextString(m) }
 func (*ResponseFetchMessages) ProtoMessage()    {}
 func (*ResponseFetchMessages) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3ce088dbf8865287, []int{12}
+	return fileDescriptor_3ce088dbf8865287, []int{14}
 }
 
 func (m *ResponseFetchMessages) XXX_Unmarshal(b []byte) error {
@@ -675,6 +792,13 @@ func (m *ResponseFetchMessages) GetMessages() [][]byte {
 	return nil
 }
 
+func (m *ResponseFetchMessages) GetSurbs() [][]byte {
+	if m != nil {
+		return m.Surbs
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Request)(nil), "types.Request")
 	proto.RegisterType((*RequestSendMessage)(nil), "types.RequestSendMessage")
@@ -682,46 +806,52 @@ func init() {
 	proto.RegisterType((*RequestGetClients)(nil), "types.RequestGetClients")
 	proto.RegisterType((*RequestOwnDetails)(nil), "types.RequestOwnDetails")
 	proto.RegisterType((*RequestFlush)(nil), "types.RequestFlush")
+	proto.RegisterType((*RequestSendReply)(nil), "types.RequestSendReply")
 	proto.RegisterType((*Response)(nil), "types.Response")
 	proto.RegisterType((*ResponseException)(nil), "types.ResponseException")
 	proto.RegisterType((*ResponseSendMessage)(nil), "types.ResponseSendMessage")
 	proto.RegisterType((*ResponseGetClients)(nil), "types.ResponseGetClients")
 	proto.RegisterType((*ResponseOwnDetails)(nil), "types.ResponseOwnDetails")
 	proto.RegisterType((*ResponseFlush)(nil), "types.ResponseFlush")
+	proto.RegisterType((*ResponseSendReply)(nil), "types.ResponseSendReply")
 	proto.RegisterType((*ResponseFetchMessages)(nil), "types.ResponseFetchMessages")
 }
 
 func init() { proto.RegisterFile("client/rpc/types/types.proto", fileDescriptor_3ce088dbf8865287) }
 
 var fileDescriptor_3ce088dbf8865287 = []byte{
-	// 449 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0xdd, 0x6e, 0x94, 0x40,
-	0x18, 0x85, 0x6d, 0x29, 0xdd, 0xcf, 0x55, 0xe3, 0x2c, 0x35, 0xd3, 0xb5, 0x17, 0x9b, 0xb9, 0xaa,
-	0xd1, 0x80, 0xe9, 0xb6, 0x89, 0xd7, 0xb6, 0x2a, 0x37, 0xc6, 0x64, 0x7c, 0x82, 0x2d, 0xfb, 0x6d,
-	0x97, 0x04, 0x01, 0x99, 0xc1, 0x9f, 0xc7, 0xf0, 0xd1, 0x7c, 0x23, 0x33, 0x33, 0x20, 0x30, 0xcb,
-	0x7a, 0x43, 0xe6, 0xe3, 0x3b, 0x67, 0x72, 0xce, 0xe1, 0x04, 0xb8, 0x48, 0xb2, 0x14, 0x73, 0x19,
-	0x55, 0x65, 0x12, 0xc9, 0x5f, 0x25, 0x0a, 0xf3, 0x0c, 0xcb, 0xaa, 0x90, 0x05, 0xf1, 0xf4, 0xb0,
-	0x08, 0x92, 0x22, 0xdf, 0xa6, 0x0f, 0x91, 0x90, 0x55, 0x9d, 0xc8, 0x66, 0xc9, 0x7e, 0x4f, 0xc0,
-	0xe7, 0xf8, 0xad, 0x46, 0x21, 0x49, 0x04, 0xc7, 0x02, 0xf3, 0x0d, 0x9d, 0x2c, 0xdd, 0xcb, 0x47,
-	0x57, 0xe7, 0xa1, 0xb9, 0xa4, 0xd9, 0x7e, 0xc1, 0x7c, 0xf3, 0x09, 0x85, 0x58, 0x3f, 0x60, 0xec,
-	0x70, 0x0d, 0x24, 0x2b, 0xf0, 0xb6, 0x28, 0x93, 0x1d, 0x3d, 0xd2, 0x8c, 0x17, 0x43, 0xc6, 0x07,
-	0xb5, 0x6a, 0x28, 0x22, 0x76, 0xb8, 0xc1, 0x92, 0x6b, 0xf0, 0x8d, 0x5c, 0x41, 0x8f, 0x35, 0x8d,
-	0x0e, 0x69, 0x1f, 0x51, 0xde, 0x9a, 0x7d, 0xec, 0xf0, 0x16, 0xaa, 0x58, 0x1b, 0x94, 0xeb, 0x34,
-	0x13, 0xd4, 0x1b, 0x63, 0x7d, 0xfe, 0x91, 0xdf, 0x99, 0xbd, 0x62, 0x35, 0x50, 0xf2, 0x0a, 0xbc,
-	0x6d, 0x56, 0x8b, 0x1d, 0x3d, 0xd1, 0x9c, 0xb9, 0x25, 0x50, 0xad, 0xb4, 0x30, 0x75, 0x78, 0xe7,
-	0x83, 0xf7, 0x7d, 0x9d, 0xd5, 0xc8, 0xee, 0x81, 0xec, 0x9b, 0x26, 0x14, 0xfc, 0xaf, 0xe6, 0x48,
-	0xdd, 0xa5, 0x7b, 0x39, 0xe3, 0xed, 0x48, 0xae, 0x60, 0x5a, 0x61, 0x92, 0x96, 0x4a, 0x69, 0x13,
-	0x5e, 0x10, 0x9a, 0xb4, 0x43, 0x63, 0xe5, 0x56, 0x0f, 0xbc, 0x83, 0xb1, 0xe7, 0x10, 0x8c, 0xc5,
-	0xc4, 0xe6, 0xf0, 0x6c, 0x2f, 0x87, 0xde, 0xcb, 0xce, 0x26, 0x7b, 0x02, 0xb3, 0xbe, 0x0f, 0xf6,
-	0x67, 0x02, 0xa7, 0x1c, 0x45, 0x59, 0xe4, 0x02, 0xc9, 0x5b, 0x98, 0xe2, 0xcf, 0x04, 0x4b, 0x99,
-	0x16, 0xb9, 0x96, 0xdb, 0x0f, 0xcc, 0x60, 0xde, 0xb7, 0xfb, 0xd8, 0xe1, 0x1d, 0x98, 0xbc, 0x19,
-	0x94, 0x60, 0x61, 0x91, 0xc6, 0x5a, 0x70, 0x3d, 0x6c, 0xc1, 0x85, 0x45, 0x39, 0x50, 0x83, 0x1b,
-	0xbb, 0x06, 0xe7, 0x16, 0x6f, 0xbc, 0x07, 0x37, 0x76, 0x0f, 0x6c, 0xda, 0x78, 0x11, 0x5e, 0x0f,
-	0x8b, 0x10, 0xd8, 0x1a, 0x0f, 0x34, 0xe1, 0xa5, 0x0a, 0xde, 0x8a, 0x8b, 0x04, 0xe0, 0x61, 0x55,
-	0x15, 0x95, 0xce, 0x75, 0xca, 0xcd, 0xc0, 0xce, 0x60, 0x3e, 0x12, 0x12, 0xbb, 0x53, 0x5d, 0xb2,
-	0x0d, 0x91, 0xb0, 0x33, 0xef, 0x2e, 0x8f, 0x0e, 0xf6, 0xa5, 0x05, 0xf5, 0x6f, 0xe9, 0xfc, 0xa9,
-	0x5b, 0xda, 0x2c, 0xdc, 0xff, 0xb4, 0xae, 0x05, 0xb1, 0xa7, 0xf0, 0x78, 0x60, 0x98, 0xad, 0xe0,
-	0x6c, 0xf4, 0x2b, 0x91, 0x05, 0x9c, 0x36, 0xe5, 0x36, 0x02, 0x67, 0xfc, 0xdf, 0x7c, 0x7f, 0xa2,
-	0x7f, 0x1c, 0xab, 0xbf, 0x01, 0x00, 0x00, 0xff, 0xff, 0x37, 0x5f, 0x6e, 0x8e, 0x75, 0x04, 0x00,
-	0x00,
+	// 527 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0x5d, 0x6f, 0xd3, 0x30,
+	0x14, 0x6d, 0xda, 0x66, 0x69, 0x2f, 0xe5, 0x63, 0x6e, 0x06, 0x5e, 0xb7, 0x87, 0xca, 0x4f, 0x43,
+	0xa0, 0x64, 0xda, 0x87, 0xc4, 0x23, 0x62, 0x03, 0xca, 0x03, 0x42, 0xf2, 0x7e, 0x00, 0x6a, 0x53,
+	0x77, 0x8d, 0x14, 0x92, 0x60, 0x3b, 0x8c, 0x3d, 0xf1, 0xc0, 0x3f, 0xe3, 0x97, 0x21, 0xdb, 0xc9,
+	0xd2, 0xb8, 0xe9, 0x5e, 0x2a, 0x5f, 0x9f, 0x73, 0xac, 0x7b, 0xcf, 0x3d, 0x0d, 0x1c, 0x47, 0x49,
+	0xcc, 0x52, 0x19, 0xf2, 0x3c, 0x0a, 0xe5, 0x7d, 0xce, 0x84, 0xf9, 0x0d, 0x72, 0x9e, 0xc9, 0x0c,
+	0xb9, 0xba, 0x98, 0xf8, 0x51, 0x96, 0xae, 0xe2, 0xdb, 0x50, 0x48, 0x5e, 0x44, 0xb2, 0x04, 0xc9,
+	0xbf, 0x2e, 0x78, 0x94, 0xfd, 0x2c, 0x98, 0x90, 0x28, 0x84, 0xbe, 0x60, 0xe9, 0x12, 0x77, 0xa7,
+	0xce, 0xc9, 0x93, 0xb3, 0xc3, 0xc0, 0x3c, 0x52, 0xa2, 0x37, 0x2c, 0x5d, 0x7e, 0x65, 0x42, 0xcc,
+	0x6f, 0xd9, 0xac, 0x43, 0x35, 0x11, 0x9d, 0x83, 0xbb, 0x62, 0x32, 0x5a, 0xe3, 0x9e, 0x56, 0x1c,
+	0x35, 0x15, 0x9f, 0x14, 0x54, 0x4a, 0xc4, 0xac, 0x43, 0x0d, 0x17, 0x5d, 0x80, 0x67, 0xda, 0x15,
+	0xb8, 0xaf, 0x65, 0xb8, 0x29, 0xfb, 0xcc, 0xe4, 0x95, 0xc1, 0x67, 0x1d, 0x5a, 0x51, 0x95, 0x6a,
+	0xc9, 0xe4, 0x3c, 0x4e, 0x04, 0x76, 0xdb, 0x54, 0xdf, 0xee, 0xd2, 0x6b, 0x83, 0x2b, 0x55, 0x49,
+	0x45, 0x6f, 0xc0, 0x5d, 0x25, 0x85, 0x58, 0xe3, 0x3d, 0xad, 0x19, 0x5b, 0x0d, 0x2a, 0x48, 0x37,
+	0xa6, 0x0e, 0x28, 0x04, 0x97, 0xb3, 0x3c, 0xb9, 0xc7, 0x9e, 0x26, 0xbf, 0xda, 0x9e, 0x9f, 0x2a,
+	0x58, 0x09, 0x34, 0xef, 0x83, 0x07, 0xee, 0xaf, 0x79, 0x52, 0x30, 0xf2, 0x07, 0xd0, 0xb6, 0x4b,
+	0x08, 0x83, 0xf7, 0xc3, 0x1c, 0xb1, 0x33, 0x75, 0x4e, 0x46, 0xb4, 0x2a, 0xd1, 0x19, 0x0c, 0x39,
+	0x8b, 0xe2, 0x5c, 0x8d, 0x56, 0xba, 0xed, 0x07, 0x66, 0x3d, 0x81, 0x99, 0xfd, 0x4a, 0x17, 0xb4,
+	0xa6, 0xa1, 0x23, 0x18, 0xde, 0xc5, 0x72, 0xfd, 0x5d, 0x14, 0x7c, 0xa1, 0xfd, 0x1e, 0xd0, 0x81,
+	0xba, 0xb8, 0x29, 0xf8, 0x82, 0xbc, 0x04, 0xbf, 0xcd, 0x74, 0x32, 0x86, 0xfd, 0x2d, 0x57, 0x37,
+	0x2e, 0x6b, 0xd3, 0xc8, 0x33, 0x18, 0x6d, 0xba, 0x42, 0xde, 0xc3, 0x0b, 0x7b, 0xf0, 0x47, 0x06,
+	0x42, 0xd0, 0xd7, 0x7d, 0x75, 0xf5, 0xb5, 0x3e, 0x93, 0xbf, 0x3d, 0x18, 0x50, 0x26, 0xf2, 0x2c,
+	0x15, 0x0c, 0xbd, 0x83, 0x21, 0xfb, 0x1d, 0xb1, 0x5c, 0xc6, 0x59, 0x8a, 0x1d, 0x6b, 0x81, 0x86,
+	0xf3, 0xb1, 0xc2, 0x67, 0x1d, 0x5a, 0x93, 0xd1, 0x69, 0x23, 0x94, 0x13, 0x4b, 0xd4, 0x96, 0xca,
+	0x8b, 0x66, 0x2a, 0x8f, 0x2d, 0xc9, 0x8e, 0x58, 0x5e, 0xda, 0xb1, 0x3c, 0xb4, 0x74, 0xed, 0xb9,
+	0xbc, 0xb4, 0x73, 0x69, 0xcb, 0xda, 0x83, 0xf9, 0xb6, 0x19, 0x4c, 0xdf, 0xee, 0xb1, 0x99, 0xcc,
+	0xd3, 0x66, 0x32, 0x71, 0x8b, 0x09, 0xbb, 0xa2, 0xf9, 0x1a, 0xf6, 0x2b, 0xda, 0x83, 0xc1, 0xc8,
+	0x07, 0x97, 0x71, 0x9e, 0x71, 0xbd, 0x89, 0x21, 0x35, 0x05, 0x39, 0x80, 0x71, 0x8b, 0xad, 0xe4,
+	0x1a, 0x50, 0x75, 0x5d, 0x5b, 0x80, 0x82, 0xda, 0x2e, 0x67, 0xda, 0xdb, 0x19, 0xe0, 0x8a, 0xb4,
+	0xf9, 0x4a, 0xed, 0x88, 0x7a, 0xa5, 0x72, 0xcf, 0x79, 0xe4, 0x6f, 0x50, 0x91, 0xc8, 0x73, 0x78,
+	0xda, 0xb0, 0xc8, 0x64, 0xd9, 0x72, 0x81, 0x7c, 0x81, 0x83, 0xd6, 0x65, 0xa3, 0x09, 0x0c, 0xca,
+	0xc4, 0x9a, 0xae, 0x47, 0xf4, 0xa1, 0x56, 0x9e, 0xa8, 0xd8, 0x0a, 0xdc, 0xd5, 0x80, 0x29, 0x16,
+	0x7b, 0xfa, 0x2b, 0x79, 0xfe, 0x7f, 0x00, 0x3b, 0xcc, 0x8d, 0x1b, 0x62, 0x05, 0x00, 0x00,
 }
@@ -96,6 +96,9 @@ func (s *SocketServer) handleRequest(req *types.Request) *types.Response {
 	case *types.Request_Details:
 		s.log.Info("Details request")
 		return requesthandler.HandleOwnDetails(r, s.client)
+	case *types.Request_Reply:
+		s.log.Info("Reply request")
+		return requesthandler.HandleSendReply(r, s.client)
 	//case *types.Request_Flush:
 	//	return requesthandler.HandleFlush(r) // doesn't do anything
 	default:
@@ -20,11 +20,12 @@
 package clientcore
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/helpers"
 	"github.com/nymtech/nym-mixnet/helpers/topology"
@@ -47,6 +48,9 @@ type NetworkPKI struct {
 	lastUpdated time.Time
 	Mixes       topology.LayeredMixes
 	Clients     []config.ClientConfig
+	// EpochKeys are the sphinx keys of the key epochs announced by the nodes which rotate their keys,
+	// indexed by the long-term keys the nodes are identified by in Mixes and in the providers of Clients.
+	EpochKeys topology.EpochKeys
 }
 
 func (n *NetworkPKI) UpdateNetwork(newMixes topology.LayeredMixes, newClients []config.ClientConfig) {
@@ -67,12 +71,16 @@ type MixClient interface {
 }
 
 // CryptoClient contains a public/private keypair and an elliptic curve for a given provider and network.
+// All the randomness used for choosing the paths, the delays and for creating the packets is read from rng.
 type CryptoClient struct {
 	pubKey   *sphinx.PublicKey
 	prvKey   *sphinx.PrivateKey
 	Provider config.MixConfig
 	Network  NetworkPKI
 	log      *logrus.Logger
+	replies  replyStore
+	rng      io.Reader
+	now      func() time.Time
 }
 
 const (
@@ -101,13 +109,13 @@ func (c *CryptoClient) createSphinxPacket(message []byte, recipient config.Clien
 		return nil, err
 	}
 
-	sphinxPacket, err := sphinx.PackForwardMessage(path, delays, message)
+	sphinxPacket, err := sphinx.DefaultParams.PackForwardMessage(c.rng, path, delays, message)
 	if err != nil {
 		c.log.Errorf("error in CreateSphinxPacket - the pack procedure failed: %v", err)
 		return nil, err
 	}
 
-	return proto.Marshal(&sphinxPacket)
+	return sphinxPacket.MarshalBinary()
 }
 
 // buildPath builds a path containing the sender's provider,
@@ -131,7 +139,21 @@ func (c *CryptoClient) buildPath(recipient config.ClientConfig) (config.E2EPath,
 		EgressProvider: *recipient.Provider,
 		Recipient:      recipient,
 	}
-	return path, nil
+	return c.withEpochKeys(path), nil
+}
+
+// withEpochKeys replaces the long-term keys of the nodes on the path, which only identify the nodes rotating
+// their keys, with the sphinx keys they announced for the current key epoch or, failing that, for the next one.
+func (c *CryptoClient) withEpochKeys(path config.E2EPath) config.E2EPath {
+	now := c.now()
+	mixes := make([]config.MixConfig, len(path.Mixes))
+	for i := range path.Mixes {
+		mixes[i] = c.Network.EpochKeys.MixAt(path.Mixes[i], now)
+	}
+	path.Mixes = mixes
+	path.IngressProvider = c.Network.EpochKeys.MixAt(path.IngressProvider, now)
+	path.EgressProvider = c.Network.EpochKeys.MixAt(path.EgressProvider, now)
+	return path
 }
 
 // getRandomMixSequence generates a random sequence of given length from all possible mixes.
@@ -144,11 +166,15 @@ func (c *CryptoClient) getRandomMixSequence(mixes topology.LayeredMixes, length
 
 	mixSequence := make([]config.MixConfig, length)
 	for i := 1; i <= length; i++ {
-		if layerMixes, ok := mixes[uint(i)]; ok {
-			mixSequence[i-1] = helpers.RandomMix(layerMixes)
-		} else {
+		layerMixes, ok := mixes[uint(i)]
+		if !ok {
 			return nil, fmt.Errorf("no valid mixes for layer: %v", i)
 		}
+		mix, err := helpers.RandomMixFrom(c.rng, layerMixes)
+		if err != nil {
+			return nil, err
+		}
+		mixSequence[i-1] = mix
 	}
 
 	return mixSequence, nil
@@ -160,7 +186,7 @@ func (c *CryptoClient) getRandomMixSequence(mixes topology.LayeredMixes, length
 func (c *CryptoClient) generateDelaySequence(desiredRateParameter float64, length int) ([]float64, error) {
 	var delays []float64
 	for i := 0; i < length; i++ {
-		d, err := helpers.RandomExponential(desiredRateParameter)
+		d, err := helpers.RandomExponentialFrom(c.rng, desiredRateParameter)
 		if err != nil {
 			c.log.Errorf("Error in generateDelaySequence - generating random exponential sample failed: %v", err)
 			return nil, err
@@ -201,11 +227,25 @@ func NewCryptoClient(privKey *sphinx.PrivateKey,
 	provider config.MixConfig,
 	network NetworkPKI,
 	log *logrus.Logger,
+) *CryptoClient {
+	return NewCryptoClientWithRandomness(privKey, pubKey, provider, network, log, rand.Reader)
+}
+
+// NewCryptoClientWithRandomness creates the CryptoClient reading all its randomness from rng
+// instead of crypto/rand, which allows reproducing the packets it creates.
+func NewCryptoClientWithRandomness(privKey *sphinx.PrivateKey,
+	pubKey *sphinx.PublicKey,
+	provider config.MixConfig,
+	network NetworkPKI,
+	log *logrus.Logger,
+	rng io.Reader,
 ) *CryptoClient {
 	return &CryptoClient{prvKey: privKey,
 		pubKey:   pubKey,
 		Provider: provider,
 		Network:  network,
 		log:      log,
+		rng:      rng,
+		now:      time.Now,
 	}
 }
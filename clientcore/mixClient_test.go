@@ -15,16 +15,21 @@
 package clientcore
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/nymtech/nym-directory/models"
 	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers"
 	"github.com/nymtech/nym-mixnet/helpers/topology"
 	"github.com/nymtech/nym-mixnet/logger"
+	"github.com/nymtech/nym-mixnet/node"
 	sphinx "github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/stretchr/testify/assert"
 )
@@ -135,6 +140,37 @@ func TestCryptoClient_EncodeMessage(t *testing.T) {
 
 }
 
+func TestCryptoClient_EncodeMessageWithFixedRandomness(t *testing.T) {
+	_, pubP, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3331", PubKey: pubP.Bytes()}
+	recipient := config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "9999", Provider: &provider}
+
+	randomness := make([]byte, 4096)
+	for i := range randomness {
+		randomness[i] = byte(i * 7)
+	}
+
+	// clients reading the same randomness should create exactly the same packet
+	encode := func() []byte {
+		c := NewCryptoClientWithRandomness(client.prvKey,
+			client.pubKey,
+			provider,
+			client.Network,
+			client.log,
+			bytes.NewReader(randomness),
+		)
+		encoded, err := c.EncodeMessage([]byte("Hello world"), recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+	assert.Equal(t, encode(), encode())
+}
+
 func TestCryptoClient_DecodeMessage(t *testing.T) {
 	packet := sphinx.SphinxPacket{Hdr: &sphinx.Header{}, Pld: []byte("Message")}
 
@@ -200,3 +236,71 @@ func Test_GetRandomMixSequence_FailNonList(t *testing.T) {
 	_, err := client.getRandomMixSequence(nil, 6)
 	assert.EqualError(t, ErrInvalidMixes, err.Error(), "")
 }
+
+// announcedEpochKeys returns the epoch keys of the provider with the given long-term key as read from the topology,
+// which holds a presence for each of its announced keys.
+func announcedEpochKeys(pubKey *sphinx.PublicKey, announced []node.EpochKey) topology.EpochKeys {
+	var providers topology.ProviderPresence
+	for _, key := range helpers.PresenceKeys(pubKey, announced) {
+		providers = append(providers, models.MixProviderPresence{MixProviderHostInfo: models.MixProviderHostInfo{
+			HostInfo: models.HostInfo{Host: "localhost:3331", PubKey: key},
+		}})
+	}
+	return topology.GetEpochKeys(&models.Topology{MixProviderNodes: providers})
+}
+
+func TestCryptoClient_EpochKeysAcrossEpochs(t *testing.T) {
+	clock := node.NewManualClock(time.Unix(0, 0).Add(1000*time.Hour + 59*time.Minute))
+	keys, err := node.NewKeyRing(time.Hour, 5*time.Minute, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privP, pubP, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerNode := node.NewMix(privP, pubP)
+	providerNode.UseKeyRing(keys)
+	provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3331", PubKey: pubP.Bytes()}
+	recipient := config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "9999", Provider: &provider}
+
+	c := NewCryptoClient(client.prvKey, client.pubKey, provider, NetworkPKI{Mixes: client.Network.Mixes,
+		EpochKeys: announcedEpochKeys(pubP, keys.Announced()),
+	}, client.log)
+	c.now = clock.Now
+	send := func() error {
+		packet, err := c.EncodeMessage([]byte("Hello world"), recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return providerNode.ProcessPacket(packet).Err()
+	}
+
+	// the long-term key of the provider only identifies it
+	path, err := c.buildPath(recipient)
+	assert.Nil(t, err)
+	assert.Equal(t, keys.Current().Bytes(), path.IngressProvider.PubKey)
+	assert.Equal(t, keys.Current().Bytes(), path.EgressProvider.PubKey)
+	assert.Equal(t, client.Network.Mixes[1][0].PubKey, path.Mixes[0].PubKey)
+	assert.Nil(t, send())
+
+	// the key announced for the next epoch is used as soon as the epoch starts, even before the provider updates
+	clock.Advance(2 * time.Minute)
+	assert.Nil(t, send())
+	_, err = providerNode.UpdateKeys()
+	assert.Nil(t, err)
+	assert.Nil(t, send())
+
+	// the client keeps using the latest announced key, which is only accepted until the end of its grace period
+	clock.Advance(time.Hour)
+	_, err = providerNode.UpdateKeys()
+	assert.Nil(t, err)
+	assert.Nil(t, send())
+	clock.Advance(5 * time.Minute)
+	_, err = providerNode.UpdateKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, sphinx.ErrInvalidMac, send())
+
+	c.Network.EpochKeys = announcedEpochKeys(pubP, keys.Announced())
+	assert.Nil(t, send())
+}
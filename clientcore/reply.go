@@ -0,0 +1,179 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+var (
+	// ErrUnknownReply is returned when the received packet is not a reply to any of the SURBs created by the client.
+	ErrUnknownReply = errors.New("the packet is not a reply to a known SURB")
+
+	// surbMessagePrefix marks the messages that have a SURB attached.
+	// The format of such message is: surbMessagePrefix || SURB length (2 bytes, big endian) || SURB || message.
+	surbMessagePrefix = []byte("NYMSURB1")
+)
+
+// replyStore holds the keys required to decrypt the replies to the SURBs created by the client.
+type replyStore struct {
+	sync.Mutex
+	keys map[string]sphinx.ReplyKeys
+}
+
+func (rs *replyStore) add(keys sphinx.ReplyKeys) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.keys == nil {
+		rs.keys = make(map[string]sphinx.ReplyKeys)
+	}
+	rs.keys[hex.EncodeToString(keys.ID)] = keys
+}
+
+// take returns and removes the keys with the given id, as each SURB can only be used once.
+func (rs *replyStore) take(id []byte) (sphinx.ReplyKeys, bool) {
+	rs.Lock()
+	defer rs.Unlock()
+	idHex := hex.EncodeToString(id)
+	keys, ok := rs.keys[idHex]
+	if ok {
+		delete(rs.keys, idHex)
+	}
+	return keys, ok
+}
+
+// CreateSURB creates a single-use reply block leading back to the client, through a random sequence of mixes
+// and its own provider. The reply block starts at the mix of the first layer, as its first hop is seen
+// by the replier, who should not learn the provider of the client. The keys required to decrypt the reply
+// are kept by the client. CreateSURB takes as input the public configuration of the client itself.
+func (c *CryptoClient) CreateSURB(self config.ClientConfig) (sphinx.SURB, error) {
+	mixSeq, err := c.getRandomMixSequence(c.Network.Mixes, pathLength)
+	if err != nil {
+		c.log.Errorf("error in CreateSURB - generating random mix path failed: %v", err)
+		return sphinx.SURB{}, err
+	}
+	// the first hop of the path is the first mix rather than a provider
+	path := c.withEpochKeys(config.E2EPath{IngressProvider: mixSeq[0],
+		Mixes:          mixSeq[1:],
+		EgressProvider: c.Provider,
+		Recipient:      self,
+	})
+
+	delays, err := c.generateDelaySequence(desiredRateParameter, path.Len())
+	if err != nil {
+		c.log.Errorf("error in CreateSURB - generating sequence of delays failed: %v", err)
+		return sphinx.SURB{}, err
+	}
+
+	surb, keys, err := sphinx.DefaultParams.CreateSURB(c.rng, path, delays)
+	if err != nil {
+		c.log.Errorf("error in CreateSURB - creating the SURB failed: %v", err)
+		return sphinx.SURB{}, err
+	}
+	c.replies.add(keys)
+	return surb, nil
+}
+
+// EncodeMessageWithSURB encodes given message, with a freshly created SURB attached, into the Sphinx packet format.
+// The recipient can use the SURB to reply without learning who the sender is.
+// EncodeMessageWithSURB takes as inputs the message, the recipient's and the client's own public configuration.
+// EncodeMessageWithSURB returns the byte representation of the packet or an error if the packet could not be created.
+func (c *CryptoClient) EncodeMessageWithSURB(message []byte,
+	recipient config.ClientConfig,
+	self config.ClientConfig,
+) ([]byte, error) {
+	surb, err := c.CreateSURB(self)
+	if err != nil {
+		return nil, err
+	}
+
+	surbBytes, err := surb.MarshalBinary()
+	if err != nil {
+		c.log.Errorf("Error in EncodeMessageWithSURB - marshal of SURB failed: %v", err)
+		return nil, err
+	}
+
+	surbLength := make([]byte, 2)
+	binary.BigEndian.PutUint16(surbLength, uint16(len(surbBytes)))
+
+	msg := make([]byte, 0, len(surbMessagePrefix)+len(surbLength)+len(surbBytes)+len(message))
+	msg = append(msg, surbMessagePrefix...)
+	msg = append(msg, surbLength...)
+	msg = append(msg, surbBytes...)
+	msg = append(msg, message...)
+	return c.EncodeMessage(msg, recipient)
+}
+
+// ParseMessage splits the received message into the actual message and the attached SURB, if there is any.
+func ParseMessage(msg []byte) ([]byte, *sphinx.SURB, error) {
+	if !bytes.HasPrefix(msg, surbMessagePrefix) {
+		return msg, nil, nil
+	}
+	msg = msg[len(surbMessagePrefix):]
+	if len(msg) < 2 {
+		return nil, nil, sphinx.ErrInvalidSURB
+	}
+	surbLength := int(binary.BigEndian.Uint16(msg))
+	msg = msg[2:]
+	if len(msg) < surbLength {
+		return nil, nil, sphinx.ErrInvalidSURB
+	}
+
+	var surb sphinx.SURB
+	if err := surb.UnmarshalBinary(msg[:surbLength]); err != nil {
+		return nil, nil, err
+	}
+	return msg[surbLength:], &surb, nil
+}
+
+// EncodeReply encodes the reply message into the Sphinx packet format using the given SURB.
+// EncodeReply returns the byte representation of the packet together with the first hop, to which
+// the packet should be sent, or an error if the packet could not be created.
+func (c *CryptoClient) EncodeReply(message []byte, surb sphinx.SURB) (sphinx.Hop, []byte, error) {
+	packet, err := sphinx.PackReplyMessage(surb, message)
+	if err != nil {
+		c.log.Errorf("Error in EncodeReply - the pack procedure failed: %v", err)
+		return sphinx.Hop{}, nil, err
+	}
+
+	packetBytes, err := packet.MarshalBinary()
+	if err != nil {
+		return sphinx.Hop{}, nil, err
+	}
+	return surb.FirstHop, packetBytes, nil
+}
+
+// DecodeReply decrypts the received reply packet using the keys of the matching SURB.
+// DecodeReply returns ErrUnknownReply if the packet is not a reply to any of the SURBs created by the client.
+func (c *CryptoClient) DecodeReply(packet []byte) ([]byte, error) {
+	id, err := sphinx.ReplyID(packet)
+	if err != nil {
+		// packets that could not be decoded, such as the legacy ones, are certainly not replies
+		return nil, ErrUnknownReply
+	}
+
+	keys, ok := c.replies.take(id)
+	if !ok {
+		return nil, ErrUnknownReply
+	}
+	return sphinx.UnwrapReplyMessage(keys, packet)
+}
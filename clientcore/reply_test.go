@@ -0,0 +1,118 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+	"github.com/nymtech/nym-mixnet/logger"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// createReplyTestNetwork creates a client together with the network of a single mix per layer
+// and returns the private keys of all nodes.
+func createReplyTestNetwork(t *testing.T) (*CryptoClient, config.ClientConfig, map[string]*sphinx.PrivateKey) {
+	baseDisabledLogger, err := logger.New("", "panic", true)
+	assert.Nil(t, err)
+
+	privs := make(map[string]*sphinx.PrivateKey)
+	newNode := func(id string, port int, layer uint) config.MixConfig {
+		priv, pub, err := sphinx.GenerateKeyPair()
+		assert.Nil(t, err)
+		privs[fmt.Sprintf("localhost:%d", port)] = priv
+		return config.NewMixConfig(id, "localhost", fmt.Sprintf("%d", port), pub.Bytes(), layer)
+	}
+
+	provider := newNode("Provider", 4000, 0)
+	network := NetworkPKI{Mixes: topology.LayeredMixes{
+		1: []config.MixConfig{newNode("Mix1", 4001, 1)},
+		2: []config.MixConfig{newNode("Mix2", 4002, 2)},
+		3: []config.MixConfig{newNode("Mix3", 4003, 3)},
+	}}
+
+	priv, pub, err := sphinx.GenerateKeyPair()
+	assert.Nil(t, err)
+	c := NewCryptoClient(priv, pub, provider, network, baseDisabledLogger.GetLogger("test"))
+	self := config.ClientConfig{Id: "Client", Host: "localhost", Port: "9999", PubKey: pub.Bytes(), Provider: &provider}
+	return c, self, privs
+}
+
+// processPath processes the packet by all nodes on its path, starting with the node at the given address.
+func processPath(t *testing.T, privs map[string]*sphinx.PrivateKey, address string, packet []byte) []byte {
+	for {
+		hop, _, newPacket, err := sphinx.ProcessSphinxPacket(packet, privs[address], nil)
+		assert.Nil(t, err)
+		packet = newPacket
+		if _, ok := privs[hop.Address]; !ok {
+			return packet
+		}
+		address = hop.Address
+	}
+}
+
+func TestCryptoClient_ReplyUsingSURB(t *testing.T) {
+	c, self, privs := createReplyTestNetwork(t)
+
+	packet, err := c.EncodeMessageWithSURB([]byte("Hello world"), self, self)
+	assert.Nil(t, err)
+
+	processed := processPath(t, privs, "localhost:4000", packet)
+	received, err := sphinx.ExtractMessage(processed)
+	assert.Nil(t, err)
+
+	message, surb, err := ParseMessage(received)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello world"), message)
+	assert.NotNil(t, surb)
+
+	// the reply block starts at the first mix, so the replier does not learn the provider of the sender
+	hop, reply, err := c.EncodeReply([]byte("Hello back"), *surb)
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:4001", hop.Address)
+
+	processedReply := processPath(t, privs, hop.Address, reply)
+	decoded, err := c.DecodeReply(processedReply)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello back"), decoded)
+
+	// the keys are discarded after the reply has been decoded
+	_, err = c.DecodeReply(processedReply)
+	assert.Equal(t, ErrUnknownReply, err)
+}
+
+func TestCryptoClient_DecodeReplyUnknown(t *testing.T) {
+	c, self, privs := createReplyTestNetwork(t)
+
+	packet, err := c.EncodeMessage([]byte("Hello world"), self)
+	assert.Nil(t, err)
+
+	processed := processPath(t, privs, "localhost:4000", packet)
+	_, err = c.DecodeReply(processed)
+	assert.Equal(t, ErrUnknownReply, err)
+}
+
+func TestParseMessageWithoutSURB(t *testing.T) {
+	message, surb, err := ParseMessage([]byte("Hello world"))
+	assert.Nil(t, err)
+	assert.Nil(t, surb)
+	assert.Equal(t, []byte("Hello world"), message)
+
+	_, _, err = ParseMessage(append(append([]byte{}, surbMessagePrefix...), []byte("Hello world")...))
+	assert.Equal(t, sphinx.ErrInvalidSURB, err)
+}
@@ -72,8 +72,8 @@ func cmdRun(args []string, usage string) {
 	}
 
 	for _, node := range initialTopology.MixProviderNodes {
-		if node.PubKey != benchmarkProviderID {
-			cfg.Client.ProviderID = node.PubKey
+		if identity := topology.Identity(node.PubKey); identity != benchmarkProviderID {
+			cfg.Client.ProviderID = identity
 			break
 		}
 	}
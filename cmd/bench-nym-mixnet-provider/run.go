@@ -18,7 +18,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
+	"path/filepath"
 
+	"github.com/nymtech/nym-mixnet/helpers"
+	serverConfig "github.com/nymtech/nym-mixnet/server/config"
 	"github.com/nymtech/nym-mixnet/server/provider"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/tav/golly/optparse"
@@ -53,12 +56,20 @@ func cmdRun(args []string, usage string) {
 	pubP := sphinx.BytesToPublicKey([]byte{17, 170, 15, 150, 155, 75, 240, 66, 54, 100, 131, 127, 193, 10,
 		133, 32, 62, 155, 9, 46, 200, 55, 60, 125, 223, 76, 170, 167, 100, 34, 176, 117})
 
-	baseProviderServer, err := provider.NewProviderServer(defaultBenchmarkProviderID,
-		defaultBenchmarkProviderHost,
-		*port,
-		privP,
-		pubP,
-	)
+	cfg, err := serverConfig.DefaultProviderConfig(defaultBenchmarkProviderID)
+	if err != nil {
+		panic(err)
+	}
+	cfg.Provider.Host = defaultBenchmarkProviderHost
+	cfg.Provider.Port = *port
+	cfg.Provider.DirectoryServer = helpers.DirectoryServerFor(defaultBenchmarkProviderHost)
+	// keep the inboxes in the current directory, where the bench inbox is emptied
+	cfg.Provider.InboxDirectory, err = filepath.Abs("inboxes")
+	if err != nil {
+		panic(err)
+	}
+
+	baseProviderServer, err := provider.NewProviderServer(cfg, privP, pubP, sphinx.DefaultRegistry.Versions())
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,225 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/server/admin"
+	serverConfig "github.com/nymtech/nym-mixnet/server/config"
+	"github.com/tav/golly/optparse"
+)
+
+// defaultSocket is the admin socket of the provider run without the config file from the current directory.
+const defaultSocket = "admin.sock"
+
+// nodeFlags are the flags selecting the node whose admin API is called.
+type nodeFlags struct {
+	socket   *string
+	mixnode  *string
+	provider *string
+}
+
+func addNodeFlags(opts *optparse.Parser) *nodeFlags {
+	return &nodeFlags{
+		socket: opts.Flags("--socket").Label("PATH").String("Path to the admin socket of the node", defaultSocket),
+		mixnode: opts.Flags("--mixnode").Label("ID").String("Id of the mixnode, whose admin socket is read "+
+			"from its default config file, instead of the socket path", ""),
+		provider: opts.Flags("--provider").Label("ID").String("Id of the provider, whose admin socket is read "+
+			"from its default config file, instead of the socket path", ""),
+	}
+}
+
+// client returns the client of the admin API of the selected node.
+func (f *nodeFlags) client() (*admin.Client, error) {
+	socket, err := f.socketPath()
+	if err != nil {
+		return nil, err
+	}
+	return admin.NewClient(socket), nil
+}
+
+func (f *nodeFlags) socketPath() (string, error) {
+	switch {
+	case len(*f.mixnode) > 0 && len(*f.provider) > 0:
+		return "", errors.New("only one of the mixnode and the provider can be selected")
+	case len(*f.mixnode) > 0:
+		configPath, err := serverConfig.DefaultMixNodeConfigPath(*f.mixnode)
+		if err != nil {
+			return "", err
+		}
+		cfg, err := serverConfig.LoadFile(configPath)
+		if err != nil {
+			return "", fmt.Errorf("could not load the config of the mixnode: %v", err)
+		}
+		if cfg.MixNode == nil {
+			return "", fmt.Errorf("the config file at %v is not the config of a mixnode", configPath)
+		}
+		return cfg.MixNode.AdminSocketFile(), nil
+	case len(*f.provider) > 0:
+		configPath, err := serverConfig.DefaultProviderConfigPath(*f.provider)
+		if err != nil {
+			return "", err
+		}
+		cfg, err := serverConfig.LoadFile(configPath)
+		if err != nil {
+			return "", fmt.Errorf("could not load the config of the provider: %v", err)
+		}
+		if cfg.Provider == nil {
+			return "", fmt.Errorf("the config file at %v is not the config of a provider", configPath)
+		}
+		return cfg.Provider.AdminSocketFile(), nil
+	default:
+		return *f.socket, nil
+	}
+}
+
+func cmdStatus(args []string, usage string) {
+	opts := newOpts("status [OPTIONS]", usage)
+	node := addNodeFlags(opts)
+	asJSON := opts.Flags("--json").Bool("Print the status as JSON")
+
+	params := opts.Parse(args)
+	if len(params) != 0 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+
+	c, err := node.client()
+	if err != nil {
+		exitWithError(err)
+	}
+	status, err := c.Status()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(status); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+	printStatus(status)
+}
+
+func printStatus(status *admin.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Type:\t%v\n", status.Type)
+	fmt.Fprintf(w, "ID:\t%v\n", status.ID)
+	fmt.Fprintf(w, "Public key:\t%v\n", status.PublicKey)
+	fmt.Fprintf(w, "Address:\t%v\n", status.Address)
+	if status.Type == "mixnode" {
+		fmt.Fprintf(w, "Layer:\t%v\n", status.Layer)
+	}
+	fmt.Fprintf(w, "Log level:\t%v\n", status.LogLevel)
+	fmt.Fprintf(w, "Started at:\t%v\n", status.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Uptime:\t%v\n", (time.Duration(status.Uptime) * time.Second).String())
+	fmt.Fprintf(w, "Draining:\t%v\n", status.Draining)
+	fmt.Fprintf(w, "Queue depth:\t%v\n", status.QueueDepth)
+	if status.RegisteredClients != nil {
+		fmt.Fprintf(w, "Registered clients:\t%v\n", *status.RegisteredClients)
+	}
+	fmt.Fprintf(w, "Peers:\t%v\n", len(status.Peers))
+	w.Flush()
+
+	if len(status.Peers) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ADDRESS\tCONNECTED\tQUEUED\tSENT\tDROPPED\n")
+	for _, p := range status.Peers {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", p.Address, p.Connected, p.Queued, p.Sent, p.Dropped)
+	}
+	w.Flush()
+}
+
+func cmdLogLevel(args []string, usage string) {
+	opts := newOpts("loglevel [OPTIONS] LEVEL", usage)
+	node := addNodeFlags(opts)
+
+	params := opts.Parse(args)
+	if len(params) != 1 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+
+	c, err := node.client()
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := c.SetLogLevel(params[0]); err != nil {
+		exitWithError(err)
+	}
+	fmt.Fprintf(os.Stdout, "Log level changed to %v\n", params[0])
+}
+
+func cmdDrain(args []string, usage string) {
+	opts := newOpts("drain [OPTIONS]", usage)
+	node := addNodeFlags(opts)
+	timeout := opts.Flags("--timeout").Label("TIMEOUT").Duration("For how long the node keeps forwarding "+
+		"the packets it already received, instead of the drain timeout in its config file, e.g. 30s", 0)
+
+	params := opts.Parse(args)
+	if len(params) != 0 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+	if *timeout < 0 {
+		exitWithError(errors.New("the drain timeout cannot be negative"))
+	}
+
+	c, err := node.client()
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := c.Drain(*timeout); err != nil {
+		exitWithError(err)
+	}
+	fmt.Fprintf(os.Stdout, "The node is draining and will shut down once done\n")
+}
+
+func cmdPresence(args []string, usage string) {
+	opts := newOpts("presence [OPTIONS]", usage)
+	node := addNodeFlags(opts)
+
+	params := opts.Parse(args)
+	if len(params) != 0 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+
+	c, err := node.client()
+	if err != nil {
+		exitWithError(err)
+	}
+	if err := c.RefreshPresence(); err != nil {
+		exitWithError(err)
+	}
+	fmt.Fprintf(os.Stdout, "Presence announced to the directory server\n")
+}
+
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "nym-admin: %v\n", err)
+	os.Exit(1)
+}
@@ -103,7 +103,8 @@ func InitCmd(args []string, usage string) {
 		os.Exit(1)
 	}
 
-	defaultCfg.Client.ProviderID = chooseRandom(initialTopology.MixProviderNodes).PubKey
+	// the provider rotating its keys is identified by its long-term key
+	defaultCfg.Client.ProviderID = topology.Identity(chooseRandom(initialTopology.MixProviderNodes).PubKey)
 
 	if err := helpers.EnsureDir(defaultCfg.Client.FullMixAppsDir(), 0700); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create mixapps directory: %v", err)
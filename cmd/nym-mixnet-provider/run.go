@@ -17,9 +17,14 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/nymtech/nym-mixnet/constants"
 	"github.com/nymtech/nym-mixnet/helpers"
+	serverConfig "github.com/nymtech/nym-mixnet/server/config"
 	"github.com/nymtech/nym-mixnet/server/provider"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/tav/golly/optparse"
@@ -31,25 +36,28 @@ const (
 	defaultPort           = "1789"
 	defaultPrivateKeyFile = "privateKey.key"
 	defaultPublicKeyFile  = "publicKey.key"
+	defaultInboxDirectory = "inboxes"
+	defaultAdminSocket    = "admin.sock"
 )
 
-func loadKeys() (*sphinx.PrivateKey, *sphinx.PublicKey, error) {
+// loadKeys loads the keys of the provider from the given files.
+func loadKeys(privateKeyFile, publicKeyFile string) (*sphinx.PrivateKey, *sphinx.PublicKey, error) {
 	prvKey := new(sphinx.PrivateKey)
 	pubKey := new(sphinx.PublicKey)
 
-	if _, err := os.Stat(defaultPrivateKeyFile); os.IsNotExist(err) {
+	if _, err := os.Stat(privateKeyFile); os.IsNotExist(err) {
 		return nil, nil, err
 	}
 
-	if _, err := os.Stat(defaultPublicKeyFile); os.IsNotExist(err) {
+	if _, err := os.Stat(publicKeyFile); os.IsNotExist(err) {
 		return nil, nil, err
 	}
 
-	if err := helpers.FromPEMFile(prvKey, defaultPrivateKeyFile, constants.PrivateKeyPEMType); err != nil {
+	if err := helpers.FromPEMFile(prvKey, privateKeyFile, constants.PrivateKeyPEMType); err != nil {
 		return nil, nil, fmt.Errorf("Failed to load the private key: %v", err)
 	}
 
-	if err := helpers.FromPEMFile(pubKey, defaultPublicKeyFile, constants.PublicKeyPEMType); err != nil {
+	if err := helpers.FromPEMFile(pubKey, publicKeyFile, constants.PublicKeyPEMType); err != nil {
 		return nil, nil, fmt.Errorf("Failed to load the public key: %v", err)
 	}
 
@@ -57,18 +65,50 @@ func loadKeys() (*sphinx.PrivateKey, *sphinx.PublicKey, error) {
 	return prvKey, pubKey, nil
 }
 
-func saveKeys(privP *sphinx.PrivateKey, pubP *sphinx.PublicKey) {
-	if err := helpers.ToPEMFile(privP, defaultPrivateKeyFile, constants.PrivateKeyPEMType); err != nil {
+func saveKeys(privP *sphinx.PrivateKey, pubP *sphinx.PublicKey, privateKeyFile, publicKeyFile string) {
+	if err := helpers.ToPEMFile(privP, privateKeyFile, constants.PrivateKeyPEMType); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to save private key: %v", err)
 		os.Exit(1)
 	}
-	fmt.Fprintf(os.Stdout, "Saved generated private key to %v\n", defaultPrivateKeyFile)
+	fmt.Fprintf(os.Stdout, "Saved generated private key to %v\n", privateKeyFile)
 
-	if err := helpers.ToPEMFile(pubP, defaultPublicKeyFile, constants.PublicKeyPEMType); err != nil {
+	if err := helpers.ToPEMFile(pubP, publicKeyFile, constants.PublicKeyPEMType); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to save public key: %v", err)
 		os.Exit(1)
 	}
-	fmt.Fprintf(os.Stdout, "Saved generated public key to %v\n", defaultPublicKeyFile)
+	fmt.Fprintf(os.Stdout, "Saved generated public key to %v\n", publicKeyFile)
+}
+
+// loadConfig loads the config file at the given path, or creates the config from the flags if the path is empty.
+// Such config keeps the keys, the inboxes and the admin socket in the current directory.
+func loadConfig(configPath, id, host, port string) (*serverConfig.Config, error) {
+	if len(configPath) > 0 {
+		cfg, err := serverConfig.LoadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Provider == nil {
+			return nil, fmt.Errorf("the config file at %v is not the config of a provider", configPath)
+		}
+		return cfg, nil
+	}
+
+	cfg, err := serverConfig.DefaultProviderConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Provider.Host = host
+	cfg.Provider.Port = port
+	cfg.Provider.DirectoryServer = helpers.DirectoryServerFor(host)
+	cfg.Provider.InboxDirectory = filepath.Join(workDir, defaultInboxDirectory)
+	cfg.Provider.PrivateKey = filepath.Join(workDir, defaultPrivateKeyFile)
+	cfg.Provider.PublicKey = filepath.Join(workDir, defaultPublicKeyFile)
+	cfg.Provider.AdminSocket = filepath.Join(workDir, defaultAdminSocket)
+	return cfg, nil
 }
 
 func cmdRun(args []string, usage string) {
@@ -76,6 +116,13 @@ func cmdRun(args []string, usage string) {
 	id := opts.Flags("--id").Label("ID").String("Id of the nym-mixnet-provider we want to run", defaultID)
 	host := opts.Flags("--host").Label("HOST").String("The host on which the nym-mixnet-provider is running", defaultHost)
 	port := opts.Flags("--port").Label("PORT").String("Port on which nym-mixnet-provider listens", defaultPort)
+	configPath := opts.Flags("--config").Label("CONFIG").String("Path to the config file of the nym-mixnet-provider. "+
+		"If specified, the id, host and port flags are ignored", "")
+	metricsAddress := opts.Flags("--metrics").Label("ADDRESS").String("The host:port address on which the metrics "+
+		"are served under /metrics, instead of the one in the config file", "")
+	versions := opts.Flags("--versions").Label("VERSIONS").String("Comma separated list of accepted sphinx packet versions",
+		sphinx.FormatVersions(sphinx.DefaultRegistry.Versions()),
+	)
 
 	params := opts.Parse(args)
 	if len(params) != 0 {
@@ -83,16 +130,32 @@ func cmdRun(args []string, usage string) {
 		os.Exit(1)
 	}
 
+	acceptedVersions, err := sphinx.ParseVersions(*versions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid packet versions: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig(*configPath, *id, *host, *port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load the config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(*metricsAddress) > 0 {
+		cfg.Provider.MetricsAddress = *metricsAddress
+	}
+
 	ip, err := helpers.GetLocalIP()
 	if err != nil {
 		panic(err)
 	}
 
-	if host == nil || len(*host) < 7 {
-		host = &ip
+	if len(cfg.Provider.Host) < 7 {
+		cfg.Provider.Host = ip
 	}
 
-	privP, pubP, err := loadKeys()
+	privP, pubP, err := loadKeys(cfg.Provider.PrivateKeyFile(), cfg.Provider.PublicKeyFile())
 	if err != nil {
 		privP, pubP, err = sphinx.GenerateKeyPair()
 		if err != nil {
@@ -100,21 +163,37 @@ func cmdRun(args []string, usage string) {
 			os.Exit(1)
 		}
 
-		saveKeys(privP, pubP)
+		saveKeys(privP, pubP, cfg.Provider.PrivateKeyFile(), cfg.Provider.PublicKeyFile())
 	}
 
-	providerServer, err := provider.NewProviderServer(*id, *host, *port, privP, pubP)
+	providerServer, err := provider.NewProviderServer(cfg, privP, pubP, acceptedVersions)
 	if err != nil {
 		panic(err)
 	}
 
+	go drainOnSignal(providerServer, cfg.Debug.DrainTimeoutDuration())
+
 	err = providerServer.Start()
 	if err != nil {
 		panic(err)
 	}
 
-	wait := make(chan struct{})
-	<-wait
+	providerServer.Wait()
+}
+
+// drainOnSignal drains the provider once it receives SIGTERM or SIGINT,
+// and shuts it down straight away if another one arrives in the meantime.
+func drainOnSignal(providerServer *provider.ProviderServer, timeout time.Duration) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+	fmt.Fprintf(os.Stdout, "Draining the provider for at most %v. "+
+		"Send the signal again to stop it straight away\n", timeout)
+	go func() {
+		<-signals
+		providerServer.Shutdown()
+	}()
+	providerServer.Drain(timeout)
 }
 
 func newOpts(command string, usage string) *optparse.Parser {
@@ -0,0 +1,117 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nymtech/nym-mixnet/constants"
+	"github.com/nymtech/nym-mixnet/helpers"
+	serverConfig "github.com/nymtech/nym-mixnet/server/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+const (
+	defaultHost  = ""
+	defaultPort  = "1789"
+	defaultLayer = serverConfig.UnassignedLayer
+)
+
+func cmdInit(args []string, usage string) {
+	opts := newOpts("init [OPTIONS]", usage)
+	id := opts.Flags("--id").Label("ID").String("Id of the nym-mixnode we want to create config for", "")
+	host := opts.Flags("--host").Label("HOST").String("The host on which the nym-mixnode is running", defaultHost)
+	port := opts.Flags("--port").Label("PORT").String("Port on which nym-mixnode listens", defaultPort)
+	layer := opts.Flags("--layer").Label("Layer").Int("Mixnet layer of this particular node. If -1, the node "+
+		"registers in the least populated layer of the network when it starts", defaultLayer)
+	announce := opts.Flags("--announce").Label("ADDRESS").String("The host:port address announced to the directory "+
+		"server, if it differs from the listening address", "")
+	local := opts.Flags("--local").Label("LOCAL").Bool("Flag to indicate whether the mixnode is expected " +
+		"to run on the local mixnet deployment")
+
+	params := opts.Parse(args)
+	if len(params) != 0 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+
+	var mixNodeID string
+	if len(*id) == 0 {
+		randomID := helpers.RandomString(8)
+		fmt.Fprintf(os.Stdout, "No mixnodeID provided. Random string will be used instead: %v.\n", randomID)
+		mixNodeID = randomID
+	} else {
+		mixNodeID = *id
+	}
+
+	cfg, err := serverConfig.DefaultMixNodeConfig(mixNodeID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create config: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.MixNode.Host = *host
+	cfg.MixNode.Port = *port
+	cfg.MixNode.Layer = *layer
+	cfg.MixNode.AnnounceAddress = *announce
+	if *local {
+		fmt.Fprintf(os.Stdout, "Using the local directory server\n")
+		cfg.MixNode.DirectoryServer = serverConfig.DefaultLocalDirectoryServer
+	}
+
+	configPath, err := serverConfig.DefaultMixNodeConfigPath(mixNodeID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get default config path for %v: %v\n", mixNodeID, err)
+		os.Exit(1)
+	}
+
+	// never overwrite the existing identity of the mixnode
+	cfgExists, err := helpers.DirExists(configPath)
+	if cfgExists || err != nil {
+		fmt.Fprintf(os.Stderr, "The mixnode %v seems to be already initialised at %v\n", mixNodeID, configPath)
+		os.Exit(1)
+	}
+
+	configDir, _ := filepath.Split(configPath)
+	if err := helpers.EnsureDir(configDir, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create mixnode directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate sphinx keypair: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := helpers.ToPEMFile(priv, cfg.MixNode.PrivateKeyFile(), constants.PrivateKeyPEMType); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save private key: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "Saved generated private key to %v\n", cfg.MixNode.PrivateKeyFile())
+
+	if err := helpers.ToPEMFile(pub, cfg.MixNode.PublicKeyFile(), constants.PublicKeyPEMType); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save public key: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "Saved generated public key to %v\n", cfg.MixNode.PublicKeyFile())
+
+	if err := serverConfig.WriteConfigFile(configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write config to a file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "Saved generated config to %v\n", configPath)
+}
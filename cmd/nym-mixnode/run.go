@@ -15,9 +15,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/nymtech/nym-mixnet/constants"
 	"github.com/nymtech/nym-mixnet/helpers"
+	serverConfig "github.com/nymtech/nym-mixnet/server/config"
 	"github.com/nymtech/nym-mixnet/server/mixnode"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/tav/golly/optparse"
@@ -25,19 +33,23 @@ import (
 
 const (
 	// PkiDb is the location of the database file, relative to the project root. TODO: move this to homedir.
-	PkiDb        = "pki/database.db"
-	defaultHost  = ""
-	defaultID    = "Mix1"
-	defaultPort  = "1789"
-	defaultLayer = -1
+	PkiDb     = "pki/database.db"
+	defaultID = "Mix1"
 )
 
 func cmdRun(args []string, usage string) {
 	opts := newOpts("run [OPTIONS]", usage)
 	id := opts.Flags("--id").Label("ID").String("Id of the nym-mixnode we want to run", defaultID)
-	host := opts.Flags("--host").Label("HOST").String("The host on which the nym-mixnode is running", defaultHost)
-	port := opts.Flags("--port").Label("PORT").String("Port on which nym-mixnode listens", defaultPort)
-	layer := opts.Flags("--layer").Label("Layer").Int("Mixnet layer of this particular node", defaultLayer)
+	customCfg := opts.Flags("--config").Label("CONFIG").String("Path to the config file of the nym-mixnode, "+
+		"instead of the default one of the mixnode with the given id", "")
+	metricsAddress := opts.Flags("--metrics").Label("ADDRESS").String("The host:port address on which the metrics "+
+		"are served under /metrics, instead of the one in the config file", "")
+	impair := opts.Flags("--impair").Label("SPEC").String("Impair the forwarded packets to emulate an unreliable "+
+		"network, only in the local networks, e.g. drop=0.1,latency=50ms,jitter=20ms,duplicate=0.05,reorder=0.1,"+
+		"corrupt=0.01,seed=42. It replaces the impairment in the config file", "")
+	versions := opts.Flags("--versions").Label("VERSIONS").String("Comma separated list of accepted sphinx packet versions",
+		sphinx.FormatVersions(sphinx.DefaultRegistry.Versions()),
+	)
 
 	params := opts.Parse(args)
 	if len(params) != 0 {
@@ -45,25 +57,71 @@ func cmdRun(args []string, usage string) {
 		os.Exit(1)
 	}
 
-	ip, err := helpers.GetLocalIP()
+	acceptedVersions, err := sphinx.ParseVersions(*versions)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "invalid packet versions: %v\n", err)
+		os.Exit(1)
+	}
+
+	configPath := *customCfg
+	if len(configPath) == 0 {
+		configPath, err = serverConfig.DefaultMixNodeConfigPath(*id)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	cfgExists, err := helpers.DirExists(configPath)
+	if !cfgExists || err != nil {
+		fmt.Fprintf(os.Stderr, "The configuration file at %v does not seem to exist. "+
+			"Create it with 'nym-mixnode init --id %v' first\n", configPath, *id)
+		os.Exit(1)
+	}
+
+	cfg, err := serverConfig.LoadFile(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load the config file: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.MixNode == nil {
+		fmt.Fprintf(os.Stderr, "The config file at %v is not the config of a mixnode\n", configPath)
+		os.Exit(1)
+	}
+
+	if len(*metricsAddress) > 0 {
+		cfg.MixNode.MetricsAddress = *metricsAddress
+	}
+
+	if len(*impair) > 0 {
+		cfg.MixNode.Impairment = *impair
+		if _, err := cfg.MixNode.ImpairmentConfig(); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid impairment: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	if host == nil || len(*host) < 7 {
-		host = &ip
+	privM, pubM, err := loadKeys(cfg.MixNode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load the keys of the mixnode: %v\n", err)
+		os.Exit(1)
 	}
 
-	pubM, privM, err := sphinx.GenerateKeyPair()
+	ip, err := helpers.GetLocalIP()
 	if err != nil {
 		panic(err)
 	}
 
-	mixServer, err := mixnode.NewMixServer(*id, *host, *port, pubM, privM, *layer)
+	if len(cfg.MixNode.Host) < 7 {
+		cfg.MixNode.Host = ip
+	}
+
+	mixServer, err := mixnode.NewMixServer(cfg, privM, pubM, acceptedVersions)
 	if err != nil {
 		panic(err)
 	}
 
+	go drainOnSignal(mixServer, cfg.Debug.DrainTimeoutDuration())
+
 	if err := mixServer.Start(); err != nil {
 		panic(err)
 	}
@@ -71,6 +129,42 @@ func cmdRun(args []string, usage string) {
 	mixServer.Wait()
 }
 
+// loadKeys loads the stored identity of the mixnode and checks that its public key matches the private key.
+func loadKeys(cfg *serverConfig.MixNode) (*sphinx.PrivateKey, *sphinx.PublicKey, error) {
+	priv := new(sphinx.PrivateKey)
+	if err := helpers.FromPEMFile(priv, cfg.PrivateKeyFile(), constants.PrivateKeyPEMType); err != nil {
+		return nil, nil, err
+	}
+	pub := new(sphinx.PublicKey)
+	if err := helpers.FromPEMFile(pub, cfg.PublicKeyFile(), constants.PublicKeyPEMType); err != nil {
+		return nil, nil, err
+	}
+
+	derivedPub, err := sphinx.X25519.ScalarBaseMult(priv.ToFieldElement())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(derivedPub.Bytes(), pub.Bytes()) {
+		return nil, nil, errors.New("the public key does not match the private key")
+	}
+	return priv, pub, nil
+}
+
+// drainOnSignal drains the mixnode once it receives SIGTERM or SIGINT,
+// and shuts it down straight away if another one arrives in the meantime.
+func drainOnSignal(mixServer *mixnode.MixServer, timeout time.Duration) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+	fmt.Fprintf(os.Stdout, "Draining the mixnode for at most %v. "+
+		"Send the signal again to stop it straight away\n", timeout)
+	go func() {
+		<-signals
+		mixServer.Shutdown()
+	}()
+	mixServer.Drain(timeout)
+}
+
 func newOpts(command string, usage string) *optparse.Parser {
 	return optparse.New("Usage: nym-mixnode " + command + "\n\n  " + usage + "\n")
 }
@@ -0,0 +1,288 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/nymtech/nym-mixnet/constants"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/networker"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+const (
+	frameSphinx           = "sphinx"
+	frameFrame            = "frame"
+	frameProviderResponse = "provider-response"
+)
+
+// inspectKey is the private key of a node, which the inspected packets might be addressed to.
+type inspectKey struct {
+	file string
+	priv *sphinx.PrivateKey
+}
+
+// inspector peels the sphinx packets layer by layer with all the known keys and describes every hop.
+type inspector struct {
+	keys  []inspectKey
+	suite sphinx.Suite
+	out   io.Writer
+}
+
+func cmdInspect(args []string, usage string) {
+	opts := newOpts("inspect [OPTIONS] PRIVATE_KEY_FILE...", usage)
+	in := opts.Flags("--in").Label("FILE").String(
+		"File containing the hex or base64 encoded dump, instead of the standard input", "")
+	frame := opts.Flags("--frame").Label("FRAME").String(
+		"Framing of the dump: sphinx, frame or provider-response", frameSphinx)
+	suiteName := opts.Flags("--suite").Label("SUITE").String(
+		"Sphinx suite of the packet: x25519 or p256", sphinx.X25519.Name())
+
+	keyFiles := opts.Parse(args)
+	if len(keyFiles) == 0 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+
+	suite, err := sphinx.ParseSuite(*suiteName)
+	if err != nil {
+		exitWithInspectError(err)
+	}
+
+	keys := make([]inspectKey, len(keyFiles))
+	for i, f := range keyFiles {
+		priv := new(sphinx.PrivateKey)
+		if err := helpers.FromPEMFile(priv, f, constants.PrivateKeyPEMType); err != nil {
+			exitWithInspectError(fmt.Errorf("failed to load the private key from %v: %v", f, err))
+		}
+		keys[i] = inspectKey{file: f, priv: priv}
+	}
+
+	var dump []byte
+	if *in == "" {
+		dump, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		dump, err = ioutil.ReadFile(*in)
+	}
+	if err != nil {
+		exitWithInspectError(err)
+	}
+	b, err := decodeDump(dump)
+	if err != nil {
+		exitWithInspectError(err)
+	}
+
+	insp := &inspector{keys: keys, suite: suite, out: os.Stdout}
+	switch *frame {
+	case frameSphinx:
+		err = insp.inspectSphinx(b, "")
+	case frameFrame:
+		err = insp.inspectFramePacket(b, "")
+	case frameProviderResponse:
+		err = insp.inspectProviderResponse(b)
+	default:
+		err = fmt.Errorf("unknown frame: %q", *frame)
+	}
+	if err != nil {
+		exitWithInspectError(err)
+	}
+}
+
+// decodeDump decodes the hex or base64 encoded dump, ignoring any whitespace in it.
+func decodeDump(dump []byte) ([]byte, error) {
+	s := strings.Join(strings.Fields(string(dump)), "")
+	if b, err := hex.DecodeString(strings.TrimPrefix(s, "0x")); err == nil {
+		return b, nil
+	}
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New("the dump is neither hex nor base64 encoded")
+}
+
+func (insp *inspector) inspectProviderResponse(b []byte) error {
+	frame, err := networker.DecodeFrame(b)
+	if err != nil {
+		return fmt.Errorf("failed to decode the frame of the provider response: %v", err)
+	}
+	packets, err := networker.DecodeProviderResponse(frame)
+	if err != nil {
+		return fmt.Errorf("failed to decode the provider response: %v", err)
+	}
+	fmt.Fprintf(insp.out, "provider response: %d packets\n", len(packets))
+
+	failed := 0
+	for i := range packets {
+		fmt.Fprintf(insp.out, "packet %d:\n", i+1)
+		if err := insp.inspectFrame(packets[i], "  "); err != nil {
+			fmt.Fprintf(insp.out, "  error: %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to inspect %d of %d packets", failed, len(packets))
+	}
+	return nil
+}
+
+func (insp *inspector) inspectFramePacket(b []byte, indent string) error {
+	frame, err := networker.DecodeFrame(b)
+	if err != nil {
+		return fmt.Errorf("failed to decode the frame: %v", err)
+	}
+	return insp.inspectFrame(frame, indent)
+}
+
+func (insp *inspector) inspectFrame(frame networker.Frame, indent string) error {
+	fmt.Fprintf(insp.out, "%sframe: flag %s, %d bytes of data\n", indent, packetTypeName(frame.Flag), len(frame.Body))
+	switch frame.Flag {
+	case flags.CommFlag:
+		return insp.inspectSphinx(frame.Body, indent+"  ")
+	case flags.ReplyFlag:
+		firstHop, packet, err := networker.DecodeReplyFrame(frame)
+		if err != nil {
+			return fmt.Errorf("failed to decode the reply: %v", err)
+		}
+		fmt.Fprintf(insp.out, "%s  reply to be relayed to: %s (%s)\n", indent, firstHop.Id, firstHop.Address)
+		return insp.inspectSphinx(packet, indent+"  ")
+	default:
+		return nil
+	}
+}
+
+// inspectSphinx processes the packet hop by hop for as long as any of the keys can process it,
+// and returns the error describing the hop at which the processing failed.
+func (insp *inspector) inspectSphinx(packetBytes []byte, indent string) error {
+	version, err := sphinx.PacketVersion(packetBytes)
+	if err != nil {
+		return fmt.Errorf("invalid sphinx packet: %v", err)
+	}
+	registry := sphinx.DefaultRegistry
+	if version == sphinx.VersionLegacy {
+		fmt.Fprintf(insp.out, "%ssphinx packet: version %v, %d bytes\n", indent, version, len(packetBytes))
+	} else {
+		params, err := sphinx.ParamsForPacketLength(len(packetBytes), insp.suite)
+		if err != nil {
+			return fmt.Errorf("invalid sphinx packet: %v", err)
+		}
+		fmt.Fprintf(insp.out, "%ssphinx packet: version %v, %d bytes, suite %s, maximum path length %d\n",
+			indent, version, len(packetBytes), insp.suite.Name(), params.MaxPathLength)
+		registry = sphinx.NewStandardRegistry(params)
+	}
+
+	for hop := 1; ; hop++ {
+		key, nextHop, commands, newPacketBytes, err := insp.processHop(registry, packetBytes)
+		if err != nil {
+			// the packet which no key can process might as well be already fully processed
+			if hop > 1 || err != sphinx.ErrInvalidMac {
+				return insp.reportFailure(indent, hop, err)
+			}
+			message, extractErr := registry.ExtractMessage(packetBytes)
+			if extractErr != nil {
+				return insp.reportFailure(indent, hop, err)
+			}
+			fmt.Fprintf(insp.out, "%sfully processed packet, message: %q\n", indent, message)
+			return nil
+		}
+
+		fmt.Fprintf(insp.out, "%shop %d: processed with %s\n", indent, hop, key.file)
+		fmt.Fprintf(insp.out, "%s  next hop: %s (%s)\n", indent, nextHop.Id, nextHop.Address)
+		fmt.Fprintf(insp.out, "%s  commands: %v\n", indent, commands)
+		fmt.Fprintf(insp.out, "%s  delay:    %vs\n", indent, commands.Delay())
+		fmt.Fprintf(insp.out, "%s  MAC:      valid\n", indent)
+		packetBytes = newPacketBytes
+
+		switch commands.Action().(type) {
+		case sphinx.RelayCommand:
+			continue
+		case sphinx.DeliverSURBAckCommand:
+			fmt.Fprintf(insp.out, "%s  payload:  encrypted for the creator of the reply block\n", indent)
+			return nil
+		}
+		message, err := registry.ExtractMessage(packetBytes)
+		if err != nil {
+			fmt.Fprintf(insp.out, "%s  payload:  %v\n", indent, err)
+			return fmt.Errorf("failed to extract the message after hop %d: %v", hop, err)
+		}
+		fmt.Fprintf(insp.out, "%s  message:  %q\n", indent, message)
+		return nil
+	}
+}
+
+// reportFailure describes the hop at which the processing failed and returns the corresponding error.
+func (insp *inspector) reportFailure(indent string, hop int, err error) error {
+	fmt.Fprintf(insp.out, "%shop %d: processing failed\n", indent, hop)
+	if err == sphinx.ErrInvalidMac {
+		fmt.Fprintf(insp.out, "%s  MAC:      invalid with each of the %d keys\n", indent, len(insp.keys))
+	} else {
+		fmt.Fprintf(insp.out, "%s  error:    %v\n", indent, err)
+	}
+	return fmt.Errorf("processing failed at hop %d: %v", hop, err)
+}
+
+// processHop processes the packet with every key in turn until any of them matches the MAC of the header.
+// If none of them does, it returns sphinx.ErrInvalidMac.
+func (insp *inspector) processHop(registry *sphinx.Registry, packetBytes []byte,
+) (inspectKey, sphinx.Hop, sphinx.CommandSet, []byte, error) {
+	var lastErr error
+	for _, key := range insp.keys {
+		hop, commands, newPacketBytes, err := registry.ProcessPacket(packetBytes, key.priv, nil)
+		if err == nil {
+			return key, hop, commands, newPacketBytes, nil
+		}
+		// the error other than the invalid MAC is more helpful, as it means the key was probably right
+		if lastErr == nil || err != sphinx.ErrInvalidMac {
+			lastErr = err
+		}
+	}
+	return inspectKey{}, sphinx.Hop{}, nil, nil, lastErr
+}
+
+func packetTypeName(flag flags.PacketTypeFlag) string {
+	switch flag {
+	case flags.AssignFlag:
+		return "assign"
+	case flags.CommFlag:
+		return "comm"
+	case flags.TokenFlag:
+		return "token"
+	case flags.PullFlag:
+		return "pull"
+	case flags.ResponseFlag:
+		return "response"
+	case flags.ReplyFlag:
+		return "reply"
+	default:
+		return "invalid"
+	}
+}
+
+func exitWithInspectError(err error) {
+	fmt.Fprintf(os.Stderr, "failed to inspect the packet: %v\n", err)
+	os.Exit(1)
+}
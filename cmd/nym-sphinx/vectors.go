@@ -0,0 +1,134 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+const (
+	defaultSeed = "nym-sphinx-test-vectors"
+)
+
+// vectorCase describes the inputs of a single generated test vector.
+type vectorCase struct {
+	description string
+	params      sphinx.Params
+	pathLength  int
+	message     string
+}
+
+// nolint: gochecknoglobals
+var vectorCases = []vectorCase{
+	{"single hop", sphinx.DefaultParams, 1, "Hello, Nym!"},
+	{"three hops", sphinx.DefaultParams, 3, "The quick brown fox jumps over the lazy dog"},
+	{"maximum path length", sphinx.DefaultParams, sphinx.DefaultMaxPathLength, "Hello, Nym!"},
+	{"shorter maximum path length", sphinx.Params{MaxPathLength: 3}, 2, ""},
+	{"p256 suite", sphinx.Params{MaxPathLength: sphinx.DefaultMaxPathLength, Suite: sphinx.P256}, 3, "Hello, Nym!"},
+}
+
+func cmdVectors(args []string, usage string) {
+	opts := newOpts("vectors [OPTIONS]", usage)
+	seed := opts.Flags("--seed").Label("SEED").String("Seed of the randomness used for generating the vectors", defaultSeed)
+	out := opts.Flags("--out").Label("FILE").String("File the vectors are written to, instead of the standard output", "")
+
+	params := opts.Parse(args)
+	if len(params) != 0 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+
+	rng, err := seededReader(*seed)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	vectors := make([]sphinx.TestVector, len(vectorCases))
+	for i, c := range vectorCases {
+		vectors[i], err = generateVector(c, rng)
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+
+	b, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		exitWithError(err)
+	}
+	b = append(b, '\n')
+
+	if *out == "" {
+		_, err = os.Stdout.Write(b)
+	} else {
+		err = ioutil.WriteFile(*out, b, 0644)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// seededReader returns the deterministic stream of pseudo-random bytes, i.e. the AES-CTR keystream
+// keyed by the hash of the seed, so that the same vectors are generated for the same seed.
+func seededReader(seed string) (io.Reader, error) {
+	key := sha256.Sum256([]byte(seed))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	stream := cipher.NewCTR(block, make([]byte, aes.BlockSize))
+	return cipher.StreamReader{S: stream, R: zeroReader{}}, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func generateVector(c vectorCase, rng io.Reader) (sphinx.TestVector, error) {
+	privs := make([]*sphinx.PrivateKey, c.pathLength)
+	nodes := make([]config.MixConfig, c.pathLength)
+	delays := make([]float64, c.pathLength)
+	for i := range nodes {
+		priv, pub, err := c.params.CryptoSuite().GenerateKeyPair(rng)
+		if err != nil {
+			return sphinx.TestVector{}, err
+		}
+		privs[i] = priv
+		nodes[i] = config.NewMixConfig(fmt.Sprintf("Node%d", i+1), "127.0.0.1", fmt.Sprintf("%d", 1789+i), pub.Bytes(), uint(i+1))
+		delays[i] = 0.25 * float64(i+1)
+	}
+	recipient := config.ClientConfig{Id: "Recipient", Host: "127.0.0.1", Port: "9001"}
+
+	return sphinx.GenerateTestVector(c.params, c.description, privs, nodes, recipient, delays, []byte(c.message), rng)
+}
+
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "failed to generate the test vectors: %v\n", err)
+	os.Exit(1)
+}
@@ -19,12 +19,17 @@
 
 package config
 
-import (
-	"github.com/golang/protobuf/proto"
-	"github.com/nymtech/nym-mixnet/flags"
-)
-
 const (
+	// DirectoryServerURL is the base URL of the directory server of the public mixnet.
+	DirectoryServerURL = "https://directory.nymtech.net"
+	// LocalDirectoryServerURL is the base URL of the directory server of the local mixnet deployment.
+	LocalDirectoryServerURL = "http://localhost:8080"
+
+	DirectoryServerMetricsPath             = "/api/metrics/mixes"
+	DirectoryServerMixPresencePath         = "/api/presence/mixnodes"
+	DirectoryServerMixProviderPresencePath = "/api/presence/mixproviders"
+	DirectoryServerTopologyPath            = "/api/presence/topology"
+
 	DirectoryServerHealthcheckURL         = "https://directory.nymtech.net/api/healthcheck"
 	DirectoryServerMetricsURL             = "https://directory.nymtech.net/api/metrics/mixes"
 	DirectoryServerPkiURL                 = "https://directory.nymtech.net/api/nodes"
@@ -55,17 +60,6 @@ func NewClientConfig(clientID, host, port string, pubKey []byte, providerInfo Mi
 	return client
 }
 
-// WrapWithFlag packs the given byte information together with a specified flag into the
-// packet.
-func WrapWithFlag(flag flags.PacketTypeFlag, data []byte) ([]byte, error) {
-	m := GeneralPacket{Flag: flag.Bytes(), Data: data}
-	mBytes, err := proto.Marshal(&m)
-	if err != nil {
-		return nil, err
-	}
-	return mBytes, nil
-}
-
 // E2EPath holds end to end path data for an entire route, prior to Sphinx header encryption
 type E2EPath struct {
 	IngressProvider MixConfig
@@ -74,17 +68,16 @@ type E2EPath struct {
 	Recipient       ClientConfig
 }
 
-// Len adds 3 to the mix path. TODO: why? Check this with Ania.
+// Len returns the number of nodes on the path, i.e. the mixes and both providers.
 func (p *E2EPath) Len() int {
-	return 3 + len(p.Mixes)
+	return 2 + len(p.Mixes)
 }
 
-func UnmarshalProviderResponse(resp ProviderResponse) ([]GeneralPacket, error) {
-	packets := make([]GeneralPacket, resp.NumberOfPackets)
-	for i, packet := range resp.Packets {
-		if err := proto.Unmarshal(packet, &packets[i]); err != nil {
-			return nil, err
-		}
-	}
-	return packets, nil
+// Nodes returns the sequence of nodes the packet traverses before reaching the recipient.
+func (p *E2EPath) Nodes() []MixConfig {
+	nodes := make([]MixConfig, 0, p.Len())
+	nodes = append(nodes, p.IngressProvider)
+	nodes = append(nodes, p.Mixes...)
+	nodes = append(nodes, p.EgressProvider)
+	return nodes
 }
@@ -21,6 +21,8 @@ package flags
 
 // SphinxFlag represents flag present in all sphinx packages to indicate whether the packet has reached
 // its final hop or should be relayed.
+// The current packet format encodes the typed routing commands instead, so the flags are only present
+// in the packets of the legacy format.
 type SphinxFlag byte
 
 const (
@@ -29,6 +31,10 @@ const (
 	// RelayFlag denotes whether this message should continue further along the path of mixes.
 	// This is implementation-specific rather than being part of the Loopix protocol design.
 	RelayFlag SphinxFlag = '\xf1'
+	// ReplyLastHopFlag denotes whether this reply message, sent using a single-use reply block,
+	// has reached its final destination. Unlike with LastHopFlag, the final hop cannot verify
+	// the integrity of the payload as it is only decrypted by the recipient.
+	ReplyLastHopFlag SphinxFlag = '\xf2'
 	// InvalidFlag denotes an invalid sphinx flag.
 	InvalidSphinxFlag SphinxFlag = '\x00'
 )
@@ -43,6 +49,8 @@ func SphinxFlagFromByte(b byte) SphinxFlag {
 		return LastHopFlag
 	case byte(RelayFlag):
 		return RelayFlag
+	case byte(ReplyLastHopFlag):
+		return ReplyLastHopFlag
 	default:
 		return InvalidSphinxFlag
 	}
@@ -69,6 +77,12 @@ const (
 	TokenFlag PacketTypeFlag = '\xa9'
 	// PullFlag is used to indicate client request to obtain all its messages stored at a particular provider.
 	PullFlag PacketTypeFlag = '\xff'
+	// ResponseFlag is used to indicate that the packet contains the response of the provider to the client,
+	// which carries the packets the client asked for.
+	ResponseFlag PacketTypeFlag = '\xa5'
+	// ReplyFlag is used to indicate that the packet contains a sphinx packet sent using a single-use reply block,
+	// which the provider of the replier should relay to the first hop of the reply block.
+	ReplyFlag PacketTypeFlag = '\xa7'
 	// InvalidFlag is used to indicate an invalid packet type flag.
 	InvalidPacketTypeFlag PacketTypeFlag = '\x00'
 )
@@ -87,6 +101,10 @@ func PacketTypeFlagFromByte(b byte) PacketTypeFlag {
 		return TokenFlag
 	case byte(PullFlag):
 		return PullFlag
+	case byte(ResponseFlag):
+		return ResponseFlag
+	case byte(ReplyFlag):
+		return ReplyFlag
 	default:
 		return InvalidPacketTypeFlag
 	}
@@ -19,8 +19,13 @@
 package helpers
 
 import (
+	crand "crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"io"
+	"math"
+	"math/big"
 	"math/rand"
 	"time"
 
@@ -46,6 +51,18 @@ func RandomMix(mixes []config.MixConfig) config.MixConfig {
 	return mixes[rand.Intn(len(mixes))]
 }
 
+// RandomMixFrom returns a single mix chosen uniformly from given slice of mixes, using the randomness read from rng.
+func RandomMixFrom(rng io.Reader, mixes []config.MixConfig) (config.MixConfig, error) {
+	if len(mixes) == 0 {
+		return config.MixConfig{}, ErrPermEmptyList
+	}
+	i, err := crand.Int(rng, big.NewInt(int64(len(mixes))))
+	if err != nil {
+		return config.MixConfig{}, err
+	}
+	return mixes[i.Int64()], nil
+}
+
 // a very dummy implementation of getting "random" string of given length
 // could be improved in number of ways but for the test sake it's good enough
 func RandomString(length int) string {
@@ -64,6 +81,21 @@ func RandomExponential(expParam float64) (float64, error) {
 	return rand.ExpFloat64() / expParam, nil
 }
 
+// RandomExponentialFrom returns a sample from the exponential distribution with the given rate parameter,
+// using the randomness read from rng.
+func RandomExponentialFrom(rng io.Reader, expParam float64) (float64, error) {
+	if expParam <= 0.0 {
+		return 0.0, ErrExponentialDistributionParam
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(rng, b[:]); err != nil {
+		return 0.0, err
+	}
+	// uniform sample from [0, 1) with the full precision of float64
+	u := float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
+	return -math.Log(1-u) / expParam, nil
+}
+
 // SHA256 computes the hash value of a given argument using SHA256 algorithm.
 func SHA256(arg []byte) ([]byte, error) {
 	h := sha256.New()
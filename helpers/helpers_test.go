@@ -15,6 +15,7 @@
 package helpers
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"reflect"
@@ -127,3 +128,45 @@ func TestRandomExponential_Fail_NegativeParam(t *testing.T) {
 		" RandomExponential should return an error if the given parameter is non-positive",
 	)
 }
+
+func TestRandomExponentialFrom_Deterministic(t *testing.T) {
+	randomness := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	val1, err := RandomExponentialFrom(bytes.NewReader(randomness), 5.0)
+	assert.Nil(t, err)
+	val2, err := RandomExponentialFrom(bytes.NewReader(randomness), 5.0)
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val2)
+	assert.True(t, val1 >= 0)
+
+	_, err = RandomExponentialFrom(bytes.NewReader(randomness), 0.0)
+	assert.Equal(t, ErrExponentialDistributionParam, err)
+
+	_, err = RandomExponentialFrom(bytes.NewReader(nil), 5.0)
+	assert.Error(t, err)
+}
+
+func TestRandomMixFrom(t *testing.T) {
+	mixes := []config.MixConfig{{Id: "Mix1"}, {Id: "Mix2"}, {Id: "Mix3"}}
+	randomness := make([]byte, 64)
+	for i := range randomness {
+		randomness[i] = byte(i * 37)
+	}
+
+	mix1, err := RandomMixFrom(bytes.NewReader(randomness), mixes)
+	assert.Nil(t, err)
+	mix2, err := RandomMixFrom(bytes.NewReader(randomness), mixes)
+	assert.Nil(t, err)
+	assert.Equal(t, mix1, mix2)
+	assert.Contains(t, mixes, mix1)
+
+	_, err = RandomMixFrom(bytes.NewReader(randomness), nil)
+	assert.Equal(t, ErrPermEmptyList, err)
+}
+
+func TestDirectoryServerFor(t *testing.T) {
+	assert.Equal(t, config.LocalDirectoryServerURL, DirectoryServerFor("localhost:9980"))
+	assert.Equal(t, config.LocalDirectoryServerURL, DirectoryServerFor("127.0.0.1"))
+	assert.Equal(t, config.LocalDirectoryServerURL, DirectoryServerFor("[::1]:9980"))
+	assert.Equal(t, config.DirectoryServerURL, DirectoryServerFor("10.0.0.1:1789"))
+	assert.Equal(t, config.DirectoryServerURL, DirectoryServerFor(""))
+}
@@ -28,6 +28,8 @@ import (
 
 	"github.com/nymtech/nym-directory/models"
 	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+	"github.com/nymtech/nym-mixnet/node"
 	"github.com/nymtech/nym-mixnet/sphinx"
 )
 
@@ -78,9 +80,35 @@ func GetLocalIP() (string, error) {
 	return "", ErrInvalidLocalIP
 }
 
+// DirectoryServerFor returns the base URL of the directory server used by the node with the given address,
+// which is the local directory server for the nodes on the loopback interface. The address can omit the port.
+func DirectoryServerFor(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if host == "localhost" || net.ParseIP(host).IsLoopback() {
+		return config.LocalDirectoryServerURL
+	}
+	return config.DirectoryServerURL
+}
+
+func directoryServerForHost(host []string) string {
+	if len(host) == 1 {
+		return DirectoryServerFor(host[0])
+	}
+	return config.DirectoryServerURL
+}
+
 // RegisterMixNodePresence registers server presence at the directory server.
 func RegisterMixNodePresence(publicKey *sphinx.PublicKey, layer int, host ...string) error {
 	b64Key := base64.URLEncoding.EncodeToString(publicKey.Bytes())
+	return RegisterMixNodePresenceAt(directoryServerForHost(host), b64Key, layer, host...)
+}
+
+// RegisterMixNodePresenceAt registers server presence with the given public key, as returned by PresenceKeys,
+// at the directory server with the given base URL.
+func RegisterMixNodePresenceAt(directory string, b64Key string, layer int, host ...string) error {
 	values := map[string]interface{}{"pubKey": b64Key, "layer": layer}
 	if len(host) == 1 {
 		values["host"] = host[0]
@@ -90,18 +118,7 @@ func RegisterMixNodePresence(publicKey *sphinx.PublicKey, layer int, host ...str
 		return err
 	}
 
-	endpoint := config.DirectoryServerMixPresenceURL
-	if len(host) == 1 && len(host[0]) > 0 {
-		ip, _, err := net.SplitHostPort(host[0])
-		if err == nil && (ip == "localhost" || net.ParseIP(ip).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMixPresenceURL
-		} else if err != nil && err.Error() == "missing port in address" &&
-			(host[0] == "localhost" || net.ParseIP(host[0]).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMixPresenceURL
-		}
-	}
-
-	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonValue))
+	resp, err := http.Post(directory+config.DirectoryServerMixPresencePath, "application/json", bytes.NewBuffer(jsonValue))
 	if err != nil {
 		return err
 	}
@@ -109,46 +126,46 @@ func RegisterMixNodePresence(publicKey *sphinx.PublicKey, layer int, host ...str
 	_ = resp
 	// TODO: properly parse it, etc.
 
-	// body, err := ioutil.ReadAll(resp.Body)
-	// if err != nil {
-	// 	return err
-	// }
-	// fmt.Println(string(body))
-	// _ = resp
 	return nil
 }
 
+// PresenceKeys returns the public keys of the presences the node announces: its long-term key or, if it rotates
+// its keys, the keys of the presences announcing its epoch keys, which carry the long-term key as well.
+func PresenceKeys(publicKey *sphinx.PublicKey, epochKeys []node.EpochKey) []string {
+	identity := base64.URLEncoding.EncodeToString(publicKey.Bytes())
+	if len(epochKeys) == 0 {
+		return []string{identity}
+	}
+	keys := make([]string, len(epochKeys))
+	for i, key := range epochKeys {
+		keys[i] = topology.EpochKeyInfo{Identity: identity,
+			Epoch:  key.Epoch,
+			Start:  key.Start.UnixNano(),
+			End:    key.End.UnixNano(),
+			PubKey: base64.URLEncoding.EncodeToString(key.PubKey.Bytes()),
+		}.PresenceKey()
+	}
+	return keys
+}
+
 // SendMixMetrics sends the mixnode related packet metrics to the directory server.
 func SendMixMetrics(metric models.MixMetric, host ...string) error {
+	return SendMixMetricsAt(directoryServerForHost(host), metric)
+}
+
+// SendMixMetricsAt sends the mixnode related packet metrics to the directory server with the given base URL.
+func SendMixMetricsAt(directory string, metric models.MixMetric) error {
 	values := map[string]interface{}{"sent": metric.Sent, "pubKey": metric.PubKey, "received": metric.Received}
 	jsonValue, err := json.Marshal(values)
 	if err != nil {
 		return err
 	}
 
-	endpoint := config.DirectoryServerMetricsURL
-	if len(host) == 1 && len(host[0]) > 0 {
-		ip, _, err := net.SplitHostPort(host[0])
-		if err == nil && (ip == "localhost" || net.ParseIP(ip).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMetricsURL
-		} else if err != nil && err.Error() == "missing port in address" &&
-			(host[0] == "localhost" || net.ParseIP(host[0]).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMetricsURL
-		}
-	}
-
-	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonValue))
+	resp, err := http.Post(directory+config.DirectoryServerMetricsPath, "application/json", bytes.NewBuffer(jsonValue))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-
-	// body, err := ioutil.ReadAll(resp.Body)
-	// if err != nil {
-	// 	return err
-	// }
-	// fmt.Println(string(body))
-	// _ = resp
 	// TODO: properly parse it, etc.
 
 	return nil
@@ -157,6 +174,16 @@ func SendMixMetrics(metric models.MixMetric, host ...string) error {
 // RegisterMixProviderPresence registers server presence at the directory server.
 func RegisterMixProviderPresence(publicKey *sphinx.PublicKey, clients []models.RegisteredClient, host ...string) error {
 	b64Key := base64.URLEncoding.EncodeToString(publicKey.Bytes())
+	return RegisterMixProviderPresenceAt(directoryServerForHost(host), b64Key, clients, host...)
+}
+
+// RegisterMixProviderPresenceAt registers server presence with the given public key, as returned by PresenceKeys,
+// at the directory server with the given base URL.
+func RegisterMixProviderPresenceAt(directory string,
+	b64Key string,
+	clients []models.RegisteredClient,
+	host ...string,
+) error {
 	values := map[string]interface{}{"pubKey": b64Key, "registeredClients": clients}
 	if len(host) == 1 {
 		values["host"] = host[0]
@@ -166,17 +193,7 @@ func RegisterMixProviderPresence(publicKey *sphinx.PublicKey, clients []models.R
 		return err
 	}
 
-	endpoint := config.DirectoryServerMixProviderPresenceURL
-	if len(host) == 1 && len(host[0]) > 0 {
-		ip, _, err := net.SplitHostPort(host[0])
-		if err == nil && (ip == "localhost" || net.ParseIP(ip).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMixProviderPresenceURL
-		} else if err != nil && err.Error() == "missing port in address" &&
-			(host[0] == "localhost" || net.ParseIP(host[0]).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMixProviderPresenceURL
-		}
-	}
-
+	endpoint := directory + config.DirectoryServerMixProviderPresencePath
 	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonValue))
 	if err != nil {
 		return err
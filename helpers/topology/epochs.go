@@ -0,0 +1,159 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// The directory server only keeps the host, the public key and the layer or the registered clients
+// of the presence, so the node rotating its keys announces a separate presence for the key of the current
+// and of the next epoch. The public key of such presence carries the long-term key identifying the node
+// together with the epoch key: identity.epoch.start.end.key, where start and end are the Unix times,
+// in nanoseconds, between which the key is used. The base64 encoding of the keys never contains the separator.
+const epochKeySeparator = "."
+
+// EpochKeyInfo is the sphinx key the node rotating its keys announces for a single key epoch.
+type EpochKeyInfo struct {
+	// Identity is the base64 encoded long-term key of the node, which only identifies it.
+	Identity string
+	Epoch    uint64
+	// Start and End are the Unix times, in nanoseconds, between which the key is used.
+	Start int64
+	End   int64
+	// PubKey is the base64 encoded sphinx key of the epoch.
+	PubKey string
+}
+
+// EpochKeys defines map of the sphinx keys announced by the nodes for the key epochs,
+// indexed by the identities of the nodes. The nodes which do not rotate their keys are not included.
+type EpochKeys map[string][]EpochKeyInfo
+
+// PresenceKey returns the public key of the presence announcing the epoch key.
+func (k EpochKeyInfo) PresenceKey() string {
+	return strings.Join([]string{k.Identity,
+		strconv.FormatUint(k.Epoch, 10),
+		strconv.FormatInt(k.Start, 10),
+		strconv.FormatInt(k.End, 10),
+		k.PubKey,
+	}, epochKeySeparator)
+}
+
+// ParsePresenceKey splits the public key of the presence into the identity of the node and the epoch key
+// it announces. The second value is false if the presence does not announce an epoch key, in which case
+// its public key is both the identity and the only sphinx key of the node.
+func ParsePresenceKey(pubKey string) (EpochKeyInfo, bool) {
+	parts := strings.Split(pubKey, epochKeySeparator)
+	if len(parts) != 5 {
+		return EpochKeyInfo{}, false
+	}
+	epoch, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return EpochKeyInfo{}, false
+	}
+	start, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return EpochKeyInfo{}, false
+	}
+	end, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return EpochKeyInfo{}, false
+	}
+	return EpochKeyInfo{Identity: parts[0], Epoch: epoch, Start: start, End: end, PubKey: parts[4]}, true
+}
+
+// Identity returns the identity of the node announcing the presence with the given public key.
+func Identity(pubKey string) string {
+	if info, ok := ParsePresenceKey(pubKey); ok {
+		return info.Identity
+	}
+	return pubKey
+}
+
+// SphinxKey returns the sphinx key announced in the presence with the given public key.
+func SphinxKey(pubKey string) string {
+	if info, ok := ParsePresenceKey(pubKey); ok {
+		return info.PubKey
+	}
+	return pubKey
+}
+
+// GetEpochKeys returns the sphinx keys the mix nodes and the providers of the topology announced for the key epochs.
+func GetEpochKeys(topologyData *models.Topology) EpochKeys {
+	epochKeys := make(EpochKeys)
+	add := func(pubKey string) {
+		if info, ok := ParsePresenceKey(pubKey); ok {
+			epochKeys[info.Identity] = append(epochKeys[info.Identity], info)
+		}
+	}
+	for _, mix := range topologyData.MixNodes {
+		add(mix.PubKey)
+	}
+	for _, provider := range topologyData.MixProviderNodes {
+		add(provider.PubKey)
+	}
+	return epochKeys
+}
+
+// KeyAt returns the sphinx key the node with the given public key uses at the given time. It is the key
+// announced for the epoch the time belongs to or, if there is none, the key of the earliest epoch starting
+// after that time, which the node already accepts. If the announced keys are outdated, the most recent one
+// of them is returned. The public key itself is returned if the node does not rotate its keys.
+func (k EpochKeys) KeyAt(pubKey []byte, t time.Time) []byte {
+	now := t.UnixNano()
+	var current, next, latest *EpochKeyInfo
+	for _, key := range k[base64.URLEncoding.EncodeToString(pubKey)] {
+		key := key
+		switch {
+		case key.Start <= now && now < key.End:
+			current = &key
+		case key.Start > now:
+			if next == nil || key.Start < next.Start {
+				next = &key
+			}
+		default:
+			if latest == nil || key.Start > latest.Start {
+				latest = &key
+			}
+		}
+	}
+	chosen := current
+	if chosen == nil {
+		chosen = next
+	}
+	if chosen == nil {
+		chosen = latest
+	}
+	if chosen == nil {
+		return pubKey
+	}
+	b, err := base64.URLEncoding.DecodeString(chosen.PubKey)
+	if err != nil {
+		return pubKey
+	}
+	return b
+}
+
+// MixAt returns the config of the mix with its public key replaced with the sphinx key it uses at the given time.
+func (k EpochKeys) MixAt(mix config.MixConfig, t time.Time) config.MixConfig {
+	mix.PubKey = k.KeyAt(mix.PubKey, t)
+	return mix
+}
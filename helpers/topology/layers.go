@@ -0,0 +1,120 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrNoLayers is returned when the layer is chosen out of zero layers.
+var ErrNoLayers = errors.New("the number of the layers has to be positive")
+
+// layerCounts returns the number of the mixnodes in each of the layers from 1 to the given number of the layers,
+// indexed by the layer, skipping the mixnode with the given public key. The mixnodes in the other layers are ignored.
+// Every mixnode is counted once, with the presence kept by UniqueMixes, so the public key is its long-term key.
+func layerCounts(mixPresence MixPresence, layers uint, skipPubKey string) []int {
+	counts := make([]int, layers+1)
+	for _, mix := range UniqueMixes(mixPresence) {
+		if mix.PubKey == skipPubKey || mix.Layer < 1 || mix.Layer > layers {
+			continue
+		}
+		counts[mix.Layer]++
+	}
+	return counts
+}
+
+// ChooseLayer returns the least populated of the layers from 1 to the given number of the layers,
+// the lowest one if several are equally populated. The mixnode with the given public key, which is
+// the one choosing its layer, is not counted in case its previous presence is still in the topology.
+func ChooseLayer(mixPresence MixPresence, layers uint, pubKey string) (uint, error) {
+	if layers == 0 {
+		return 0, ErrNoLayers
+	}
+	counts := layerCounts(mixPresence, layers, pubKey)
+	chosen := uint(1)
+	for layer := uint(2); layer <= layers; layer++ {
+		if counts[layer] < counts[chosen] {
+			chosen = layer
+		}
+	}
+	return chosen, nil
+}
+
+// RebalancedLayer returns the layer the mixnode with the given public key should move to, so that
+// the numbers of the mixnodes in the layers from 1 to the given number of the layers differ by at most one.
+// The second value is false if the mixnode should stay in its layer. The mixnodes announcing several presences
+// are identified by their long-term keys, as in the presences kept by UniqueMixes.
+//
+// All the mixnodes which rebalance their layers compute the same moves from the same topology, so that they
+// do not all move to the same layer at once: the layers which have more mixnodes than their balanced share
+// give up the mixnodes with the lowest public keys, which are moved to the layers which have fewer,
+// the lowest layers first. The moves are only planned when the numbers differ by more than one.
+func RebalancedLayer(mixPresence MixPresence, layers uint, pubKey string) (uint, bool) {
+	if layers == 0 {
+		return 0, false
+	}
+	mixPresence = UniqueMixes(mixPresence)
+	counts := layerCounts(mixPresence, layers, "")
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+
+	// the most populated layers keep the remainder of the nodes which cannot be spread evenly
+	order := make([]uint, 0, layers)
+	for layer := uint(1); layer <= layers; layer++ {
+		order = append(order, layer)
+	}
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+	targets := make([]int, layers+1)
+	for i, layer := range order {
+		targets[layer] = total / int(layers)
+		if i < total%int(layers) {
+			targets[layer]++
+		}
+	}
+
+	// the free places in the layers with fewer nodes than their targets, the lowest layers first
+	var free []uint
+	for layer := uint(1); layer <= layers; layer++ {
+		for i := counts[layer]; i < targets[layer]; i++ {
+			free = append(free, layer)
+		}
+	}
+
+	// the nodes moved out of the layers with more nodes than their targets, the lowest layers first
+	next := 0
+	for layer := uint(1); layer <= layers; layer++ {
+		surplus := counts[layer] - targets[layer]
+		if surplus <= 0 {
+			continue
+		}
+		pubKeys := make([]string, 0, counts[layer])
+		for _, mix := range mixPresence {
+			if mix.Layer == layer {
+				pubKeys = append(pubKeys, mix.PubKey)
+			}
+		}
+		sort.Strings(pubKeys)
+		for _, movedPubKey := range pubKeys[:surplus] {
+			if movedPubKey == pubKey {
+				return free[next], true
+			}
+			next++
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// syntheticTopology creates the presence of the mixnodes with the given layers,
+// whose public keys are "mix0", "mix1" and so on.
+func syntheticTopology(layers ...uint) MixPresence {
+	mixes := make(MixPresence, len(layers))
+	for i, layer := range layers {
+		mixes[i] = models.MixNodePresence{MixHostInfo: models.MixHostInfo{
+			HostInfo: models.HostInfo{Host: fmt.Sprintf("localhost:%d", 9980+i), PubKey: fmt.Sprintf("mix%d", i)},
+			Layer:    layer,
+		}}
+	}
+	return mixes
+}
+
+func TestChooseLayer(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		mixes    MixPresence
+		expected uint
+	}{
+		{"empty network", nil, 1},
+		{"lowest of the equally populated layers", syntheticTopology(1, 2, 3), 1},
+		{"least populated layer", syntheticTopology(1, 1, 2, 3, 3), 2},
+		{"empty layer", syntheticTopology(1, 2, 2, 1), 3},
+		{"layers out of range are ignored", syntheticTopology(1, 2, 3, 3, 4, 4, 4), 1},
+		{"previous presence of the node is ignored", syntheticTopology(2, 2, 3, 3, 1), 1},
+	} {
+		layer, err := ChooseLayer(test.mixes, 3, "mix4")
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.expected, layer, test.name)
+	}
+
+	_, err := ChooseLayer(syntheticTopology(1), 0, "mix1")
+	assert.Equal(t, ErrNoLayers, err)
+}
+
+// rebalance moves all the mixnodes of the topology to the layers returned by RebalancedLayer
+// and returns the number of the moved ones.
+func rebalance(mixes MixPresence, layers uint) int {
+	moves := make(map[int]uint)
+	for i, mix := range mixes {
+		if layer, ok := RebalancedLayer(mixes, layers, mix.PubKey); ok {
+			moves[i] = layer
+		}
+	}
+	for i, layer := range moves {
+		mixes[i].Layer = layer
+	}
+	return len(moves)
+}
+
+func TestRebalancedLayer(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		mixes    MixPresence
+		moved    int
+		balanced []int
+	}{
+		{"balanced", syntheticTopology(1, 2, 3, 1), 0, []int{0, 2, 1, 1}},
+		{"all in a single layer", syntheticTopology(1, 1, 1, 1, 1, 1), 4, []int{0, 2, 2, 2}},
+		{"remainder stays in the most populated layers", syntheticTopology(2, 2, 2, 2, 3), 2, []int{0, 1, 2, 2}},
+		{"difference of one is not rebalanced", syntheticTopology(3, 3, 2, 1), 0, []int{0, 1, 1, 2}},
+		{"many layers to fill", syntheticTopology(3, 3, 3, 3, 3, 3, 3, 3, 3), 6, []int{0, 3, 3, 3}},
+		{"layers out of range are ignored", syntheticTopology(1, 1, 1, 1, 5), 2, []int{0, 2, 1, 1}},
+	} {
+		assert.Equal(t, test.moved, rebalance(test.mixes, 3), test.name)
+		assert.Equal(t, test.balanced, layerCounts(test.mixes, 3, ""), test.name)
+		// once rebalanced, nothing moves any more
+		assert.Equal(t, 0, rebalance(test.mixes, 3), test.name)
+	}
+}
+
+func TestRebalancedLayerMovesLowestPublicKeys(t *testing.T) {
+	mixes := syntheticTopology(1, 1, 1, 1)
+	layer, ok := RebalancedLayer(mixes, 3, "mix0")
+	assert.True(t, ok)
+	assert.Equal(t, uint(2), layer)
+	layer, ok = RebalancedLayer(mixes, 3, "mix1")
+	assert.True(t, ok)
+	assert.Equal(t, uint(3), layer)
+	for _, pubKey := range []string{"mix2", "mix3", "unknown"} {
+		_, ok = RebalancedLayer(mixes, 3, pubKey)
+		assert.False(t, ok, pubKey)
+	}
+
+	_, ok = RebalancedLayer(mixes, 0, "mix0")
+	assert.False(t, ok)
+}
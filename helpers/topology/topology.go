@@ -64,8 +64,61 @@ func GetNetworkTopology(endpoint string) (*models.Topology, error) {
 	return model, nil
 }
 
+// UniqueMixes returns the presence of every mix node only once, with the public key replaced with the identity
+// of the mix node. The mix nodes rotating their keys announce a separate presence for each of their announced keys,
+// and the most recently seen one is kept, or the one with the greatest public key if several were seen
+// at the same time, so that every node reading the same topology keeps the same presence.
+func UniqueMixes(mixPresence MixPresence) MixPresence {
+	byIdentity := make(map[string]int, len(mixPresence))
+	seen := make([]string, 0, len(mixPresence))
+	unique := make(MixPresence, 0, len(mixPresence))
+	for _, mix := range mixPresence {
+		presenceKey := mix.PubKey
+		mix.PubKey = Identity(presenceKey)
+		i, ok := byIdentity[mix.PubKey]
+		if !ok {
+			byIdentity[mix.PubKey] = len(unique)
+			unique = append(unique, mix)
+			seen = append(seen, presenceKey)
+			continue
+		}
+		if mix.LastSeen > unique[i].LastSeen || (mix.LastSeen == unique[i].LastSeen && presenceKey > seen[i]) {
+			unique[i] = mix
+			seen[i] = presenceKey
+		}
+	}
+	return unique
+}
+
+// UniqueProviders returns the presence of every provider only once, with the public key replaced with the identity
+// of the provider, in the same way as UniqueMixes does for the mix nodes.
+func UniqueProviders(providerPresence ProviderPresence) ProviderPresence {
+	byIdentity := make(map[string]int, len(providerPresence))
+	seen := make([]string, 0, len(providerPresence))
+	unique := make(ProviderPresence, 0, len(providerPresence))
+	for _, provider := range providerPresence {
+		presenceKey := provider.PubKey
+		provider.PubKey = Identity(presenceKey)
+		i, ok := byIdentity[provider.PubKey]
+		if !ok {
+			byIdentity[provider.PubKey] = len(unique)
+			unique = append(unique, provider)
+			seen = append(seen, presenceKey)
+			continue
+		}
+		if provider.LastSeen > unique[i].LastSeen ||
+			(provider.LastSeen == unique[i].LastSeen && presenceKey > seen[i]) {
+			unique[i] = provider
+			seen[i] = presenceKey
+		}
+	}
+	return unique
+}
+
 // GetMixesPKI returns PKI data for mix nodes, grouped by layer
+// with every mix node included only once, identified by its long-term key.
 func GetMixesPKI(mixPresence MixPresence) (LayeredMixes, error) {
+	mixPresence = UniqueMixes(mixPresence)
 	mixes := make(LayeredMixes)
 	for k, v := range mixPresence {
 		b, err := base64.URLEncoding.DecodeString(v.PubKey)
@@ -93,8 +146,9 @@ func GetMixesPKI(mixPresence MixPresence) (LayeredMixes, error) {
 	return mixes, nil
 }
 
+// ProviderPresenceToConfig returns the config of the provider announcing the presence, identified by its long-term key.
 func ProviderPresenceToConfig(presence models.MixProviderPresence) (config.MixConfig, error) {
-	b, err := base64.URLEncoding.DecodeString(presence.PubKey)
+	b, err := base64.URLEncoding.DecodeString(Identity(presence.PubKey))
 	if err != nil {
 		return config.MixConfig{}, errors.New("invalid provider presence")
 	}
@@ -122,6 +176,7 @@ func RegisteredClientToConfig(client models.RegisteredClient) (config.ClientConf
 
 // GetClientPKI returns a map of the current client PKI from the PKI database
 func GetClientPKI(providerPresence ProviderPresence) ([]config.ClientConfig, error) {
+	providerPresence = UniqueProviders(providerPresence)
 	var clientsNum int = 0
 	for _, v := range providerPresence {
 		clientsNum += len(v.RegisteredClients)
@@ -0,0 +1,123 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func mixPresence(host string, pubKey []byte, lastSeen int64) models.MixNodePresence {
+	return models.MixNodePresence{MixHostInfo: models.MixHostInfo{
+		HostInfo: models.HostInfo{Host: host, PubKey: base64.URLEncoding.EncodeToString(pubKey)},
+		Layer:    1,
+	},
+		LastSeen: lastSeen,
+	}
+}
+
+// epochKey returns the epoch key of the node with the given identity, whose epochs last for 10 nanoseconds.
+func epochKey(identity []byte, epoch uint64, key []byte) EpochKeyInfo {
+	return EpochKeyInfo{Identity: base64.URLEncoding.EncodeToString(identity),
+		Epoch:  epoch,
+		Start:  int64(epoch) * 10,
+		End:    int64(epoch+1) * 10,
+		PubKey: base64.URLEncoding.EncodeToString(key),
+	}
+}
+
+func epochMixPresence(host string, key EpochKeyInfo, lastSeen int64) models.MixNodePresence {
+	presence := mixPresence(host, nil, lastSeen)
+	presence.PubKey = key.PresenceKey()
+	return presence
+}
+
+func TestPresenceKey(t *testing.T) {
+	key := epochKey([]byte{1}, 42, []byte{2})
+	parsed, ok := ParsePresenceKey(key.PresenceKey())
+	assert.True(t, ok)
+	assert.Equal(t, key, parsed)
+	assert.Equal(t, key.Identity, Identity(key.PresenceKey()))
+	assert.Equal(t, key.PubKey, SphinxKey(key.PresenceKey()))
+
+	// the presence of the node which does not rotate its keys carries its long-term key alone
+	_, ok = ParsePresenceKey(key.Identity)
+	assert.False(t, ok)
+	assert.Equal(t, key.Identity, Identity(key.Identity))
+	assert.Equal(t, key.Identity, SphinxKey(key.Identity))
+}
+
+func TestUniqueMixes(t *testing.T) {
+	mixes := MixPresence{
+		mixPresence("localhost:9980", []byte{1}, 10),
+		mixPresence("localhost:9981", []byte{2}, 10),
+		// the mix node rotating its keys announces its current and next key
+		epochMixPresence("localhost:9982", epochKey([]byte{3}, 1, []byte{4}), 20),
+		epochMixPresence("localhost:9982", epochKey([]byte{3}, 2, []byte{5}), 10),
+	}
+	unique := UniqueMixes(mixes)
+	assert.Len(t, unique, 3)
+	assert.Equal(t, mixes[:2], unique[:2])
+	assert.Equal(t, base64.URLEncoding.EncodeToString([]byte{3}), unique[2].PubKey)
+	assert.Equal(t, int64(20), unique[2].LastSeen)
+
+	// the mix nodes are identified by their long-term keys, which the epoch keys are chosen for
+	layered, err := GetMixesPKI(mixes)
+	assert.Nil(t, err)
+	assert.Len(t, layered[1], 3)
+	assert.Equal(t, []byte{3}, layered[1][2].PubKey)
+}
+
+func TestUniqueProviders(t *testing.T) {
+	client := models.RegisteredClient{PubKey: base64.URLEncoding.EncodeToString([]byte{9})}
+	providers := ProviderPresence{}
+	for epoch := uint64(1); epoch <= 2; epoch++ {
+		key := epochKey([]byte{1}, epoch, []byte{byte(epoch)})
+		providers = append(providers, models.MixProviderPresence{MixProviderHostInfo: models.MixProviderHostInfo{
+			HostInfo:          models.HostInfo{Host: "localhost:9990", PubKey: key.PresenceKey()},
+			RegisteredClients: []models.RegisteredClient{client},
+		}})
+	}
+
+	// the clients are picked by the identity of their provider, which is listed once
+	assert.Len(t, UniqueProviders(providers), 1)
+	clients, err := GetClientPKI(providers)
+	assert.Nil(t, err)
+	assert.Len(t, clients, 1)
+	assert.Equal(t, []byte{1}, clients[0].Provider.PubKey)
+}
+
+func TestEpochKeysAt(t *testing.T) {
+	identity := []byte{1}
+	topologyData := &models.Topology{MixNodes: []models.MixNodePresence{
+		epochMixPresence("localhost:9980", epochKey(identity, 1, []byte{11}), 0),
+		epochMixPresence("localhost:9980", epochKey(identity, 2, []byte{12}), 0),
+		mixPresence("localhost:9981", []byte{2}, 0),
+	}}
+	epochKeys := GetEpochKeys(topologyData)
+	assert.Len(t, epochKeys, 1)
+
+	// the key of the current epoch is chosen, then the key of the next one, then the most recent one
+	assert.Equal(t, []byte{11}, epochKeys.KeyAt(identity, time.Unix(0, 15)))
+	assert.Equal(t, []byte{12}, epochKeys.KeyAt(identity, time.Unix(0, 20)))
+	assert.Equal(t, []byte{11}, epochKeys.KeyAt(identity, time.Unix(0, 5)))
+	assert.Equal(t, []byte{12}, epochKeys.KeyAt(identity, time.Unix(0, 35)))
+	// the node which does not rotate its keys keeps using its long-term key
+	assert.Equal(t, []byte{2}, epochKeys.KeyAt([]byte{2}, time.Unix(0, 15)))
+}
@@ -26,6 +26,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -95,7 +96,10 @@ func (f *customFormatter) Format(entry *log.Entry) ([]byte, error) {
 // Hold all necessary data to create module-specific loggers
 type Logger struct {
 	logOut io.Writer
-	level  log.Level
+
+	mu      sync.Mutex
+	level   log.Level
+	loggers []*log.Logger
 }
 
 // GetLogger returns a per-module logger that writes to the backend.
@@ -113,12 +117,39 @@ func (l *Logger) GetLogger(module string) *log.Logger {
 	baseLogger := log.New()
 	baseLogger.Formatter = formatter
 	baseLogger.Out = l.logOut
-	baseLogger.Level = l.level
 	baseLogger.ReportCaller = true
 
+	// the loggers are kept, so that their level could be changed at runtime
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	baseLogger.Level = l.level
+	l.loggers = append(l.loggers, baseLogger)
+
 	return baseLogger
 }
 
+// SetLevel changes the level of all the module-specific loggers, including the ones created later on.
+func (l *Logger) SetLevel(level string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = lvl
+	for _, logger := range l.loggers {
+		logger.SetLevel(lvl)
+	}
+	return nil
+}
+
+// Level returns the current level of the loggers.
+func (l *Logger) Level() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level.String()
+}
+
 // New returns new instance of logger
 func New(f string, level string, disable bool) (*Logger, error) {
 	lvl, err := log.ParseLevel(level)
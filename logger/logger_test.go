@@ -22,6 +22,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -81,3 +82,20 @@ func TestNew(t *testing.T) {
 
 	}
 }
+
+func TestSetLevel(t *testing.T) {
+	logger, err := New("", "info", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := logger.GetLogger("before")
+	assert.Error(t, logger.SetLevel("foo"))
+	assert.Equal(t, "info", logger.Level())
+
+	assert.Nil(t, logger.SetLevel("debug"))
+	after := logger.GetLogger("after")
+	assert.Equal(t, "debug", logger.Level())
+	assert.True(t, before.IsLevelEnabled(logrus.DebugLevel))
+	assert.True(t, after.IsLevelEnabled(logrus.DebugLevel))
+	assert.False(t, after.IsLevelEnabled(logrus.TraceLevel))
+}
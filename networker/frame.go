@@ -0,0 +1,146 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// The frame is the unit of data sent between all the entities of the system. It consists of the header:
+//
+//	magic (4 bytes) || version (1 byte) || body length (4 bytes, big endian) || packet type flag (1 byte)
+//
+// followed by the body. Any number of frames can be sent over a single connection.
+const (
+	// FrameMagic starts every frame.
+	FrameMagic = "NYMF"
+	// FrameVersion is the version of the frame format.
+	FrameVersion = 1
+	// FrameHeaderSize is the size of the frame header.
+	FrameHeaderSize = len(FrameMagic) + 1 + 4 + 1
+	// MaxFrameBodySize is the size of the largest body a frame can carry.
+	MaxFrameBodySize = 64 * 1024
+
+	frameVersionOffset = len(FrameMagic)
+	frameLengthOffset  = frameVersionOffset + 1
+	frameFlagOffset    = frameLengthOffset + 4
+)
+
+var (
+	// ErrInvalidFrameMagic is returned when the data does not start with FrameMagic.
+	ErrInvalidFrameMagic = errors.New("invalid frame magic")
+	// ErrUnsupportedFrameVersion is returned when the frame is in an unknown version of the format.
+	ErrUnsupportedFrameVersion = errors.New("unsupported frame version")
+	// ErrFrameTooLarge is returned when the body of the frame exceeds MaxFrameBodySize.
+	ErrFrameTooLarge = errors.New("frame exceeds the maximum size")
+	// ErrTruncatedFrame is returned when the data ends before the frame is complete.
+	ErrTruncatedFrame = errors.New("truncated frame")
+	// ErrTrailingFrameData is returned when the decoded data continues after the end of the frame.
+	ErrTrailingFrameData = errors.New("trailing data after the frame")
+)
+
+// Frame is a single packet sent between the entities of the system.
+type Frame struct {
+	// Flag indicates the type of the body.
+	Flag flags.PacketTypeFlag
+	// Body is the content of the frame, for example a sphinx packet.
+	Body []byte
+}
+
+// EncodeFrame encodes the body together with its flag into a frame.
+func EncodeFrame(flag flags.PacketTypeFlag, body []byte) ([]byte, error) {
+	if len(body) > MaxFrameBodySize {
+		return nil, ErrFrameTooLarge
+	}
+	b := make([]byte, FrameHeaderSize+len(body))
+	copy(b, FrameMagic)
+	b[frameVersionOffset] = FrameVersion
+	binary.BigEndian.PutUint32(b[frameLengthOffset:], uint32(len(body)))
+	b[frameFlagOffset] = byte(flag)
+	copy(b[FrameHeaderSize:], body)
+	return b, nil
+}
+
+// WriteFrame writes the body together with its flag as a single frame.
+func WriteFrame(w io.Writer, flag flags.PacketTypeFlag, body []byte) error {
+	b, err := EncodeFrame(flag, body)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// parseFrameHeader checks the header and returns the flag and the length of the body.
+func parseFrameHeader(header []byte) (flags.PacketTypeFlag, int, error) {
+	if string(header[:len(FrameMagic)]) != FrameMagic {
+		return flags.InvalidPacketTypeFlag, 0, ErrInvalidFrameMagic
+	}
+	if header[frameVersionOffset] != FrameVersion {
+		return flags.InvalidPacketTypeFlag, 0, ErrUnsupportedFrameVersion
+	}
+	length := binary.BigEndian.Uint32(header[frameLengthOffset:])
+	if length > MaxFrameBodySize {
+		return flags.InvalidPacketTypeFlag, 0, ErrFrameTooLarge
+	}
+	return flags.PacketTypeFlagFromByte(header[frameFlagOffset]), int(length), nil
+}
+
+// ReadFrame reads a single frame, waiting until all of it arrives. It returns io.EOF only if the reader ended
+// before the frame started, and ErrTruncatedFrame if it ended in the middle of the frame.
+// After any other error the reader can no longer be trusted to be at the start of a frame.
+func ReadFrame(r io.Reader) (Frame, error) {
+	var header [FrameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncatedFrame
+		}
+		return Frame{}, err
+	}
+	flag, length, err := parseFrameHeader(header[:])
+	if err != nil {
+		return Frame{}, err
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncatedFrame
+		}
+		return Frame{}, err
+	}
+	return Frame{Flag: flag, Body: body}, nil
+}
+
+// DecodeFrame decodes the frame which is the entire content of b.
+func DecodeFrame(b []byte) (Frame, error) {
+	if len(b) < FrameHeaderSize {
+		return Frame{}, ErrTruncatedFrame
+	}
+	flag, length, err := parseFrameHeader(b[:FrameHeaderSize])
+	if err != nil {
+		return Frame{}, err
+	}
+	if len(b) < FrameHeaderSize+length {
+		return Frame{}, ErrTruncatedFrame
+	} else if len(b) > FrameHeaderSize+length {
+		return Frame{}, ErrTrailingFrameData
+	}
+	return Frame{Flag: flag, Body: b[FrameHeaderSize:]}, nil
+}
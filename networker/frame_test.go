@@ -0,0 +1,106 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAndReadFrame(t *testing.T) {
+	var buf bytes.Buffer
+	frames := []Frame{
+		{Flag: flags.CommFlag, Body: []byte("first")},
+		{Flag: flags.PullFlag, Body: []byte{}},
+		{Flag: flags.AssignFlag, Body: bytes.Repeat([]byte{42}, 3000)},
+	}
+	for _, frame := range frames {
+		assert.Nil(t, WriteFrame(&buf, frame.Flag, frame.Body))
+	}
+	for _, frame := range frames {
+		read, err := ReadFrame(&buf)
+		assert.Nil(t, err)
+		assert.Equal(t, frame, read)
+	}
+	_, err := ReadFrame(&buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadFrameFromSplitReads(t *testing.T) {
+	var buf bytes.Buffer
+	body := bytes.Repeat([]byte{1, 2, 3}, 1000)
+	assert.Nil(t, WriteFrame(&buf, flags.CommFlag, body))
+	assert.Nil(t, WriteFrame(&buf, flags.CommFlag, []byte("second")))
+
+	// every read returns a single byte, as if every byte came in a separate segment
+	r := iotest.OneByteReader(&buf)
+	frame, err := ReadFrame(r)
+	assert.Nil(t, err)
+	assert.Equal(t, body, frame.Body)
+	frame, err = ReadFrame(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("second"), frame.Body)
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	_, err := EncodeFrame(flags.CommFlag, make([]byte, MaxFrameBodySize+1))
+	assert.Equal(t, ErrFrameTooLarge, err)
+
+	b, err := EncodeFrame(flags.CommFlag, make([]byte, MaxFrameBodySize))
+	assert.Nil(t, err)
+	// the length is checked before the body is read
+	b[frameLengthOffset+1]++
+	_, err = ReadFrame(bytes.NewReader(b[:FrameHeaderSize]))
+	assert.Equal(t, ErrFrameTooLarge, err)
+}
+
+func TestReadInvalidFrame(t *testing.T) {
+	b, err := EncodeFrame(flags.CommFlag, []byte("body"))
+	assert.Nil(t, err)
+
+	badMagic := append([]byte{}, b...)
+	badMagic[0] = 'X'
+	_, err = ReadFrame(bytes.NewReader(badMagic))
+	assert.Equal(t, ErrInvalidFrameMagic, err)
+
+	badVersion := append([]byte{}, b...)
+	badVersion[frameVersionOffset] = FrameVersion + 1
+	_, err = ReadFrame(bytes.NewReader(badVersion))
+	assert.Equal(t, ErrUnsupportedFrameVersion, err)
+
+	_, err = ReadFrame(bytes.NewReader(b[:FrameHeaderSize-1]))
+	assert.Equal(t, ErrTruncatedFrame, err)
+	_, err = ReadFrame(bytes.NewReader(b[:len(b)-1]))
+	assert.Equal(t, ErrTruncatedFrame, err)
+}
+
+func TestDecodeFrame(t *testing.T) {
+	b, err := EncodeFrame(flags.TokenFlag, []byte("token"))
+	assert.Nil(t, err)
+
+	frame, err := DecodeFrame(b)
+	assert.Nil(t, err)
+	assert.Equal(t, Frame{Flag: flags.TokenFlag, Body: []byte("token")}, frame)
+
+	_, err = DecodeFrame(b[:len(b)-1])
+	assert.Equal(t, ErrTruncatedFrame, err)
+	_, err = DecodeFrame(append(b, 0))
+	assert.Equal(t, ErrTrailingFrameData, err)
+}
@@ -0,0 +1,450 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultLinkQueueSize is the default number of the packets that can wait to be sent to a single peer.
+	DefaultLinkQueueSize = 256
+	// DefaultLinkDialTimeout is the default time after which connecting to a peer fails.
+	DefaultLinkDialTimeout = 5 * time.Second
+	// DefaultLinkWriteTimeout is the default time after which writing a packet to a peer fails.
+	DefaultLinkWriteTimeout = 5 * time.Second
+	// DefaultLinkMinBackoff is the default time to wait before reconnecting to a peer after the first failure.
+	DefaultLinkMinBackoff = 100 * time.Millisecond
+	// DefaultLinkMaxBackoff is the default longest time to wait before reconnecting to a peer.
+	DefaultLinkMaxBackoff = 30 * time.Second
+	// DefaultMaxLinks is the default largest number of the peers the node keeps the links to at the same time.
+	DefaultMaxLinks = 1024
+	// DefaultLinkMaxFailures is the default number of the consecutive failed attempts to connect to a peer
+	// after which its link is dropped.
+	DefaultLinkMaxFailures = 8
+	// DefaultLinkIdleTimeout is the default time after which the link no packet was sent over is dropped.
+	DefaultLinkIdleTimeout = 5 * time.Minute
+
+	// flushPollInterval is how often Flush checks whether all the packets have been written.
+	flushPollInterval = 10 * time.Millisecond
+)
+
+var (
+	// ErrLinkQueueFull is returned when the packet cannot be sent as the outbound queue of the peer is full.
+	ErrLinkQueueFull = errors.New("outbound queue of the peer is full")
+	// ErrLinkManagerClosed is returned when the packet is sent after the link manager was closed.
+	ErrLinkManagerClosed = errors.New("link manager is closed")
+	// ErrTooManyLinks is returned when the packet cannot be sent as the node keeps the links to too many peers.
+	ErrTooManyLinks = errors.New("too many links to the peers")
+)
+
+// LinkConfig defines the behaviour of the LinkManager. The zero values are replaced with the defaults.
+type LinkConfig struct {
+	// QueueSize is the number of the packets that can wait to be sent to a single peer,
+	// DefaultLinkQueueSize by default.
+	QueueSize int
+	// DialTimeout is the time after which connecting to a peer fails, DefaultLinkDialTimeout by default.
+	DialTimeout time.Duration
+	// WriteTimeout is the time after which writing a packet to a peer fails, DefaultLinkWriteTimeout by default.
+	WriteTimeout time.Duration
+	// MinBackoff is the time to wait before reconnecting after the first failure, DefaultLinkMinBackoff by default.
+	// It is doubled with every consecutive failure.
+	MinBackoff time.Duration
+	// MaxBackoff is the longest time to wait before reconnecting, DefaultLinkMaxBackoff by default.
+	MaxBackoff time.Duration
+	// MaxLinks is the largest number of the peers the node keeps the links to at the same time,
+	// DefaultMaxLinks by default.
+	MaxLinks int
+	// MaxFailures is the number of the consecutive failed attempts to connect to a peer after which its link
+	// is dropped together with its queue, DefaultLinkMaxFailures by default.
+	MaxFailures int
+	// IdleTimeout is the time after which the link no packet was sent over is dropped,
+	// DefaultLinkIdleTimeout by default.
+	IdleTimeout time.Duration
+}
+
+// LinkStatus describes the link to a single peer.
+type LinkStatus struct {
+	// Address is the address of the peer.
+	Address string
+	// Connected tells whether the link currently has an open connection.
+	Connected bool
+	// Queued is the number of the packets waiting to be sent.
+	Queued int
+	// Sent is the number of the packets sent over the link.
+	Sent uint64
+	// Dropped is the number of the packets dropped as the queue was full or the peer could not be reached.
+	Dropped uint64
+}
+
+// LinkManager keeps long-lived connections to the peers of the node, over which it sends the packets.
+// The packets are sent as frames, many of them over the same connection. Every connection starts
+// with the handshake, in which the node and the peer authenticate each other with their static keys.
+// Every peer has its own connection and its own bounded queue of the outbound packets,
+// so that a slow or unreachable peer does not hold up the packets for the others.
+// The connections are opened when the first packet is sent to the peer and reopened with exponential backoff
+// whenever they fail. As the addresses of the peers come from the packets, the number of the links is capped,
+// and the links to the peers which cannot be reached or are no longer sent anything to are dropped.
+type LinkManager struct {
+	// unsent is accessed atomically and is kept first to guarantee its 64-bit alignment.
+	// It is the number of the queued packets that have not been written to their peers yet.
+	unsent int64
+
+	config   LinkConfig
+	identity *Identity
+	log      *logrus.Logger
+
+	mu     sync.Mutex
+	links  map[string]*link
+	closed bool
+
+	haltCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// link is the connection to a single peer together with its outbound queue.
+type link struct {
+	address   string
+	peerKey   atomic.Value
+	queue     chan []byte
+	connected int32
+	sent      uint64
+	dropped   uint64
+}
+
+// NewLinkManager creates the LinkManager authenticating the node with the given identity
+// and logging the failures of the links to the given logger.
+func NewLinkManager(log *logrus.Logger, identity *Identity, config LinkConfig) *LinkManager {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultLinkQueueSize
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = DefaultLinkDialTimeout
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = DefaultLinkWriteTimeout
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultLinkMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = DefaultLinkMaxBackoff
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+	if config.MaxLinks <= 0 {
+		config.MaxLinks = DefaultMaxLinks
+	}
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = DefaultLinkMaxFailures
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultLinkIdleTimeout
+	}
+	return &LinkManager{config: config,
+		identity: identity,
+		log:      log,
+		links:    make(map[string]*link),
+		haltCh:   make(chan struct{}),
+	}
+}
+
+// SetIdentity changes the identity the node authenticates itself with and returns the one it replaced.
+// The new identity is used from the next connection on, while the connections already open are kept.
+func (m *LinkManager) SetIdentity(identity *Identity) *Identity {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.identity
+	m.identity = identity
+	return previous
+}
+
+// Send queues the body to be sent in a frame with the given flag to the peer with the given address,
+// which has to authenticate itself with the given static key. If the key of the peer changes, the frames are sent
+// with the new key from the next connection on. It never blocks: if the queue of the peer is full,
+// the frame is dropped and ErrLinkQueueFull is returned, and if there is no link to the peer yet
+// and the number of the links is at its cap, ErrTooManyLinks is returned.
+func (m *LinkManager) Send(address string, peerKey []byte, flag flags.PacketTypeFlag, body []byte) error {
+	if len(peerKey) != KeySize {
+		return ErrInvalidKey
+	}
+	packet, err := EncodeFrame(flag, body)
+	if err != nil {
+		return err
+	}
+
+	// the packet is queued with the lock held, so that the link could not be dropped in the meantime
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrLinkManagerClosed
+	}
+	l, ok := m.links[address]
+	if !ok {
+		if len(m.links) >= m.config.MaxLinks {
+			return ErrTooManyLinks
+		}
+		l = &link{address: address, queue: make(chan []byte, m.config.QueueSize)}
+		l.peerKey.Store(peerKey)
+		m.links[address] = l
+		m.wg.Add(1)
+		go m.runLink(l)
+	} else if !bytes.Equal(l.peerKey.Load().([]byte), peerKey) {
+		l.peerKey.Store(peerKey)
+	}
+
+	atomic.AddInt64(&m.unsent, 1)
+	select {
+	case l.queue <- packet:
+		return nil
+	default:
+		atomic.AddInt64(&m.unsent, -1)
+		atomic.AddUint64(&l.dropped, 1)
+		return ErrLinkQueueFull
+	}
+}
+
+// Flush waits for all the queued packets to be written to their peers, for at most the given timeout.
+// The packets sent in the meantime are waited for as well. Flush returns the number of the packets
+// that are still waiting once it returns.
+func (m *LinkManager) Flush(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	for {
+		unsent := int(atomic.LoadInt64(&m.unsent))
+		if unsent == 0 || !time.Now().Before(deadline) {
+			return unsent
+		}
+		select {
+		case <-m.haltCh:
+			return int(atomic.LoadInt64(&m.unsent))
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close closes all the links and waits for them to stop. The packets still waiting in the queues are dropped.
+func (m *LinkManager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.haltCh)
+	m.mu.Unlock()
+
+	m.wg.Wait()
+}
+
+// Links returns the status of the links to all the peers the packets were sent to, sorted by their addresses.
+func (m *LinkManager) Links() []LinkStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]LinkStatus, 0, len(m.links))
+	for _, l := range m.links {
+		statuses = append(statuses, LinkStatus{Address: l.address,
+			Connected: atomic.LoadInt32(&l.connected) == 1,
+			Queued:    len(l.queue),
+			Sent:      atomic.LoadUint64(&l.sent),
+			Dropped:   atomic.LoadUint64(&l.dropped),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Address < statuses[j].Address })
+	return statuses
+}
+
+// QueueDepth returns the total number of the packets waiting to be sent to all the peers.
+func (m *LinkManager) QueueDepth() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	depth := 0
+	for _, l := range m.links {
+		depth += len(l.queue)
+	}
+	return depth
+}
+
+// runLink sends the queued packets to the peer for as long as the manager is open, the peer can be reached
+// and the packets keep coming. The connection is only opened when there is a packet to send, and a packet
+// whose write failed is sent again over the next connection.
+func (m *LinkManager) runLink(l *link) {
+	defer m.wg.Done()
+
+	var conn net.Conn
+	var closedCh <-chan struct{}
+	var pending []byte
+	backoff := time.Duration(0)
+	failures := 0
+	active := time.Now()
+	idle := time.NewTimer(m.config.IdleTimeout)
+	defer idle.Stop()
+
+	disconnect := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+			closedCh = nil
+			atomic.StoreInt32(&l.connected, 0)
+		}
+	}
+	defer disconnect()
+
+	for {
+		if pending == nil {
+			select {
+			case <-m.haltCh:
+				return
+			case <-closedCh:
+				// the peer closed the idle connection, it is reopened with the next packet
+				disconnect()
+				continue
+			case <-idle.C:
+				if since := time.Since(active); since < m.config.IdleTimeout {
+					idle.Reset(m.config.IdleTimeout - since)
+				} else if m.dropIdle(l) {
+					return
+				} else {
+					idle.Reset(m.config.IdleTimeout)
+				}
+				continue
+			case pending = <-l.queue:
+			}
+		}
+
+		if conn == nil {
+			if backoff > 0 {
+				select {
+				case <-m.haltCh:
+					return
+				case <-time.After(backoff):
+				}
+			}
+			var err error
+			conn, closedCh, err = m.connect(l.address, l.peerKey.Load().([]byte))
+			if err != nil {
+				if failures++; failures >= m.config.MaxFailures {
+					m.log.Warnf("Failed to connect to %v: %v (dropping the link after %d attempts)",
+						l.address, err, failures)
+					m.drop(l, pending)
+					return
+				}
+				backoff = m.nextBackoff(backoff)
+				m.log.Warnf("Failed to connect to %v: %v (retrying in %v)", l.address, err, backoff)
+				continue
+			}
+			backoff = 0
+			failures = 0
+			atomic.StoreInt32(&l.connected, 1)
+		}
+
+		if err := conn.SetWriteDeadline(time.Now().Add(m.config.WriteTimeout)); err != nil {
+			m.log.Warnf("Failed to set the write deadline of the link to %v: %v", l.address, err)
+		}
+		if _, err := conn.Write(pending); err != nil {
+			m.log.Warnf("Failed to send the packet to %v: %v", l.address, err)
+			disconnect()
+			backoff = m.nextBackoff(backoff)
+			continue
+		}
+		atomic.AddUint64(&l.sent, 1)
+		atomic.AddInt64(&m.unsent, -1)
+		pending = nil
+		active = time.Now()
+	}
+}
+
+// drop removes the link together with the pending packet and the packets waiting in its queue.
+// The packets sent to the peer afterwards start a new link.
+func (m *LinkManager) drop(l *link, pending []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.links, l.address)
+
+	dropped := 0
+	if pending != nil {
+		dropped++
+	}
+	for len(l.queue) > 0 {
+		<-l.queue
+		dropped++
+	}
+	atomic.AddInt64(&m.unsent, -int64(dropped))
+	atomic.AddUint64(&l.dropped, uint64(dropped))
+}
+
+// dropIdle removes the idle link, unless a packet was queued in the meantime, and tells whether it was removed.
+func (m *LinkManager) dropIdle(l *link) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(l.queue) > 0 {
+		return false
+	}
+	delete(m.links, l.address)
+	return true
+}
+
+// connect opens the connection to the peer, performs the handshake and starts the goroutine discarding
+// anything the peer might send, which closes the returned channel once the connection is closed.
+func (m *LinkManager) connect(address string, peerKey []byte) (net.Conn, <-chan struct{}, error) {
+	conn, err := net.DialTimeout("tcp", address, m.config.DialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the handshake uses its own copy of the identity, as the identity might be replaced and erased in the meantime
+	var id *Identity
+	m.mu.Lock()
+	if m.identity != nil {
+		identity := *m.identity
+		id = &identity
+		defer id.Erase()
+	}
+	m.mu.Unlock()
+	sconn, err := ClientHandshake(conn, id, peerKey)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	closedCh := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, sconn)
+		close(closedCh)
+	}()
+	return sconn, closedCh, nil
+}
+
+func (m *LinkManager) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return m.config.MinBackoff
+	}
+	backoff *= 2
+	if backoff > m.config.MaxBackoff {
+		backoff = m.config.MaxBackoff
+	}
+	return backoff
+}
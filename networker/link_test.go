@@ -0,0 +1,291 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestLogger() *logrus.Logger {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return log
+}
+
+// linkPacket is the packet received by the test listener, together with the number of the connection it came from
+// and the key the sender authenticated itself with.
+type linkPacket struct {
+	conn    int
+	peerKey []byte
+	packet  []byte
+}
+
+// startTestListener accepts the links of the peer with the given identity and passes all the packets
+// received over them to the returned channel.
+func startTestListener(t *testing.T, id *Identity) (net.Listener, <-chan linkPacket, <-chan net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetsCh := make(chan linkPacket, 64)
+	connsCh := make(chan net.Conn, 8)
+	go func() {
+		for i := 1; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connsCh <- conn
+			go func(i int, conn net.Conn) {
+				sconn, err := ServerHandshake(conn, id)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				r := bufio.NewReader(sconn)
+				for {
+					frame, err := ReadFrame(r)
+					if err != nil || frame.Flag != flags.CommFlag {
+						conn.Close()
+						return
+					}
+					packetsCh <- linkPacket{conn: i, peerKey: sconn.PeerKey(), packet: frame.Body}
+				}
+			}(i, conn)
+		}
+	}()
+	return listener, packetsCh, connsCh
+}
+
+func receiveLinkPacket(t *testing.T, packetsCh <-chan linkPacket) linkPacket {
+	select {
+	case p := <-packetsCh:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet was received")
+		return linkPacket{}
+	}
+}
+
+func TestLinkManagerReusesConnection(t *testing.T) {
+	peerID := createTestIdentity(t)
+	listener, packetsCh, _ := startTestListener(t, peerID)
+	defer listener.Close()
+	id := createTestIdentity(t)
+	m := NewLinkManager(createTestLogger(), id, LinkConfig{})
+	defer m.Close()
+
+	address := listener.Addr().String()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte(fmt.Sprintf("Packet %d", i))))
+	}
+	for i := 0; i < 10; i++ {
+		p := receiveLinkPacket(t, packetsCh)
+		assert.Equal(t, 1, p.conn)
+		assert.Equal(t, id.PublicKey(), p.peerKey)
+		assert.Equal(t, []byte(fmt.Sprintf("Packet %d", i)), p.packet)
+	}
+
+	links := m.Links()
+	assert.Len(t, links, 1)
+	assert.Equal(t, address, links[0].Address)
+	assert.True(t, links[0].Connected)
+	assert.Equal(t, uint64(10), links[0].Sent)
+}
+
+func TestLinkManagerFlush(t *testing.T) {
+	peerID := createTestIdentity(t)
+	listener, packetsCh, _ := startTestListener(t, peerID)
+	defer listener.Close()
+	m := NewLinkManager(createTestLogger(), createTestIdentity(t), LinkConfig{})
+	defer m.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, m.Send(listener.Addr().String(), peerID.PublicKey(), flags.CommFlag, []byte("packet")))
+	}
+	assert.Equal(t, 0, m.Flush(5*time.Second))
+	for i := 0; i < 10; i++ {
+		receiveLinkPacket(t, packetsCh)
+	}
+}
+
+func TestLinkManagerReconnects(t *testing.T) {
+	peerID := createTestIdentity(t)
+	listener, packetsCh, connsCh := startTestListener(t, peerID)
+	defer listener.Close()
+	m := NewLinkManager(createTestLogger(), createTestIdentity(t), LinkConfig{MinBackoff: 10 * time.Millisecond})
+	defer m.Close()
+
+	address := listener.Addr().String()
+	assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("first")))
+	assert.Equal(t, 1, receiveLinkPacket(t, packetsCh).conn)
+
+	// the peer drops the connection, so the next packet is sent over the new one
+	(<-connsCh).Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Links()[0].Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("the link did not notice the closed connection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("second")))
+	p := receiveLinkPacket(t, packetsCh)
+	assert.Equal(t, 2, p.conn)
+	assert.Equal(t, []byte("second"), p.packet)
+}
+
+func TestLinkManagerSetIdentity(t *testing.T) {
+	peerID := createTestIdentity(t)
+	listener, packetsCh, connsCh := startTestListener(t, peerID)
+	defer listener.Close()
+	id := createTestIdentity(t)
+	m := NewLinkManager(createTestLogger(), id, LinkConfig{MinBackoff: 10 * time.Millisecond})
+	defer m.Close()
+
+	address := listener.Addr().String()
+	assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("first")))
+	assert.Equal(t, id.PublicKey(), receiveLinkPacket(t, packetsCh).peerKey)
+	// the handshake erases only its own copy of the identity
+	assert.NotEqual(t, [KeySize]byte{}, id.private)
+
+	// the open connection keeps the old identity, and the new one is used from the next connection on
+	newID := createTestIdentity(t)
+	assert.Equal(t, id, m.SetIdentity(newID))
+	assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("second")))
+	assert.Equal(t, id.PublicKey(), receiveLinkPacket(t, packetsCh).peerKey)
+
+	(<-connsCh).Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Links()[0].Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("the link did not notice the closed connection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("third")))
+	p := receiveLinkPacket(t, packetsCh)
+	assert.Equal(t, 2, p.conn)
+	assert.Equal(t, newID.PublicKey(), p.peerKey)
+}
+
+func TestLinkManagerQueueFull(t *testing.T) {
+	// nothing listens at the address of the closed listener, so the packets pile up in the queue
+	peerID := createTestIdentity(t)
+	listener, _, _ := startTestListener(t, peerID)
+	address := listener.Addr().String()
+	listener.Close()
+
+	m := NewLinkManager(createTestLogger(), createTestIdentity(t), LinkConfig{QueueSize: 1, MinBackoff: time.Hour})
+	failed := 0
+	for i := 0; i < 10; i++ {
+		if err := m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("packet")); err != nil {
+			assert.Equal(t, ErrLinkQueueFull, err)
+			failed++
+		}
+	}
+	// at most one packet is queued and at most one is being sent
+	assert.True(t, failed >= 8)
+	assert.Equal(t, uint64(failed), m.Links()[0].Dropped)
+	// the queued packets cannot be flushed to the unreachable peer
+	assert.Equal(t, 10-failed, m.Flush(50*time.Millisecond))
+
+	m.Close()
+	assert.Equal(t, ErrLinkManagerClosed, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("packet")))
+}
+
+func TestLinkManagerAuthenticatesPeer(t *testing.T) {
+	peerID := createTestIdentity(t)
+	listener, packetsCh, _ := startTestListener(t, peerID)
+	defer listener.Close()
+	m := NewLinkManager(createTestLogger(), createTestIdentity(t), LinkConfig{MinBackoff: time.Hour})
+	defer m.Close()
+
+	// the peer does not own the expected key, so the handshake fails and nothing is sent
+	address := listener.Addr().String()
+	assert.Nil(t, m.Send(address, createTestIdentity(t).PublicKey(), flags.CommFlag, []byte("packet")))
+	select {
+	case <-packetsCh:
+		t.Fatal("the packet was sent to the peer which failed to authenticate")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.False(t, m.Links()[0].Connected)
+	assert.Equal(t, uint64(0), m.Links()[0].Sent)
+
+	assert.Equal(t, ErrInvalidKey, m.Send(address, []byte("short"), flags.CommFlag, []byte("packet")))
+}
+
+func TestLinkManagerMaxLinks(t *testing.T) {
+	peerID := createTestIdentity(t)
+	m := NewLinkManager(createTestLogger(), createTestIdentity(t), LinkConfig{MaxLinks: 2, MinBackoff: time.Hour})
+	defer m.Close()
+
+	assert.Nil(t, m.Send("127.0.0.1:1", peerID.PublicKey(), flags.CommFlag, []byte("packet")))
+	assert.Nil(t, m.Send("127.0.0.1:2", peerID.PublicKey(), flags.CommFlag, []byte("packet")))
+	assert.Equal(t, ErrTooManyLinks, m.Send("127.0.0.1:3", peerID.PublicKey(), flags.CommFlag, []byte("packet")))
+	// the peers which already have their links are still sent the packets
+	assert.Nil(t, m.Send("127.0.0.1:1", peerID.PublicKey(), flags.CommFlag, []byte("packet")))
+	assert.Len(t, m.Links(), 2)
+}
+
+func TestLinkManagerDropsUnreachablePeer(t *testing.T) {
+	peerID := createTestIdentity(t)
+	listener, _, _ := startTestListener(t, peerID)
+	address := listener.Addr().String()
+	listener.Close()
+
+	m := NewLinkManager(createTestLogger(), createTestIdentity(t), LinkConfig{MaxFailures: 3,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+	})
+	defer m.Close()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("packet")))
+	}
+
+	// the link is dropped together with its packets once the connects keep failing
+	assert.Eventually(t, func() bool { return len(m.Links()) == 0 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, 0, m.QueueDepth())
+	assert.Equal(t, 0, m.Flush(0))
+}
+
+func TestLinkManagerDropsIdleLink(t *testing.T) {
+	peerID := createTestIdentity(t)
+	listener, packetsCh, _ := startTestListener(t, peerID)
+	defer listener.Close()
+	m := NewLinkManager(createTestLogger(), createTestIdentity(t), LinkConfig{IdleTimeout: 50 * time.Millisecond})
+	defer m.Close()
+
+	address := listener.Addr().String()
+	assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("first")))
+	assert.Equal(t, 1, receiveLinkPacket(t, packetsCh).conn)
+	assert.Eventually(t, func() bool { return len(m.Links()) == 0 }, 5*time.Second, time.Millisecond)
+
+	// the packet sent afterwards starts a new link
+	assert.Nil(t, m.Send(address, peerID.PublicKey(), flags.CommFlag, []byte("second")))
+	p := receiveLinkPacket(t, packetsCh)
+	assert.Equal(t, 2, p.conn)
+	assert.Equal(t, []byte("second"), p.packet)
+}
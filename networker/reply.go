@@ -0,0 +1,69 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+// The replier sends the reply packet to its own provider, like any other packet, rather than connecting
+// to the first hop of the reply block itself. The frame carries the first hop together with the packet:
+// the length of the encoded hop (2 bytes, big endian) || the encoded hop || the packet.
+
+var (
+	// ErrInvalidReplyFrame is returned when the frame does not carry the reply packet together with its first hop.
+	ErrInvalidReplyFrame = errors.New("the frame does not carry a valid reply packet")
+)
+
+// EncodeReplyFrame encodes the reply packet, which should be relayed to the given first hop, into a frame.
+func EncodeReplyFrame(firstHop sphinx.Hop, packet []byte) ([]byte, error) {
+	hop, err := proto.Marshal(&firstHop)
+	if err != nil {
+		return nil, err
+	}
+	if len(hop) > 0xffff {
+		return nil, ErrInvalidReplyFrame
+	}
+	body := make([]byte, 2, 2+len(hop)+len(packet))
+	binary.BigEndian.PutUint16(body, uint16(len(hop)))
+	body = append(body, hop...)
+	body = append(body, packet...)
+	return EncodeFrame(flags.ReplyFlag, body)
+}
+
+// DecodeReplyFrame decodes the reply packet carried by the frame together with the first hop it should be relayed to.
+func DecodeReplyFrame(frame Frame) (sphinx.Hop, []byte, error) {
+	if frame.Flag != flags.ReplyFlag || len(frame.Body) < 2 {
+		return sphinx.Hop{}, nil, ErrInvalidReplyFrame
+	}
+	hopLength := int(binary.BigEndian.Uint16(frame.Body))
+	body := frame.Body[2:]
+	if len(body) < hopLength {
+		return sphinx.Hop{}, nil, ErrInvalidReplyFrame
+	}
+	var firstHop sphinx.Hop
+	if err := proto.Unmarshal(body[:hopLength], &firstHop); err != nil {
+		return sphinx.Hop{}, nil, err
+	}
+	if len(firstHop.Address) == 0 || len(firstHop.PubKey) == 0 {
+		return sphinx.Hop{}, nil, ErrInvalidReplyFrame
+	}
+	return firstHop, body[hopLength:], nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyFrame(t *testing.T) {
+	firstHop := sphinx.Hop{Id: "Mix1", Address: "localhost:4001", PubKey: []byte("Mix1PubKey")}
+	b, err := EncodeReplyFrame(firstHop, []byte("packet"))
+	assert.Nil(t, err)
+
+	frame, err := DecodeFrame(b)
+	assert.Nil(t, err)
+	assert.Equal(t, flags.ReplyFlag, frame.Flag)
+	hop, packet, err := DecodeReplyFrame(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, firstHop.Address, hop.Address)
+	assert.Equal(t, firstHop.PubKey, hop.PubKey)
+	assert.Equal(t, []byte("packet"), packet)
+}
+
+func TestReplyFrameInvalid(t *testing.T) {
+	_, _, err := DecodeReplyFrame(Frame{Flag: flags.CommFlag, Body: []byte("packet")})
+	assert.Equal(t, ErrInvalidReplyFrame, err)
+
+	// the length of the hop exceeds the body
+	_, _, err = DecodeReplyFrame(Frame{Flag: flags.ReplyFlag, Body: []byte{0, 42, 1}})
+	assert.Equal(t, ErrInvalidReplyFrame, err)
+
+	// the hop without an address cannot be relayed to
+	b, err := EncodeReplyFrame(sphinx.Hop{PubKey: []byte("key")}, []byte("packet"))
+	assert.Nil(t, err)
+	frame, err := DecodeFrame(b)
+	assert.Nil(t, err)
+	_, _, err = DecodeReplyFrame(frame)
+	assert.Equal(t, ErrInvalidReplyFrame, err)
+}
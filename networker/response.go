@@ -0,0 +1,92 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"errors"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// The provider responds to the requests of the clients with a single frame carrying the config.ProviderResponse,
+// whose packets are the frames the client asked for, such as the token or the messages from its inbox.
+const (
+	// responseOverhead bounds the bytes the encoding of the response adds to its packets.
+	responseOverhead = 16
+	// responsePacketOverhead bounds the bytes the encoding of the response adds to every one of its packets.
+	responsePacketOverhead = 8
+	// MaxProviderResponsePackets is the largest total size of the packets carried by a single response.
+	MaxProviderResponsePackets = MaxFrameBodySize - responseOverhead
+)
+
+var (
+	// ErrUnexpectedResponse is returned when the provider responds with a frame other than the response.
+	ErrUnexpectedResponse = errors.New("the frame is not the response of the provider")
+)
+
+// ProviderResponseSize returns the size the packet adds to the total size of the packets of the response,
+// which cannot exceed MaxProviderResponsePackets.
+func ProviderResponseSize(packet []byte) int {
+	return len(packet) + responsePacketOverhead
+}
+
+// EncodeProviderResponse encodes the response of the provider carrying the given packets into a frame.
+func EncodeProviderResponse(packets ...[]byte) ([]byte, error) {
+	response := &config.ProviderResponse{
+		NumberOfPackets: uint64(len(packets)),
+		Packets:         packets,
+	}
+	b, err := proto.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeFrame(flags.ResponseFlag, b)
+}
+
+// DecodeProviderResponse decodes the frames carried by the response of the provider.
+func DecodeProviderResponse(frame Frame) ([]Frame, error) {
+	if frame.Flag != flags.ResponseFlag {
+		return nil, ErrUnexpectedResponse
+	}
+	var response config.ProviderResponse
+	if err := proto.Unmarshal(frame.Body, &response); err != nil {
+		return nil, err
+	}
+	frames := make([]Frame, len(response.Packets))
+	for i, packet := range response.Packets {
+		decoded, err := DecodeFrame(packet)
+		if err != nil {
+			return nil, err
+		}
+		frames[i] = decoded
+	}
+	return frames, nil
+}
+
+// ReadProviderResponse reads the response of the provider and decodes the frames it carries.
+// It returns no frames if the provider closed the connection without responding,
+// as it does after receiving the sphinx packets.
+func ReadProviderResponse(r io.Reader) ([]Frame, error) {
+	frame, err := ReadFrame(r)
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return DecodeProviderResponse(frame)
+}
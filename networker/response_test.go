@@ -0,0 +1,81 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderResponse(t *testing.T) {
+	token, err := EncodeFrame(flags.TokenFlag, []byte("token"))
+	assert.Nil(t, err)
+	message, err := EncodeFrame(flags.CommFlag, []byte("message"))
+	assert.Nil(t, err)
+	b, err := EncodeProviderResponse(token, message)
+	assert.Nil(t, err)
+
+	frame, err := DecodeFrame(b)
+	assert.Nil(t, err)
+	assert.Equal(t, flags.ResponseFlag, frame.Flag)
+	frames, err := ReadProviderResponse(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, []Frame{{Flag: flags.TokenFlag, Body: []byte("token")}, {Flag: flags.CommFlag, Body: []byte("message")}},
+		frames)
+
+	// the provider does not respond to the sphinx packets
+	frames, err = ReadProviderResponse(bytes.NewReader(nil))
+	assert.Nil(t, err)
+	assert.Empty(t, frames)
+}
+
+func TestProviderResponseInvalid(t *testing.T) {
+	// any other frame is not the response
+	b, err := EncodeFrame(flags.TokenFlag, []byte("token"))
+	assert.Nil(t, err)
+	_, err = ReadProviderResponse(bytes.NewReader(b))
+	assert.Equal(t, ErrUnexpectedResponse, err)
+
+	// the response in an unknown version of the frame format is rejected
+	b, err = EncodeProviderResponse()
+	assert.Nil(t, err)
+	b[frameVersionOffset] = FrameVersion + 1
+	_, err = ReadProviderResponse(bytes.NewReader(b))
+	assert.Equal(t, ErrUnsupportedFrameVersion, err)
+
+	// and so is the response cut short
+	b[frameVersionOffset] = FrameVersion
+	_, err = ReadProviderResponse(bytes.NewReader(b[:FrameHeaderSize-1]))
+	assert.Equal(t, ErrTruncatedFrame, err)
+}
+
+func TestProviderResponseSize(t *testing.T) {
+	// the response carrying the packets of the largest total size still fits in a frame
+	var packets [][]byte
+	size := 0
+	for {
+		packet := bytes.Repeat([]byte{1}, 2100)
+		if size+ProviderResponseSize(packet) > MaxProviderResponsePackets {
+			break
+		}
+		size += ProviderResponseSize(packet)
+		packets = append(packets, packet)
+	}
+	_, err := EncodeProviderResponse(packets...)
+	assert.Nil(t, err)
+}
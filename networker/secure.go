@@ -0,0 +1,561 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// Every connection starts with a handshake following the Noise protocol framework
+// (Noise_IK_25519_AESGCM_SHA256 or Noise_NK_25519_AESGCM_SHA256), in which the initiator
+// authenticates the responder using its known static X25519 key. The nodes use the IK pattern,
+// in which the initiator authenticates itself with its own static key as well,
+// while the clients use the NK pattern and remain anonymous. The initiator starts with a byte identifying
+// the pattern, followed by its handshake message, and the responder replies with its own one.
+// Every handshake message is preceded by its length as 2 bytes in big endian.
+//
+// Once the handshake is completed, all the data is sent in records sealed with AES-GCM, each preceded
+// by its length as 2 bytes in big endian. The frames are then sent on top of the records.
+const (
+	// KeySize is the size of the X25519 keys used in the handshake.
+	KeySize = 32
+	// HandshakeTimeout is the time after which an unfinished handshake fails.
+	HandshakeTimeout = 10 * time.Second
+
+	handshakePatternIK  = 1
+	handshakePatternNK  = 2
+	noiseIKProtocolName = "Noise_IK_25519_AESGCM_SHA256"
+	noiseNKProtocolName = "Noise_NK_25519_AESGCM_SHA256"
+	handshakePrologue   = "nym-mixnet-handshake-v1"
+
+	tagSize = 16
+	// maxRecordSize is the size of the largest sealed record, including the authentication tag.
+	maxRecordSize          = math.MaxUint16
+	maxRecordPlaintextSize = maxRecordSize - tagSize
+)
+
+var (
+	// ErrInvalidKey is returned when the key is not a valid X25519 key.
+	ErrInvalidKey = errors.New("invalid X25519 key")
+	// ErrUnknownHandshakePattern is returned when the initiator requests an unknown handshake pattern.
+	ErrUnknownHandshakePattern = errors.New("unknown handshake pattern")
+	// ErrHandshakeFailed is returned when the handshake message could not be authenticated,
+	// for example because the responder does not have the expected static key.
+	ErrHandshakeFailed = errors.New("handshake failed")
+	// ErrRecordAuthenticationFailed is returned when the received record could not be authenticated.
+	ErrRecordAuthenticationFailed = errors.New("record authentication failed")
+	// ErrNoncesExhausted is returned when the session has sent or received the largest possible number of records.
+	ErrNoncesExhausted = errors.New("nonces of the session are exhausted")
+)
+
+// Identity is the static X25519 key pair authenticating the node in the handshakes.
+// The nodes use the key pairs of their sphinx keys.
+type Identity struct {
+	private [KeySize]byte
+	public  [KeySize]byte
+}
+
+// NewIdentity creates the identity with the given X25519 private key.
+func NewIdentity(privateKey []byte) (*Identity, error) {
+	if len(privateKey) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	id := new(Identity)
+	copy(id.private[:], privateKey)
+	curve25519.ScalarBaseMult(&id.public, &id.private)
+	return id, nil
+}
+
+// generateIdentity creates the ephemeral key pair for a single handshake.
+func generateIdentity() (*Identity, error) {
+	var privateKey [KeySize]byte
+	if _, err := io.ReadFull(rand.Reader, privateKey[:]); err != nil {
+		return nil, err
+	}
+	return NewIdentity(privateKey[:])
+}
+
+// Erase overwrites the private key of the identity with zeros.
+func (id *Identity) Erase() {
+	for i := range id.private {
+		id.private[i] = 0
+	}
+}
+
+// PublicKey returns the public key of the identity.
+func (id *Identity) PublicKey() []byte {
+	return append([]byte{}, id.public[:]...)
+}
+
+// dh performs the Diffie-Hellman operation with the public key of the other party. It rejects the keys
+// of a low order, which would lead to the shared secret known to everyone.
+func (id *Identity) dh(publicKey []byte) ([]byte, error) {
+	var pub, shared [KeySize]byte
+	copy(pub[:], publicKey)
+	curve25519.ScalarMult(&shared, &id.private, &pub)
+	var zero [KeySize]byte
+	if subtle.ConstantTimeCompare(shared[:], zero[:]) == 1 {
+		return nil, ErrInvalidKey
+	}
+	return shared[:], nil
+}
+
+// cipherState seals the consecutive messages with AES-GCM under the same key, using a counter as the nonce.
+type cipherState struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+func newCipherState(key []byte) (*cipherState, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cipherState{aead: aead}, nil
+}
+
+// nextNonce returns the nonce for the next message, which consists of 4 zero bytes and the counter in big endian.
+func (cs *cipherState) nextNonce() ([]byte, error) {
+	if cs.nonce == math.MaxUint64 {
+		return nil, ErrNoncesExhausted
+	}
+	nonce := make([]byte, cs.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], cs.nonce)
+	cs.nonce++
+	return nonce, nil
+}
+
+func (cs *cipherState) encrypt(ad, plaintext []byte) ([]byte, error) {
+	nonce, err := cs.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	return cs.aead.Seal(nil, nonce, plaintext, ad), nil
+}
+
+func (cs *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	nonce, err := cs.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	return cs.aead.Open(nil, nonce, ciphertext, ad)
+}
+
+// symmetricState keeps the chaining key and the hash of the handshake transcript.
+type symmetricState struct {
+	cs *cipherState
+	ck []byte
+	h  []byte
+}
+
+func newSymmetricState(protocolName string) *symmetricState {
+	// all the protocol names are shorter than the hash, so they are padded with zeros
+	h := make([]byte, sha256.Size)
+	copy(h, protocolName)
+	ss := &symmetricState{ck: h, h: h}
+	ss.mixHash([]byte(handshakePrologue))
+	return ss
+}
+
+func (ss *symmetricState) mixHash(data []byte) {
+	hash := sha256.New()
+	_, _ = hash.Write(ss.h)
+	_, _ = hash.Write(data)
+	ss.h = hash.Sum(nil)
+}
+
+func (ss *symmetricState) mixKey(ikm []byte) error {
+	var key []byte
+	ss.ck, key = noiseHKDF(ss.ck, ikm)
+	cs, err := newCipherState(key)
+	if err != nil {
+		return err
+	}
+	ss.cs = cs
+	return nil
+}
+
+// mixDH mixes the shared secret of the given private key and the public key into the chaining key.
+func (ss *symmetricState) mixDH(private *Identity, publicKey []byte) error {
+	shared, err := private.dh(publicKey)
+	if err != nil {
+		return err
+	}
+	return ss.mixKey(shared)
+}
+
+func (ss *symmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	ciphertext, err := ss.cs.encrypt(ss.h, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	ss.mixHash(ciphertext)
+	return ciphertext, nil
+}
+
+func (ss *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := ss.cs.decrypt(ss.h, ciphertext)
+	if err != nil {
+		return nil, ErrHandshakeFailed
+	}
+	ss.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split derives the cipher states of the initiator and the responder for the rest of the session.
+func (ss *symmetricState) split() (*cipherState, *cipherState, error) {
+	k1, k2 := noiseHKDF(ss.ck, nil)
+	c1, err := newCipherState(k1)
+	if err != nil {
+		return nil, nil, err
+	}
+	c2, err := newCipherState(k2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c1, c2, nil
+}
+
+// noiseHKDF derives two keys from the chaining key and the input key material as defined by the Noise protocol.
+func noiseHKDF(ck, ikm []byte) ([]byte, []byte) {
+	prk := hmacSHA256(ck, ikm)
+	out1 := hmacSHA256(prk, []byte{1})
+	out2 := hmacSHA256(prk, append(out1, 2))
+	return out1, out2
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// writeMessage writes the prefix followed by the message preceded by its length.
+func writeMessage(w io.Writer, prefix []byte, msg []byte) error {
+	b := make([]byte, 0, len(prefix)+2+len(msg))
+	b = append(b, prefix...)
+	b = append(b, byte(len(msg)>>8), byte(len(msg)))
+	b = append(b, msg...)
+	_, err := w.Write(b)
+	return err
+}
+
+// readHandshakeMessage reads the handshake message, which has to be of the expected length.
+func readHandshakeMessage(r io.Reader, expectedLength int) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	if int(binary.BigEndian.Uint16(length[:])) != expectedLength {
+		return nil, ErrHandshakeFailed
+	}
+	msg := make([]byte, expectedLength)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ClientHandshake performs the handshake as the initiator with the responder owning the given static key.
+// If the identity is nil, the initiator remains anonymous.
+func ClientHandshake(conn net.Conn, id *Identity, remoteKey []byte) (*SecureConn, error) {
+	if len(remoteKey) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	if err := conn.SetDeadline(time.Now().Add(HandshakeTimeout)); err != nil {
+		return nil, err
+	}
+
+	pattern, protocolName := byte(handshakePatternNK), noiseNKProtocolName
+	if id != nil {
+		pattern, protocolName = handshakePatternIK, noiseIKProtocolName
+	}
+	ss := newSymmetricState(protocolName)
+	ss.mixHash(remoteKey)
+
+	// -> e, es, s, ss (the last two only in IK)
+	e, err := generateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	msg := e.PublicKey()
+	ss.mixHash(e.public[:])
+	if err := ss.mixDH(e, remoteKey); err != nil {
+		return nil, err
+	}
+	if id != nil {
+		encryptedKey, err := ss.encryptAndHash(id.public[:])
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, encryptedKey...)
+		if err := ss.mixDH(id, remoteKey); err != nil {
+			return nil, err
+		}
+	}
+	payload, err := ss.encryptAndHash(nil)
+	if err != nil {
+		return nil, err
+	}
+	msg = append(msg, payload...)
+	if err := writeMessage(conn, []byte{pattern}, msg); err != nil {
+		return nil, err
+	}
+
+	// <- e, ee, se (the last one only in IK)
+	reply, err := readHandshakeMessage(conn, KeySize+tagSize)
+	if err != nil {
+		return nil, err
+	}
+	re := reply[:KeySize]
+	ss.mixHash(re)
+	if err := ss.mixDH(e, re); err != nil {
+		return nil, err
+	}
+	if id != nil {
+		if err := ss.mixDH(id, re); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := ss.decryptAndHash(reply[KeySize:]); err != nil {
+		return nil, err
+	}
+
+	send, recv, err := ss.split()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &SecureConn{Conn: conn, send: send, recv: recv}, nil
+}
+
+// ServerHandshake performs the handshake as the responder owning any of the given static keys, which allows
+// the node to be reached with any of the keys it announced. The initiator might either authenticate itself,
+// in which case its static key is available through PeerKey, or remain anonymous.
+func ServerHandshake(conn net.Conn, ids ...*Identity) (*SecureConn, error) {
+	if len(ids) == 0 {
+		return nil, ErrInvalidKey
+	}
+	if err := conn.SetDeadline(time.Now().Add(HandshakeTimeout)); err != nil {
+		return nil, err
+	}
+
+	var pattern [1]byte
+	if _, err := io.ReadFull(conn, pattern[:]); err != nil {
+		return nil, err
+	}
+	msgLength := KeySize + tagSize
+	switch pattern[0] {
+	case handshakePatternIK:
+		msgLength += KeySize + tagSize
+	case handshakePatternNK:
+	default:
+		return nil, ErrUnknownHandshakePattern
+	}
+
+	// -> e, es, s, ss (the last two only in IK)
+	msg, err := readHandshakeMessage(conn, msgLength)
+	if err != nil {
+		return nil, err
+	}
+	// the message only decrypts with the static key the initiator expected
+	var ss *symmetricState
+	var peerKey []byte
+	for _, id := range ids {
+		ss, peerKey, err = readInitiatorMessage(pattern[0], id, msg)
+		if err != ErrHandshakeFailed {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	re := msg[:KeySize]
+
+	// <- e, ee, se (the last one only in IK)
+	e, err := generateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	reply := e.PublicKey()
+	ss.mixHash(e.public[:])
+	if err := ss.mixDH(e, re); err != nil {
+		return nil, err
+	}
+	if peerKey != nil {
+		if err := ss.mixDH(e, peerKey); err != nil {
+			return nil, err
+		}
+	}
+	payload, err := ss.encryptAndHash(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn, nil, append(reply, payload...)); err != nil {
+		return nil, err
+	}
+
+	recv, send, err := ss.split()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &SecureConn{Conn: conn, peerKey: peerKey, send: send, recv: recv}, nil
+}
+
+// readInitiatorMessage processes the first handshake message as the responder with the given identity.
+// It returns ErrHandshakeFailed if the message was not meant for the identity.
+func readInitiatorMessage(pattern byte, id *Identity, msg []byte) (*symmetricState, []byte, error) {
+	protocolName := noiseNKProtocolName
+	if pattern == handshakePatternIK {
+		protocolName = noiseIKProtocolName
+	}
+	ss := newSymmetricState(protocolName)
+	ss.mixHash(id.public[:])
+
+	re := msg[:KeySize]
+	msg = msg[KeySize:]
+	ss.mixHash(re)
+	if err := ss.mixDH(id, re); err != nil {
+		return nil, nil, err
+	}
+	var peerKey []byte
+	if pattern == handshakePatternIK {
+		var err error
+		peerKey, err = ss.decryptAndHash(msg[:KeySize+tagSize])
+		if err != nil {
+			return nil, nil, err
+		}
+		msg = msg[KeySize+tagSize:]
+		if err := ss.mixDH(id, peerKey); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err := ss.decryptAndHash(msg); err != nil {
+		return nil, nil, err
+	}
+	return ss, peerKey, nil
+}
+
+// SecureConn is the connection over which all the data is encrypted and authenticated
+// with the keys agreed in the handshake.
+type SecureConn struct {
+	net.Conn
+	peerKey []byte
+
+	readMu  sync.Mutex
+	recv    *cipherState
+	readBuf []byte
+	readErr error
+
+	writeMu sync.Mutex
+	send    *cipherState
+}
+
+// PeerKey returns the static key the other party authenticated itself with,
+// or nil if it remained anonymous.
+func (c *SecureConn) PeerKey() []byte {
+	return c.peerKey
+}
+
+// Read reads the decrypted data. It returns io.EOF only if the connection ended between the records.
+func (c *SecureConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.readBuf) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		c.readBuf, c.readErr = c.readRecord()
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *SecureConn) readRecord() ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(c.Conn, length[:]); err != nil {
+		return nil, err
+	}
+	record := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(c.Conn, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	plaintext, err := c.recv.decrypt(nil, record)
+	if err != nil {
+		return nil, ErrRecordAuthenticationFailed
+	}
+	return plaintext, nil
+}
+
+// Write encrypts the data and writes it in as many records as needed.
+func (c *SecureConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxRecordPlaintextSize {
+			chunk = chunk[:maxRecordPlaintextSize]
+		}
+		record, err := c.send.encrypt(nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		if err := writeMessage(c.Conn, nil, record); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// CloseWrite shuts down the writing side of the underlying connection, if it supports it,
+// so that the other party knows no more data is coming.
+func (c *SecureConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
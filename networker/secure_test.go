@@ -0,0 +1,241 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networker
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestIdentity(t *testing.T) *Identity {
+	id, err := generateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// serverResult is the outcome of the handshake on the side of the test server.
+type serverResult struct {
+	conn *SecureConn
+	err  error
+}
+
+// startSecureServer accepts a single connection over loopback and performs the handshake on it.
+func startSecureServer(t *testing.T, ids ...*Identity) (string, <-chan serverResult) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultCh := make(chan serverResult, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			resultCh <- serverResult{err: err}
+			return
+		}
+		sconn, err := ServerHandshake(conn, ids...)
+		if err != nil {
+			conn.Close()
+		}
+		resultCh <- serverResult{conn: sconn, err: err}
+	}()
+	return listener.Addr().String(), resultCh
+}
+
+func dialSecure(t *testing.T, address string, id *Identity, remoteKey []byte) (*SecureConn, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sconn, err := ClientHandshake(conn, id, remoteKey)
+	if err != nil {
+		conn.Close()
+	}
+	return sconn, err
+}
+
+func TestNewIdentity(t *testing.T) {
+	id := createTestIdentity(t)
+	same, err := NewIdentity(id.private[:])
+	assert.Nil(t, err)
+	assert.Equal(t, id.PublicKey(), same.PublicKey())
+
+	_, err = NewIdentity([]byte("short"))
+	assert.Equal(t, ErrInvalidKey, err)
+
+	same.Erase()
+	assert.Equal(t, [KeySize]byte{}, same.private)
+}
+
+func TestMutuallyAuthenticatedSession(t *testing.T) {
+	serverID := createTestIdentity(t)
+	clientID := createTestIdentity(t)
+	address, resultCh := startSecureServer(t, serverID)
+
+	client, err := dialSecure(t, address, clientID, serverID.PublicKey())
+	assert.Nil(t, err)
+	defer client.Close()
+	server := <-resultCh
+	assert.Nil(t, server.err)
+	defer server.conn.Close()
+	assert.Equal(t, clientID.PublicKey(), server.conn.PeerKey())
+
+	// the frames larger than a single record are split and put back together
+	body := bytes.Repeat([]byte{7}, MaxFrameBodySize)
+	assert.Nil(t, WriteFrame(client, flags.CommFlag, body))
+	assert.Nil(t, WriteFrame(client, flags.CommFlag, []byte("second")))
+	frame, err := ReadFrame(server.conn)
+	assert.Nil(t, err)
+	assert.Equal(t, body, frame.Body)
+	frame, err = ReadFrame(server.conn)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("second"), frame.Body)
+
+	// and the other way round
+	assert.Nil(t, WriteFrame(server.conn, flags.TokenFlag, []byte("reply")))
+	assert.Nil(t, server.conn.CloseWrite())
+	frame, err = ReadFrame(client)
+	assert.Nil(t, err)
+	assert.Equal(t, Frame{Flag: flags.TokenFlag, Body: []byte("reply")}, frame)
+	_, err = ReadFrame(client)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestAnonymousSession(t *testing.T) {
+	serverID := createTestIdentity(t)
+	address, resultCh := startSecureServer(t, serverID)
+
+	client, err := dialSecure(t, address, nil, serverID.PublicKey())
+	assert.Nil(t, err)
+	defer client.Close()
+	server := <-resultCh
+	assert.Nil(t, server.err)
+	defer server.conn.Close()
+	assert.Nil(t, server.conn.PeerKey())
+
+	_, err = client.Write([]byte("request"))
+	assert.Nil(t, err)
+	assert.Nil(t, client.CloseWrite())
+	received, err := ioutil.ReadAll(server.conn)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("request"), received)
+}
+
+func TestHandshakeWithWrongServerKey(t *testing.T) {
+	serverID := createTestIdentity(t)
+	impostorID := createTestIdentity(t)
+
+	for _, clientID := range []*Identity{createTestIdentity(t), nil} {
+		address, resultCh := startSecureServer(t, impostorID)
+		// the server cannot decrypt the handshake message meant for the expected key
+		_, err := dialSecure(t, address, clientID, serverID.PublicKey())
+		assert.NotNil(t, err)
+		assert.Equal(t, ErrHandshakeFailed, (<-resultCh).err)
+	}
+}
+
+func TestHandshakeWithAnyOfServerKeys(t *testing.T) {
+	serverIDs := []*Identity{createTestIdentity(t), createTestIdentity(t), createTestIdentity(t)}
+	clientID := createTestIdentity(t)
+
+	for _, serverID := range serverIDs {
+		address, resultCh := startSecureServer(t, serverIDs...)
+		client, err := dialSecure(t, address, clientID, serverID.PublicKey())
+		assert.Nil(t, err)
+		server := <-resultCh
+		assert.Nil(t, server.err)
+		assert.Equal(t, clientID.PublicKey(), server.conn.PeerKey())
+
+		assert.Nil(t, WriteFrame(client, flags.CommFlag, []byte("packet")))
+		frame, err := ReadFrame(server.conn)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("packet"), frame.Body)
+		client.Close()
+		server.conn.Close()
+	}
+
+	address, resultCh := startSecureServer(t, serverIDs...)
+	_, err := dialSecure(t, address, nil, createTestIdentity(t).PublicKey())
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrHandshakeFailed, (<-resultCh).err)
+}
+
+func TestHandshakeWithUnknownPattern(t *testing.T) {
+	address, resultCh := startSecureServer(t, createTestIdentity(t))
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte{42})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrUnknownHandshakePattern, (<-resultCh).err)
+}
+
+// tamperingConn flips a bit of the data written after the handshake.
+type tamperingConn struct {
+	net.Conn
+	tamper bool
+}
+
+func (c *tamperingConn) Write(b []byte) (int, error) {
+	if c.tamper {
+		b = append([]byte{}, b...)
+		b[len(b)-1] ^= 1
+	}
+	return c.Conn.Write(b)
+}
+
+func TestTamperedRecord(t *testing.T) {
+	serverID := createTestIdentity(t)
+	address, resultCh := startSecureServer(t, serverID)
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &tamperingConn{Conn: conn}
+	client, err := ClientHandshake(tc, createTestIdentity(t), serverID.PublicKey())
+	assert.Nil(t, err)
+	defer client.Close()
+	server := <-resultCh
+	assert.Nil(t, server.err)
+	defer server.conn.Close()
+
+	tc.tamper = true
+	_, err = client.Write([]byte("packet"))
+	assert.Nil(t, err)
+	_, err = server.conn.Read(make([]byte, 16))
+	assert.Equal(t, ErrRecordAuthenticationFailed, err)
+	// the session can no longer be trusted
+	_, err = server.conn.Read(make([]byte, 16))
+	assert.Equal(t, ErrRecordAuthenticationFailed, err)
+}
+
+func TestClientHandshakeInvalidKey(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	_, err := ClientHandshake(client, nil, []byte("short"))
+	assert.Equal(t, ErrInvalidKey, err)
+}
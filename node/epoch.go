@@ -0,0 +1,224 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/node/replay"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+var (
+	// ErrInvalidEpochParameters is returned when the key epochs cannot be created with the given parameters.
+	ErrInvalidEpochParameters = errors.New("invalid key epochs: the grace period has to be shorter than the epoch")
+)
+
+// EpochKey is the sphinx key pair used by the node during a single key epoch.
+// The epochs are consecutive periods of the same length counted from the Unix epoch,
+// so that all the nodes using the same length agree on them.
+type EpochKey struct {
+	// Epoch is the number of the epoch.
+	Epoch uint64
+	// Start is the time the epoch starts at.
+	Start time.Time
+	// End is the time the epoch ends at, which is the start of the following one.
+	End time.Time
+	// PrivKey is the private key of the epoch. It is nil in the keys returned by Announced.
+	PrivKey *sphinx.PrivateKey
+	// PubKey is the public key of the epoch.
+	PubKey *sphinx.PublicKey
+
+	// replays detects the replayed packets created for the key. It is dropped together with the key,
+	// so that the packets are remembered for exactly as long as they are accepted.
+	replays *replay.Filter
+}
+
+// KeyRing holds the sphinx keys of the previous, the current and the next key epoch. The key of the next epoch
+// is generated in advance and announced together with the current one. As the clients cannot tell the announced
+// keys apart, the packets are accepted for both of them. The key of the previous epoch is still accepted during
+// the grace period following the end of its epoch, which allows the packets created for it shortly before
+// the end of the epoch to arrive, and it is erased afterwards.
+type KeyRing struct {
+	sync.RWMutex
+	duration time.Duration
+	grace    time.Duration
+	clock    Clock
+	rng      io.Reader
+
+	previous *EpochKey
+	current  *EpochKey
+	next     *EpochKey
+}
+
+// NewKeyRing creates the KeyRing with the keys of the current and the next epoch of the given duration.
+// The key of the previous epoch is accepted for the given grace period after the epoch ends,
+// which has to be shorter than the epoch.
+func NewKeyRing(duration, grace time.Duration, clock Clock) (*KeyRing, error) {
+	if duration <= 0 || grace < 0 || grace >= duration {
+		return nil, ErrInvalidEpochParameters
+	}
+	r := &KeyRing{duration: duration, grace: grace, clock: clock, rng: rand.Reader}
+	if _, err := r.Update(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// EpochAt returns the number of the epoch of the given duration the given time belongs to.
+func EpochAt(t time.Time, duration time.Duration) uint64 {
+	return uint64(t.UnixNano() / int64(duration))
+}
+
+func (r *KeyRing) newKey(epoch uint64) (*EpochKey, error) {
+	priv, pub, err := sphinx.GenerateKeyPairFrom(r.rng)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Unix(0, int64(epoch)*int64(r.duration))
+	return &EpochKey{Epoch: epoch,
+		Start:   start,
+		End:     start.Add(r.duration),
+		PrivKey: priv,
+		PubKey:  pub,
+		replays: replay.NewDefaultFilter(),
+	}, nil
+}
+
+// expired tells whether the key is no longer accepted at the given time.
+func (r *KeyRing) expired(key *EpochKey, now time.Time) bool {
+	return !now.Before(key.End.Add(r.grace))
+}
+
+func eraseKey(key *EpochKey) {
+	if key != nil {
+		key.PrivKey.Erase()
+		key.replays = nil
+	}
+}
+
+// Update brings the keys up to date with the current time. Once a new epoch starts, the key of the next epoch
+// becomes the current one and the key of the following epoch is generated. The key of the previous epoch is erased
+// as soon as its grace period ends. Update returns true if a new epoch has started since the last update.
+func (r *KeyRing) Update() (bool, error) {
+	now := r.clock.Now()
+	epoch := EpochAt(now, r.duration)
+
+	r.Lock()
+	defer r.Unlock()
+
+	changed := false
+	if r.current == nil || r.current.Epoch != epoch {
+		current := r.next
+		if current == nil || current.Epoch != epoch {
+			// the node was not updated for a whole epoch, so the key announced as the next one is already outdated
+			var err error
+			if current, err = r.newKey(epoch); err != nil {
+				return false, err
+			}
+			eraseKey(r.next)
+		}
+		next, err := r.newKey(epoch + 1)
+		if err != nil {
+			return false, err
+		}
+
+		eraseKey(r.previous)
+		r.previous = nil
+		if r.current != nil {
+			changed = true
+			if r.current.Epoch+1 == epoch {
+				r.previous = r.current
+			} else {
+				eraseKey(r.current)
+			}
+		}
+		r.current = current
+		r.next = next
+	}
+
+	if r.previous != nil && r.expired(r.previous, now) {
+		eraseKey(r.previous)
+		r.previous = nil
+	}
+	return changed, nil
+}
+
+// accepted returns the keys the packets are accepted for at the given time, the current one first,
+// which are the announced keys and the previous key during its grace period. It has to be called with the lock held.
+func (r *KeyRing) accepted(now time.Time) []*EpochKey {
+	keys := make([]*EpochKey, 0, 3)
+	for _, key := range []*EpochKey{r.current, r.previous, r.next} {
+		if key != nil && !r.expired(key, now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// withAccepted calls fn with the keys the packets are currently accepted for, the key of the current epoch first.
+// The keys must not be retained after fn returns, as they might be erased at any time afterwards.
+func (r *KeyRing) withAccepted(fn func(keys []*EpochKey)) {
+	r.RLock()
+	defer r.RUnlock()
+	fn(r.accepted(r.clock.Now()))
+}
+
+// Current returns the public key of the current epoch.
+func (r *KeyRing) Current() *sphinx.PublicKey {
+	r.RLock()
+	defer r.RUnlock()
+	return r.current.PubKey
+}
+
+// Accepted returns the public keys the packets are currently accepted for, the key of the current epoch first.
+func (r *KeyRing) Accepted() []*sphinx.PublicKey {
+	r.RLock()
+	defer r.RUnlock()
+	keys := r.accepted(r.clock.Now())
+	pubKeys := make([]*sphinx.PublicKey, len(keys))
+	for i, key := range keys {
+		pubKeys[i] = key.PubKey
+	}
+	return pubKeys
+}
+
+// Announced returns the keys of the current and the next epoch, without their private keys,
+// which the node announces to the network.
+func (r *KeyRing) Announced() []EpochKey {
+	r.RLock()
+	defer r.RUnlock()
+	announced := make([]EpochKey, 0, 2)
+	for _, key := range []*EpochKey{r.current, r.next} {
+		announced = append(announced, EpochKey{Epoch: key.Epoch, Start: key.Start, End: key.End, PubKey: key.PubKey})
+	}
+	return announced
+}
+
+// PrivateKeys calls fn with the private keys the packets are currently accepted for, the key of the current
+// epoch first. The keys must not be retained after fn returns, as they might be erased at any time afterwards.
+func (r *KeyRing) PrivateKeys(fn func(keys []*sphinx.PrivateKey)) {
+	r.withAccepted(func(keys []*EpochKey) {
+		privKeys := make([]*sphinx.PrivateKey, len(keys))
+		for i, key := range keys {
+			privKeys[i] = key.PrivKey
+		}
+		fn(privKeys)
+	})
+}
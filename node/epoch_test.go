@@ -0,0 +1,162 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testEpochDuration = time.Hour
+	testGracePeriod   = 5 * time.Minute
+)
+
+// createTestKeyRing creates the key ring with the clock set to the given time into the 1000th epoch.
+func createTestKeyRing(t *testing.T, intoEpoch time.Duration) (*KeyRing, *ManualClock) {
+	clock := NewManualClock(time.Unix(0, 0).Add(1000*testEpochDuration + intoEpoch))
+	keys, err := NewKeyRing(testEpochDuration, testGracePeriod, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, clock
+}
+
+func TestNewKeyRingInvalidParameters(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	for _, grace := range []time.Duration{-time.Second, testEpochDuration, 2 * testEpochDuration} {
+		_, err := NewKeyRing(testEpochDuration, grace, clock)
+		assert.Equal(t, ErrInvalidEpochParameters, err)
+	}
+	_, err := NewKeyRing(0, 0, clock)
+	assert.Equal(t, ErrInvalidEpochParameters, err)
+}
+
+func TestKeyRingAcrossEpochs(t *testing.T) {
+	keys, clock := createTestKeyRing(t, 30*time.Minute)
+
+	announced := keys.Announced()
+	assert.Len(t, announced, 2)
+	assert.Equal(t, uint64(1000), announced[0].Epoch)
+	assert.Equal(t, uint64(1001), announced[1].Epoch)
+	assert.Equal(t, time.Unix(0, 0).Add(1001*testEpochDuration), announced[0].End)
+	assert.Equal(t, announced[0].End, announced[1].Start)
+	assert.Nil(t, announced[1].PrivKey)
+	assert.Equal(t, announced[0].PubKey, keys.Current())
+	assert.Equal(t, []*sphinx.PublicKey{announced[0].PubKey, announced[1].PubKey}, keys.Accepted())
+
+	// the key announced as the next one becomes the current one once its epoch starts,
+	// and the previous one is kept for a while
+	clock.Advance(31 * time.Minute)
+	changed, err := keys.Update()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, announced[1].PubKey, keys.Current())
+	next := keys.Announced()[1]
+	assert.Equal(t, uint64(1002), next.Epoch)
+	assert.Equal(t, []*sphinx.PublicKey{announced[1].PubKey, announced[0].PubKey, next.PubKey}, keys.Accepted())
+
+	var previous *sphinx.PrivateKey
+	keys.PrivateKeys(func(privKeys []*sphinx.PrivateKey) {
+		previous = privKeys[1]
+	})
+
+	// once the grace period ends, the previous key is no longer accepted and gets erased
+	clock.Advance(testGracePeriod)
+	assert.Equal(t, []*sphinx.PublicKey{announced[1].PubKey, next.PubKey}, keys.Accepted())
+	changed, err = keys.Update()
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, make([]byte, sphinx.PrivateKeySize), previous.Bytes())
+}
+
+func TestKeyRingWithoutUpdates(t *testing.T) {
+	keys, clock := createTestKeyRing(t, 59*time.Minute)
+	announced := keys.Announced()
+
+	// both announced keys stay accepted after the epoch ends, even before the keys are updated
+	clock.Advance(2 * time.Minute)
+	assert.Equal(t, []*sphinx.PublicKey{announced[0].PubKey, announced[1].PubKey}, keys.Accepted())
+
+	// if the node missed a whole epoch, none of the old keys are kept
+	clock.Advance(2 * testEpochDuration)
+	changed, err := keys.Update()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	accepted := keys.Accepted()
+	assert.Len(t, accepted, 2)
+	for _, key := range accepted {
+		assert.NotEqual(t, announced[0].PubKey, key)
+		assert.NotEqual(t, announced[1].PubKey, key)
+	}
+	assert.Equal(t, uint64(1003), keys.Announced()[0].Epoch)
+}
+
+func TestMixProcessPacketAcrossEpochs(t *testing.T) {
+	keys, clock := createTestKeyRing(t, 59*time.Minute)
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerWorker.UseKeyRing(keys)
+
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	createPacket := func(providerKey *sphinx.PublicKey) []byte {
+		provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3333", PubKey: providerKey.Bytes()}
+		dest := config.ClientConfig{Id: "Destination", Host: "localhost", Port: "3334", Provider: &provider}
+		packet, err := createTestPacket(mixes, provider, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := packet.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	announced := keys.Announced()
+	longTermPacket := createPacket(providerWorker.GetPublicKey())
+	currentPacket := createPacket(announced[0].PubKey)
+	nextPacket := createPacket(announced[1].PubKey)
+
+	// the long-term key is no longer used for the packets, while both announced keys are
+	assert.Equal(t, sphinx.ErrInvalidMac, providerWorker.ProcessPacket(longTermPacket).Err())
+	assert.Nil(t, providerWorker.ProcessPacket(nextPacket).Err())
+	assert.Nil(t, providerWorker.ProcessPacket(currentPacket).Err())
+
+	// the replays are still detected once the next key becomes the current one and the current one the previous one
+	clock.Advance(2 * time.Minute)
+	changed, err := providerWorker.UpdateKeys()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, sphinx.ErrReplayedPacket, providerWorker.ProcessPacket(nextPacket).Err())
+	assert.Equal(t, sphinx.ErrReplayedPacket, providerWorker.ProcessPacket(currentPacket).Err())
+	assert.Equal(t, uint64(2), providerWorker.ReplayedPackets())
+	// the fresh packets of the previous epoch are still accepted during the grace period
+	assert.Nil(t, providerWorker.ProcessPacket(createPacket(announced[0].PubKey)).Err())
+
+	clock.Advance(testGracePeriod)
+	_, err = providerWorker.UpdateKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, sphinx.ErrInvalidMac, providerWorker.ProcessPacket(createPacket(announced[0].PubKey)).Err())
+	assert.Nil(t, providerWorker.ProcessPacket(createPacket(announced[1].PubKey)).Err())
+}
@@ -17,22 +17,35 @@
 package node
 
 import (
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
-	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/node/replay"
 	"github.com/nymtech/nym-mixnet/sphinx"
 )
 
 type Mix struct {
-	pubKey *sphinx.PublicKey
-	prvKey *sphinx.PrivateKey
+	// the counters are accessed atomically and are kept first to guarantee their 64-bit alignment
+	unsupportedVersions uint64
+	replayedPackets     uint64
+
+	pubKey   *sphinx.PublicKey
+	prvKey   *sphinx.PrivateKey
+	keys     *KeyRing
+	replays  *replay.Filter
+	versions *sphinx.Registry
 }
 
 type PacketProcessingResult struct {
 	packetData []byte
 	nextHop    sphinx.Hop
-	flag       flags.SphinxFlag
+	commands   sphinx.CommandSet
 	err        error
+
+	processingTime time.Duration
+	receivedAt     time.Time
 }
 
 func (p *PacketProcessingResult) PacketData() []byte {
@@ -43,41 +56,166 @@ func (p *PacketProcessingResult) NextHop() sphinx.Hop {
 	return p.nextHop
 }
 
-func (p *PacketProcessingResult) Flag() flags.SphinxFlag {
-	return p.flag
+// Commands returns the routing commands telling the node what to do with the processed packet.
+func (p *PacketProcessingResult) Commands() sphinx.CommandSet {
+	return p.commands
+}
+
+// Delay returns how long the node should hold the processed packet before acting on it.
+func (p *PacketProcessingResult) Delay() time.Duration {
+	return time.Duration(p.commands.Delay() * float64(time.Second))
 }
 
 func (p *PacketProcessingResult) Err() error {
 	return p.err
 }
 
+// ProcessingTime returns how long the cryptographic processing of the packet took.
+func (p *PacketProcessingResult) ProcessingTime() time.Duration {
+	return p.processingTime
+}
+
+// ReceivedAt returns the time the packet was scheduled at, or the zero time if it was processed directly.
+func (p *PacketProcessingResult) ReceivedAt() time.Time {
+	return p.receivedAt
+}
+
 // ProcessPacket performs the processing operation on the received packet, including cryptographic operations and
-// extraction of the meta information.
+// extraction of the meta information. Packets in versions of the packet format not accepted by the mix
+// are rejected with sphinx.ErrUnsupportedVersion. ProcessPacket returns straight away, holding the packet
+// for its delay is left to the caller, normally the Scheduler.
 func (m *Mix) ProcessPacket(packet []byte) *PacketProcessingResult {
 	res := new(PacketProcessingResult)
 
-	nextHop, commands, newPacket, err := sphinx.ProcessSphinxPacket(packet, m.prvKey)
+	start := time.Now()
+	nextHop, commands, newPacket, err := m.processPacket(packet)
+	res.processingTime = time.Since(start)
 	res.err = err
-
-	// rather than sleeping in new gouroutine and waiting for channel data that is sent from it
-	// just sleep in the main goroutine and avoid extra communication overhead
-	time.Sleep(time.Second * time.Duration(commands.Delay))
+	if err == sphinx.ErrUnsupportedVersion {
+		atomic.AddUint64(&m.unsupportedVersions, 1)
+	} else if err == sphinx.ErrReplayedPacket {
+		atomic.AddUint64(&m.replayedPackets, 1)
+	}
+	if err != nil {
+		return res
+	}
 
 	res.packetData = newPacket
 	res.nextHop = nextHop
-	res.flag = flags.SphinxFlagFromBytes(commands.Flag)
+	res.commands = commands
 
 	return res
 }
 
+// processPacket processes the packet with the long-term key of the mix or, if the mix uses the key ring,
+// with every key of the key ring that is currently accepted until the MAC of the packet matches.
+// Every key of the key ring detects the replays of the packets created for it on its own.
+func (m *Mix) processPacket(packet []byte) (sphinx.Hop, sphinx.CommandSet, []byte, error) {
+	if m.keys == nil {
+		return m.versions.ProcessPacket(packet, m.prvKey, m.replays)
+	}
+
+	var nextHop sphinx.Hop
+	var commands sphinx.CommandSet
+	var newPacket []byte
+	err := sphinx.ErrInvalidMac
+	m.keys.withAccepted(func(keys []*EpochKey) {
+		for _, key := range keys {
+			nextHop, commands, newPacket, err = m.versions.ProcessPacket(packet, key.PrivKey, key.replays)
+			if err != sphinx.ErrInvalidMac {
+				return
+			}
+		}
+	})
+	return nextHop, commands, newPacket, err
+}
+
+// UseKeyRing makes the mix process the packets with the keys of the key epochs held by the key ring
+// instead of its long-term key, which is then only used to identify the mix. It has to be called
+// before the mix processes any packets.
+func (m *Mix) UseKeyRing(keys *KeyRing) {
+	m.keys = keys
+}
+
+// KeyRing returns the key ring used by the mix, or nil if the mix only uses its long-term key.
+func (m *Mix) KeyRing() *KeyRing {
+	return m.keys
+}
+
+// UpdateKeys brings the keys of the key ring up to date. It returns true if a new epoch has started.
+func (m *Mix) UpdateKeys() (bool, error) {
+	if m.keys == nil {
+		return false, nil
+	}
+	return m.keys.Update()
+}
+
+// SphinxKey returns the public key the packets should currently be created for, which is the key
+// of the current epoch if the mix uses the key ring, and its long-term key otherwise.
+func (m *Mix) SphinxKey() *sphinx.PublicKey {
+	if m.keys == nil {
+		return m.pubKey
+	}
+	return m.keys.Current()
+}
+
+// AnnouncedKeys returns the keys of the current and the next epoch the mix announces to the network,
+// or nil if the mix does not use the key ring, in which case it announces its long-term key.
+func (m *Mix) AnnouncedKeys() []EpochKey {
+	if m.keys == nil {
+		return nil
+	}
+	return m.keys.Announced()
+}
+
+// ExtractMessage recovers the message from the packet for which the mix was the final hop.
+func (m *Mix) ExtractMessage(packet []byte) ([]byte, error) {
+	return m.versions.ExtractMessage(packet)
+}
+
 // GetPublicKey returns the public key of the mixnode.
 func (m *Mix) GetPublicKey() *sphinx.PublicKey {
 	return m.pubKey
 }
 
-// NewMix creates a new instance of Mix struct with given public and private key
+// ReplayedPackets returns the number of packets that were rejected as replays.
+func (m *Mix) ReplayedPackets() uint64 {
+	return atomic.LoadUint64(&m.replayedPackets)
+}
+
+// UnsupportedVersionPackets returns the number of packets that were rejected
+// because of the version of their packet format.
+func (m *Mix) UnsupportedVersionPackets() uint64 {
+	return atomic.LoadUint64(&m.unsupportedVersions)
+}
+
+// AcceptedVersions returns the versions of the packet format accepted by the mix.
+func (m *Mix) AcceptedVersions() []sphinx.Version {
+	return m.versions.Versions()
+}
+
+// NewMix creates a new instance of Mix struct with given public and private key,
+// accepting all the versions of the packet format known to the sphinx package.
 func NewMix(prvKey *sphinx.PrivateKey, pubKey *sphinx.PublicKey) *Mix {
 	return &Mix{prvKey: prvKey,
-		pubKey: pubKey,
+		pubKey:   pubKey,
+		replays:  replay.NewDefaultFilter(),
+		versions: sphinx.DefaultRegistry,
+	}
+}
+
+// NewMixWithVersions creates a new instance of Mix struct with given public and private key,
+// only accepting the given versions of the packet format. It returns an error if any of the versions
+// is not known to the sphinx package.
+func NewMixWithVersions(prvKey *sphinx.PrivateKey, pubKey *sphinx.PublicKey, versions []sphinx.Version) (*Mix, error) {
+	if len(versions) == 0 {
+		return nil, errors.New("at least one packet version has to be accepted")
+	}
+	registry, err := sphinx.DefaultRegistry.Restrict(versions)
+	if err != nil {
+		return nil, fmt.Errorf("error in NewMixWithVersions - invalid packet versions %v: %v", versions, err)
 	}
+	m := NewMix(prvKey, pubKey)
+	m.versions = registry
+	return m, nil
 }
@@ -18,10 +18,9 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
-	"github.com/nymtech/nym-mixnet/flags"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/stretchr/testify/assert"
 )
@@ -108,7 +107,7 @@ func TestMixProcessPacket(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testPacketBytes, err := proto.Marshal(testPacket)
+	testPacketBytes, err := testPacket.MarshalBinary()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -116,7 +115,7 @@ func TestMixProcessPacket(t *testing.T) {
 	res := providerWorker.ProcessPacket(testPacketBytes)
 	dePacket := res.PacketData()
 	nextHop := res.NextHop()
-	flag := res.Flag()
+	commands := res.Commands()
 	if err := res.Err(); err != nil {
 		t.Fatal(err)
 	}
@@ -126,5 +125,89 @@ func TestMixProcessPacket(t *testing.T) {
 		PubKey:  nodes[0].PubKey,
 	}, nextHop, "Next hop does not match")
 	assert.Equal(t, reflect.TypeOf([]byte{}), reflect.TypeOf(dePacket))
-	assert.Equal(t, flags.RelayFlag, flag, reflect.TypeOf(dePacket))
+	assert.Equal(t, sphinx.RelayCommand{}, commands.Action(), reflect.TypeOf(dePacket))
+	assert.Equal(t, 1400*time.Millisecond, res.Delay())
+}
+
+func TestMixProcessPacketReplay(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := config.MixConfig{Id: "Provider",
+		Host: "localhost",
+		Port: "3333", PubKey: providerWorker.pubKey.Bytes(),
+	}
+	dest := config.ClientConfig{Id: "Destination",
+		Host:     "localhost",
+		Port:     "3334",
+		Provider: &provider,
+	}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider, Recipient: dest}
+	testPacket, err := sphinx.PackForwardMessage(path, []float64{0.0, 0.0, 0.0, 0.0, 0.0}, []byte("Test Message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testPacketBytes, err := testPacket.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := providerWorker.ProcessPacket(testPacketBytes)
+	assert.Nil(t, res.Err())
+	assert.Equal(t, uint64(0), providerWorker.ReplayedPackets())
+
+	res = providerWorker.ProcessPacket(testPacketBytes)
+	assert.Equal(t, sphinx.ErrReplayedPacket, res.Err())
+	assert.Nil(t, res.PacketData())
+	assert.Equal(t, uint64(1), providerWorker.ReplayedPackets())
+}
+
+func TestProcessPacketUnsupportedVersion(t *testing.T) {
+	privP, pubP, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyOnly, err := NewMixWithVersions(privP, pubP, []sphinx.Version{sphinx.VersionLegacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []sphinx.Version{sphinx.VersionLegacy}, legacyOnly.AcceptedVersions())
+
+	provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3333", PubKey: pubP.Bytes()}
+	dest := config.ClientConfig{Id: "Destination", Host: "localhost", Port: "3334", Provider: &provider}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacket, err := createTestPacket(mixes, provider, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacketBytes, err := testPacket.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := legacyOnly.ProcessPacket(testPacketBytes)
+	assert.Equal(t, sphinx.ErrUnsupportedVersion, res.Err())
+	assert.Equal(t, uint64(1), legacyOnly.UnsupportedVersionPackets())
+	assert.Equal(t, uint64(0), legacyOnly.ReplayedPackets())
+}
+
+func TestNewMixWithVersionsInvalid(t *testing.T) {
+	privP, pubP, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewMixWithVersions(privP, pubP, nil)
+	assert.Error(t, err)
+	_, err = NewMixWithVersions(privP, pubP, []sphinx.Version{0x02})
+	assert.Error(t, err)
 }
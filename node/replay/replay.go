@@ -0,0 +1,190 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package replay implements a memory-bounded detector of replayed sphinx packets.
+Tags of processed packets are recorded in a pair of rotating Bloom filters, so that
+the memory usage does not depend on the number of processed packets.
+*/
+package replay
+
+import (
+	"errors"
+	"hash/maphash"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultCapacity is the default number of tags recorded in a single generation of the filter.
+	DefaultCapacity = 1 << 20
+	// DefaultFalsePositiveRate is the default probability of treating a fresh packet as a replay.
+	DefaultFalsePositiveRate = 1e-5
+)
+
+var (
+	// ErrInvalidParameters is returned when the filter cannot be created with the given parameters.
+	ErrInvalidParameters = errors.New("invalid replay filter parameters")
+)
+
+// bloom is a basic Bloom filter. It is not safe for concurrent use.
+type bloom struct {
+	bits   []uint64
+	m      uint64
+	hashes uint64
+}
+
+func newBloom(m, hashes uint64) *bloom {
+	return &bloom{
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		hashes: hashes,
+	}
+}
+
+func (b *bloom) contains(h1, h2 uint64) bool {
+	for i := uint64(0); i < b.hashes; i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloom) add(h1, h2 uint64) {
+	for i := uint64(0); i < b.hashes; i++ {
+		pos := (h1 + i*h2) % b.m
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (b *bloom) reset() {
+	for i := range b.bits {
+		b.bits[i] = 0
+	}
+}
+
+// Filter detects replayed tags. It keeps the current and the previous generation of recorded tags
+// and rotates them either explicitly or once the current generation reaches its capacity.
+// Note that tags older than two generations are forgotten, so every key used for packet processing
+// should have a filter of its own, dropped together with the key.
+type Filter struct {
+	// counters are accessed atomically and are kept first to guarantee their 64-bit alignment
+	rejected  uint64
+	recorded  uint64
+	rotations uint64
+
+	sync.Mutex
+	current  *bloom
+	previous *bloom
+	capacity uint64
+	inserted uint64
+	seed1    maphash.Seed
+	seed2    maphash.Seed
+}
+
+// NewFilter creates a new replay filter, where each generation can hold up to capacity tags
+// while keeping the probability of false positives at the specified rate.
+func NewFilter(capacity uint64, falsePositiveRate float64) (*Filter, error) {
+	if capacity == 0 || falsePositiveRate <= 0.0 || falsePositiveRate >= 1.0 {
+		return nil, ErrInvalidParameters
+	}
+
+	// optimal values for the number of bits and hash functions
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := uint64(math.Ceil(float64(m) / float64(capacity) * math.Ln2))
+
+	return &Filter{
+		current:  newBloom(m, hashes),
+		previous: newBloom(m, hashes),
+		capacity: capacity,
+		seed1:    maphash.MakeSeed(),
+		seed2:    maphash.MakeSeed(),
+	}, nil
+}
+
+// NewDefaultFilter creates a new replay filter with the default parameters.
+func NewDefaultFilter() *Filter {
+	// the default parameters are valid so the error can be safely ignored
+	f, _ := NewFilter(DefaultCapacity, DefaultFalsePositiveRate)
+	return f
+}
+
+// indices computes the two base hashes of the tag used for double hashing.
+// The hashes are keyed with random seeds so that an adversary could not
+// craft tags that deliberately saturate particular bits of the filter.
+func (f *Filter) indices(tag []byte) (uint64, uint64) {
+	var h maphash.Hash
+	h.SetSeed(f.seed1)
+	_, _ = h.Write(tag)
+	h1 := h.Sum64()
+
+	h.SetSeed(f.seed2)
+	_, _ = h.Write(tag)
+	// make sure the second hash is odd so that it would never be zero
+	h2 := h.Sum64() | 1
+	return h1, h2
+}
+
+// CheckAndRecord reports whether the given tag was seen before. If it was not, it gets recorded.
+func (f *Filter) CheckAndRecord(tag []byte) bool {
+	h1, h2 := f.indices(tag)
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.current.contains(h1, h2) || f.previous.contains(h1, h2) {
+		atomic.AddUint64(&f.rejected, 1)
+		return true
+	}
+
+	if f.inserted >= f.capacity {
+		f.rotate()
+	}
+	f.current.add(h1, h2)
+	f.inserted++
+	atomic.AddUint64(&f.recorded, 1)
+	return false
+}
+
+// Rotate discards the previous generation of tags and starts a fresh one.
+func (f *Filter) Rotate() {
+	f.Lock()
+	defer f.Unlock()
+	f.rotate()
+}
+
+func (f *Filter) rotate() {
+	f.previous, f.current = f.current, f.previous
+	f.current.reset()
+	f.inserted = 0
+	atomic.AddUint64(&f.rotations, 1)
+}
+
+// Rejected returns the number of tags that were rejected as replays.
+func (f *Filter) Rejected() uint64 {
+	return atomic.LoadUint64(&f.rejected)
+}
+
+// Recorded returns the number of tags that were recorded as fresh.
+func (f *Filter) Recorded() uint64 {
+	return atomic.LoadUint64(&f.recorded)
+}
+
+// Rotations returns the number of times the filter has been rotated.
+func (f *Filter) Rotations() uint64 {
+	return atomic.LoadUint64(&f.rotations)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tagFromUint64(n uint64) []byte {
+	b := make([]byte, 32)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func TestNewFilterInvalidParameters(t *testing.T) {
+	_, err := NewFilter(0, 0.01)
+	assert.Equal(t, ErrInvalidParameters, err)
+
+	_, err = NewFilter(100, 0.0)
+	assert.Equal(t, ErrInvalidParameters, err)
+
+	_, err = NewFilter(100, 1.0)
+	assert.Equal(t, ErrInvalidParameters, err)
+}
+
+func TestFilterDetectsReplay(t *testing.T) {
+	f, err := NewFilter(1000, 1e-6)
+	assert.Nil(t, err)
+
+	tag := tagFromUint64(42)
+	assert.False(t, f.CheckAndRecord(tag))
+	assert.True(t, f.CheckAndRecord(tag))
+	assert.True(t, f.CheckAndRecord(tag))
+
+	assert.Equal(t, uint64(1), f.Recorded())
+	assert.Equal(t, uint64(2), f.Rejected())
+}
+
+func TestFilterDistinctTags(t *testing.T) {
+	f, err := NewFilter(1000, 1e-6)
+	assert.Nil(t, err)
+
+	for i := uint64(0); i < 1000; i++ {
+		assert.False(t, f.CheckAndRecord(tagFromUint64(i)))
+	}
+	assert.Equal(t, uint64(1000), f.Recorded())
+	assert.Equal(t, uint64(0), f.Rejected())
+}
+
+func TestFilterSurvivesSingleRotation(t *testing.T) {
+	f, err := NewFilter(1000, 1e-6)
+	assert.Nil(t, err)
+
+	tag := tagFromUint64(1)
+	assert.False(t, f.CheckAndRecord(tag))
+
+	// tags from the previous generation are still remembered
+	f.Rotate()
+	assert.True(t, f.CheckAndRecord(tag))
+
+	// but are forgotten after another rotation
+	f.Rotate()
+	assert.False(t, f.CheckAndRecord(tag))
+	assert.Equal(t, uint64(2), f.Rotations())
+}
+
+func TestFilterRotatesWhenFull(t *testing.T) {
+	capacity := uint64(100)
+	f, err := NewFilter(capacity, 1e-6)
+	assert.Nil(t, err)
+
+	for i := uint64(0); i <= capacity; i++ {
+		assert.False(t, f.CheckAndRecord(tagFromUint64(i)))
+	}
+	assert.Equal(t, uint64(1), f.Rotations())
+
+	// the first tag got moved to the previous generation so it is still detected
+	assert.True(t, f.CheckAndRecord(tagFromUint64(0)))
+}
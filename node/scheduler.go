@@ -0,0 +1,358 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"container/heap"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultSchedulerBacklogSize is the default number of the received packets
+	// that can wait for a processing worker before the scheduler starts rejecting them.
+	DefaultSchedulerBacklogSize = 1024
+)
+
+var (
+	// ErrSchedulerFull is returned when the packet cannot be scheduled as the backlog of the scheduler is full.
+	ErrSchedulerFull = errors.New("scheduler backlog is full")
+	// ErrSchedulerStopped is returned when the packet is scheduled after the scheduler was stopped.
+	ErrSchedulerStopped = errors.New("scheduler is stopped")
+	// ErrSchedulerDraining is returned when the packet is scheduled while the scheduler is being drained.
+	ErrSchedulerDraining = errors.New("scheduler is draining")
+	// ErrDelayTooLong is the processing error of the packets requesting longer delays than the scheduler allows.
+	ErrDelayTooLong = errors.New("packet delay exceeds the limit")
+)
+
+// SchedulerConfig defines the resources used by the Scheduler. The zero values are replaced with the defaults.
+type SchedulerConfig struct {
+	// Workers is the number of the workers processing the packets, runtime.GOMAXPROCS(0) by default.
+	Workers int
+	// Forwarders is the number of the workers handling the released packets, the same as Workers by default.
+	Forwarders int
+	// BacklogSize is the number of the received packets that can wait for a processing worker,
+	// DefaultSchedulerBacklogSize by default.
+	BacklogSize int
+	// MaxDelay is the longest delay the packets can be held for. The packets requesting longer delays
+	// are passed to the handler straight away with ErrDelayTooLong. The delays are not limited by default.
+	MaxDelay time.Duration
+	// Clock is the source of the time, SystemClock by default.
+	Clock Clock
+}
+
+// Scheduler processes the packets received by the node and holds them for their delays.
+// The packets are processed by a bounded pool of workers and put into a delay queue,
+// from which a single dispatcher releases them once their deadlines pass. The released packets
+// are passed to the handler by a bounded pool of forwarders. The packets that failed to be processed
+// are passed to the handler straight away, so that the errors can be reported.
+type Scheduler struct {
+	// pending is accessed atomically and is kept first to guarantee its 64-bit alignment.
+	// It is the number of the scheduled packets that have not been passed to the handler yet.
+	pending int64
+
+	mix     *Mix
+	handler func(*PacketProcessingResult)
+	config  SchedulerConfig
+
+	backlog  chan receivedPacket
+	released chan *PacketProcessingResult
+
+	mu     sync.Mutex
+	queue  delayQueue
+	wakeCh chan struct{}
+
+	// scheduleMu makes sure no packet is scheduled after the scheduler has been found drained
+	scheduleMu sync.RWMutex
+	drainCh    chan struct{}
+	drainedCh  chan struct{}
+	drainOnce  sync.Once
+	doneOnce   sync.Once
+
+	haltCh    chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+// receivedPacket is the packet waiting for a processing worker.
+type receivedPacket struct {
+	data       []byte
+	receivedAt time.Time
+}
+
+// NewScheduler creates the Scheduler processing the packets with the given mix and passing the results to the handler.
+func NewScheduler(mix *Mix, handler func(*PacketProcessingResult), config SchedulerConfig) *Scheduler {
+	if config.Workers <= 0 {
+		config.Workers = runtime.GOMAXPROCS(0)
+	}
+	if config.Forwarders <= 0 {
+		config.Forwarders = config.Workers
+	}
+	if config.BacklogSize <= 0 {
+		config.BacklogSize = DefaultSchedulerBacklogSize
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+	return &Scheduler{mix: mix,
+		handler:   handler,
+		config:    config,
+		backlog:   make(chan receivedPacket, config.BacklogSize),
+		released:  make(chan *PacketProcessingResult, config.Forwarders),
+		wakeCh:    make(chan struct{}, 1),
+		drainCh:   make(chan struct{}),
+		drainedCh: make(chan struct{}),
+		haltCh:    make(chan struct{}),
+	}
+}
+
+// Start starts the workers, the dispatcher and the forwarders of the scheduler.
+func (s *Scheduler) Start() {
+	s.startOnce.Do(func() {
+		s.wg.Add(s.config.Workers + s.config.Forwarders + 1)
+		for i := 0; i < s.config.Workers; i++ {
+			go s.processPackets()
+		}
+		for i := 0; i < s.config.Forwarders; i++ {
+			go s.forwardPackets()
+		}
+		go s.dispatchPackets()
+	})
+}
+
+// Stop stops the scheduler and waits for all its goroutines to return.
+// The packets still waiting in the scheduler are dropped.
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.haltCh)
+		s.wg.Wait()
+	})
+}
+
+// Drain stops the scheduler from accepting any new packets and waits for the ones already scheduled
+// to be processed, held for their delays and passed to the handler, for at most the given timeout.
+// The scheduler is then stopped. Drain returns the number of the packets dropped as their delays
+// did not elapse in time.
+func (s *Scheduler) Drain(timeout time.Duration) int {
+	timer := s.config.Clock.NewTimer(timeout)
+	defer timer.Stop()
+
+	s.scheduleMu.Lock()
+	s.drainOnce.Do(func() { close(s.drainCh) })
+	s.scheduleMu.Unlock()
+	if atomic.LoadInt64(&s.pending) == 0 {
+		s.markDrained()
+	}
+
+	select {
+	case <-s.drainedCh:
+	case <-timer.C():
+	case <-s.haltCh:
+	}
+	s.Stop()
+	return int(atomic.LoadInt64(&s.pending))
+}
+
+func (s *Scheduler) markDrained() {
+	s.doneOnce.Do(func() { close(s.drainedCh) })
+}
+
+// Schedule queues the received packet for processing. The delay of the packet is counted from the moment
+// it is scheduled. It returns ErrSchedulerFull if the backlog is full, rather than blocking the caller.
+func (s *Scheduler) Schedule(packet []byte) error {
+	s.scheduleMu.RLock()
+	defer s.scheduleMu.RUnlock()
+	select {
+	case <-s.haltCh:
+		return ErrSchedulerStopped
+	default:
+	}
+	select {
+	case <-s.drainCh:
+		return ErrSchedulerDraining
+	default:
+	}
+
+	atomic.AddInt64(&s.pending, 1)
+	select {
+	case s.backlog <- receivedPacket{data: packet, receivedAt: s.config.Clock.Now()}:
+		return nil
+	default:
+		atomic.AddInt64(&s.pending, -1)
+		return ErrSchedulerFull
+	}
+}
+
+// QueueDepth returns the number of the processed packets waiting for their deadlines.
+func (s *Scheduler) QueueDepth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue.Len()
+}
+
+// Backlog returns the number of the received packets waiting for a processing worker.
+func (s *Scheduler) Backlog() int {
+	return len(s.backlog)
+}
+
+func (s *Scheduler) processPackets() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.haltCh:
+			return
+		case packet := <-s.backlog:
+			res := s.mix.ProcessPacket(packet.data)
+			res.receivedAt = packet.receivedAt
+			if res.Err() == nil && s.config.MaxDelay > 0 && res.Delay() > s.config.MaxDelay {
+				res.err = ErrDelayTooLong
+			}
+			if res.Err() != nil {
+				s.release(res)
+				continue
+			}
+			s.enqueue(packet.receivedAt.Add(res.Delay()), res)
+		}
+	}
+}
+
+// enqueue puts the processed packet into the delay queue and wakes up the dispatcher
+// if the packet is due before any other one.
+func (s *Scheduler) enqueue(deadline time.Time, res *PacketProcessingResult) {
+	s.mu.Lock()
+	item := &delayedPacket{deadline: deadline, res: res}
+	heap.Push(&s.queue, item)
+	earliest := s.queue[0] == item
+	s.mu.Unlock()
+
+	if earliest {
+		select {
+		case s.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// dispatchPackets releases the packets from the delay queue once their deadlines pass.
+// It sleeps until the earliest deadline, unless woken up by a packet due even earlier.
+func (s *Scheduler) dispatchPackets() {
+	defer s.wg.Done()
+	for {
+		var due []*PacketProcessingResult
+		wait := time.Duration(-1)
+
+		s.mu.Lock()
+		now := s.config.Clock.Now()
+		for s.queue.Len() > 0 && !s.queue[0].deadline.After(now) {
+			due = append(due, heap.Pop(&s.queue).(*delayedPacket).res)
+		}
+		if s.queue.Len() > 0 {
+			wait = s.queue[0].deadline.Sub(now)
+		}
+		s.mu.Unlock()
+
+		for _, res := range due {
+			if !s.release(res) {
+				return
+			}
+		}
+
+		var timer Timer
+		var timerCh <-chan time.Time
+		if wait >= 0 {
+			timer = s.config.Clock.NewTimer(wait)
+			timerCh = timer.C()
+		}
+		select {
+		case <-timerCh:
+		case <-s.wakeCh:
+		case <-s.haltCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// release passes the packet to the forwarders. It returns false if the scheduler was stopped in the meantime.
+func (s *Scheduler) release(res *PacketProcessingResult) bool {
+	select {
+	case s.released <- res:
+		return true
+	case <-s.haltCh:
+		return false
+	}
+}
+
+func (s *Scheduler) forwardPackets() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.haltCh:
+			return
+		case res := <-s.released:
+			s.handler(res)
+			if atomic.AddInt64(&s.pending, -1) == 0 {
+				select {
+				case <-s.drainCh:
+					s.markDrained()
+				default:
+				}
+			}
+		}
+	}
+}
+
+// delayedPacket is the processed packet waiting in the delay queue.
+type delayedPacket struct {
+	deadline time.Time
+	res      *PacketProcessingResult
+}
+
+// delayQueue is the min-heap of the delayed packets ordered by their deadlines.
+type delayQueue []*delayedPacket
+
+func (q delayQueue) Len() int {
+	return len(q)
+}
+
+func (q delayQueue) Less(i, j int) bool {
+	return q[i].deadline.Before(q[j].deadline)
+}
+
+func (q delayQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *delayQueue) Push(x interface{}) {
+	*q = append(*q, x.(*delayedPacket))
+}
+
+func (q *delayQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// releasedPacket is the packet passed to the handler of the scheduler, together with the time it was released at.
+type releasedPacket struct {
+	res *PacketProcessingResult
+	at  time.Time
+}
+
+func createTestScheduler(t *testing.T, clock Clock, backlogSize int) (*Scheduler, <-chan releasedPacket) {
+	mix, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	releasedCh := make(chan releasedPacket, 16)
+	handler := func(res *PacketProcessingResult) {
+		releasedCh <- releasedPacket{res: res, at: clock.Now()}
+	}
+	s := NewScheduler(mix, handler, SchedulerConfig{Workers: 2, BacklogSize: backlogSize, Clock: clock})
+	return s, releasedCh
+}
+
+// createDelayedPacket creates the packet whose first hop is the mix of the scheduler, which delays it by the given delay.
+func createDelayedPacket(t *testing.T, s *Scheduler, delay float64, message string) []byte {
+	provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3333", PubKey: s.mix.GetPublicKey().Bytes()}
+	dest := config.ClientConfig{Id: "Destination", Host: "localhost", Port: "3334", Provider: &provider}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider, Recipient: dest}
+	packet, err := sphinx.PackForwardMessage(path, []float64{delay, 0, 0, 0, 0}, []byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetBytes, err := packet.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packetBytes
+}
+
+func waitForQueueDepth(t *testing.T, s *Scheduler, depth int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.QueueDepth() != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth is %v instead of %v", s.QueueDepth(), depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receiveReleased(t *testing.T, releasedCh <-chan releasedPacket) releasedPacket {
+	select {
+	case released := <-releasedCh:
+		return released
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet was released")
+		return releasedPacket{}
+	}
+}
+
+func TestSchedulerReleasesPacketsAtDeadlines(t *testing.T) {
+	start := time.Unix(1560000000, 0)
+	clock := NewManualClock(start)
+	s, releasedCh := createTestScheduler(t, clock, 0)
+	s.Start()
+	defer s.Stop()
+
+	delays := []float64{3, 1, 2.5}
+	packets := make(map[string]float64)
+	for i, delay := range delays {
+		packet := createDelayedPacket(t, s, delay, fmt.Sprintf("Message %d", i))
+		assert.Nil(t, s.Schedule(packet))
+		packets[string(packet)] = delay
+	}
+	waitForQueueDepth(t, s, len(delays))
+
+	// the packets are released in the order of their deadlines rather than in the order they were received
+	for i, advance := range []time.Duration{time.Second, 1500 * time.Millisecond, 500 * time.Millisecond} {
+		clock.Advance(advance)
+		released := receiveReleased(t, releasedCh)
+		assert.Nil(t, released.res.Err())
+		assert.Equal(t, clock.Now(), released.at)
+		assert.Equal(t, released.at.Sub(start), released.res.Delay())
+		assert.Equal(t, start, released.res.ReceivedAt())
+		assert.True(t, released.res.ProcessingTime() > 0)
+		assert.Equal(t, len(delays)-i-1, s.QueueDepth())
+	}
+	assert.Equal(t, 0, s.Backlog())
+}
+
+func TestSchedulerReleasesFailedPacketsImmediately(t *testing.T) {
+	clock := NewManualClock(time.Unix(1560000000, 0))
+	s, releasedCh := createTestScheduler(t, clock, 0)
+	s.Start()
+	defer s.Stop()
+
+	packet := createDelayedPacket(t, s, 10, "Message")
+	packet[42] ^= 0x01
+	assert.Nil(t, s.Schedule(packet))
+
+	released := receiveReleased(t, releasedCh)
+	assert.Equal(t, sphinx.ErrInvalidMac, released.res.Err())
+	assert.Equal(t, 0, s.QueueDepth())
+}
+
+func TestSchedulerDropsPacketsExceedingMaxDelay(t *testing.T) {
+	clock := NewManualClock(time.Unix(1560000000, 0))
+	s, releasedCh := createTestScheduler(t, clock, 0)
+	s.config.MaxDelay = 5 * time.Second
+	s.Start()
+	defer s.Stop()
+
+	assert.Nil(t, s.Schedule(createDelayedPacket(t, s, 10, "Message")))
+	released := receiveReleased(t, releasedCh)
+	assert.Equal(t, ErrDelayTooLong, released.res.Err())
+	assert.Equal(t, 0, s.QueueDepth())
+
+	assert.Nil(t, s.Schedule(createDelayedPacket(t, s, 5, "Message")))
+	waitForQueueDepth(t, s, 1)
+	clock.Advance(5 * time.Second)
+	released = receiveReleased(t, releasedCh)
+	assert.Nil(t, released.res.Err())
+}
+
+func TestSchedulerBacklog(t *testing.T) {
+	clock := NewManualClock(time.Unix(1560000000, 0))
+	s, _ := createTestScheduler(t, clock, 2)
+
+	// the scheduler is not started, so the packets wait in the backlog
+	packet := createDelayedPacket(t, s, 1, "Message")
+	assert.Nil(t, s.Schedule(packet))
+	assert.Nil(t, s.Schedule(packet))
+	assert.Equal(t, ErrSchedulerFull, s.Schedule(packet))
+	assert.Equal(t, 2, s.Backlog())
+
+	s.Stop()
+	assert.Equal(t, ErrSchedulerStopped, s.Schedule(packet))
+}
+
+func TestSchedulerDrain(t *testing.T) {
+	clock := NewManualClock(time.Unix(1560000000, 0))
+	s, releasedCh := createTestScheduler(t, clock, 0)
+	s.Start()
+
+	assert.Nil(t, s.Schedule(createDelayedPacket(t, s, 1, "Message 1")))
+	assert.Nil(t, s.Schedule(createDelayedPacket(t, s, 10, "Message 2")))
+	waitForQueueDepth(t, s, 2)
+
+	droppedCh := make(chan int)
+	go func() {
+		droppedCh <- s.Drain(5 * time.Second)
+	}()
+	packet := createDelayedPacket(t, s, 1, "Message 3")
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Schedule(packet) != ErrSchedulerDraining {
+		if time.Now().After(deadline) {
+			t.Fatal("the scheduler did not start draining")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the packets are still released while the scheduler is draining, until the timeout
+	clock.Advance(time.Second)
+	assert.Nil(t, receiveReleased(t, releasedCh).res.Err())
+	clock.Advance(4 * time.Second)
+	select {
+	case dropped := <-droppedCh:
+		assert.Equal(t, 1, dropped)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scheduler was not drained")
+	}
+	assert.Equal(t, ErrSchedulerStopped, s.Schedule(packet))
+}
+
+func TestSchedulerDrainWithoutPackets(t *testing.T) {
+	clock := NewManualClock(time.Unix(1560000000, 0))
+	s, _ := createTestScheduler(t, clock, 0)
+	s.Start()
+	// the scheduler stops straight away, without waiting for the timeout
+	assert.Equal(t, 0, s.Drain(time.Minute))
+}
+
+func TestManualClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(1560000000, 0))
+	t1 := clock.NewTimer(time.Second)
+	t2 := clock.NewTimer(2 * time.Second)
+	t3 := clock.NewTimer(0)
+
+	clock.Advance(time.Second)
+	assert.Len(t, t1.C(), 1)
+	assert.Len(t, t2.C(), 0)
+	assert.Len(t, t3.C(), 1)
+	assert.False(t, t1.Stop())
+	assert.True(t, t2.Stop())
+
+	clock.Advance(time.Hour)
+	assert.Len(t, t2.C(), 0)
+}
@@ -27,16 +27,17 @@ else
     echo "Created logging directory"
 fi
 
-MAX_LAYERS=3
 NUMMIXES=${1:-3} # Set $NUMMIXES to default of 3, but allow the user to set other values if desired
 
 for (( j=0; j<$NUMMIXES; j++ ))
 
-# Note: to disable logging (or direct it to another output) modify the constant on top of mixnode or provider; 
-# Will make it later either configurable by flags or config file.
+# Note: to disable logging (or direct it to another output) modify the [logging] section
+# of the config file of the mixnode, located at $HOME/.nym/mixnodes/<id>/config/config.toml
 do
-    let layer=j%MAX_LAYERS+1
-    $PWD/build/nym-mixnode run --id "Mix$j" --port $((9980+$j)) --host "localhost" --layer $layer &
+    # init fails without overwriting anything if the mixnode was initialised by the previous run.
+    # The mixnodes register in the least populated layers, so they are started one after another
+    $PWD/build/nym-mixnode init --id "Mix$j" --port $((9980+$j)) --host "localhost" --local
+    $PWD/build/nym-mixnode run --id "Mix$j" &
     sleep 1
 done
 
@@ -0,0 +1,286 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin implements the admin API of the running mixnodes and providers. The API is served
+// over HTTP on a Unix domain socket, so that only the local users allowed to access the socket can use it.
+// The requests and the responses are JSON encoded.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/networker"
+	"github.com/sirupsen/logrus"
+)
+
+// The paths of the calls of the admin API.
+const (
+	// StatusPath returns the Status of the node.
+	StatusPath = "/status"
+	// LogLevelPath changes the log level of the node to the one in the LogLevelRequest.
+	LogLevelPath = "/loglevel"
+	// DrainPath starts draining the node before shutting it down, as described by the DrainRequest.
+	DrainPath = "/drain"
+	// PresencePath makes the node announce its presence to the directory server straight away.
+	PresencePath = "/presence"
+)
+
+const (
+	// socketMode allows only the user running the node to use the admin API.
+	socketMode = 0600
+	// maxRequestSize is the largest request body the admin API accepts.
+	maxRequestSize = 4096
+)
+
+// Node is the running mixnode or provider controlled over the admin API.
+type Node interface {
+	// Status returns the current status of the node.
+	Status() Status
+	// SetLogLevel changes the level of all the loggers of the node.
+	SetLogLevel(level string) error
+	// Drain gracefully shuts down the node, waiting at most the given timeout for the received packets.
+	// It is called in its own goroutine, as it only returns once the node is drained.
+	Drain(timeout time.Duration)
+	// DrainTimeout returns the drain timeout the node is configured with.
+	DrainTimeout() time.Duration
+	// RefreshPresence announces the presence of the node to the directory server.
+	RefreshPresence() error
+}
+
+// Status describes the running node.
+type Status struct {
+	// Type is the type of the node, either "mixnode" or "provider".
+	Type string `json:"type"`
+	// ID is the human readable ID of the node.
+	ID string `json:"id"`
+	// PublicKey is the base64 encoded long-term public key identifying the node.
+	PublicKey string `json:"publicKey"`
+	// Address is the address announced to the directory server.
+	Address string `json:"address"`
+	// Layer is the mixnet layer of the mixnode. It is not set for the providers.
+	Layer int `json:"layer,omitempty"`
+	// LogLevel is the current log level of the node.
+	LogLevel string `json:"logLevel"`
+	// StartedAt is the time the node was started at.
+	StartedAt time.Time `json:"startedAt"`
+	// Uptime is the number of seconds the node has been running for.
+	Uptime float64 `json:"uptime"`
+	// Draining tells whether the node is being drained before shutting down.
+	Draining bool `json:"draining"`
+	// QueueDepth is the number of the processed packets waiting for their delays to elapse.
+	QueueDepth int `json:"queueDepth"`
+	// Peers describes the links to the nodes the packets were forwarded to.
+	Peers []Peer `json:"peers"`
+	// RegisteredClients is the number of the clients registered with the provider.
+	// It is not set for the mixnodes.
+	RegisteredClients *int `json:"registeredClients,omitempty"`
+}
+
+// Peer describes the link to a single node the packets were forwarded to.
+type Peer struct {
+	// Address is the address of the peer.
+	Address string `json:"address"`
+	// Connected tells whether the link currently has an open connection.
+	Connected bool `json:"connected"`
+	// Queued is the number of the packets waiting to be sent.
+	Queued int `json:"queued"`
+	// Sent is the number of the packets sent over the link.
+	Sent uint64 `json:"sent"`
+	// Dropped is the number of the packets dropped as the queue was full.
+	Dropped uint64 `json:"dropped"`
+}
+
+// Peers converts the statuses of the links of the node into the peers reported in its Status.
+func Peers(links []networker.LinkStatus) []Peer {
+	peers := make([]Peer, len(links))
+	for i, l := range links {
+		peers[i] = Peer{Address: l.Address, Connected: l.Connected, Queued: l.Queued, Sent: l.Sent, Dropped: l.Dropped}
+	}
+	return peers
+}
+
+// LogLevelRequest is the body of the LogLevelPath call.
+type LogLevelRequest struct {
+	// Level is the new log level: trace, debug, info, warn, error, fatal or panic.
+	Level string `json:"level"`
+}
+
+// DrainRequest is the body of the DrainPath call.
+type DrainRequest struct {
+	// Timeout is the drain timeout in milliseconds. If not set, the configured one is used.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// errorResponse is the body of the response to the failed call.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the admin API of the node on the Unix domain socket.
+type Server struct {
+	node     Node
+	listener net.Listener
+	server   *http.Server
+	log      *logrus.Logger
+}
+
+// NewServer creates the Server of the admin API of the given node, listening on the Unix domain socket
+// at the given path. The socket left behind by the node which did not shut down cleanly is replaced.
+// The server does not serve any requests until started.
+func NewServer(path string, node Node, log *logrus.Logger) (*Server, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s := &Server{node: node, listener: listener, log: log}
+	mux := http.NewServeMux()
+	mux.HandleFunc(StatusPath, s.handleStatus)
+	mux.HandleFunc(LogLevelPath, s.handleLogLevel)
+	mux.HandleFunc(DrainPath, s.handleDrain)
+	mux.HandleFunc(PresencePath, s.handlePresence)
+	s.server = &http.Server{Handler: mux}
+	return s, nil
+}
+
+// removeStaleSocket removes the socket at the given path, unless another node is still listening on it.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New("the admin socket path exists and is not a socket: " + path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.New("another node is already listening on the admin socket: " + path)
+	}
+	return os.Remove(path)
+}
+
+// Start serves the admin API in the background until the server is closed.
+func (s *Server) Start() {
+	go func() {
+		s.log.Infof("Serving the admin API on %v", s.listener.Addr())
+		if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			s.log.Errorf("Failed to serve the admin API: %v", err)
+		}
+	}()
+}
+
+// Close stops the server and removes the socket.
+func (s *Server) Close() error {
+	err := s.server.Close()
+	// the listener is only closed by the server once it is served on
+	_ = s.listener.Close()
+	return err
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.node.Status())
+}
+
+func (s *Server) handleLogLevel(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPut) {
+		return
+	}
+	var request LogLevelRequest
+	if !readJSON(w, req, &request) {
+		return
+	}
+	if err := s.node.SetLogLevel(request.Level); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	s.log.Infof("Log level changed to %v", request.Level)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDrain(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	var request DrainRequest
+	if !readJSON(w, req, &request) {
+		return
+	}
+	if request.Timeout < 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "the drain timeout cannot be negative"})
+		return
+	}
+	timeout := s.node.DrainTimeout()
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Millisecond
+	}
+	s.log.Infof("Drain requested over the admin API")
+	// the response is sent straight away, the progress of the drain can be followed with the status calls
+	go s.node.Drain(timeout)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handlePresence(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	if err := s.node.RefreshPresence(); err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func allowMethod(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return false
+	}
+	return true
+}
+
+// readJSON decodes the body of the request, which might be empty, into v.
+// If it fails, the error is written to the response and false is returned.
+func readJSON(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestSize)).Decode(v)
+	if err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/logger"
+	"github.com/nymtech/nym-mixnet/networker"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeNode struct {
+	level        string
+	drainedWith  chan time.Duration
+	presenceErr  error
+	presenceRuns int
+}
+
+func (n *fakeNode) Status() Status {
+	clients := 2
+	return Status{Type: "provider",
+		ID:                "Provider",
+		LogLevel:          n.level,
+		QueueDepth:        3,
+		Peers:             Peers([]networker.LinkStatus{{Address: "127.0.0.1:1789", Connected: true, Sent: 5}}),
+		RegisteredClients: &clients,
+	}
+}
+
+func (n *fakeNode) SetLogLevel(level string) error {
+	if level != "debug" && level != "info" {
+		return errors.New("not a valid level: " + level)
+	}
+	n.level = level
+	return nil
+}
+
+func (n *fakeNode) Drain(timeout time.Duration) {
+	n.drainedWith <- timeout
+}
+
+func (n *fakeNode) DrainTimeout() time.Duration {
+	return 10 * time.Second
+}
+
+func (n *fakeNode) RefreshPresence() error {
+	n.presenceRuns++
+	return n.presenceErr
+}
+
+func createTestServer(t *testing.T, node Node) (*Server, string) {
+	baseDisabledLogger, err := logger.New("", "info", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "admin.sock")
+	s, err := NewServer(path, node, baseDisabledLogger.GetLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	return s, dir
+}
+
+func TestAdminAPI(t *testing.T) {
+	node := &fakeNode{level: "info", drainedWith: make(chan time.Duration, 2)}
+	s, dir := createTestServer(t, node)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+	path := filepath.Join(dir, "admin.sock")
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(socketMode), info.Mode().Perm())
+
+	c := NewClient(path)
+	status, err := c.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, "Provider", status.ID)
+	assert.Equal(t, 3, status.QueueDepth)
+	assert.Equal(t, []Peer{{Address: "127.0.0.1:1789", Connected: true, Sent: 5}}, status.Peers)
+	assert.Equal(t, 2, *status.RegisteredClients)
+
+	assert.Nil(t, c.SetLogLevel("debug"))
+	assert.Equal(t, "debug", node.level)
+	assert.EqualError(t, c.SetLogLevel("foo"), "admin call failed: not a valid level: foo")
+
+	assert.Nil(t, c.Drain(0))
+	assert.Equal(t, 10*time.Second, <-node.drainedWith)
+	assert.Nil(t, c.Drain(500*time.Millisecond))
+	assert.Equal(t, 500*time.Millisecond, <-node.drainedWith)
+
+	assert.Nil(t, c.RefreshPresence())
+	node.presenceErr = errors.New("directory unreachable")
+	assert.EqualError(t, c.RefreshPresence(), "admin call failed: directory unreachable")
+	assert.Equal(t, 2, node.presenceRuns)
+
+	assert.Error(t, c.call("DELETE", StatusPath, nil, nil))
+}
+
+func TestStaleSocket(t *testing.T) {
+	s, dir := createTestServer(t, &fakeNode{})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "admin.sock")
+
+	baseDisabledLogger, err := logger.New("", "info", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := baseDisabledLogger.GetLogger("test")
+	// the socket of the running node is not replaced
+	_, err = NewServer(path, &fakeNode{}, log)
+	assert.Error(t, err)
+
+	assert.Nil(t, s.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// neither is a file which is not a socket
+	assert.Nil(t, ioutil.WriteFile(path, []byte("foo"), 0600))
+	_, err = NewServer(path, &fakeNode{}, log)
+	assert.Error(t, err)
+}
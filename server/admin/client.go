@@ -0,0 +1,112 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// clientTimeout is the time after which the call to the admin API fails.
+	clientTimeout = 30 * time.Second
+	// baseURL is the URL the calls are made to. The host is ignored, as the client always dials the socket.
+	baseURL = "http://admin"
+)
+
+// Client calls the admin API of the node listening on the Unix domain socket.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates the Client of the admin API listening on the socket at the given path.
+func NewClient(path string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport, Timeout: clientTimeout}}
+}
+
+// Status returns the status of the node.
+func (c *Client) Status() (*Status, error) {
+	status := &Status{}
+	if err := c.call(http.MethodGet, StatusPath, nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// SetLogLevel changes the log level of the node.
+func (c *Client) SetLogLevel(level string) error {
+	return c.call(http.MethodPut, LogLevelPath, &LogLevelRequest{Level: level}, nil)
+}
+
+// Drain starts draining the node before shutting it down. If the timeout is zero, the configured one is used.
+// It returns as soon as the node started draining.
+func (c *Client) Drain(timeout time.Duration) error {
+	return c.call(http.MethodPost, DrainPath, &DrainRequest{Timeout: int(timeout / time.Millisecond)}, nil)
+}
+
+// RefreshPresence makes the node announce its presence to the directory server straight away.
+func (c *Client) RefreshPresence() error {
+	return c.call(http.MethodPost, PresencePath, nil, nil)
+}
+
+// call makes the call to the admin API with the given request body and decodes the response body into response.
+// The bodies are only sent and decoded if not nil.
+func (c *Client) call(method, path string, request interface{}, response interface{}) error {
+	var body io.Reader
+	if request != nil {
+		b, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return fmt.Errorf("admin call failed: %v", resp.Status)
+		}
+		return fmt.Errorf("admin call failed: %v", errResp.Error)
+	}
+	if response != nil {
+		return json.NewDecoder(resp.Body).Decode(response)
+	}
+	return nil
+}
@@ -0,0 +1,679 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config implements the configuration of the Nym mixnodes and providers.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	mainConfig "github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/server/impairment"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultNymDirectory          = ".nym"
+	defaultNymMixNodesDirectory  = "mixnodes"
+	defaultNymProvidersDirectory = "providers"
+	defaultConfigDirectory       = "config"
+	defaultConfigFileName        = "config.toml"
+	defaultInboxDirectory        = "inboxes"
+	defaultAdminSocketFileName   = "admin.sock"
+
+	defaultLogLevel = "info"
+
+	defaultPrivateKeyFileName = "private_key.pem"
+	defaultPublicKeyFileName  = "public_key.pem"
+
+	defaultPort = "1789"
+	// UnassignedLayer means the layer was not chosen, so that the mixnode chooses it itself when it starts.
+	UnassignedLayer = -1
+	// defaultLayers is the number of the mixnet layers the clients build their paths through.
+	defaultLayers = 3
+
+	// all the intervals and delays are in milliseconds
+	defaultPresenceInterval = 2000
+	defaultMetricsInterval  = 1000
+	defaultMaxPacketDelay   = 60000
+	defaultLoopTimeout      = 30000
+	defaultKeyGracePeriod   = 120000
+	defaultDrainTimeout     = 10000
+
+	defaultLoopCoverTrafficRate = 1.0
+
+	defaultDirectoryServer = mainConfig.DirectoryServerURL
+	// DefaultLocalDirectoryServer is the directory server of the local mixnet deployment.
+	DefaultLocalDirectoryServer = mainConfig.LocalDirectoryServerURL
+)
+
+// nolint: gochecknoglobals
+var (
+	defaultMixNodesHomeDirectory  = os.ExpandEnv(filepath.Join("$HOME", defaultNymDirectory, defaultNymMixNodesDirectory))
+	defaultProvidersHomeDirectory = os.ExpandEnv(filepath.Join("$HOME", defaultNymDirectory, defaultNymProvidersDirectory))
+	defaultPrivateKeyPath         = filepath.Join(defaultConfigDirectory, defaultPrivateKeyFileName)
+	defaultPublicKeyPath          = filepath.Join(defaultConfigDirectory, defaultPublicKeyFileName)
+)
+
+// DefaultMixNodeConfigPath returns absolute path to the default configuration file of the particular mixnode.
+// The returned path should be $HOME/.nym/mixnodes/mixnodeID/config/config.toml
+func DefaultMixNodeConfigPath(mixNodeID string) (string, error) {
+	if len(mixNodeID) == 0 {
+		return "", errors.New("invalid mixnodeID provided")
+	}
+	return filepath.Join(
+		defaultMixNodesHomeDirectory,
+		mixNodeID,
+		defaultConfigDirectory,
+		defaultConfigFileName,
+	), nil
+}
+
+// DefaultProviderConfigPath returns absolute path to the default configuration file of the particular provider.
+// The returned path should be $HOME/.nym/providers/providerID/config/config.toml
+func DefaultProviderConfigPath(providerID string) (string, error) {
+	if len(providerID) == 0 {
+		return "", errors.New("invalid providerID provided")
+	}
+	return filepath.Join(
+		defaultProvidersHomeDirectory,
+		providerID,
+		defaultConfigDirectory,
+		defaultConfigFileName,
+	), nil
+}
+
+// MixNode is the Nym mixnode configuration.
+type MixNode struct {
+	// HomeDirectory specifies absolute path to the home nym mixnodes directory.
+	// It is expected to use default value and hence .toml file should not redefine this field.
+	HomeDirectory string `toml:"nym_home_directory"`
+
+	// ID specifies the human readable ID of this particular mixnode.
+	ID string `toml:"id"`
+
+	// Host specifies the host on which the mixnode is listening.
+	// If it is not a valid address, the local IP address is used instead.
+	Host string `toml:"host"`
+
+	// Port specifies the port on which the mixnode is listening.
+	Port string `toml:"port"`
+
+	// AnnounceAddress specifies the host:port address announced to the directory server,
+	// if it differs from the listening address, for example behind NAT.
+	// If omitted, the listening address is announced.
+	AnnounceAddress string `toml:"announce_address"`
+
+	// MetricsAddress specifies the host:port address on which the mixnode serves its metrics
+	// in the Prometheus text format under /metrics. If omitted, the metrics are not served.
+	MetricsAddress string `toml:"metrics_address"`
+
+	// AdminSocket specifies path to the Unix domain socket on which the mixnode serves its admin API.
+	AdminSocket string `toml:"admin_socket"`
+
+	// Impairment specifies how the mixnode impairs the packets it forwards, to emulate an unreliable network
+	// when testing the clients, in the format parsed by impairment.ParseConfig. It is only allowed
+	// with the local directory server. If omitted, the packets are not impaired.
+	Impairment string `toml:"impairment"`
+
+	// Layer specifies the mixnet layer of this particular mixnode. If set to UnassignedLayer,
+	// the mixnode registers in the least populated layer of the network when it starts.
+	Layer int `toml:"layer"`
+
+	// DirectoryServer specifies the base URL of the directory server.
+	DirectoryServer string `toml:"directory_server"`
+
+	// PrivateKey specifies path to file containing private key.
+	PrivateKey string `toml:"priv_key_file"`
+
+	// PublicKey specifies path to file containing public key.
+	PublicKey string `toml:"pub_key_file"`
+}
+
+// DefaultMixNode returns default MixNode block of the config for provided mixNodeID.
+func DefaultMixNode(mixNodeID string) (*MixNode, error) {
+	if len(mixNodeID) == 0 {
+		return nil, errors.New("invalid mixnodeID provided")
+	}
+	return &MixNode{
+		HomeDirectory:   defaultMixNodesHomeDirectory,
+		ID:              mixNodeID,
+		Port:            defaultPort,
+		Layer:           UnassignedLayer,
+		AdminSocket:     defaultAdminSocketFileName,
+		DirectoryServer: defaultDirectoryServer,
+		PrivateKey:      defaultPrivateKeyPath,
+		PublicKey:       defaultPublicKeyPath,
+	}, nil
+}
+
+// Home returns the full path to the home directory of this particular mixnode.
+func (cfg *MixNode) Home() string {
+	return filepath.Join(cfg.HomeDirectory, cfg.ID)
+}
+
+// PrivateKeyFile returns the full path to the private key file.
+func (cfg *MixNode) PrivateKeyFile() string {
+	return rootify(cfg.PrivateKey, cfg.Home())
+}
+
+// PublicKeyFile returns the full path to the public key file.
+func (cfg *MixNode) PublicKeyFile() string {
+	return rootify(cfg.PublicKey, cfg.Home())
+}
+
+// AdminSocketFile returns the full path to the admin socket.
+func (cfg *MixNode) AdminSocketFile() string {
+	return rootify(cfg.AdminSocket, cfg.Home())
+}
+
+// AnnounceHostPort returns the host and the port announced to the directory server,
+// which are the listening ones unless the announce address is specified.
+func (cfg *MixNode) AnnounceHostPort() (string, string, error) {
+	return announceHostPort(cfg.AnnounceAddress, cfg.Host, cfg.Port)
+}
+
+// ImpairmentConfig returns the parsed impairment of the forwarded packets, or nil if they are not impaired.
+// The impairment is only allowed in the local networks, using the local directory server.
+func (cfg *MixNode) ImpairmentConfig() (*impairment.Config, error) {
+	if len(cfg.Impairment) == 0 {
+		return nil, nil
+	}
+	if cfg.DirectoryServer != DefaultLocalDirectoryServer {
+		return nil, fmt.Errorf("config: the impairment is only allowed with the local directory server %s",
+			DefaultLocalDirectoryServer,
+		)
+	}
+	impairmentCfg, err := impairment.ParseConfig(cfg.Impairment)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	return &impairmentCfg, nil
+}
+
+func (cfg *MixNode) validateAndApplyDefaults() error {
+	// if custom home directory is specified it must have an absolute path
+	if len(cfg.HomeDirectory) > 0 {
+		if !filepath.IsAbs(cfg.HomeDirectory) {
+			return errors.New("config: specified home directory is not an absolute path")
+		}
+	} else {
+		cfg.HomeDirectory = defaultMixNodesHomeDirectory
+	}
+
+	// it is also required to specify ID otherwise we could not distinguish between multiple instances
+	if len(cfg.ID) == 0 {
+		return errors.New("config: mixnode ID was not specified")
+	}
+
+	if err := validateAnnounceAddress(cfg.AnnounceAddress); err != nil {
+		return err
+	}
+
+	if err := validateMetricsAddress(cfg.MetricsAddress); err != nil {
+		return err
+	}
+
+	// for the rest, if left unspecified, use defaults
+	if len(cfg.Port) == 0 {
+		cfg.Port = defaultPort
+	}
+
+	if len(cfg.DirectoryServer) == 0 {
+		cfg.DirectoryServer = defaultDirectoryServer
+	}
+
+	if len(cfg.AdminSocket) == 0 {
+		cfg.AdminSocket = defaultAdminSocketFileName
+	}
+
+	if _, err := cfg.ImpairmentConfig(); err != nil {
+		return err
+	}
+
+	// unlike the client, the mixnode does not generate its keys when they are missing, they are created by init
+	if len(cfg.PrivateKey) == 0 {
+		cfg.PrivateKey = defaultPrivateKeyPath
+	}
+
+	if len(cfg.PublicKey) == 0 {
+		cfg.PublicKey = defaultPublicKeyPath
+	}
+
+	return nil
+}
+
+// Provider is the Nym provider configuration.
+type Provider struct {
+	// HomeDirectory specifies absolute path to the home nym providers directory.
+	// It is expected to use default value and hence .toml file should not redefine this field.
+	HomeDirectory string `toml:"nym_home_directory"`
+
+	// ID specifies the human readable ID of this particular provider.
+	ID string `toml:"id"`
+
+	// Host specifies the host on which the provider is listening.
+	// If it is not a valid address, the local IP address is used instead.
+	Host string `toml:"host"`
+
+	// Port specifies the port on which the provider is listening.
+	Port string `toml:"port"`
+
+	// AnnounceAddress specifies the host:port address announced to the directory server,
+	// if it differs from the listening address, for example behind NAT.
+	// If omitted, the listening address is announced.
+	AnnounceAddress string `toml:"announce_address"`
+
+	// MetricsAddress specifies the host:port address on which the provider serves its metrics
+	// in the Prometheus text format under /metrics. If omitted, the metrics are not served.
+	MetricsAddress string `toml:"metrics_address"`
+
+	// AdminSocket specifies path to the Unix domain socket on which the provider serves its admin API.
+	AdminSocket string `toml:"admin_socket"`
+
+	// DirectoryServer specifies the base URL of the directory server.
+	DirectoryServer string `toml:"directory_server"`
+
+	// InboxDirectory specifies the directory in which the messages of the clients are stored.
+	InboxDirectory string `toml:"inbox_directory"`
+
+	// PrivateKey specifies path to file containing private key.
+	PrivateKey string `toml:"priv_key_file"`
+
+	// PublicKey specifies path to file containing public key.
+	PublicKey string `toml:"pub_key_file"`
+}
+
+// DefaultProvider returns default Provider block of the config for provided providerID.
+func DefaultProvider(providerID string) (*Provider, error) {
+	if len(providerID) == 0 {
+		return nil, errors.New("invalid providerID provided")
+	}
+	return &Provider{
+		HomeDirectory:   defaultProvidersHomeDirectory,
+		ID:              providerID,
+		Port:            defaultPort,
+		AdminSocket:     defaultAdminSocketFileName,
+		DirectoryServer: defaultDirectoryServer,
+		InboxDirectory:  defaultInboxDirectory,
+		PrivateKey:      defaultPrivateKeyPath,
+		PublicKey:       defaultPublicKeyPath,
+	}, nil
+}
+
+// Home returns the full path to the home directory of this particular provider.
+func (cfg *Provider) Home() string {
+	return filepath.Join(cfg.HomeDirectory, cfg.ID)
+}
+
+// PrivateKeyFile returns the full path to the private key file.
+func (cfg *Provider) PrivateKeyFile() string {
+	return rootify(cfg.PrivateKey, cfg.Home())
+}
+
+// PublicKeyFile returns the full path to the public key file.
+func (cfg *Provider) PublicKeyFile() string {
+	return rootify(cfg.PublicKey, cfg.Home())
+}
+
+// AdminSocketFile returns the full path to the admin socket.
+func (cfg *Provider) AdminSocketFile() string {
+	return rootify(cfg.AdminSocket, cfg.Home())
+}
+
+// FullInboxDir returns the full path to the directory with the inboxes of the clients.
+func (cfg *Provider) FullInboxDir() string {
+	return rootify(cfg.InboxDirectory, cfg.Home())
+}
+
+// AnnounceHostPort returns the host and the port announced to the directory server,
+// which are the listening ones unless the announce address is specified.
+func (cfg *Provider) AnnounceHostPort() (string, string, error) {
+	return announceHostPort(cfg.AnnounceAddress, cfg.Host, cfg.Port)
+}
+
+func (cfg *Provider) validateAndApplyDefaults() error {
+	// if custom home directory is specified it must have an absolute path
+	if len(cfg.HomeDirectory) > 0 {
+		if !filepath.IsAbs(cfg.HomeDirectory) {
+			return errors.New("config: specified home directory is not an absolute path")
+		}
+	} else {
+		cfg.HomeDirectory = defaultProvidersHomeDirectory
+	}
+
+	// it is also required to specify ID otherwise we could not distinguish between multiple instances
+	if len(cfg.ID) == 0 {
+		return errors.New("config: provider ID was not specified")
+	}
+
+	if err := validateAnnounceAddress(cfg.AnnounceAddress); err != nil {
+		return err
+	}
+
+	if err := validateMetricsAddress(cfg.MetricsAddress); err != nil {
+		return err
+	}
+
+	// for the rest, if left unspecified, use defaults
+	if len(cfg.Port) == 0 {
+		cfg.Port = defaultPort
+	}
+
+	if len(cfg.DirectoryServer) == 0 {
+		cfg.DirectoryServer = defaultDirectoryServer
+	}
+
+	if len(cfg.AdminSocket) == 0 {
+		cfg.AdminSocket = defaultAdminSocketFileName
+	}
+
+	if len(cfg.InboxDirectory) == 0 {
+		cfg.InboxDirectory = defaultInboxDirectory
+	}
+
+	// we're not checking for existence of the key files as if they do not exist, they're going to be generated
+	if len(cfg.PrivateKey) == 0 {
+		cfg.PrivateKey = defaultPrivateKeyPath
+	}
+
+	if len(cfg.PublicKey) == 0 {
+		cfg.PublicKey = defaultPublicKeyPath
+	}
+
+	return nil
+}
+
+func announceHostPort(address, host, port string) (string, string, error) {
+	if len(address) == 0 {
+		return host, port, nil
+	}
+	return net.SplitHostPort(address)
+}
+
+func validateAnnounceAddress(address string) error {
+	if len(address) == 0 {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("config: invalid announce address: %s (%v)", address, err)
+	}
+	return nil
+}
+
+func validateMetricsAddress(address string) error {
+	if len(address) == 0 {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("config: invalid metrics address: %s (%v)", address, err)
+	}
+	return nil
+}
+
+// Logging is the Nym node logging configuration.
+type Logging struct {
+	// Disable disables logging entirely.
+	Disable bool `toml:"disable"`
+
+	// File specifies the log file, if omitted stdout will be used.
+	File string `toml:"file"`
+
+	// Level specifies the log level.
+	Level string `toml:"level"`
+}
+
+func (cfg *Logging) validateAndApplyDefaults() error {
+	if len(cfg.Level) == 0 {
+		cfg.Level = defaultLogLevel
+	}
+	_, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		return fmt.Errorf("config: invalid logging level: %s (%v)", cfg.Level, err)
+	}
+	return nil
+}
+
+// DefaultLoggingConfig returns default logging configuration.
+func DefaultLoggingConfig() *Logging {
+	return &Logging{
+		Disable: false,
+		File:    "",
+		Level:   defaultLogLevel,
+	}
+}
+
+// Debug is the Nym node debug configuration.
+type Debug struct {
+	// PresenceInterval defines, in milliseconds, how often the node announces its presence to the directory server.
+	PresenceInterval int `toml:"presence_interval"`
+
+	// MetricsInterval defines, in milliseconds, how often the mixnode sends its metrics to the directory server.
+	// It is not used by the providers.
+	MetricsInterval int `toml:"metrics_interval"`
+
+	// MaxPacketDelay defines, in milliseconds, the longest delay the node is willing to hold a packet for.
+	// The packets requesting longer delays are dropped, so that they could not fill the memory of the node.
+	// If set to a negative value, the delays are not limited.
+	MaxPacketDelay int `toml:"max_packet_delay"`
+
+	// LoopCoverTrafficRate defines the rate at which the mixnode sends its loop cover packets,
+	// which come back to it through the other layers. It is not used by the providers.
+	// The value is the parameter of an exponential distribution, and is the reciprocal of the
+	// expected value of the exponential distribution.
+	// If set to a negative value, the mixnode does not send any loop cover packets.
+	LoopCoverTrafficRate float64 `toml:"loop_cover_traffic_rate"`
+
+	// LoopTimeout defines, in milliseconds, after how long the loop cover packet that has not come back
+	// is considered lost.
+	LoopTimeout int `toml:"loop_timeout"`
+
+	// KeyEpochDuration defines, in milliseconds, the length of the key epochs. If set, the node processes
+	// the packets with a fresh sphinx key in every epoch and erases the keys of the past epochs. It announces
+	// a separate presence for the key of the current and of the next epoch, each carrying its long-term key,
+	// which then only identifies the node. If not set, the node keeps using its long-term key for the packets.
+	KeyEpochDuration int `toml:"key_epoch_duration"`
+
+	// KeyGracePeriod defines, in milliseconds, for how long after the end of the key epoch the node
+	// still accepts the packets for its key. It has to be shorter than the key epoch.
+	KeyGracePeriod int `toml:"key_grace_period"`
+
+	// DrainTimeout defines, in milliseconds, for how long the node, when shut down gracefully, keeps forwarding
+	// the packets it has already received before it exits. The packets whose delays have not elapsed by then
+	// are dropped.
+	DrainTimeout int `toml:"drain_timeout"`
+
+	// Layers defines the number of the mixnet layers the mixnode without an assigned layer chooses from.
+	// It is not used by the providers.
+	Layers int `toml:"layers"`
+
+	// RebalanceLayers defines whether the mixnode without an assigned layer moves to another layer
+	// at the end of the key epoch, if the layers of the network became unbalanced. It requires the key epochs.
+	// It is not used by the providers.
+	RebalanceLayers bool `toml:"rebalance_layers"`
+}
+
+func (dCfg *Debug) validateAndApplyDefaults() error {
+	if dCfg.PresenceInterval < 0 || dCfg.MetricsInterval < 0 {
+		return errors.New("config: the presence and metrics intervals cannot be negative")
+	}
+	if dCfg.LoopTimeout < 0 {
+		return errors.New("config: the loop timeout cannot be negative")
+	}
+	if dCfg.KeyEpochDuration < 0 || dCfg.KeyGracePeriod < 0 {
+		return errors.New("config: the key epoch duration and the key grace period cannot be negative")
+	}
+	if dCfg.DrainTimeout < 0 {
+		return errors.New("config: the drain timeout cannot be negative")
+	}
+	if dCfg.Layers < 0 {
+		return errors.New("config: the number of the layers cannot be negative")
+	}
+	if dCfg.PresenceInterval == 0 {
+		dCfg.PresenceInterval = defaultPresenceInterval
+	}
+	if dCfg.MetricsInterval == 0 {
+		dCfg.MetricsInterval = defaultMetricsInterval
+	}
+	if dCfg.MaxPacketDelay == 0 {
+		dCfg.MaxPacketDelay = defaultMaxPacketDelay
+	}
+	if dCfg.LoopCoverTrafficRate == 0.0 {
+		dCfg.LoopCoverTrafficRate = defaultLoopCoverTrafficRate
+	}
+	if dCfg.LoopTimeout == 0 {
+		dCfg.LoopTimeout = defaultLoopTimeout
+	}
+	if dCfg.KeyGracePeriod == 0 {
+		dCfg.KeyGracePeriod = defaultKeyGracePeriod
+	}
+	if dCfg.DrainTimeout == 0 {
+		dCfg.DrainTimeout = defaultDrainTimeout
+	}
+	if dCfg.Layers == 0 {
+		dCfg.Layers = defaultLayers
+	}
+	if dCfg.KeyEpochDuration > 0 && dCfg.KeyGracePeriod >= dCfg.KeyEpochDuration {
+		return errors.New("config: the key grace period has to be shorter than the key epoch")
+	}
+	if dCfg.RebalanceLayers && dCfg.KeyEpochDuration == 0 {
+		return errors.New("config: the layers can only be rebalanced with the key epochs")
+	}
+	return nil
+}
+
+// DefaultDebugConfig returns default debug configuration.
+func DefaultDebugConfig() *Debug {
+	return &Debug{
+		PresenceInterval: defaultPresenceInterval,
+		MetricsInterval:  defaultMetricsInterval,
+		MaxPacketDelay:   defaultMaxPacketDelay,
+
+		LoopCoverTrafficRate: defaultLoopCoverTrafficRate,
+		LoopTimeout:          defaultLoopTimeout,
+
+		KeyGracePeriod: defaultKeyGracePeriod,
+
+		DrainTimeout: defaultDrainTimeout,
+
+		Layers: defaultLayers,
+	}
+}
+
+// PresenceIntervalDuration returns the presence interval as time.Duration.
+func (dCfg *Debug) PresenceIntervalDuration() time.Duration {
+	return time.Duration(dCfg.PresenceInterval) * time.Millisecond
+}
+
+// MetricsIntervalDuration returns the metrics interval as time.Duration.
+func (dCfg *Debug) MetricsIntervalDuration() time.Duration {
+	return time.Duration(dCfg.MetricsInterval) * time.Millisecond
+}
+
+// MaxPacketDelayDuration returns the maximum packet delay as time.Duration, or 0 if the delays are not limited.
+func (dCfg *Debug) MaxPacketDelayDuration() time.Duration {
+	if dCfg.MaxPacketDelay < 0 {
+		return 0
+	}
+	return time.Duration(dCfg.MaxPacketDelay) * time.Millisecond
+}
+
+// LoopTimeoutDuration returns the loop timeout as time.Duration.
+func (dCfg *Debug) LoopTimeoutDuration() time.Duration {
+	return time.Duration(dCfg.LoopTimeout) * time.Millisecond
+}
+
+// KeyEpochDurationDuration returns the length of the key epochs as time.Duration, or 0 if the keys are not rotated.
+func (dCfg *Debug) KeyEpochDurationDuration() time.Duration {
+	return time.Duration(dCfg.KeyEpochDuration) * time.Millisecond
+}
+
+// KeyGracePeriodDuration returns the key grace period as time.Duration.
+func (dCfg *Debug) KeyGracePeriodDuration() time.Duration {
+	return time.Duration(dCfg.KeyGracePeriod) * time.Millisecond
+}
+
+// DrainTimeoutDuration returns the drain timeout as time.Duration.
+func (dCfg *Debug) DrainTimeoutDuration() time.Duration {
+	return time.Duration(dCfg.DrainTimeout) * time.Millisecond
+}
+
+// Config is the top level Nym node configuration. Exactly one of the MixNode and Provider blocks is present.
+type Config struct {
+	MixNode  *MixNode  `toml:"mixnode"`
+	Provider *Provider `toml:"provider"`
+	Logging  *Logging  `toml:"logging"`
+	Debug    *Debug    `toml:"debug"`
+}
+
+// DefaultMixNodeConfig returns full default config for given mixNodeID
+func DefaultMixNodeConfig(mixNodeID string) (*Config, error) {
+	defaultMixNode, err := DefaultMixNode(mixNodeID)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		MixNode: defaultMixNode,
+		Logging: DefaultLoggingConfig(),
+		Debug:   DefaultDebugConfig(),
+	}, nil
+}
+
+// DefaultProviderConfig returns full default config for given providerID
+func DefaultProviderConfig(providerID string) (*Config, error) {
+	defaultProvider, err := DefaultProvider(providerID)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		Provider: defaultProvider,
+		Logging:  DefaultLoggingConfig(),
+		Debug:    DefaultDebugConfig(),
+	}, nil
+}
+
+func (cfg *Config) validateAndApplyDefaults() error {
+	switch {
+	case cfg.MixNode == nil && cfg.Provider == nil:
+		return errors.New("config: Neither MixNode nor Provider block was present")
+	case cfg.MixNode != nil && cfg.Provider != nil:
+		return errors.New("config: Both MixNode and Provider blocks were present")
+	case cfg.MixNode != nil:
+		if err := cfg.MixNode.validateAndApplyDefaults(); err != nil {
+			return err
+		}
+	default:
+		if err := cfg.Provider.validateAndApplyDefaults(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Debug == nil {
+		cfg.Debug = &Debug{}
+	}
+	if err := cfg.Debug.validateAndApplyDefaults(); err != nil {
+		return err
+	}
+
+	if cfg.Logging == nil {
+		cfg.Logging = DefaultLoggingConfig()
+	}
+
+	return cfg.Logging.validateAndApplyDefaults()
+}
@@ -0,0 +1,278 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultMixNodeConfigPath(t *testing.T) {
+	configPath, err := DefaultMixNodeConfigPath("")
+	assert.Len(t, configPath, 0)
+	assert.Error(t, err)
+
+	configPath, err = DefaultMixNodeConfigPath("foo")
+	homeDir := os.ExpandEnv("$HOME")
+	assert.Equal(t, filepath.Join(homeDir, "/.nym/mixnodes/foo/config/config.toml"), configPath)
+	assert.Nil(t, err)
+}
+
+func TestDefaultProviderConfigPath(t *testing.T) {
+	configPath, err := DefaultProviderConfigPath("")
+	assert.Len(t, configPath, 0)
+	assert.Error(t, err)
+
+	configPath, err = DefaultProviderConfigPath("foo")
+	homeDir := os.ExpandEnv("$HOME")
+	assert.Equal(t, filepath.Join(homeDir, "/.nym/providers/foo/config/config.toml"), configPath)
+	assert.Nil(t, err)
+}
+
+func TestDefaultMixNodeConfig(t *testing.T) {
+	fullCfg, err := DefaultMixNodeConfig("")
+	assert.Nil(t, fullCfg)
+	assert.Error(t, err)
+
+	fullCfg, err = DefaultMixNodeConfig("foo")
+	assert.NotNil(t, fullCfg)
+	assert.Nil(t, err)
+	assert.Nil(t, fullCfg.Provider)
+
+	// check that replacing homedir correctly affects locations of keys
+	fullCfg.MixNode.HomeDirectory = "/baz"
+	assert.Equal(t, "/baz/foo/config/private_key.pem", fullCfg.MixNode.PrivateKeyFile())
+	assert.Equal(t, "/baz/foo/config/public_key.pem", fullCfg.MixNode.PublicKeyFile())
+
+	// However, if keys have absolute paths, homedir should be ignored
+	fullCfg.MixNode.PrivateKey = "/some/absolute/path/priv.pem"
+	fullCfg.MixNode.PublicKey = "/some/absolute/path/pub.pem"
+	assert.Equal(t, "/some/absolute/path/priv.pem", fullCfg.MixNode.PrivateKeyFile())
+	assert.Equal(t, "/some/absolute/path/pub.pem", fullCfg.MixNode.PublicKeyFile())
+	assert.Equal(t, "/baz/foo/admin.sock", fullCfg.MixNode.AdminSocketFile())
+}
+
+func TestDefaultProviderConfig(t *testing.T) {
+	fullCfg, err := DefaultProviderConfig("")
+	assert.Nil(t, fullCfg)
+	assert.Error(t, err)
+
+	fullCfg, err = DefaultProviderConfig("foo")
+	assert.NotNil(t, fullCfg)
+	assert.Nil(t, err)
+	assert.Nil(t, fullCfg.MixNode)
+
+	fullCfg.Provider.HomeDirectory = "/baz"
+	assert.Equal(t, "/baz/foo/config/private_key.pem", fullCfg.Provider.PrivateKeyFile())
+	assert.Equal(t, "/baz/foo/inboxes", fullCfg.Provider.FullInboxDir())
+
+	fullCfg.Provider.InboxDirectory = "/var/nym/inboxes"
+	assert.Equal(t, "/var/nym/inboxes", fullCfg.Provider.FullInboxDir())
+	assert.Equal(t, "/baz/foo/admin.sock", fullCfg.Provider.AdminSocketFile())
+
+	fullCfg.Provider.AdminSocket = "/run/nym/provider.sock"
+	assert.Equal(t, "/run/nym/provider.sock", fullCfg.Provider.AdminSocketFile())
+}
+
+func TestImpairmentConfig(t *testing.T) {
+	cfg, err := DefaultMixNode("foo")
+	assert.Nil(t, err)
+
+	impairmentCfg, err := cfg.ImpairmentConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, impairmentCfg)
+
+	cfg.Impairment = "drop=0.1,seed=42"
+	_, err = cfg.ImpairmentConfig()
+	assert.Error(t, err)
+
+	cfg.DirectoryServer = DefaultLocalDirectoryServer
+	impairmentCfg, err = cfg.ImpairmentConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.1, impairmentCfg.Drop)
+	assert.Equal(t, int64(42), impairmentCfg.Seed)
+}
+
+func TestAnnounceHostPort(t *testing.T) {
+	cfg, err := DefaultMixNode("foo")
+	assert.Nil(t, err)
+	cfg.Host = "10.0.0.1"
+
+	host, port, err := cfg.AnnounceHostPort()
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", host)
+	assert.Equal(t, defaultPort, port)
+
+	cfg.AnnounceAddress = "mix.example.com:9000"
+	host, port, err = cfg.AnnounceHostPort()
+	assert.Nil(t, err)
+	assert.Equal(t, "mix.example.com", host)
+	assert.Equal(t, "9000", port)
+}
+
+func TestValidateAndApplyDefaults(t *testing.T) {
+	// if we create empty structs and apply defaults to them, we should obtain results identical
+	// to just obtaining default structs, apart from the layer, which can be legitimately set to 0
+	fullCfg, err := DefaultMixNodeConfig("foo")
+	assert.Nil(t, err)
+
+	freshFullCfg := &Config{MixNode: &MixNode{ID: "foo", Layer: fullCfg.MixNode.Layer}}
+	assert.Nil(t, freshFullCfg.validateAndApplyDefaults())
+	assert.Equal(t, fullCfg, freshFullCfg)
+
+	fullProviderCfg, err := DefaultProviderConfig("foo")
+	assert.Nil(t, err)
+
+	freshProviderCfg := &Config{Provider: &Provider{ID: "foo"}, Logging: &Logging{}}
+	assert.Nil(t, freshProviderCfg.validateAndApplyDefaults())
+	assert.Equal(t, fullProviderCfg, freshProviderCfg)
+
+	// No mixnode or provider block
+	assert.Error(t, (&Config{}).validateAndApplyDefaults())
+
+	// Both mixnode and provider blocks
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"}, Provider: &Provider{ID: "foo"}}).validateAndApplyDefaults())
+
+	// No ID
+	assert.Error(t, (&Config{MixNode: &MixNode{}}).validateAndApplyDefaults())
+	assert.Error(t, (&Config{Provider: &Provider{}}).validateAndApplyDefaults())
+
+	// Announce address without a port
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo", AnnounceAddress: "10.0.0.1"}}).validateAndApplyDefaults())
+
+	// Metrics address without a port
+	assert.Error(t, (&Config{Provider: &Provider{ID: "foo", MetricsAddress: "localhost"}}).validateAndApplyDefaults())
+
+	// Impairment outside of the local network, or with an invalid specification
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo", Impairment: "drop=0.1"}}).validateAndApplyDefaults())
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo",
+		DirectoryServer: DefaultLocalDirectoryServer,
+		Impairment:      "drop=2",
+	}}).validateAndApplyDefaults())
+
+	// Invalid logging level
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"}, Logging: &Logging{Level: "foo"}}).validateAndApplyDefaults())
+
+	// Negative interval
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"}, Debug: &Debug{PresenceInterval: -1}}).validateAndApplyDefaults())
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"}, Debug: &Debug{LoopTimeout: -1}}).validateAndApplyDefaults())
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"}, Debug: &Debug{DrainTimeout: -1}}).validateAndApplyDefaults())
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"},
+		Debug: &Debug{KeyEpochDuration: -1},
+	}).validateAndApplyDefaults())
+
+	// Key grace period not shorter than the key epoch
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"},
+		Debug: &Debug{KeyEpochDuration: 60000, KeyGracePeriod: 60000},
+	}).validateAndApplyDefaults())
+
+	// Negative number of the layers, or the rebalancing of the layers without the key epochs
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"}, Debug: &Debug{Layers: -1}}).validateAndApplyDefaults())
+	assert.Error(t, (&Config{MixNode: &MixNode{ID: "foo"},
+		Debug: &Debug{RebalanceLayers: true},
+	}).validateAndApplyDefaults())
+
+	// Setting custom home directory that is not absolute
+	fullCfg.MixNode.HomeDirectory = "non/absolute/path"
+	assert.Error(t, fullCfg.validateAndApplyDefaults())
+}
+
+func TestMaxPacketDelayDuration(t *testing.T) {
+	dCfg := DefaultDebugConfig()
+	assert.Equal(t, time.Minute, dCfg.MaxPacketDelayDuration())
+
+	// the negative value disables the limit
+	dCfg.MaxPacketDelay = -1
+	assert.Equal(t, time.Duration(0), dCfg.MaxPacketDelayDuration())
+}
+
+func TestLoadBinary(t *testing.T) {
+	cfg, err := LoadBinary([]byte(""))
+	assert.Nil(t, cfg)
+	assert.Error(t, err)
+
+	cfg, err = LoadBinary([]byte("[someinvalid[toml{data]"))
+	assert.Nil(t, cfg)
+	assert.Error(t, err)
+
+	fullCfg, err := DefaultMixNodeConfig("foo")
+	assert.Nil(t, err)
+
+	b, err := toml.Marshal(fullCfg)
+	assert.Nil(t, err)
+
+	cfg, err = LoadBinary(b)
+	assert.Nil(t, err)
+	assert.Equal(t, fullCfg, cfg)
+}
+
+func TestWriteConfig(t *testing.T) {
+	cfg, err := LoadFile("/path/that/does/not/exist")
+	assert.Nil(t, cfg)
+	assert.Error(t, err)
+
+	tmpDir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	outFilePath := filepath.Join(tmpDir, "testCfg.toml")
+
+	fullCfg, err := DefaultMixNodeConfig("foo")
+	assert.Nil(t, err)
+
+	// set some nondefault values
+	fullCfg.MixNode.HomeDirectory = "/foomp/.nym"
+	fullCfg.MixNode.Host = "localhost"
+	fullCfg.MixNode.Port = "9980"
+	fullCfg.MixNode.AnnounceAddress = "mix.example.com:9980"
+	fullCfg.MixNode.MetricsAddress = "localhost:9981"
+	fullCfg.MixNode.Layer = 2
+	fullCfg.MixNode.DirectoryServer = DefaultLocalDirectoryServer
+	fullCfg.MixNode.Impairment = "drop=0.1,latency=50ms,seed=42"
+	fullCfg.Logging.File = "/tmp/mixnode.log"
+	fullCfg.Logging.Level = "trace"
+	fullCfg.Debug.MaxPacketDelay = -1
+	fullCfg.Debug.LoopCoverTrafficRate = 0.25
+	fullCfg.Debug.KeyEpochDuration = 3600000
+	fullCfg.Debug.Layers = 4
+	fullCfg.Debug.RebalanceLayers = true
+
+	assert.Nil(t, WriteConfigFile(outFilePath, fullCfg))
+
+	loadedCfg, err := LoadFile(outFilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, fullCfg, loadedCfg)
+
+	fullProviderCfg, err := DefaultProviderConfig("bar")
+	assert.Nil(t, err)
+
+	fullProviderCfg.Provider.Host = "localhost"
+	fullProviderCfg.Provider.InboxDirectory = "/var/nym/inboxes"
+	fullProviderCfg.Provider.MetricsAddress = ":9100"
+	fullProviderCfg.Provider.AdminSocket = "/run/nym/provider.sock"
+	fullProviderCfg.Debug.PresenceInterval = 5000
+	fullProviderCfg.Debug.DrainTimeout = 30000
+
+	assert.Nil(t, WriteConfigFile(outFilePath, fullProviderCfg))
+
+	loadedCfg, err = LoadFile(outFilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, fullProviderCfg, loadedCfg)
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+)
+
+var configTemplate *template.Template
+
+func init() {
+	var err error
+	if configTemplate, err = template.New("configFileTemplate").Funcs(template.FuncMap{
+		"FormatFloats": func(f float64) string { return fmt.Sprintf("%.2f", f) },
+	}).Parse(defaultConfigTemplate); err != nil {
+		panic(err)
+	}
+}
+
+// LoadBinary loads, parses and validates the provided buffer b (as a config)
+// and returns the Config.
+func LoadBinary(b []byte) (*Config, error) {
+	cfg := new(Config)
+	_, err := toml.Decode(string(b), cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validateAndApplyDefaults(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LoadFile loads, parses and validates the provided file and returns the Config.
+func LoadFile(f string) (*Config, error) {
+	b, err := ioutil.ReadFile(filepath.Clean(f))
+	if err != nil {
+		return nil, err
+	}
+	return LoadBinary(b)
+}
+
+// WriteConfigFile renders config using the template and writes it to specified file path.
+func WriteConfigFile(path string, config *Config) error {
+	var buffer bytes.Buffer
+
+	if err := configTemplate.Execute(&buffer, config); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+// helper function to make config creation independent of root dir
+// adapted from the tendermint code
+func rootify(path, root string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// As with the client config, the template is used rather than the toml marshalling to attach comments to the file.
+// Note: any changes to the template must be reflected in the appropriate structs and tags.
+const defaultConfigTemplate = `# This is a TOML config file.
+# For more information, see https://github.com/toml-lang/toml
+{{ with .MixNode }}
+##### main base mixnode config options #####
+[mixnode]
+
+# Human readable ID of this particular mixnode.
+id = "{{ .ID }}"
+
+# The host on which the mixnode is listening. If it is not a valid address, the local IP address is used instead.
+host = "{{ .Host }}"
+
+# The port on which the mixnode is listening.
+port = "{{ .Port }}"
+
+# The host:port address announced to the directory server. If empty, the listening address is announced.
+announce_address = "{{ .AnnounceAddress }}"
+
+# The host:port address on which the mixnode serves its metrics under /metrics. If empty, they are not served.
+metrics_address = "{{ .MetricsAddress }}"
+
+# Path to the Unix domain socket on which the mixnode serves its admin API.
+admin_socket = "{{ .AdminSocket }}"
+
+# The mixnet layer of this particular mixnode. If -1, the mixnode registers in the least populated layer
+# of the network when it starts.
+layer = {{ .Layer }}
+
+# Impairment of the forwarded packets, only allowed with the local directory server, for example
+# "drop=0.1,latency=50ms,jitter=20ms,duplicate=0.05,reorder=0.1,corrupt=0.01,seed=42". If empty, none.
+impairment = "{{ .Impairment }}"
+
+# Base URL of the directory server.
+directory_server = "{{ .DirectoryServer }}"
+
+# Path to file containing private key.
+priv_key_file = "{{ .PrivateKey }}"
+
+# Path to file containing public key.
+pub_key_file = "{{ .PublicKey }}"
+
+##### advanced configuration options #####
+
+# Absolute path to the home Nym mixnodes directory.
+nym_home_directory = "{{ .HomeDirectory }}"
+{{ end }}{{ with .Provider }}
+##### main base provider config options #####
+[provider]
+
+# Human readable ID of this particular provider.
+id = "{{ .ID }}"
+
+# The host on which the provider is listening. If it is not a valid address, the local IP address is used instead.
+host = "{{ .Host }}"
+
+# The port on which the provider is listening.
+port = "{{ .Port }}"
+
+# The host:port address announced to the directory server. If empty, the listening address is announced.
+announce_address = "{{ .AnnounceAddress }}"
+
+# The host:port address on which the provider serves its metrics under /metrics. If empty, they are not served.
+metrics_address = "{{ .MetricsAddress }}"
+
+# Path to the Unix domain socket on which the provider serves its admin API.
+admin_socket = "{{ .AdminSocket }}"
+
+# Base URL of the directory server.
+directory_server = "{{ .DirectoryServer }}"
+
+# Directory in which the messages of the clients are stored.
+inbox_directory = "{{ .InboxDirectory }}"
+
+# Path to file containing private key.
+priv_key_file = "{{ .PrivateKey }}"
+
+# Path to file containing public key.
+pub_key_file = "{{ .PublicKey }}"
+
+##### advanced configuration options #####
+
+# Absolute path to the home Nym providers directory.
+nym_home_directory = "{{ .HomeDirectory }}"
+{{ end }}
+##### logging configuration options #####
+[logging]
+
+# Disable logging entirely.
+disable = {{ .Logging.Disable }}
+
+# The log file. If empty, stdout is used.
+file = "{{ .Logging.File }}"
+
+# The log level: trace, debug, info, warn, error, fatal or panic.
+level = "{{ .Logging.Level }}"
+
+##### debug configuration options #####
+# The following options should not be modified unless you know what you are doing.
+# All the intervals and delays are in milliseconds.
+[debug]
+
+# How often the node announces its presence to the directory server.
+presence_interval = {{ .Debug.PresenceInterval }}
+
+# How often the mixnode sends its metrics to the directory server.
+metrics_interval = {{ .Debug.MetricsInterval }}
+
+# The longest delay the node holds a packet for. Packets requesting longer delays are dropped.
+# If negative, the delays are not limited.
+max_packet_delay = {{ .Debug.MaxPacketDelay }}
+
+# The rate at which the mixnode sends its loop cover packets, which come back to it through the other layers.
+# The value is the parameter of an exponential distribution, and is the reciprocal of the
+# expected value of the exponential distribution.
+# If set to a negative value, the mixnode does not send any loop cover packets. It is not used by the providers.
+loop_cover_traffic_rate = {{FormatFloats .Debug.LoopCoverTrafficRate }}
+
+# The time after which the loop cover packet that has not come back is considered lost.
+loop_timeout = {{ .Debug.LoopTimeout }}
+
+# The length of the key epochs. If set, the node uses a fresh sphinx key in every epoch and erases the keys of
+# the past epochs, while its long-term key only identifies it. If 0, the node keeps using its long-term key
+# for the packets.
+key_epoch_duration = {{ .Debug.KeyEpochDuration }}
+
+# For how long after the end of the key epoch the packets for its key are still accepted.
+key_grace_period = {{ .Debug.KeyGracePeriod }}
+
+# For how long the node, when shut down gracefully, keeps forwarding the packets it has already received.
+# The packets whose delays have not elapsed by then are dropped.
+drain_timeout = {{ .Debug.DrainTimeout }}
+
+# The number of the mixnet layers the mixnode without an assigned layer chooses from.
+layers = {{ .Debug.Layers }}
+
+# Whether the mixnode without an assigned layer moves to another layer at the end of the key epoch,
+# if the layers of the network became unbalanced. It requires the key epochs.
+rebalance_layers = {{ .Debug.RebalanceLayers }}
+`
@@ -0,0 +1,234 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package impairment emulates the unreliable network in the forwarding path of the mixnodes,
+// so that the reliability of the clients could be tested. The packets can be dropped, delayed, duplicated,
+// reordered and corrupted with the configured probabilities. All the decisions are drawn from the generator
+// seeded with the configured seed, so the same sequence of packets is always impaired in the same way.
+// It is only meant for the local networks.
+package impairment
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReorderDelay is the time the reordered packets are held back for, unless configured otherwise.
+	DefaultReorderDelay = 100 * time.Millisecond
+)
+
+// The keys of the impairment specification.
+const (
+	keySeed         = "seed"
+	keyDrop         = "drop"
+	keyLatency      = "latency"
+	keyJitter       = "jitter"
+	keyDuplicate    = "duplicate"
+	keyReorder      = "reorder"
+	keyReorderDelay = "reorder_delay"
+	keyCorrupt      = "corrupt"
+)
+
+// Config defines how the packets are impaired. The probabilities are between 0 and 1.
+type Config struct {
+	// Seed seeds the generator all the decisions are drawn from.
+	Seed int64
+	// Drop is the probability of the packet being dropped.
+	Drop float64
+	// Latency is the extra time every packet is delayed for.
+	Latency time.Duration
+	// Jitter is the largest random deviation from the latency, in either direction.
+	// The delay never goes below zero.
+	Jitter time.Duration
+	// Duplicate is the probability of the packet being sent twice. Both copies are impaired independently.
+	Duplicate float64
+	// Reorder is the probability of the packet being held back for ReorderDelay,
+	// so that the packets forwarded after it overtake it.
+	Reorder float64
+	// ReorderDelay is the time the reordered packets are held back for, DefaultReorderDelay by default.
+	ReorderDelay time.Duration
+	// Corrupt is the probability of a single random bit of the sphinx packet being flipped.
+	Corrupt float64
+}
+
+// ParseConfig parses the impairment specification: the comma separated list of key=value pairs,
+// for example "drop=0.1,latency=50ms,jitter=20ms,duplicate=0.05,reorder=0.1,corrupt=0.01,seed=42".
+// The keys are seed, drop, latency, jitter, duplicate, reorder, reorder_delay and corrupt.
+// The omitted ones are zero, except for reorder_delay, which defaults to DefaultReorderDelay.
+func ParseConfig(spec string) (Config, error) {
+	var cfg Config
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return Config{}, fmt.Errorf("impairment: %q is not a key=value pair", pair)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		var err error
+		switch key {
+		case keySeed:
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		case keyDrop:
+			cfg.Drop, err = strconv.ParseFloat(value, 64)
+		case keyLatency:
+			cfg.Latency, err = time.ParseDuration(value)
+		case keyJitter:
+			cfg.Jitter, err = time.ParseDuration(value)
+		case keyDuplicate:
+			cfg.Duplicate, err = strconv.ParseFloat(value, 64)
+		case keyReorder:
+			cfg.Reorder, err = strconv.ParseFloat(value, 64)
+		case keyReorderDelay:
+			cfg.ReorderDelay, err = time.ParseDuration(value)
+		case keyCorrupt:
+			cfg.Corrupt, err = strconv.ParseFloat(value, 64)
+		default:
+			return Config{}, fmt.Errorf("impairment: unknown key %q", key)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("impairment: invalid value of %v: %v", key, err)
+		}
+	}
+	if err := cfg.applyDefaults(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// String returns the specification of the config, which ParseConfig parses back into the same config.
+func (cfg Config) String() string {
+	return fmt.Sprintf("%s=%d,%s=%s,%s=%v,%s=%v,%s=%s,%s=%s,%s=%v,%s=%s",
+		keySeed, cfg.Seed,
+		keyDrop, strconv.FormatFloat(cfg.Drop, 'g', -1, 64),
+		keyLatency, cfg.Latency,
+		keyJitter, cfg.Jitter,
+		keyDuplicate, strconv.FormatFloat(cfg.Duplicate, 'g', -1, 64),
+		keyReorder, strconv.FormatFloat(cfg.Reorder, 'g', -1, 64),
+		keyReorderDelay, cfg.ReorderDelay,
+		keyCorrupt, strconv.FormatFloat(cfg.Corrupt, 'g', -1, 64),
+	)
+}
+
+func (cfg *Config) applyDefaults() error {
+	for _, p := range []float64{cfg.Drop, cfg.Duplicate, cfg.Reorder, cfg.Corrupt} {
+		if p < 0 || p > 1 {
+			return errors.New("impairment: the probabilities have to be between 0 and 1")
+		}
+	}
+	if cfg.Latency < 0 || cfg.Jitter < 0 || cfg.ReorderDelay < 0 {
+		return errors.New("impairment: the delays cannot be negative")
+	}
+	if cfg.ReorderDelay == 0 {
+		cfg.ReorderDelay = DefaultReorderDelay
+	}
+	return nil
+}
+
+// Delivery is a single packet to forward, once its delay elapses.
+type Delivery struct {
+	// Packet is the packet to forward. If it was corrupted, it is a modified copy of the original packet.
+	Packet []byte
+	// Delay is the time to wait for before forwarding the packet.
+	Delay time.Duration
+}
+
+// Impairer decides how every forwarded packet is impaired. It is safe for concurrent use,
+// but the decisions only repeat for the same seed if the packets are impaired in the same order.
+type Impairer struct {
+	cfg Config
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// New creates the Impairer with the given config.
+func New(cfg Config) (*Impairer, error) {
+	if err := cfg.applyDefaults(); err != nil {
+		return nil, err
+	}
+	return &Impairer{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed))}, nil
+}
+
+// Config returns the config of the impairer.
+func (im *Impairer) Config() Config {
+	return im.cfg
+}
+
+// Impair returns the deliveries of the given packet: none if it is dropped, two if it is duplicated,
+// and a single one otherwise. The given packet is never modified.
+func (im *Impairer) Impair(packet []byte) []Delivery {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	// the decisions are always drawn in the same order, so that they only depend on the seed
+	// and the number of the packets impaired before
+	if im.chance(im.cfg.Drop) {
+		return nil
+	}
+	copies := 1
+	if im.chance(im.cfg.Duplicate) {
+		copies = 2
+	}
+	deliveries := make([]Delivery, copies)
+	for i := range deliveries {
+		deliveries[i] = Delivery{Packet: packet, Delay: im.delay()}
+		if im.chance(im.cfg.Corrupt) {
+			deliveries[i].Packet = im.corrupt(packet)
+		}
+	}
+	return deliveries
+}
+
+// chance returns true with the given probability. It does not draw anything if the probability is zero,
+// so that the impairments which are not enabled do not change the decisions of the other ones.
+func (im *Impairer) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	return im.rng.Float64() < p
+}
+
+func (im *Impairer) delay() time.Duration {
+	d := im.cfg.Latency
+	if im.cfg.Jitter > 0 {
+		d += time.Duration(im.rng.Int63n(int64(2*im.cfg.Jitter)+1)) - im.cfg.Jitter
+	}
+	if im.chance(im.cfg.Reorder) {
+		d += im.cfg.ReorderDelay
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// corrupt returns the copy of the packet with a single random bit flipped.
+func (im *Impairer) corrupt(packet []byte) []byte {
+	corrupted := make([]byte, len(packet))
+	copy(corrupted, packet)
+	if len(corrupted) > 0 {
+		bit := im.rng.Intn(len(corrupted) * 8)
+		corrupted[bit/8] ^= 1 << uint(bit%8)
+	}
+	return corrupted
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impairment
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig("drop=0.1, latency=50ms,jitter=20ms,duplicate=0.05,reorder=0.1,corrupt=0.01,seed=42")
+	assert.Nil(t, err)
+	assert.Equal(t, Config{Seed: 42,
+		Drop:         0.1,
+		Latency:      50 * time.Millisecond,
+		Jitter:       20 * time.Millisecond,
+		Duplicate:    0.05,
+		Reorder:      0.1,
+		ReorderDelay: DefaultReorderDelay,
+		Corrupt:      0.01,
+	}, cfg)
+
+	parsed, err := ParseConfig(cfg.String())
+	assert.Nil(t, err)
+	assert.Equal(t, cfg, parsed)
+
+	cfg, err = ParseConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, Config{ReorderDelay: DefaultReorderDelay}, cfg)
+
+	for _, spec := range []string{
+		"drop",
+		"drop=foo",
+		"drop=1.5",
+		"corrupt=-0.1",
+		"latency=-1s",
+		"latency=50",
+		"foo=1",
+	} {
+		_, err := ParseConfig(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func impairAll(t *testing.T, cfg Config, packets int) [][]Delivery {
+	im, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries := make([][]Delivery, packets)
+	for i := range deliveries {
+		deliveries[i] = im.Impair([]byte("packet"))
+	}
+	return deliveries
+}
+
+func TestImpairIsDeterministic(t *testing.T) {
+	cfg := Config{Seed: 42, Drop: 0.2, Latency: time.Second, Jitter: 500 * time.Millisecond,
+		Duplicate: 0.2, Reorder: 0.2, Corrupt: 0.2,
+	}
+	first := impairAll(t, cfg, 1000)
+	assert.Equal(t, first, impairAll(t, cfg, 1000))
+
+	cfg.Seed = 43
+	assert.NotEqual(t, first, impairAll(t, cfg, 1000))
+}
+
+func TestImpairWithoutImpairments(t *testing.T) {
+	for _, deliveries := range impairAll(t, Config{Seed: 1}, 100) {
+		assert.Equal(t, []Delivery{{Packet: []byte("packet")}}, deliveries)
+	}
+}
+
+func TestImpairRates(t *testing.T) {
+	const packets = 10000
+	cfg := Config{Seed: 1, Drop: 0.1, Duplicate: 0.2, Corrupt: 0.3, Reorder: 0.4, ReorderDelay: time.Minute,
+		Latency: time.Second, Jitter: 100 * time.Millisecond,
+	}
+	dropped, duplicated, corrupted, reordered, sent := 0, 0, 0, 0, 0
+	for _, deliveries := range impairAll(t, cfg, packets) {
+		switch len(deliveries) {
+		case 0:
+			dropped++
+		case 2:
+			duplicated++
+		}
+		for _, d := range deliveries {
+			sent++
+			if !bytes.Equal(d.Packet, []byte("packet")) {
+				corrupted++
+				assert.Len(t, d.Packet, len("packet"))
+			}
+			if d.Delay >= time.Minute {
+				reordered++
+				d.Delay -= time.Minute
+			}
+			assert.True(t, d.Delay >= 900*time.Millisecond && d.Delay <= 1100*time.Millisecond)
+		}
+	}
+	assert.InDelta(t, 0.1, float64(dropped)/packets, 0.02)
+	assert.InDelta(t, 0.2, float64(duplicated)/float64(packets-dropped), 0.02)
+	assert.InDelta(t, 0.3, float64(corrupted)/float64(sent), 0.02)
+	assert.InDelta(t, 0.4, float64(reordered)/float64(sent), 0.02)
+}
+
+func TestImpairCorruptsCopy(t *testing.T) {
+	im, err := New(Config{Corrupt: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := []byte("packet")
+	deliveries := im.Impair(packet)
+	assert.Equal(t, []byte("packet"), packet)
+	assert.Len(t, deliveries, 1)
+
+	// exactly one bit is flipped
+	flipped := 0
+	for i := range packet {
+		for diff := packet[i] ^ deliveries[0].Packet[i]; diff != 0; diff &= diff - 1 {
+			flipped++
+		}
+	}
+	assert.Equal(t, 1, flipped)
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	_, err := New(Config{Duplicate: 2})
+	assert.Error(t, err)
+}
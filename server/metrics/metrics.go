@@ -0,0 +1,300 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics implements the local metrics of the mixnodes and providers
+// exposed over HTTP in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metric is a single named metric which can write its samples in the text format.
+type metric interface {
+	name() string
+	help() string
+	kind() string
+	writeSamples(w *bufio.Writer)
+}
+
+// Registry holds the metrics of the node and writes them in the Prometheus text format,
+// in the order they were registered in.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]struct{}
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]struct{})}
+}
+
+// register adds the metric to the registry. The metrics are only registered when the node is created,
+// so registering the same name twice is a programming error.
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.names[m.name()]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", m.name()))
+	}
+	r.names[m.name()] = struct{}{}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all the metrics in the Prometheus text format to the writer.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name(), escapeHelp(m.help()))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name(), m.kind())
+		m.writeSamples(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Counter is the metric whose value only ever goes up.
+type Counter struct {
+	metricName string
+	metricHelp string
+	value      uint64
+}
+
+// NewCounter registers the counter with the given name and help text.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, metricHelp: help}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increments the counter by the given value.
+func (c *Counter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *Counter) name() string { return c.metricName }
+func (c *Counter) help() string { return c.metricHelp }
+func (c *Counter) kind() string { return "counter" }
+
+func (c *Counter) writeSamples(w *bufio.Writer) {
+	fmt.Fprintf(w, "%s %d\n", c.metricName, c.Value())
+}
+
+// CounterVec is the set of the counters distinguished by the value of a single label.
+type CounterVec struct {
+	metricName string
+	metricHelp string
+	label      string
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewCounterVec registers the set of the counters with the given name, help text and label name.
+// The counters of the given label values are created straight away, so that they are written even before
+// they are first incremented.
+func (r *Registry) NewCounterVec(name, help, label string, values ...string) *CounterVec {
+	v := &CounterVec{metricName: name, metricHelp: help, label: label, counters: make(map[string]*Counter)}
+	for _, value := range values {
+		v.With(value)
+	}
+	r.register(v)
+	return v
+}
+
+// With returns the counter of the given label value, creating it if needed.
+func (v *CounterVec) With(value string) *Counter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[value]
+	if !ok {
+		c = &Counter{metricName: v.metricName}
+		v.counters[value] = c
+	}
+	return c
+}
+
+func (v *CounterVec) name() string { return v.metricName }
+func (v *CounterVec) help() string { return v.metricHelp }
+func (v *CounterVec) kind() string { return "counter" }
+
+func (v *CounterVec) writeSamples(w *bufio.Writer) {
+	v.mu.Lock()
+	values := make([]string, 0, len(v.counters))
+	for value := range v.counters {
+		values = append(values, value)
+	}
+	counters := make([]*Counter, len(values))
+	sort.Strings(values)
+	for i, value := range values {
+		counters[i] = v.counters[value]
+	}
+	v.mu.Unlock()
+
+	for i, value := range values {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", v.metricName, v.label, escapeLabelValue(value), counters[i].Value())
+	}
+}
+
+// funcMetric is the counter or the gauge whose value is read from the node whenever the metrics are written.
+type funcMetric struct {
+	metricName string
+	metricHelp string
+	metricKind string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers the gauge with the given name and help text, whose value is returned by fn.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{metricName: name, metricHelp: help, metricKind: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers the counter with the given name and help text, whose value is returned by fn.
+// It is meant for the counters the node already keeps on its own.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{metricName: name, metricHelp: help, metricKind: "counter", fn: fn})
+}
+
+func (f *funcMetric) name() string { return f.metricName }
+func (f *funcMetric) help() string { return f.metricHelp }
+func (f *funcMetric) kind() string { return f.metricKind }
+
+func (f *funcMetric) writeSamples(w *bufio.Writer) {
+	fmt.Fprintf(w, "%s %s\n", f.metricName, formatFloat(f.fn()))
+}
+
+// Histogram counts the observed values in the buckets with the given upper bounds.
+type Histogram struct {
+	metricName string
+	metricHelp string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers the histogram with the given name, help text and upper bounds of the buckets.
+// The bucket of all the values, with the infinite upper bound, is added implicitly.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	h := &Histogram{metricName: name, metricHelp: help, buckets: sorted, counts: make([]uint64, len(sorted))}
+	r.register(h)
+	return h
+}
+
+// Observe adds the value to the histogram.
+func (h *Histogram) Observe(value float64) {
+	// the counts are not cumulative, they are summed up when the histogram is written
+	i := sort.SearchFloat64s(h.buckets, value)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+// ObserveDuration adds the duration to the histogram in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func (h *Histogram) name() string { return h.metricName }
+func (h *Histogram) help() string { return h.metricHelp }
+func (h *Histogram) kind() string { return "histogram" }
+
+func (h *Histogram) writeSamples(w *bufio.Writer) {
+	h.mu.Lock()
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	cumulative := uint64(0)
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.metricName, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.metricName, count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.metricName, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.metricName, count)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// nolint: gochecknoglobals
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/logger"
+	"github.com/nymtech/nym-mixnet/node"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_received_total", "Number of the packets\nreceived.")
+	c.Inc()
+	c.Add(2)
+	v := r.NewCounterVec("test_dropped_total", "Number of the packets dropped.", "reason", "replay")
+	v.With(`weird "reason"`).Inc()
+	r.NewGaugeFunc("test_queue_depth", "Depth of the queue.", func() float64 { return 2.5 })
+	r.NewCounterFunc("test_infinite_total", `Infinite \ counter.`, func() float64 { return math.Inf(1) })
+	h := r.NewHistogram("test_latency_seconds", "Latency.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.ObserveDuration(500 * time.Millisecond)
+	h.Observe(3)
+
+	var b bytes.Buffer
+	n, err := r.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+	assert.Equal(t, `# HELP test_received_total Number of the packets\nreceived.
+# TYPE test_received_total counter
+test_received_total 3
+# HELP test_dropped_total Number of the packets dropped.
+# TYPE test_dropped_total counter
+test_dropped_total{reason="replay"} 0
+test_dropped_total{reason="weird \"reason\""} 1
+# HELP test_queue_depth Depth of the queue.
+# TYPE test_queue_depth gauge
+test_queue_depth 2.5
+# HELP test_infinite_total Infinite \\ counter.
+# TYPE test_infinite_total counter
+test_infinite_total +Inf
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 2
+test_latency_seconds_bucket{le="1"} 3
+test_latency_seconds_bucket{le="+Inf"} 4
+test_latency_seconds_sum 3.65
+test_latency_seconds_count 4
+`, b.String())
+}
+
+func TestRegistryDuplicateName(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.")
+	assert.Panics(t, func() { r.NewGaugeFunc("test_total", "Test.", func() float64 { return 0 }) })
+}
+
+func TestProcessingDropReason(t *testing.T) {
+	assert.Equal(t, DropReplay, ProcessingDropReason(sphinx.ErrReplayedPacket))
+	assert.Equal(t, DropUnsupportedVersion, ProcessingDropReason(sphinx.ErrUnsupportedVersion))
+	assert.Equal(t, DropDelayTooLong, ProcessingDropReason(node.ErrDelayTooLong))
+	assert.Equal(t, DropInvalid, ProcessingDropReason(errors.New("foo")))
+}
+
+func TestServer(t *testing.T) {
+	baseDisabledLogger, err := logger.New("", "info", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+	s, err := NewServer("127.0.0.1:0", r, baseDisabledLogger.GetLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Close()
+
+	resp, err := http.Get("http://" + s.Addr().String() + Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "# HELP test_total Test.\n# TYPE test_total counter\ntest_total 1\n", string(body))
+
+	resp, err = http.Post("http://"+s.Addr().String()+Path, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
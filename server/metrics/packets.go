@@ -0,0 +1,161 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/nymtech/nym-mixnet/node"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+// The reasons the packets are dropped for.
+const (
+	// DropReplay is the reason of the replayed packets.
+	DropReplay = "replay"
+	// DropUnsupportedVersion is the reason of the packets in the versions of the packet format
+	// the node does not accept.
+	DropUnsupportedVersion = "unsupported_version"
+	// DropInvalid is the reason of the packets which failed to be processed for any other reason.
+	DropInvalid = "invalid"
+	// DropDelayTooLong is the reason of the packets requesting longer delays than the node allows.
+	DropDelayTooLong = "delay_too_long"
+	// DropBacklogFull is the reason of the packets which could not be scheduled as the backlog was full.
+	DropBacklogFull = "backlog_full"
+	// DropDraining is the reason of the packets received while the node is being drained before shutting down.
+	DropDraining = "draining"
+	// DropForwardFailed is the reason of the packets which could not be forwarded to the next hop.
+	DropForwardFailed = "forward_failed"
+	// DropCover is the reason of the cover packets, which are dropped once processed.
+	DropCover = "cover"
+	// DropUnknownCommand is the reason of the packets whose commands the node does not act on.
+	DropUnknownCommand = "unknown_command"
+	// DropStoreFailed is the reason of the packets which the provider failed to store in the inbox of the client.
+	DropStoreFailed = "store_failed"
+	// DropImpaired is the reason of the packets dropped on purpose by the mixnode emulating an unreliable network.
+	DropImpaired = "impaired"
+	// DropUnknownNextHop is the reason of the packets whose next hops are not the nodes of the network.
+	DropUnknownNextHop = "unknown_next_hop"
+	// DropShutdown is the reason of the packets still held by the node when it shut down.
+	DropShutdown = "shutdown"
+)
+
+// nolint: gochecknoglobals
+var (
+	// processingBuckets are the upper bounds, in seconds, of the buckets of the processing times of the packets.
+	processingBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05}
+	// delayBuckets are the upper bounds, in seconds, of the buckets of the times the packets spend in the node.
+	delayBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+// PacketSource is the node whose packets are counted by the PacketMetrics.
+type PacketSource interface {
+	// QueueDepth returns the number of the processed packets waiting for their delays to elapse.
+	QueueDepth() int
+	// ReplayedPackets returns the number of the replayed packets the node rejected.
+	ReplayedPackets() uint64
+}
+
+// PacketMetrics are the metrics of the sphinx packets common to the mixnodes and the providers.
+type PacketMetrics struct {
+	// Received counts the packets received by the node.
+	Received *Counter
+	// Forwarded counts the packets forwarded to the next hop.
+	Forwarded *Counter
+	// Dropped counts the packets dropped by the node by the reason they were dropped for.
+	Dropped *CounterVec
+	// Processing is the histogram of the times the cryptographic processing of the packets took.
+	Processing *Histogram
+	// Delay is the histogram of the times between the packets being received and acted on.
+	Delay *Histogram
+}
+
+// NewPacketMetrics registers the metrics of the packets of the given node.
+func NewPacketMetrics(r *Registry, source PacketSource) *PacketMetrics {
+	p := &PacketMetrics{
+		Received:  r.NewCounter("nym_packets_received_total", "Number of the sphinx packets received."),
+		Forwarded: r.NewCounter("nym_packets_forwarded_total", "Number of the sphinx packets forwarded to the next hop."),
+		Dropped: r.NewCounterVec("nym_packets_dropped_total", "Number of the sphinx packets dropped, by the reason.",
+			"reason",
+			DropReplay,
+			DropUnsupportedVersion,
+			DropInvalid,
+			DropDelayTooLong,
+			DropBacklogFull,
+			DropDraining,
+			DropForwardFailed,
+			DropCover,
+			DropUnknownCommand,
+			DropStoreFailed,
+			DropImpaired,
+		),
+		Processing: r.NewHistogram("nym_packet_processing_seconds",
+			"Time the cryptographic processing of the sphinx packets took.",
+			processingBuckets,
+		),
+		Delay: r.NewHistogram("nym_packet_delay_seconds",
+			"Time between the sphinx packets being received and acted on, including their delays.",
+			delayBuckets,
+		),
+	}
+	r.NewGaugeFunc("nym_delay_queue_depth", "Number of the processed packets waiting for their delays to elapse.",
+		func() float64 { return float64(source.QueueDepth()) },
+	)
+	r.NewCounterFunc("nym_packets_replayed_total", "Number of the replayed sphinx packets rejected.",
+		func() float64 { return float64(source.ReplayedPackets()) },
+	)
+	return p
+}
+
+// Scheduled records the outcome of scheduling the received packet. The packets which could not be scheduled
+// are counted as dropped.
+func (p *PacketMetrics) Scheduled(err error) {
+	switch err {
+	case nil:
+	case node.ErrSchedulerFull:
+		p.Dropped.With(DropBacklogFull).Inc()
+	case node.ErrSchedulerDraining, node.ErrSchedulerStopped:
+		p.Dropped.With(DropDraining).Inc()
+	default:
+		p.Dropped.With(DropInvalid).Inc()
+	}
+}
+
+// Processed records the packet released by the scheduler. The packets that failed to be processed
+// are counted as dropped.
+func (p *PacketMetrics) Processed(res *node.PacketProcessingResult) {
+	p.Processing.ObserveDuration(res.ProcessingTime())
+	if err := res.Err(); err != nil {
+		p.Dropped.With(ProcessingDropReason(err)).Inc()
+		return
+	}
+	if !res.ReceivedAt().IsZero() {
+		p.Delay.ObserveDuration(time.Since(res.ReceivedAt()))
+	}
+}
+
+// ProcessingDropReason returns the reason the packet whose processing failed with the given error is dropped for.
+func ProcessingDropReason(err error) string {
+	switch err {
+	case sphinx.ErrReplayedPacket:
+		return DropReplay
+	case sphinx.ErrUnsupportedVersion:
+		return DropUnsupportedVersion
+	case node.ErrDelayTooLong:
+		return DropDelayTooLong
+	default:
+		return DropInvalid
+	}
+}
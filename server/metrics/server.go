@@ -0,0 +1,87 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Path is the path under which the metrics are served.
+	Path = "/metrics"
+	// contentType is the content type of the Prometheus text format.
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+	// writeTimeout is the time after which writing the metrics to a slow scraper fails.
+	writeTimeout = 10 * time.Second
+)
+
+// ServeHTTP writes all the metrics of the registry in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if req.Method == http.MethodHead {
+		return
+	}
+	_, _ = r.WriteTo(w)
+}
+
+// Server serves the metrics of the registry over HTTP.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+	log      *logrus.Logger
+}
+
+// NewServer creates the Server listening on the given address. It does not serve any requests until started.
+func NewServer(address string, registry *Registry, log *logrus.Logger) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, registry)
+	return &Server{listener: listener,
+		server: &http.Server{Handler: mux, ReadHeaderTimeout: writeTimeout, WriteTimeout: writeTimeout},
+		log:    log,
+	}, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Start serves the metrics in the background until the server is closed.
+func (s *Server) Start() {
+	go func() {
+		s.log.Infof("Serving the metrics on http://%v%v", s.Addr(), Path)
+		if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			s.log.Errorf("Failed to serve the metrics: %v", err)
+		}
+	}()
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixnode
+
+import (
+	"encoding/base64"
+	"sync/atomic"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+)
+
+// The mix node started without an assigned layer registers in the least populated of the layers
+// of the network, and, if configured to, moves to another layer at the end of the key epoch
+// whenever the layers become unbalanced. The move takes effect with its next presence announcement.
+
+// Layer returns the layer the mix node is currently registered in.
+func (m *MixServer) Layer() int {
+	return int(atomic.LoadInt32(&m.layer))
+}
+
+func (m *MixServer) setLayer(layer int) {
+	atomic.StoreInt32(&m.layer, int32(layer))
+}
+
+// mixPresence returns the presence of the mix nodes from the topology of the network.
+func (m *MixServer) mixPresence() (topology.MixPresence, error) {
+	topologyData, err := topology.GetNetworkTopology(m.cfg.MixNode.DirectoryServer + config.DirectoryServerTopologyPath)
+	if err != nil {
+		return nil, err
+	}
+	return topologyData.MixNodes, nil
+}
+
+// ownPubKey returns the public key the mix node is counted with in the layers: its long-term key, which identifies
+// it even if it rotates its keys and announces a presence for each of its epoch keys.
+func (m *MixServer) ownPubKey() string {
+	return base64.URLEncoding.EncodeToString(m.GetPublicKey().Bytes())
+}
+
+// assignLayer registers the mix node in the least populated of the layers of the given mix nodes.
+func (m *MixServer) assignLayer(mixes topology.MixPresence) error {
+	layer, err := topology.ChooseLayer(mixes, uint(m.cfg.Debug.Layers), m.ownPubKey())
+	if err != nil {
+		return err
+	}
+	m.setLayer(int(layer))
+	m.log.Infof("%s: Registering in the layer %d", m.id, layer)
+	return nil
+}
+
+// rebalanceLayer moves the mix node to another layer if the layers of the given mix nodes are unbalanced
+// and it is one of the nodes chosen to move.
+func (m *MixServer) rebalanceLayer(mixes topology.MixPresence) {
+	layer, ok := topology.RebalancedLayer(mixes, uint(m.cfg.Debug.Layers), m.ownPubKey())
+	if !ok || int(layer) == m.Layer() {
+		return
+	}
+	m.log.Infof("%s: Moving from the layer %d to the layer %d to rebalance the layers", m.id, m.Layer(), layer)
+	m.setLayer(int(layer))
+}
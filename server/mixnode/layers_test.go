@@ -0,0 +1,152 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixnode
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+	"github.com/nymtech/nym-mixnet/node"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func presenceInLayer(pubKey string, layer uint) models.MixNodePresence {
+	return presenceOfHost(pubKey, pubKey, layer)
+}
+
+// presenceOfHost creates the presence with the given public key announced by the mix node with the given host.
+func presenceOfHost(host string, pubKey string, layer uint) models.MixNodePresence {
+	return models.MixNodePresence{MixHostInfo: models.MixHostInfo{
+		HostInfo: models.HostInfo{Host: host, PubKey: pubKey},
+		Layer:    layer,
+	}}
+}
+
+func b64Key(key *sphinx.PublicKey) string {
+	return base64.URLEncoding.EncodeToString(key.Bytes())
+}
+
+func TestMixServerAssignLayer(t *testing.T) {
+	m := createLoopTestMixServer(t, -1)
+	mixes := topology.MixPresence{
+		presenceInLayer("a", 1),
+		presenceInLayer("b", 2),
+		presenceInLayer("c", 3),
+		presenceInLayer("d", 1),
+		// the previous presence of the node itself is not counted
+		presenceInLayer(b64Key(m.GetPublicKey()), 2),
+	}
+	assert.Nil(t, m.assignLayer(mixes))
+	assert.Equal(t, 2, m.Layer())
+
+	m.cfg.Debug.Layers = 0
+	assert.Error(t, m.assignLayer(mixes))
+}
+
+func TestMixServerRebalanceLayer(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	mixes := topology.MixPresence{
+		presenceInLayer("~a", 1),
+		presenceInLayer("b", 2),
+		presenceInLayer("c", 3),
+		presenceInLayer(b64Key(m.GetPublicKey()), 1),
+	}
+	// one node more in a layer is balanced
+	m.rebalanceLayer(mixes)
+	assert.Equal(t, 1, m.Layer())
+
+	// the lowest public key of the most populated layer moves to the emptiest layer,
+	// and "~" follows all the characters of the base64 encoded keys
+	mixes = append(mixes, presenceInLayer("~b", 1))
+	mixes[2].Layer = 2
+	m.rebalanceLayer(mixes)
+	assert.Equal(t, 3, m.Layer())
+}
+
+func TestMixServerRebalanceLayerWithEpochKeys(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	keys, err := node.NewKeyRing(time.Hour, time.Minute, node.SystemClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.UseKeyRing(keys)
+	presenceKeys := helpers.PresenceKeys(m.GetPublicKey(), m.AnnouncedKeys())
+	mixes := topology.MixPresence{
+		presenceInLayer("~a", 1),
+		presenceInLayer("b", 2),
+		presenceInLayer("c", 3),
+		presenceOfHost("self", presenceKeys[0], 1),
+		presenceOfHost("self", presenceKeys[1], 1),
+	}
+	// the two presences of the node count as a single node, identified by its long-term key
+	m.rebalanceLayer(mixes)
+	assert.Equal(t, 1, m.Layer())
+
+	mixes = append(mixes, presenceInLayer("~b", 1))
+	mixes[2].Layer = 2
+	m.rebalanceLayer(mixes)
+	assert.Equal(t, 3, m.Layer())
+}
+
+// greaterKey generates the public key following all the given ones in the order the mix nodes
+// move between the layers in.
+func greaterKey(t *testing.T, keys []*sphinx.PublicKey) *sphinx.PublicKey {
+	for {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		greater := true
+		for _, key := range keys {
+			greater = greater && b64Key(pub) > b64Key(key)
+		}
+		if greater {
+			return pub
+		}
+	}
+}
+
+func TestMixServerRebalancesLayerAtEpochEnd(t *testing.T) {
+	directory := startFakeDirectory(t)
+	m := createLoopTestMixServer(t, 1)
+	m.cfg.MixNode.DirectoryServer = directory
+	m.rebalanceLayers = true
+	clock := node.NewManualClock(time.Unix(0, 0).Add(1000*time.Hour + 30*time.Minute))
+	keys, err := node.NewKeyRing(time.Hour, time.Minute, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.UseKeyRing(keys)
+	assert.Nil(t, m.RefreshPresence())
+
+	// the node has the lowest public key of the most populated layer, so it is the one to move
+	for _, layer := range []int{1, 1, 2} {
+		pub := greaterKey(t, []*sphinx.PublicKey{m.GetPublicKey()})
+		assert.Nil(t, helpers.RegisterMixNodePresenceAt(directory, b64Key(pub), layer, b64Key(pub)))
+	}
+
+	// the node only moves once the key epoch ends
+	clock.Advance(20 * time.Minute)
+	assert.Nil(t, m.RefreshPresence())
+	assert.Equal(t, 1, m.Layer())
+	clock.Advance(20 * time.Minute)
+	assert.Nil(t, m.RefreshPresence())
+	assert.Equal(t, 3, m.Layer())
+}
@@ -0,0 +1,311 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixnode
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/clientcore"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+// The mix node sends its own loop cover packets, which traverse a mix from every other layer and come back to it.
+// Apart from hiding the volume of the traffic, the loops which do not come back tell the node
+// that the packets are being dropped, for example by an (n-1) attack on the node.
+const (
+	// loopMessagePrefix starts the message of every loop packet and is followed by the id of the loop.
+	loopMessagePrefix = "nym-mix-loop:"
+	// loopIDSize is the number of the random bytes of the id of the loop.
+	loopIDSize = 16
+	// loopHopDelayRate is the parameter of the exponential distribution of the delays at every hop of the loop,
+	// the same as the one used by the clients.
+	loopHopDelayRate = 5
+)
+
+var (
+	// errNoLoopRoute is returned when the topology does not contain the mixes of any other layer.
+	errNoLoopRoute = errors.New("no mixes in the other layers to route the loop through")
+)
+
+// LoopStats describes the loop cover traffic sent by the mix node.
+type LoopStats struct {
+	// Sent is the number of the loops sent.
+	Sent uint64
+	// Returned is the number of the loops which came back in time.
+	Returned uint64
+	// Lost is the number of the loops which did not come back before the timeout.
+	Lost uint64
+	// Pending is the number of the loops which are still on their way.
+	Pending int
+}
+
+// LossRate returns the fraction of the finished loops that were lost.
+func (s LoopStats) LossRate() float64 {
+	finished := s.Returned + s.Lost
+	if finished == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(finished)
+}
+
+// loopTracker keeps the deadlines of the loops that were sent and have not come back yet.
+type loopTracker struct {
+	sync.Mutex
+	timeout  time.Duration
+	pending  map[string]time.Time
+	sent     uint64
+	returned uint64
+	lost     uint64
+}
+
+func newLoopTracker(timeout time.Duration) *loopTracker {
+	return &loopTracker{timeout: timeout, pending: make(map[string]time.Time)}
+}
+
+// add records the loop sent at the given time.
+func (t *loopTracker) add(id string, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	t.pending[id] = now.Add(t.timeout)
+	t.sent++
+}
+
+// remove forgets the loop which could not be sent after all.
+func (t *loopTracker) remove(id string) {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.pending[id]; ok {
+		delete(t.pending, id)
+		t.sent--
+	}
+}
+
+// markReturned records the loop that came back. It returns false if the loop is not pending,
+// because it was never sent, has already come back or has already been considered lost.
+func (t *loopTracker) markReturned(id string) bool {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.pending[id]; !ok {
+		return false
+	}
+	delete(t.pending, id)
+	t.returned++
+	return true
+}
+
+// expire considers all the loops whose deadlines passed lost and returns their number.
+func (t *loopTracker) expire(now time.Time) int {
+	t.Lock()
+	defer t.Unlock()
+	expired := 0
+	for id, deadline := range t.pending {
+		if now.After(deadline) {
+			delete(t.pending, id)
+			expired++
+		}
+	}
+	t.lost += uint64(expired)
+	return expired
+}
+
+func (t *loopTracker) stats() LoopStats {
+	t.Lock()
+	defer t.Unlock()
+	return LoopStats{Sent: t.sent, Returned: t.returned, Lost: t.lost, Pending: len(t.pending)}
+}
+
+// loopRoute chooses the nodes the loop of the given mix node in the given layer traverses: a random mix
+// from each of the other layers, in the order of the layers following the layer of the node,
+// followed by the node itself, so that the packet comes back from the layer preceding its own.
+func loopRoute(rng io.Reader,
+	mixes topology.LayeredMixes,
+	self config.MixConfig,
+	layer uint,
+) ([]config.MixConfig, error) {
+	layers := make([]uint, 0, len(mixes))
+	for l := range mixes {
+		if l != layer {
+			layers = append(layers, l)
+		}
+	}
+	// the layers following the layer of the node come first, then the ones preceding it
+	sort.Slice(layers, func(i, j int) bool {
+		if (layers[i] > layer) != (layers[j] > layer) {
+			return layers[i] > layer
+		}
+		return layers[i] < layers[j]
+	})
+
+	route := make([]config.MixConfig, 0, len(layers)+1)
+	for _, l := range layers {
+		mix, err := helpers.RandomMixFrom(rng, mixes[l])
+		if err == helpers.ErrPermEmptyList {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		route = append(route, mix)
+	}
+	if len(route) == 0 {
+		return nil, errNoLoopRoute
+	}
+	return append(route, self), nil
+}
+
+// createLoopPacket creates the loop packet traversing the given mixes and returns its id together with its route
+// and the packet. The packet comes back for the sphinx key the mix node uses in the current key epoch.
+func (m *MixServer) createLoopPacket(network clientcore.NetworkPKI) (string, []config.MixConfig, []byte, error) {
+	route, err := loopRoute(rand.Reader, network.Mixes, m.config, uint(m.Layer()))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// the other mixes rotating their keys are known by their long-term keys, which only identify them
+	now := time.Now()
+	for i := range route[:len(route)-1] {
+		route[i] = network.EpochKeys.MixAt(route[i], now)
+	}
+	route[len(route)-1].PubKey = m.SphinxKey().Bytes()
+	delays := make([]float64, len(route))
+	for i := range delays {
+		if delays[i], err = helpers.RandomExponential(loopHopDelayRate); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	idBytes := make([]byte, loopIDSize)
+	if _, err := io.ReadFull(rand.Reader, idBytes); err != nil {
+		return "", nil, nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	self := config.ClientConfig{Id: m.config.Id, Host: m.config.Host, Port: m.config.Port, PubKey: m.config.PubKey}
+	packet, err := sphinx.DefaultParams.PackMessageWithCommand(rand.Reader,
+		route,
+		delays,
+		self,
+		[]byte(loopMessagePrefix+id),
+		sphinx.LoopBackCommand{},
+	)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	packetBytes, err := packet.MarshalBinary()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return id, route, packetBytes, nil
+}
+
+// sendLoop sends a single loop packet through the mixes of the network.
+func (m *MixServer) sendLoop(network clientcore.NetworkPKI) error {
+	id, route, packet, err := m.createLoopPacket(network)
+	if err != nil {
+		return err
+	}
+	// the loop is recorded before it is sent, so that it is recognised even if it comes back straight away
+	m.loops.add(id, time.Now())
+	firstHop := route[0]
+	if err := m.links.Send(net.JoinHostPort(firstHop.Host, firstHop.Port),
+		firstHop.PubKey,
+		flags.CommFlag,
+		packet,
+	); err != nil {
+		m.loops.remove(id)
+		return err
+	}
+	m.log.Debugf("%s: Sent loop %s through %d mixes", m.id, id, len(route)-1)
+	return nil
+}
+
+// handleLoop records the loop packet which came back to the node.
+func (m *MixServer) handleLoop(packet []byte) {
+	message, err := m.ExtractMessage(packet)
+	if err != nil {
+		m.log.Errorf("%s: Failed to extract the message of the loop packet: %v", m.id, err)
+		return
+	}
+	id := strings.TrimPrefix(string(message), loopMessagePrefix)
+	if !strings.HasPrefix(string(message), loopMessagePrefix) || !m.loops.markReturned(id) {
+		m.log.Warnf("%s: Received unknown or expired loop packet. Packet dropped", m.id)
+		return
+	}
+	m.log.Debugf("%s: Loop %s came back", m.id, id)
+}
+
+// LoopStats returns the statistics of the loop cover traffic of the mix node.
+func (m *MixServer) LoopStats() LoopStats {
+	return m.loops.stats()
+}
+
+// startSendingLoops sends the loop packets at the configured rate for as long as the node is running.
+// The topology the loops are routed through is updated whenever it gets too old.
+func (m *MixServer) startSendingLoops() {
+	topologyEndpoint := m.cfg.MixNode.DirectoryServer + config.DirectoryServerTopologyPath
+	var network clientcore.NetworkPKI
+	for {
+		delay, err := helpers.RandomExponential(m.cfg.Debug.LoopCoverTrafficRate)
+		if err != nil {
+			m.log.Errorf("Failed to generate the delay of the loop cover traffic: %v", err)
+			return
+		}
+		timer := time.NewTimer(time.Duration(delay * float64(time.Second)))
+		select {
+		case <-m.drainCh:
+			// the loops could no longer come back to the node
+			timer.Stop()
+			return
+		case <-m.haltedCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if lost := m.loops.expire(time.Now()); lost > 0 {
+			stats := m.loops.stats()
+			m.log.Warnf("%s: %d loops did not come back in time (loss rate: %.2f)", m.id, lost, stats.LossRate())
+		}
+
+		if network.ShouldUpdate() {
+			topologyData, err := topology.GetNetworkTopology(topologyEndpoint)
+			if err != nil {
+				m.log.Errorf("Failed to obtain the topology for the loop cover traffic: %v", err)
+				continue
+			}
+			mixes, err := topology.GetMixesPKI(topologyData.MixNodes)
+			if err != nil {
+				m.log.Errorf("Failed to read the mixes from the topology: %v", err)
+				continue
+			}
+			network.UpdateNetwork(mixes, nil)
+			network.EpochKeys = topology.GetEpochKeys(topologyData)
+		}
+
+		if err := m.sendLoop(network); err != nil {
+			m.log.Errorf("%s: Failed to send the loop cover packet: %v", m.id, err)
+		}
+	}
+}
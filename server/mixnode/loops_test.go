@@ -0,0 +1,196 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixnode
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/clientcore"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+	"github.com/nymtech/nym-mixnet/logger"
+	"github.com/nymtech/nym-mixnet/networker"
+	"github.com/nymtech/nym-mixnet/node"
+	serverConfig "github.com/nymtech/nym-mixnet/server/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// createLoopTestMixServer creates the mix server in the given layer which does not listen for any connections.
+func createLoopTestMixServer(t *testing.T, layer int) *MixServer {
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := serverConfig.DefaultMixNodeConfig("loop-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing listens at the address, so the tests which need the directory server have to start their own
+	cfg.MixNode.DirectoryServer = "http://127.0.0.1:1"
+	baseDisabledLogger, err := logger.New(cfg.Logging.File, cfg.Logging.Level, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &MixServer{id: "loop-test",
+		Mix:        node.NewMix(priv, pub),
+		layer:      int32(layer),
+		cfg:        cfg,
+		loops:      newLoopTracker(time.Minute),
+		peers:      newKnownPeers(),
+		startedAt:  time.Now(),
+		log:        baseDisabledLogger.GetLogger("test"),
+		baseLogger: baseDisabledLogger,
+	}
+	m.identity, err = networker.NewIdentity(priv.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.links = networker.NewLinkManager(m.log, m.identity, networker.LinkConfig{})
+	m.config = config.MixConfig{Id: m.id, Host: "localhost", Port: "9996", PubKey: pub.Bytes()}
+	m.scheduler = node.NewScheduler(m.Mix, m.handleProcessedPacket, node.SchedulerConfig{})
+	m.registerMetrics()
+	return m
+}
+
+func createLoopTestTopology(self config.MixConfig) topology.LayeredMixes {
+	return topology.LayeredMixes{
+		1: {{Id: "1a", PubKey: []byte{1}}},
+		2: {self},
+		3: {{Id: "3a", PubKey: []byte{3}}},
+		4: {{Id: "4a", PubKey: []byte{4}}},
+	}
+}
+
+func TestLoopRoute(t *testing.T) {
+	self := config.MixConfig{Id: "self", PubKey: []byte{2}}
+	route, err := loopRoute(rand.Reader, createLoopTestTopology(self), self, 2)
+	assert.Nil(t, err)
+
+	ids := make([]string, len(route))
+	for i := range route {
+		ids[i] = route[i].Id
+	}
+	// the loop goes through the following layers first and comes back from the preceding one
+	assert.Equal(t, []string{"3a", "4a", "1a", "self"}, ids)
+}
+
+func TestLoopRouteWithoutOtherLayers(t *testing.T) {
+	self := config.MixConfig{Id: "self", PubKey: []byte{2}}
+	_, err := loopRoute(rand.Reader, topology.LayeredMixes{2: {self}, 3: {}}, self, 2)
+	assert.Equal(t, errNoLoopRoute, err)
+}
+
+func TestLoopTracker(t *testing.T) {
+	tracker := newLoopTracker(time.Second)
+	now := time.Now()
+	tracker.add("returned", now)
+	tracker.add("lost", now)
+	tracker.add("late", now.Add(time.Second))
+	tracker.add("failed", now)
+	tracker.remove("failed")
+
+	assert.True(t, tracker.markReturned("returned"))
+	assert.False(t, tracker.markReturned("returned"))
+	assert.False(t, tracker.markReturned("unknown"))
+
+	assert.Equal(t, 1, tracker.expire(now.Add(1500*time.Millisecond)))
+	assert.False(t, tracker.markReturned("lost"))
+
+	stats := tracker.stats()
+	assert.Equal(t, LoopStats{Sent: 3, Returned: 1, Lost: 1, Pending: 1}, stats)
+	assert.Equal(t, 0.5, stats.LossRate())
+	assert.Equal(t, 0.0, LoopStats{}.LossRate())
+}
+
+func TestLoopComesBack(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+
+	otherPriv, otherPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := node.NewMix(otherPriv, otherPub)
+	network := clientcore.NetworkPKI{Mixes: topology.LayeredMixes{
+		1: {m.config},
+		2: {{Id: "other", Host: "localhost", Port: "9997", PubKey: otherPub.Bytes()}},
+	}}
+
+	id, route, packet, err := m.createLoopPacket(network)
+	assert.Nil(t, err)
+	assert.Equal(t, "other", route[0].Id)
+	m.loops.add(id, time.Now())
+
+	res := other.ProcessPacket(packet)
+	assert.Nil(t, res.Err())
+	assert.IsType(t, sphinx.RelayCommand{}, res.Commands().Action())
+
+	res = m.ProcessPacket(res.PacketData())
+	assert.Nil(t, res.Err())
+	m.handleProcessedPacket(res)
+	assert.Equal(t, LoopStats{Sent: 1, Returned: 1}, m.LoopStats())
+
+	// the replayed loop is not counted again
+	m.handleLoop(res.PacketData())
+	assert.Equal(t, LoopStats{Sent: 1, Returned: 1}, m.LoopStats())
+}
+
+func TestLoopComesBackWithEpochKeys(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	ownKeys, err := node.NewKeyRing(time.Hour, time.Minute, node.SystemClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.UseKeyRing(ownKeys)
+
+	otherPriv, otherPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeys, err := node.NewKeyRing(time.Hour, time.Minute, node.SystemClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := node.NewMix(otherPriv, otherPub)
+	other.UseKeyRing(otherKeys)
+	// the other mix is known by its long-term key, together with the epoch keys it announces
+	var presences topology.MixPresence
+	for _, key := range helpers.PresenceKeys(otherPub, other.AnnouncedKeys()) {
+		presences = append(presences, presenceOfHost("localhost:9997", key, 2))
+	}
+	network := clientcore.NetworkPKI{Mixes: topology.LayeredMixes{
+		1: {m.config},
+		2: {{Id: "other", Host: "localhost", Port: "9997", PubKey: otherPub.Bytes()}},
+	}, EpochKeys: topology.GetEpochKeys(&models.Topology{MixNodes: presences})}
+
+	id, route, packet, err := m.createLoopPacket(network)
+	assert.Nil(t, err)
+	// the packet is created for the keys of the current epoch of both mixes
+	assert.Equal(t, otherKeys.Current().Bytes(), route[0].PubKey)
+	assert.Equal(t, ownKeys.Current().Bytes(), route[1].PubKey)
+	m.loops.add(id, time.Now())
+
+	res := other.ProcessPacket(packet)
+	assert.Nil(t, res.Err())
+	assert.Equal(t, ownKeys.Current().Bytes(), res.NextHop().PubKey)
+	res = m.ProcessPacket(res.PacketData())
+	assert.Nil(t, res.Err())
+	m.handleProcessedPacket(res)
+	assert.Equal(t, LoopStats{Sent: 1, Returned: 1}, m.LoopStats())
+}
@@ -16,12 +16,14 @@
 package mixnode
 
 import (
+	"bufio"
 	"encoding/base64"
+	"errors"
+	"io"
 	"net"
 	"sync"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-directory/models"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/flags"
@@ -29,22 +31,14 @@ import (
 	"github.com/nymtech/nym-mixnet/logger"
 	"github.com/nymtech/nym-mixnet/networker"
 	"github.com/nymtech/nym-mixnet/node"
+	"github.com/nymtech/nym-mixnet/server/admin"
+	serverConfig "github.com/nymtech/nym-mixnet/server/config"
+	"github.com/nymtech/nym-mixnet/server/impairment"
+	serverMetrics "github.com/nymtech/nym-mixnet/server/metrics"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	metricsInterval  = time.Second
-	presenceInterval = 2 * time.Second
-
-	// Below should be moved to a config file once we have it
-	// logFileLocation can either point to some valid file to which all log data should be written
-	// or if left an empty string, stdout will be used instead
-	defaultLogFileLocation = ""
-	// considering we are under heavy development and nowhere near production level, log EVERYTHING
-	defaultLogLevel = "trace"
-)
-
 // MixServerIt is the interface of a mix server.
 type MixServerIt interface {
 	networker.NetworkServer
@@ -56,21 +50,44 @@ type MixServerIt interface {
 // MixServer is the data of a mix server
 type MixServer struct {
 	*node.Mix
-	id       string
-	host     string
-	port     string
-	layer    int
-	listener net.Listener
-	config   config.MixConfig
-	metrics  *metrics
-	haltedCh chan struct{}
-	haltOnce sync.Once
-	log      *logrus.Logger
+	id        string
+	host      string
+	port      string
+	layer     int32 // accessed atomically, as the layers can be rebalanced
+	listener  net.Listener
+	identity  *networker.Identity
+	links     *networker.LinkManager
+	peers     *knownPeers
+	config    config.MixConfig
+	cfg       *serverConfig.Config
+	metrics   *metrics
+	loops     *loopTracker
+	scheduler *node.Scheduler
+	impairer  *impairment.Impairer
+	// impaired counts the impaired packets whose delays have not elapsed yet
+	impaired  sync.WaitGroup
+	drainCh   chan struct{}
+	drainOnce sync.Once
+	haltedCh  chan struct{}
+	haltOnce  sync.Once
+	startedAt time.Time
+	log       *logrus.Logger
+
+	baseLogger  *logger.Logger
+	adminServer *admin.Server
+
+	// rebalanceLayers is set if the mix node chose its own layer and moves between the layers
+	// at the ends of the key epochs
+	rebalanceLayers bool
+
+	registry      *serverMetrics.Registry
+	packets       *serverMetrics.PacketMetrics
+	metricsServer *serverMetrics.Server
 }
 
 type metrics struct {
 	sync.Mutex
-	host             string
+	directory        string
 	b64Key           string
 	receivedMessages uint
 	sentMessages     map[string]uint
@@ -112,7 +129,7 @@ func (m *metrics) sendToDirectoryServer() {
 	receivedCopy := m.receivedMessages
 
 	go func(metricsCopy models.MixMetric) {
-		if err := helpers.SendMixMetrics(metricsCopy, m.host); err != nil {
+		if err := helpers.SendMixMetricsAt(m.directory, metricsCopy); err != nil {
 			m.log.Errorf("Failed to send metrics: %v", err)
 		}
 	}(models.MixMetric{
@@ -122,14 +139,14 @@ func (m *metrics) sendToDirectoryServer() {
 	})
 }
 
-func newMetrics(log *logrus.Logger, publicKey *sphinx.PublicKey, host string) *metrics {
+func newMetrics(log *logrus.Logger, publicKey *sphinx.PublicKey, directory string) *metrics {
 	b64key := base64.URLEncoding.EncodeToString(publicKey.Bytes())
 	log.Infof("Our public key is: %v", b64key)
 	return &metrics{
 		log:          log,
 		b64Key:       b64key,
 		sentMessages: make(map[string]uint),
-		host:         host,
+		directory:    directory,
 	}
 }
 
@@ -143,6 +160,75 @@ func (m *MixServer) Shutdown() {
 	m.haltOnce.Do(func() { m.halt() })
 }
 
+// Drain gracefully shuts down the mix node. It stops announcing its presence, so that the directory server drops it
+// once its last presence expires, as the directory server cannot remove it explicitly. It also stops accepting
+// new connections and packets. The packets already received are still forwarded once their delays elapse,
+// for at most the given timeout, after which the mix node shuts down. Only the first call has any effect.
+func (m *MixServer) Drain(timeout time.Duration) {
+	started := false
+	m.drainOnce.Do(func() {
+		started = true
+		close(m.drainCh)
+	})
+	if !started {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	m.log.Infof("%s: Draining before the shutdown (timeout: %v)", m.id, timeout)
+	if err := m.listener.Close(); err != nil {
+		m.log.Warnf("Failed to close the listener: %v", err)
+	}
+	if dropped := m.scheduler.Drain(timeout); dropped > 0 {
+		m.log.Warnf("%s: %d delayed packets dropped, as the drain timed out", m.id, dropped)
+	}
+	// the scheduler no longer relays any packets, so no more impaired packets are delayed
+	if !waitTimeout(&m.impaired, time.Until(deadline)) {
+		m.log.Warnf("%s: Impaired packets dropped, as the drain timed out", m.id)
+	}
+	if unsent := m.links.Flush(time.Until(deadline)); unsent > 0 {
+		m.log.Warnf("%s: %d packets not sent to the next hops, as the drain timed out", m.id, unsent)
+	}
+	m.Shutdown()
+}
+
+// waitTimeout waits for the WaitGroup for at most the given timeout and tells whether it is done.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// halted tells whether the mix node has shut down.
+func (m *MixServer) halted() bool {
+	select {
+	case <-m.haltedCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Draining tells whether the mix node is being drained before shutting down.
+func (m *MixServer) Draining() bool {
+	select {
+	case <-m.drainCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // calls any required cleanup code
 func (m *MixServer) halt() {
 	m.log.Info("Starting graceful shutdown")
@@ -150,6 +236,18 @@ func (m *MixServer) halt() {
 	// possibly send "remove presence" message
 
 	close(m.haltedCh)
+	m.scheduler.Stop()
+	m.links.Close()
+	if m.metricsServer != nil {
+		if err := m.metricsServer.Close(); err != nil {
+			m.log.Warnf("Failed to close the metrics server: %v", err)
+		}
+	}
+	if m.adminServer != nil {
+		if err := m.adminServer.Close(); err != nil {
+			m.log.Warnf("Failed to close the admin server: %v", err)
+		}
+	}
 }
 
 // Start runs a mix server
@@ -166,61 +264,200 @@ func (m *MixServer) GetConfig() config.MixConfig {
 func (m *MixServer) receivedPacket(packet []byte) error {
 	m.log.Infof("%s: Received new sphinx packet", m.id)
 	m.metrics.incrementReceived()
+	m.packets.Received.Inc()
 
-	// process in goroutine so we wouldn't block while executing the required delay
-	go func(packet []byte) {
-		res := m.ProcessPacket(packet)
-		dePacket := res.PacketData()
-		nextHop := res.NextHop()
-		flag := res.Flag()
-		if err := res.Err(); err != nil {
-			m.log.Errorf("error while processing packet: %v", err)
-		}
+	// the scheduler processes and delays the packet so we wouldn't block while executing the required delay
+	err := m.scheduler.Schedule(packet)
+	m.packets.Scheduled(err)
+	return err
+}
 
-		if flag == flags.RelayFlag {
-			if err := m.forwardPacket(dePacket, nextHop.Address); err != nil {
-				m.log.Errorf("error while forwarding packet: %v", err)
-			}
-			// add it only if we didn't return an error
-			m.metrics.addMessage(nextHop.Address)
+// handleProcessedPacket acts on the packet released by the scheduler once its delay elapsed,
+// or straight away if its processing failed.
+func (m *MixServer) handleProcessedPacket(res *node.PacketProcessingResult) {
+	dePacket := res.PacketData()
+	nextHop := res.NextHop()
+	commands := res.Commands()
+	m.packets.Processed(res)
+	if err := res.Err(); err != nil {
+		if err == sphinx.ErrReplayedPacket {
+			m.log.Warnf("%s: Replayed packet detected. Packet dropped (total replays: %v)",
+				m.id,
+				m.ReplayedPackets(),
+			)
+		} else if err == sphinx.ErrUnsupportedVersion {
+			m.log.Warnf("%s: Packet version not accepted (accepted: %v). Packet dropped (total rejected: %v)",
+				m.id,
+				sphinx.FormatVersions(m.AcceptedVersions()),
+				m.UnsupportedVersionPackets(),
+			)
 		} else {
-			m.log.Info("Packet has non-forward flag. Packet dropped")
+			m.log.Errorf("error while processing packet: %v", err)
 		}
-	}(packet)
+		return
+	}
 
-	return nil
+	switch commands.Action().(type) {
+	case sphinx.RelayCommand:
+		m.relayPacket(dePacket, nextHop)
+	case sphinx.DropCommand:
+		m.packets.Dropped.With(serverMetrics.DropCover).Inc()
+		m.log.Debugf("%s: Cover packet dropped", m.id)
+	case sphinx.LoopBackCommand:
+		m.handleLoop(dePacket)
+	default:
+		m.packets.Dropped.With(serverMetrics.DropUnknownCommand).Inc()
+		m.log.Infof("Packet has non-forward commands %v. Packet dropped", commands)
+	}
 }
 
-func (m *MixServer) forwardPacket(sphinxPacket []byte, address string) error {
-	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
-	if err != nil {
-		return err
+// QueueDepth returns the number of the processed packets waiting for their delays to elapse.
+func (m *MixServer) QueueDepth() int {
+	return m.scheduler.QueueDepth()
+}
+
+// Links returns the status of the links to the nodes the packets were forwarded to.
+func (m *MixServer) Links() []networker.LinkStatus {
+	return m.links.Links()
+}
+
+// Status returns the current status of the mix node reported over the admin API.
+func (m *MixServer) Status() admin.Status {
+	return admin.Status{Type: "mixnode",
+		ID:         m.id,
+		PublicKey:  base64.URLEncoding.EncodeToString(m.GetPublicKey().Bytes()),
+		Address:    net.JoinHostPort(m.config.Host, m.config.Port),
+		Layer:      m.Layer(),
+		LogLevel:   m.baseLogger.Level(),
+		StartedAt:  m.startedAt,
+		Uptime:     time.Since(m.startedAt).Seconds(),
+		Draining:   m.Draining(),
+		QueueDepth: m.QueueDepth(),
+		Peers:      admin.Peers(m.Links()),
 	}
-	if err := m.send(packetBytes, address); err != nil {
-		return err
+}
+
+// SetLogLevel changes the level of all the loggers of the mix node.
+func (m *MixServer) SetLogLevel(level string) error {
+	return m.baseLogger.SetLevel(level)
+}
+
+// DrainTimeout returns the drain timeout the mix node is configured with.
+func (m *MixServer) DrainTimeout() time.Duration {
+	return m.cfg.Debug.DrainTimeoutDuration()
+}
+
+// RefreshPresence announces the presence of the mix node to the directory server straight away,
+// rather than once the presence interval elapses. The draining mix node no longer announces its presence.
+func (m *MixServer) RefreshPresence() error {
+	if m.Draining() {
+		return errors.New("the mix node is draining")
 	}
+	m.updateKeys()
+	return m.announcePresence()
+}
 
-	return nil
+// registerMetrics creates the local metrics of the mix node, which are served over HTTP
+// if the metrics address is configured.
+func (m *MixServer) registerMetrics() {
+	m.registry = serverMetrics.NewRegistry()
+	m.packets = serverMetrics.NewPacketMetrics(m.registry, m)
+	m.registry.NewCounterFunc("nym_mixnode_loops_sent_total", "Number of the loop cover packets sent.",
+		func() float64 { return float64(m.LoopStats().Sent) },
+	)
+	m.registry.NewCounterFunc("nym_mixnode_loops_returned_total", "Number of the loop cover packets which came back.",
+		func() float64 { return float64(m.LoopStats().Returned) },
+	)
+	m.registry.NewCounterFunc("nym_mixnode_loops_lost_total", "Number of the loop cover packets which were lost.",
+		func() float64 { return float64(m.LoopStats().Lost) },
+	)
+	m.registry.NewGaugeFunc("nym_mixnode_loops_pending", "Number of the loop cover packets still on their way.",
+		func() float64 { return float64(m.LoopStats().Pending) },
+	)
 }
 
-func (m *MixServer) send(packet []byte, address string) error {
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		return err
+// Metrics writes the local metrics of the mix node in the Prometheus text format.
+func (m *MixServer) Metrics(w io.Writer) error {
+	_, err := m.registry.WriteTo(w)
+	return err
+}
+
+// UseImpairer makes the mix node impair the packets it forwards, to emulate an unreliable network.
+// It has to be called before the mix node is started.
+func (m *MixServer) UseImpairer(impairer *impairment.Impairer) {
+	m.impairer = impairer
+}
+
+// relayPacket forwards the packet to the next hop, through the impairment layer if the mix node has one.
+// The impaired packets which are delayed are forwarded in the background. They are waited for when the mix node
+// is drained, and dropped if their delays elapse after it shut down.
+func (m *MixServer) relayPacket(packet []byte, nextHop sphinx.Hop) {
+	// the links are only opened to the nodes of the network, whatever the next hops in the packets are
+	if !m.knownNextHop(nextHop) {
+		m.packets.Dropped.With(serverMetrics.DropUnknownNextHop).Inc()
+		m.log.Warnf("%s: Packet dropped: %v (%v)", m.id, errUnknownNextHop, nextHop.Address)
+		return
 	}
-	defer conn.Close()
+	if m.impairer == nil {
+		m.forwardAndCount(packet, nextHop)
+		return
+	}
+	deliveries := m.impairer.Impair(packet)
+	if len(deliveries) == 0 {
+		m.packets.Dropped.With(serverMetrics.DropImpaired).Inc()
+		m.log.Debugf("%s: Packet dropped by the impairment", m.id)
+		return
+	}
+	for _, delivery := range deliveries {
+		if delivery.Delay == 0 {
+			m.forwardAndCount(delivery.Packet, nextHop)
+			continue
+		}
+		packet := delivery.Packet
+		m.impaired.Add(1)
+		time.AfterFunc(delivery.Delay, func() {
+			defer m.impaired.Done()
+			if m.halted() {
+				m.packets.Dropped.With(serverMetrics.DropShutdown).Inc()
+				return
+			}
+			m.forwardAndCount(packet, nextHop)
+		})
+	}
+}
 
-	if _, err := conn.Write(packet); err != nil {
-		return err
+func (m *MixServer) forwardAndCount(packet []byte, nextHop sphinx.Hop) {
+	if err := m.forwardPacket(packet, nextHop); err != nil {
+		m.packets.Dropped.With(serverMetrics.DropForwardFailed).Inc()
+		m.log.Errorf("error while forwarding packet: %v", err)
+		return
 	}
-	return nil
+	// add it only if we didn't return an error
+	m.metrics.addMessage(nextHop.Address)
+	m.packets.Forwarded.Inc()
+}
+
+func (m *MixServer) forwardPacket(sphinxPacket []byte, nextHop sphinx.Hop) error {
+	// the packet is sent over the long-lived link to the next hop, which is shared with all the other packets
+	return m.links.Send(nextHop.Address, nextHop.PubKey, flags.CommFlag, sphinxPacket)
 }
 
 func (m *MixServer) run() {
 	defer m.listener.Close()
 
+	m.scheduler.Start()
+	if m.metricsServer != nil {
+		m.metricsServer.Start()
+	}
+	if m.adminServer != nil {
+		m.adminServer.Start()
+	}
 	go m.startSendingMetrics()
 	go m.startSendingPresence()
+	// the loops need the layer of the node to be routed through the other ones
+	if m.cfg.Debug.LoopCoverTrafficRate > 0 && m.Layer() > 0 {
+		go m.startSendingLoops()
+	}
 
 	go func() {
 		m.log.Infof("Listening on %s", m.host+":"+m.port)
@@ -231,7 +468,7 @@ func (m *MixServer) run() {
 }
 
 func (m *MixServer) startSendingMetrics() {
-	ticker := time.NewTicker(metricsInterval)
+	ticker := time.NewTicker(m.cfg.Debug.MetricsIntervalDuration())
 	for {
 		select {
 		case <-ticker.C:
@@ -243,27 +480,106 @@ func (m *MixServer) startSendingMetrics() {
 	}
 }
 
+// startSendingPresence announces the presence of the node. If it rotates its keys,
+// they are updated right before every announcement. The keys of its peers are read from time to time as well.
 func (m *MixServer) startSendingPresence() {
-	ticker := time.NewTicker(presenceInterval)
+	ticker := time.NewTicker(m.cfg.Debug.PresenceIntervalDuration())
 	for {
 		select {
 		case <-ticker.C:
-			if err := helpers.RegisterMixNodePresence(m.GetPublicKey(),
-				m.layer,
-				net.JoinHostPort(m.host, m.port),
-			); err != nil {
+			m.updateKeys()
+			if err := m.announcePresence(); err != nil {
 				m.log.Errorf("Failed to register presence: %v", err)
 			}
+			if m.peers.due(time.Now(), peersUpdateInterval) {
+				if err := m.updatePeers(); err != nil {
+					m.log.Errorf("Failed to obtain the topology to update the keys of the peers: %v", err)
+				}
+			}
+		case <-m.drainCh:
+			ticker.Stop()
+			return
 		case <-m.haltedCh:
 			return
 		}
 	}
 }
 
+// announcePresence announces a presence for every key the node announces. As the directory server only keeps
+// the host, the public key and the layer of the presence, the node rotating its keys announces the keys
+// of the current and the next epoch as two presences with the same host, each carrying its long-term key.
+func (m *MixServer) announcePresence() error {
+	for _, key := range helpers.PresenceKeys(m.GetPublicKey(), m.AnnouncedKeys()) {
+		if err := helpers.RegisterMixNodePresenceAt(m.cfg.MixNode.DirectoryServer,
+			key,
+			m.Layer(),
+			net.JoinHostPort(m.config.Host, m.config.Port),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MixServer) updateKeys() {
+	changed, err := m.UpdateKeys()
+	if err != nil {
+		m.log.Errorf("Failed to update the keys: %v", err)
+	} else if changed {
+		m.log.Infof("%s: New key epoch started", m.id)
+		if err := m.useCurrentKeyIdentity(); err != nil {
+			m.log.Errorf("Failed to authenticate with the key of the new epoch: %v", err)
+		}
+		if m.rebalanceLayers {
+			mixes, err := m.mixPresence()
+			if err != nil {
+				m.log.Errorf("Failed to obtain the topology to rebalance the layers: %v", err)
+				return
+			}
+			m.rebalanceLayer(mixes)
+		}
+	}
+}
+
+// handshakeIdentities returns the identities the node can be reached with: its long-term one and the ones
+// of the sphinx keys it currently accepts, which the other nodes know from the topology and the sphinx packets.
+// All but the long-term identity have to be erased after the handshake.
+func (m *MixServer) handshakeIdentities() ([]*networker.Identity, error) {
+	ids := []*networker.Identity{m.identity}
+	if m.KeyRing() == nil {
+		return ids, nil
+	}
+	var err error
+	m.KeyRing().PrivateKeys(func(keys []*sphinx.PrivateKey) {
+		for _, key := range keys {
+			var id *networker.Identity
+			if id, err = networker.NewIdentity(key.Bytes()); err != nil {
+				return
+			}
+			ids = append(ids, id)
+		}
+	})
+	if err != nil {
+		eraseIdentities(ids[1:])
+		return nil, err
+	}
+	return ids, nil
+}
+
+func eraseIdentities(ids []*networker.Identity) {
+	for _, id := range ids {
+		id.Erase()
+	}
+}
+
 func (m *MixServer) listenForIncomingConnections() {
 	for {
 		conn, err := m.listener.Accept()
 		if err != nil {
+			// the listener is closed when the mix node is drained or shut down
+			if m.Draining() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			m.log.Errorf("Error when listening for incoming connection: %v", err)
 		} else {
 			m.log.Infof("Received connection from %s", conn.RemoteAddr())
@@ -277,69 +593,159 @@ func (m *MixServer) listenForIncomingConnections() {
 	}
 }
 
+// handleConnection performs the handshake and reads the frames from the connection until it is closed.
+// Only the other nodes send the packets to the mix node, so the peers have to authenticate themselves
+// with the keys announced by the nodes of the network.
 func (m *MixServer) handleConnection(conn net.Conn) error {
 	defer conn.Close()
 
-	buff := make([]byte, 2048)
-	reqLen, err := conn.Read(buff)
+	ids, err := m.handshakeIdentities()
 	if err != nil {
 		return err
 	}
-
-	var packet config.GeneralPacket
-	if err := proto.Unmarshal(buff[:reqLen], &packet); err != nil {
+	sconn, err := networker.ServerHandshake(conn, ids...)
+	eraseIdentities(ids[1:])
+	if err != nil {
 		return err
 	}
+	if sconn.PeerKey() == nil {
+		return errors.New("only the authenticated nodes can send packets to the mix node")
+	}
+	if !m.knownPeer(sconn.PeerKey()) {
+		return errUnknownPeer
+	}
 
-	switch flags.PacketTypeFlagFromBytes(packet.Flag) {
+	r := bufio.NewReader(sconn)
+	for {
+		frame, err := networker.ReadFrame(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			// the rest of the stream can no longer be split into frames
+			return err
+		}
+		// a single invalid packet does not break the link
+		if err := m.handleFrame(frame); err != nil {
+			m.log.Errorf("Error while handling packet from %v: %v", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+func (m *MixServer) handleFrame(frame networker.Frame) error {
+	switch frame.Flag {
 	case flags.CommFlag:
-		if err := m.receivedPacket(packet.Data); err != nil {
+		if err := m.receivedPacket(frame.Body); err != nil {
 			return err
 		}
 	default:
-		m.log.Infof("Packet flag %s not recognised. Packet dropped", packet.Flag)
+		m.log.Infof("Packet flag %v not recognised. Packet dropped", frame.Flag)
 		return nil
 	}
 	return nil
 }
 
-// NewMixServer constructor
-// TODO: Identical case to 'NewClient'
-func NewMixServer(id string,
-	host string,
-	port string,
+// NewMixServer constructor. The mix server only accepts packets in the given versions of the packet format.
+// The keys of the mix server are expected to be already loaded from the files specified in the config.
+func NewMixServer(cfg *serverConfig.Config,
 	prvKey *sphinx.PrivateKey,
 	pubKey *sphinx.PublicKey,
-	layer int,
+	versions []sphinx.Version,
 ) (*MixServer, error) {
+	if cfg.MixNode == nil {
+		return nil, errors.New("the config does not contain the MixNode block")
+	}
+	id := cfg.MixNode.ID
+	host := cfg.MixNode.Host
+	port := cfg.MixNode.Port
+
+	announceHost, announcePort, err := cfg.MixNode.AnnounceHostPort()
+	if err != nil {
+		return nil, err
+	}
 
-	baseLogger, err := logger.New(defaultLogFileLocation, defaultLogLevel, false)
+	baseLogger, err := logger.New(cfg.Logging.File, cfg.Logging.Level, cfg.Logging.Disable)
 	if err != nil {
 		return nil, err
 	}
 
 	log := baseLogger.GetLogger(id)
 
-	mix := node.NewMix(prvKey, pubKey)
+	mix, err := node.NewMixWithVersions(prvKey, pubKey, versions)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Debug.KeyEpochDuration > 0 {
+		keys, err := node.NewKeyRing(cfg.Debug.KeyEpochDurationDuration(),
+			cfg.Debug.KeyGracePeriodDuration(),
+			node.SystemClock,
+		)
+		if err != nil {
+			return nil, err
+		}
+		mix.UseKeyRing(keys)
+	}
 	mixServer := MixServer{id: id,
-		host:     host,
-		port:     port,
-		Mix:      mix,
-		layer:    layer,
-		metrics:  newMetrics(baseLogger.GetLogger("metrics "+id), pubKey, net.JoinHostPort(host, port)),
-		haltedCh: make(chan struct{}),
-		log:      log,
+		host:       host,
+		port:       port,
+		Mix:        mix,
+		layer:      int32(cfg.MixNode.Layer),
+		cfg:        cfg,
+		metrics:    newMetrics(baseLogger.GetLogger("metrics "+id), pubKey, cfg.MixNode.DirectoryServer),
+		loops:      newLoopTracker(cfg.Debug.LoopTimeoutDuration()),
+		peers:      newKnownPeers(),
+		drainCh:    make(chan struct{}),
+		haltedCh:   make(chan struct{}),
+		startedAt:  time.Now(),
+		log:        log,
+		baseLogger: baseLogger,
+	}
+	mixServer.scheduler = node.NewScheduler(mix, mixServer.handleProcessedPacket, node.SchedulerConfig{
+		MaxDelay: cfg.Debug.MaxPacketDelayDuration(),
+	})
+	mixServer.registerMetrics()
+	impairmentCfg, err := cfg.MixNode.ImpairmentConfig()
+	if err != nil {
+		return nil, err
+	}
+	if impairmentCfg != nil {
+		impairer, err := impairment.New(*impairmentCfg)
+		if err != nil {
+			return nil, err
+		}
+		mixServer.UseImpairer(impairer)
+		log.Warnf("%s: Impairing the forwarded packets (%v). Use it only in the local networks", id, impairmentCfg)
+	}
+	mixServer.identity, err = networker.NewIdentity(prvKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	mixServer.links = networker.NewLinkManager(baseLogger.GetLogger("links "+id),
+		mixServer.identity,
+		networker.LinkConfig{},
+	)
+	if mixServer.KeyRing() != nil {
+		if err := mixServer.useCurrentKeyIdentity(); err != nil {
+			return nil, err
+		}
 	}
 	mixServer.config = config.MixConfig{Id: mixServer.id,
-		Host:   mixServer.host,
-		Port:   mixServer.port,
+		Host:   announceHost,
+		Port:   announcePort,
 		PubKey: mixServer.GetPublicKey().Bytes(),
 	}
 
-	if err := helpers.RegisterMixNodePresence(mixServer.GetPublicKey(),
-		layer,
-		net.JoinHostPort(host, port),
-	); err != nil {
+	if cfg.MixNode.Layer == serverConfig.UnassignedLayer {
+		mixes, err := mixServer.mixPresence()
+		if err != nil {
+			return nil, err
+		}
+		if err := mixServer.assignLayer(mixes); err != nil {
+			return nil, err
+		}
+		mixServer.rebalanceLayers = cfg.Debug.RebalanceLayers
+	}
+
+	if err := mixServer.announcePresence(); err != nil {
 		return nil, err
 	}
 
@@ -349,6 +755,31 @@ func NewMixServer(id string,
 	}
 	mixServer.listener = listener
 
+	if len(cfg.MixNode.MetricsAddress) > 0 {
+		mixServer.metricsServer, err = serverMetrics.NewServer(cfg.MixNode.MetricsAddress,
+			mixServer.registry,
+			baseLogger.GetLogger("metrics server "+id),
+		)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	if len(cfg.MixNode.AdminSocket) > 0 {
+		mixServer.adminServer, err = admin.NewServer(cfg.MixNode.AdminSocketFile(),
+			&mixServer,
+			baseLogger.GetLogger("admin "+id),
+		)
+		if err != nil {
+			listener.Close()
+			if mixServer.metricsServer != nil {
+				mixServer.metricsServer.Close()
+			}
+			return nil, err
+		}
+	}
+
 	return &mixServer, nil
 }
 
@@ -357,15 +788,37 @@ func CreateTestMixnode() (*MixServer, error) {
 	if err != nil {
 		return nil, err
 	}
-	baseDisabledLogger, err := logger.New(defaultLogFileLocation, defaultLogLevel, true)
+	cfg, err := serverConfig.DefaultMixNodeConfig("test")
+	if err != nil {
+		return nil, err
+	}
+	baseDisabledLogger, err := logger.New(cfg.Logging.File, cfg.Logging.Level, true)
 	if err != nil {
 		return nil, err
 	}
 	// this logger can be shared as it will be disabled anyway
 	disabledLog := baseDisabledLogger.GetLogger("test")
 
-	node := node.NewMix(priv, pub)
-	mix := MixServer{host: "localhost", port: "9995", Mix: node, log: disabledLog}
+	mixNode := node.NewMix(priv, pub)
+	mix := MixServer{host: "localhost",
+		port:       "9995",
+		Mix:        mixNode,
+		cfg:        cfg,
+		loops:      newLoopTracker(cfg.Debug.LoopTimeoutDuration()),
+		peers:      newKnownPeers(),
+		drainCh:    make(chan struct{}),
+		haltedCh:   make(chan struct{}),
+		startedAt:  time.Now(),
+		log:        disabledLog,
+		baseLogger: baseDisabledLogger,
+	}
+	mix.scheduler = node.NewScheduler(mixNode, mix.handleProcessedPacket, node.SchedulerConfig{})
+	mix.registerMetrics()
+	mix.identity, err = networker.NewIdentity(priv.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	mix.links = networker.NewLinkManager(disabledLog, mix.identity, networker.LinkConfig{})
 	mix.config = config.MixConfig{Id: mix.id,
 		Host:   mix.host,
 		Port:   mix.port,
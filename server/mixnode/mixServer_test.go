@@ -13,3 +13,487 @@
 // limitations under the License.
 
 package mixnode
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/clientcore"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+	"github.com/nymtech/nym-mixnet/networker"
+	"github.com/nymtech/nym-mixnet/node"
+	"github.com/nymtech/nym-mixnet/server/impairment"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMixServerMetrics(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	otherPriv, otherPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := node.NewMix(otherPriv, otherPub)
+	network := clientcore.NetworkPKI{Mixes: topology.LayeredMixes{
+		1: {m.config},
+		2: {{Id: "other", Host: "localhost", Port: "9997", PubKey: otherPub.Bytes()}},
+	}}
+	id, _, packet, err := m.createLoopPacket(network)
+	assert.Nil(t, err)
+	m.loops.add(id, time.Now())
+	packet = other.ProcessPacket(packet).PacketData()
+
+	// the loop comes back once, and is rejected as a replay the second time
+	for i := 0; i < 2; i++ {
+		m.packets.Received.Inc()
+		m.handleProcessedPacket(m.ProcessPacket(packet))
+	}
+
+	var b bytes.Buffer
+	assert.Nil(t, m.Metrics(&b))
+	for _, line := range []string{
+		"nym_packets_received_total 2",
+		"nym_packets_forwarded_total 0",
+		`nym_packets_dropped_total{reason="replay"} 1`,
+		`nym_packets_dropped_total{reason="cover"} 0`,
+		"nym_packets_replayed_total 1",
+		"nym_packet_processing_seconds_count 2",
+		"nym_delay_queue_depth 0",
+		"nym_mixnode_loops_sent_total 1",
+		"nym_mixnode_loops_returned_total 1",
+		"nym_mixnode_loops_pending 0",
+	} {
+		assert.Contains(t, b.String(), line+"\n")
+	}
+}
+
+func TestMixServerDrain(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	m.drainCh = make(chan struct{})
+	m.haltedCh = make(chan struct{})
+	m.metrics = &metrics{sentMessages: make(map[string]uint)}
+	m.links = networker.NewLinkManager(m.log, nil, networker.LinkConfig{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.listener = listener
+	m.scheduler.Start()
+	acceptDone := make(chan struct{})
+	go func() {
+		m.listenForIncomingConnections()
+		close(acceptDone)
+	}()
+
+	assert.False(t, m.Draining())
+	m.Drain(time.Minute)
+	assert.True(t, m.Draining())
+	// the node shuts down straight away as it has no packets to forward
+	m.Wait()
+
+	select {
+	case <-acceptDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the node is still accepting the connections")
+	}
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.Error(t, err)
+
+	assert.Equal(t, node.ErrSchedulerStopped, m.receivedPacket([]byte("packet")))
+	var b bytes.Buffer
+	assert.Nil(t, m.Metrics(&b))
+	assert.Contains(t, b.String(), `nym_packets_dropped_total{reason="draining"} 1`+"\n")
+
+	// draining again has no effect
+	m.Drain(time.Minute)
+}
+
+func TestMixServerShutdownStopsAccepting(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	m.drainCh = make(chan struct{})
+	m.haltedCh = make(chan struct{})
+	m.links = networker.NewLinkManager(m.log, nil, networker.LinkConfig{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.listener = listener
+	m.scheduler.Start()
+	acceptDone := make(chan struct{})
+	go func() {
+		m.listenForIncomingConnections()
+		close(acceptDone)
+	}()
+
+	// the listener is closed once the node shuts down, without being drained first
+	m.Shutdown()
+	assert.Nil(t, listener.Close())
+	select {
+	case <-acceptDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the node is still accepting the connections")
+	}
+}
+
+func TestMixServerDrainWaitsForImpairedPackets(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	m.drainCh = make(chan struct{})
+	m.haltedCh = make(chan struct{})
+	m.metrics = &metrics{sentMessages: make(map[string]uint)}
+	m.links = networker.NewLinkManager(m.log, nil, networker.LinkConfig{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.listener = listener
+	m.scheduler.Start()
+
+	relayImpaired(t, m, impairment.Config{Latency: 50 * time.Millisecond})
+	assert.Equal(t, uint64(0), m.packets.Forwarded.Value())
+	m.Drain(200 * time.Millisecond)
+	m.Wait()
+	assert.Equal(t, uint64(1), m.packets.Forwarded.Value())
+}
+
+func TestMixServerShutdownDropsImpairedPackets(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	m.haltedCh = make(chan struct{})
+	m.metrics = &metrics{sentMessages: make(map[string]uint)}
+	m.links = networker.NewLinkManager(m.log, nil, networker.LinkConfig{})
+	m.scheduler.Start()
+
+	relayImpaired(t, m, impairment.Config{Latency: 50 * time.Millisecond})
+	m.Shutdown()
+	m.impaired.Wait()
+	assert.Equal(t, uint64(0), m.packets.Forwarded.Value())
+	assert.Equal(t, uint64(1), m.packets.Dropped.With("shutdown").Value())
+}
+
+func TestMixServerStatus(t *testing.T) {
+	m := createLoopTestMixServer(t, 2)
+	m.drainCh = make(chan struct{})
+	m.links = networker.NewLinkManager(m.log, nil, networker.LinkConfig{})
+	defer m.links.Close()
+
+	status := m.Status()
+	assert.Equal(t, "mixnode", status.Type)
+	assert.Equal(t, "loop-test", status.ID)
+	assert.Equal(t, "localhost:9996", status.Address)
+	assert.Equal(t, 2, status.Layer)
+	assert.Equal(t, "info", status.LogLevel)
+	assert.False(t, status.Draining)
+	assert.Empty(t, status.Peers)
+	assert.Nil(t, status.RegisteredClients)
+	assert.True(t, status.Uptime >= 0)
+
+	assert.Nil(t, m.SetLogLevel("debug"))
+	assert.Equal(t, "debug", m.Status().LogLevel)
+	assert.True(t, m.log.IsLevelEnabled(logrus.DebugLevel))
+	assert.Error(t, m.SetLogLevel("foo"))
+
+	// the draining node does not announce its presence again
+	close(m.drainCh)
+	assert.True(t, m.Status().Draining)
+	assert.Error(t, m.RefreshPresence())
+}
+
+func TestMixServerImpairment(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	m.metrics = &metrics{sentMessages: make(map[string]uint)}
+	m.links = networker.NewLinkManager(m.log, nil, networker.LinkConfig{})
+	defer m.links.Close()
+	relay := func(cfg impairment.Config) { relayImpaired(t, m, cfg) }
+
+	relay(impairment.Config{})
+	assert.Equal(t, uint64(1), m.packets.Forwarded.Value())
+	relay(impairment.Config{Duplicate: 1})
+	assert.Equal(t, uint64(3), m.packets.Forwarded.Value())
+	relay(impairment.Config{Drop: 1})
+	assert.Equal(t, uint64(3), m.packets.Forwarded.Value())
+	assert.Equal(t, uint64(1), m.packets.Dropped.With("impaired").Value())
+
+	// the delayed packets are forwarded in the background
+	relay(impairment.Config{Latency: 10 * time.Millisecond})
+	assert.Equal(t, uint64(3), m.packets.Forwarded.Value())
+	deadline := time.Now().Add(5 * time.Second)
+	for m.packets.Forwarded.Value() != 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, uint64(4), m.packets.Forwarded.Value())
+}
+
+// relayImpaired relays the packet to another mix node through the impairer with the given config.
+func relayImpaired(t *testing.T, m *MixServer, cfg impairment.Config) {
+	_, otherPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := config.MixConfig{Id: "other", Host: "localhost", Port: "9997", PubKey: otherPub.Bytes()}
+	knowPeers(m, other)
+	packet, err := sphinx.DefaultParams.PackMessageWithCommand(rand.Reader,
+		[]config.MixConfig{m.config, other},
+		[]float64{0, 0},
+		config.ClientConfig{Id: "client", Host: "localhost", Port: "9998"},
+		[]byte("Cover"),
+		sphinx.DropCommand{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetBytes, err := packet.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	impairer, err := impairment.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.UseImpairer(impairer)
+	m.handleProcessedPacket(m.ProcessPacket(packetBytes))
+}
+
+// knowPeers makes the mix node know the given nodes, as if it read them from the topology.
+func knowPeers(m *MixServer, nodes ...config.MixConfig) {
+	topologyData := &models.Topology{}
+	for _, n := range nodes {
+		topologyData.MixNodes = append(topologyData.MixNodes, models.MixNodePresence{MixHostInfo: models.MixHostInfo{
+			HostInfo: models.HostInfo{Host: net.JoinHostPort(n.Host, n.Port), PubKey: b64Key(sphinx.BytesToPublicKey(n.PubKey))},
+		}})
+	}
+	m.peers.update(topologyData)
+}
+
+func TestMixServerDropsUnknownNextHops(t *testing.T) {
+	m := createLoopTestMixServer(t, 1)
+	m.metrics = &metrics{sentMessages: make(map[string]uint)}
+	m.links = networker.NewLinkManager(m.log, nil, networker.LinkConfig{})
+	defer m.links.Close()
+	_, otherPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := config.MixConfig{Id: "other", Host: "localhost", Port: "9997", PubKey: otherPub.Bytes()}
+
+	// the next hop is not in the topology at all, or is announced at another address
+	m.relayPacket([]byte("packet"), sphinx.Hop{Address: "localhost:9997", PubKey: otherPub.Bytes()})
+	knowPeers(m, config.MixConfig{Host: "localhost", Port: "9998", PubKey: other.PubKey})
+	m.relayPacket([]byte("packet"), sphinx.Hop{Address: "localhost:9997", PubKey: otherPub.Bytes()})
+	assert.Equal(t, uint64(2), m.packets.Dropped.With("unknown_next_hop").Value())
+	assert.Empty(t, m.Links())
+
+	knowPeers(m, other)
+	m.relayPacket([]byte("packet"), sphinx.Hop{Address: "localhost:9997", PubKey: otherPub.Bytes()})
+	assert.Equal(t, uint64(1), m.packets.Forwarded.Value())
+	assert.Len(t, m.Links(), 1)
+}
+
+// fakeDirectory keeps the presences the way the directory server does: indexed by their public keys and with
+// only the fields of its models, so that anything else announced in the presence is dropped.
+type fakeDirectory struct {
+	sync.Mutex
+	mixes     map[string]models.MixNodePresence
+	providers map[string]models.MixProviderPresence
+}
+
+// startFakeDirectory starts the fake directory server and returns its base URL.
+func startFakeDirectory(t *testing.T) string {
+	d := &fakeDirectory{mixes: make(map[string]models.MixNodePresence),
+		providers: make(map[string]models.MixProviderPresence),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(config.DirectoryServerMixPresencePath, func(w http.ResponseWriter, r *http.Request) {
+		var info models.MixHostInfo
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		d.Lock()
+		defer d.Unlock()
+		d.mixes[info.PubKey] = models.MixNodePresence{MixHostInfo: info, LastSeen: time.Now().UnixNano()}
+	})
+	mux.HandleFunc(config.DirectoryServerMixProviderPresencePath, func(w http.ResponseWriter, r *http.Request) {
+		var info models.MixProviderHostInfo
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		d.Lock()
+		defer d.Unlock()
+		d.providers[info.PubKey] = models.MixProviderPresence{MixProviderHostInfo: info, LastSeen: time.Now().UnixNano()}
+	})
+	mux.HandleFunc(config.DirectoryServerTopologyPath, func(w http.ResponseWriter, r *http.Request) {
+		d.Lock()
+		defer d.Unlock()
+		topologyData := models.Topology{}
+		for _, mix := range d.mixes {
+			topologyData.MixNodes = append(topologyData.MixNodes, mix)
+		}
+		for _, provider := range d.providers {
+			topologyData.MixProviderNodes = append(topologyData.MixProviderNodes, provider)
+		}
+		_ = json.NewEncoder(w).Encode(topologyData)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestMixServerEpochKeysThroughDirectory(t *testing.T) {
+	directory := startFakeDirectory(t)
+
+	m := createLoopTestMixServer(t, 1)
+	m.cfg.MixNode.DirectoryServer = directory
+	clock := node.NewManualClock(time.Unix(0, 0).Add(1000*time.Hour + 59*time.Minute))
+	keys, err := node.NewKeyRing(time.Hour, 5*time.Minute, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.UseKeyRing(keys)
+	assert.Nil(t, m.RefreshPresence())
+
+	// the other mixes and the provider do not rotate their keys
+	route := []*node.Mix{nil, m.Mix}
+	for i, layer := range []int{2, 3} {
+		priv, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		route = append(route, node.NewMix(priv, pub))
+		host := net.JoinHostPort("localhost", strconv.Itoa(9990+i))
+		assert.Nil(t, helpers.RegisterMixNodePresenceAt(directory, b64Key(pub), layer, host))
+	}
+	providerPriv, providerPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	route[0] = node.NewMix(providerPriv, providerPub)
+	route = append(route, route[0])
+	assert.Nil(t, helpers.RegisterMixProviderPresenceAt(directory, b64Key(providerPub), nil, "localhost:9998"))
+
+	// newClient creates the client with the current topology of the network
+	newClient := func() (*clientcore.CryptoClient, config.ClientConfig) {
+		topologyData, err := topology.GetNetworkTopology(directory + config.DirectoryServerTopologyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mixes, err := topology.GetMixesPKI(topologyData.MixNodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the two presences of the mix node count as a single node, identified by its long-term key
+		assert.Len(t, mixes[1], 1)
+		assert.Equal(t, m.GetPublicKey().Bytes(), mixes[1][0].PubKey)
+		provider, err := topology.ProviderPresenceToConfig(topologyData.MixProviderNodes[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientPriv, clientPub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipient := config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "9999", Provider: &provider}
+		network := clientcore.NetworkPKI{Mixes: mixes, EpochKeys: topology.GetEpochKeys(topologyData)}
+		return clientcore.NewCryptoClient(clientPriv, clientPub, provider, network, m.log), recipient
+	}
+	createPacket := func(c *clientcore.CryptoClient, recipient config.ClientConfig) []byte {
+		packet, err := c.EncodeMessage([]byte("Hello world"), recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packet
+	}
+	process := func(packet []byte) error {
+		for _, mix := range route {
+			res := mix.ProcessPacket(packet)
+			if res.Err() != nil {
+				return res.Err()
+			}
+			packet = res.PacketData()
+		}
+		return nil
+	}
+
+	// the packets are created for one of the keys the mix node announces
+	c, recipient := newClient()
+	assert.Nil(t, process(createPacket(c, recipient)))
+	delayed := createPacket(c, recipient)
+
+	// the packets created before the epoch ends are still accepted after it ends
+	clock.Advance(2 * time.Minute)
+	assert.Nil(t, m.RefreshPresence())
+	assert.Nil(t, process(delayed))
+	c, recipient = newClient()
+	assert.Nil(t, process(createPacket(c, recipient)))
+
+	// the clients reading the topology after the grace period keep creating the packets the mix node accepts
+	clock.Advance(5 * time.Minute)
+	assert.Nil(t, m.RefreshPresence())
+	c, recipient = newClient()
+	assert.Nil(t, process(createPacket(c, recipient)))
+}
+
+func TestMixServerRefusesUnknownPeers(t *testing.T) {
+	directory := startFakeDirectory(t)
+	m := createLoopTestMixServer(t, 1)
+	m.cfg.MixNode.DirectoryServer = directory
+
+	peerPriv, peerPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, helpers.RegisterMixNodePresenceAt(directory, b64Key(peerPub), 2, "localhost:9997"))
+	unknownPriv, _, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// connect completes the handshake with the given static key and returns the result of handling the connection
+	connect := func(priv *sphinx.PrivateKey) error {
+		errCh := make(chan error, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			errCh <- m.handleConnection(conn)
+		}()
+
+		rawConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rawConn.Close()
+		id, err := networker.NewIdentity(priv.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sconn, err := networker.ClientHandshake(rawConn, id, m.GetPublicKey().Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, sconn.CloseWrite())
+		return <-errCh
+	}
+
+	assert.Equal(t, errUnknownPeer, connect(unknownPriv))
+	assert.Nil(t, connect(peerPriv))
+}
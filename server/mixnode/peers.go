@@ -0,0 +1,177 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixnode
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+	"github.com/nymtech/nym-mixnet/networker"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+// The mix node only accepts the connections of the nodes of the network, which authenticate themselves
+// in the handshake with the keys announced in their presence: their long-term keys or, if they rotate their keys,
+// the keys of their current epoch.
+// Likewise, it only forwards the packets to the nodes of the network, at the addresses announced with their keys,
+// as the next hops are chosen by the senders of the packets.
+const (
+	// peersUpdateInterval defines how often the keys of the nodes of the network are read from the topology.
+	peersUpdateInterval = 30 * time.Second
+	// peersMinRefreshInterval defines the minimum time between the reads of the topology prompted
+	// by the unknown keys, so that the unknown peers could not make the node flood the directory server.
+	peersMinRefreshInterval = time.Second
+)
+
+var (
+	// errUnknownPeer is returned when the peer authenticates itself with the key of none of the nodes of the network.
+	errUnknownPeer = errors.New("the key of the peer does not belong to any node of the network")
+	// errUnknownNextHop is returned when the next hop of the packet is not a node of the network.
+	errUnknownNextHop = errors.New("the next hop is not a node of the network")
+)
+
+// knownPeers holds the keys announced by the nodes of the network together with their addresses. It starts empty
+// and is filled by the first read of the topology, so the mix node starts even if the directory server cannot be
+// reached yet.
+type knownPeers struct {
+	sync.Mutex
+	// keys maps the base64 encoded keys to the addresses in the format of the addresses of the sphinx hops
+	keys map[string]string
+	// readAt is the time the last read of the keys started at
+	readAt time.Time
+}
+
+func newKnownPeers() *knownPeers {
+	return &knownPeers{keys: make(map[string]string)}
+}
+
+// hopAddress converts the host announced in the presence into the address of the sphinx hop,
+// which the clients build from the host and the port of the node.
+func hopAddress(host string) string {
+	h, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	return h + ":" + port
+}
+
+// update replaces the known keys with the keys of the mix nodes and the providers of the given topology.
+// The presence announcing an epoch key makes both the epoch key and the long-term key of the node known.
+func (p *knownPeers) update(topologyData *models.Topology) {
+	keys := make(map[string]string, len(topologyData.MixNodes)+len(topologyData.MixProviderNodes))
+	add := func(pubKey, host string) {
+		if info, ok := topology.ParsePresenceKey(pubKey); ok {
+			keys[info.Identity] = hopAddress(host)
+			keys[info.PubKey] = hopAddress(host)
+			return
+		}
+		keys[pubKey] = hopAddress(host)
+	}
+	for _, mix := range topologyData.MixNodes {
+		add(mix.PubKey, mix.Host)
+	}
+	for _, provider := range topologyData.MixProviderNodes {
+		add(provider.PubKey, provider.Host)
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.keys = keys
+}
+
+// address returns the address announced with the key, if the key is known.
+func (p *knownPeers) address(key []byte) (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+	address, ok := p.keys[base64.URLEncoding.EncodeToString(key)]
+	return address, ok
+}
+
+// due tells whether the last read of the keys started at least the given interval before the given time.
+// If it did, the new read is recorded as started at the given time, so that only one of the concurrent callers
+// reads them.
+func (p *knownPeers) due(now time.Time, interval time.Duration) bool {
+	p.Lock()
+	defer p.Unlock()
+	if now.Sub(p.readAt) < interval {
+		return false
+	}
+	p.readAt = now
+	return true
+}
+
+// updatePeers reads the keys of the nodes of the network from the topology.
+func (m *MixServer) updatePeers() error {
+	topologyData, err := topology.GetNetworkTopology(m.cfg.MixNode.DirectoryServer + config.DirectoryServerTopologyPath)
+	if err != nil {
+		return err
+	}
+	m.peers.update(topologyData)
+	return nil
+}
+
+// peerAddress returns the address of the node of the network the key belongs to. If the key is not known,
+// the topology is read again, unless it was read very recently, as the node might have only just announced it.
+func (m *MixServer) peerAddress(key []byte) (string, bool) {
+	if address, ok := m.peers.address(key); ok {
+		return address, true
+	}
+	if !m.peers.due(time.Now(), peersMinRefreshInterval) {
+		return "", false
+	}
+	if err := m.updatePeers(); err != nil {
+		m.log.Errorf("Failed to obtain the topology to check the key of the peer: %v", err)
+		return "", false
+	}
+	return m.peers.address(key)
+}
+
+// knownPeer tells whether the key belongs to any of the nodes of the network.
+func (m *MixServer) knownPeer(key []byte) bool {
+	_, ok := m.peerAddress(key)
+	return ok
+}
+
+// knownNextHop tells whether the hop is a node of the network, announced with the key and the address of the hop.
+func (m *MixServer) knownNextHop(hop sphinx.Hop) bool {
+	address, ok := m.peerAddress(hop.PubKey)
+	return ok && address == hop.Address
+}
+
+// useCurrentKeyIdentity makes the mix node rotating its keys authenticate itself to its peers with the key
+// of the current epoch, since its long-term key is not announced. The identity of the previous epoch is erased.
+func (m *MixServer) useCurrentKeyIdentity() error {
+	var id *networker.Identity
+	var err error
+	m.KeyRing().PrivateKeys(func(keys []*sphinx.PrivateKey) {
+		if len(keys) == 0 {
+			err = errors.New("no key is currently accepted")
+			return
+		}
+		id, err = networker.NewIdentity(keys[0].Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	if previous := m.links.SetIdentity(id); previous != nil && previous != m.identity {
+		previous.Erase()
+	}
+	return nil
+}
@@ -17,17 +17,18 @@
 package provider
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"time"
 
-	"github.com/golang/protobuf/proto"
-	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/flags"
-	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/networker"
+	"github.com/nymtech/nym-mixnet/sphinx"
 )
 
 const (
@@ -48,14 +49,12 @@ type BenchProvider struct {
 }
 
 func (p *BenchProvider) startSendingPresence() {
-	ticker := time.NewTicker(presenceInterval)
+	ticker := time.NewTicker(p.cfg.Debug.PresenceIntervalDuration())
 	for {
 		select {
 		case <-ticker.C:
-			if err := helpers.RegisterMixProviderPresence(p.GetPublicKey(),
-				p.convertRecordsToModelData(),
-				net.JoinHostPort(p.host, p.port),
-			); err != nil {
+			p.updateKeys()
+			if err := p.announcePresence(); err != nil {
 				p.log.Errorf("Failed to register presence: %v", err)
 			}
 		case <-p.haltedCh:
@@ -131,14 +130,20 @@ func (p *BenchProvider) receivedPacket(packet []byte) error {
 	res := p.ProcessPacket(packet)
 	dePacket := res.PacketData()
 	nextHop := res.NextHop()
-	flag := res.Flag()
+	commands := res.Commands()
 	if err := res.Err(); err != nil {
 		return err
 	}
+	// the benchmark receives the packets one by one, so the delay is simply executed in place
+	time.Sleep(res.Delay())
 
-	if flag == flags.LastHopFlag {
+	if _, ok := commands.Action().(sphinx.DeliverCommand); ok {
 		if nextHop.Id == "BenchmarkClientRecipient" {
-			msgContent := string(dePacket[38:])
+			msg, err := sphinx.ExtractMessage(dePacket)
+			if err != nil {
+				return err
+			}
+			msgContent := string(msg)
 			p.receivedMessages = append(p.receivedMessages, timestampedMessage{timestamp: time.Now(), content: msgContent})
 			p.receivedMessagesCount++
 			if p.receivedMessagesCount == p.numMessages {
@@ -172,8 +177,8 @@ func (p *BenchProvider) listenForIncomingConnections() {
 	}
 }
 
-// HandleConnection handles the received packets; it checks the flag of the
-// packet and schedules a corresponding process function and returns an error.
+// HandleConnection performs the handshake and reads the frames from the connection until it is closed;
+// it checks the flag of every frame and schedules a corresponding process function.
 func (p *BenchProvider) handleConnection(conn net.Conn) {
 	defer func() {
 		p.log.Debugf("Closing Connection to %v", conn.RemoteAddr())
@@ -182,27 +187,40 @@ func (p *BenchProvider) handleConnection(conn net.Conn) {
 		}
 	}()
 
-	buff := make([]byte, 1024)
-	reqLen, err := conn.Read(buff)
+	ids, err := p.handshakeIdentities()
 	if err != nil {
-		p.log.Errorf("Error while reading from the connection: %v", err)
+		p.log.Errorf("Failed to prepare the handshake with %v: %v", conn.RemoteAddr(), err)
 		return
 	}
-
-	var packet config.GeneralPacket
-	if err = proto.Unmarshal(buff[:reqLen], &packet); err != nil {
-		p.log.Errorf("Error while unmarshalling received packet: %v", err)
+	sconn, err := networker.ServerHandshake(conn, ids...)
+	eraseIdentities(ids[1:])
+	if err != nil {
+		p.log.Errorf("Handshake with %v failed: %v", conn.RemoteAddr(), err)
 		return
 	}
 
-	switch flags.PacketTypeFlagFromBytes(packet.Flag) {
+	r := bufio.NewReader(sconn)
+	for {
+		frame, err := networker.ReadFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				p.log.Errorf("Error while reading from the connection: %v", err)
+			}
+			return
+		}
+		p.handleFrame(frame)
+	}
+}
+
+func (p *BenchProvider) handleFrame(frame networker.Frame) {
+	switch frame.Flag {
 	case flags.CommFlag:
-		if err := p.receivedPacket(packet.Data); err != nil {
+		if err := p.receivedPacket(frame.Body); err != nil {
 			panic(err)
 		}
 
 	default:
-		fmt.Fprintf(os.Stderr, "%v", string(packet.Data))
+		fmt.Fprintf(os.Stderr, "%v", string(frame.Body))
 		panic(errors.New("unknown packet received - can't have those during benchmark"))
 	}
 }
@@ -16,10 +16,12 @@
 package provider
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -35,21 +37,13 @@ import (
 	"github.com/nymtech/nym-mixnet/logger"
 	"github.com/nymtech/nym-mixnet/networker"
 	"github.com/nymtech/nym-mixnet/node"
+	"github.com/nymtech/nym-mixnet/server/admin"
+	serverConfig "github.com/nymtech/nym-mixnet/server/config"
+	"github.com/nymtech/nym-mixnet/server/metrics"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	presenceInterval = 2 * time.Second
-
-	// Below should be moved to a config file once we have it
-	// logFileLocation can either point to some valid file to which all log data should be written
-	// or if left an empty string, stdout will be used instead
-	defaultLogFileLocation = ""
-	// considering we are under heavy development and nowhere near production level, log EVERYTHING
-	defaultLogLevel = "trace"
-)
-
 // ProviderIt is the interface of a given Provider mix server
 type ProviderIt interface {
 	networker.NetworkServer
@@ -65,11 +59,30 @@ type ProviderServer struct {
 	host            string
 	port            string
 	listener        net.Listener
+	identity        *networker.Identity
+	links           *networker.LinkManager
+	clientsMu       sync.RWMutex
 	assignedClients map[string]ClientRecord
 	config          config.MixConfig
+	cfg             *serverConfig.Config
+	inboxDirectory  string
+	scheduler       *node.Scheduler
+	drainCh         chan struct{}
+	drainOnce       sync.Once
 	haltedCh        chan struct{}
 	haltOnce        sync.Once
+	startedAt       time.Time
 	log             *logrus.Logger
+
+	baseLogger  *logger.Logger
+	adminServer *admin.Server
+
+	registry      *metrics.Registry
+	packets       *metrics.PacketMetrics
+	registrations *metrics.Counter
+	stored        *metrics.Counter
+	pulled        *metrics.Counter
+	metricsServer *metrics.Server
 }
 
 // ClientRecord holds identity and network data for clients.
@@ -91,6 +104,44 @@ func (p *ProviderServer) Shutdown() {
 	p.haltOnce.Do(func() { p.halt() })
 }
 
+// Drain gracefully shuts down the provider. It stops announcing its presence, so that the directory server drops it
+// once its last presence expires, as the directory server cannot remove it explicitly. It also stops accepting
+// new connections and packets. The packets already received are still forwarded or stored once their delays elapse,
+// for at most the given timeout, after which the provider shuts down. Only the first call has any effect.
+func (p *ProviderServer) Drain(timeout time.Duration) {
+	started := false
+	p.drainOnce.Do(func() {
+		started = true
+		close(p.drainCh)
+	})
+	if !started {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	p.log.Infof("%s: Draining before the shutdown (timeout: %v)", p.id, timeout)
+	if err := p.listener.Close(); err != nil {
+		p.log.Warnf("Failed to close the listener: %v", err)
+	}
+	if dropped := p.scheduler.Drain(timeout); dropped > 0 {
+		p.log.Warnf("%s: %d delayed packets dropped, as the drain timed out", p.id, dropped)
+	}
+	if unsent := p.links.Flush(time.Until(deadline)); unsent > 0 {
+		p.log.Warnf("%s: %d packets not sent to the next hops, as the drain timed out", p.id, unsent)
+	}
+	p.Shutdown()
+}
+
+// Draining tells whether the provider is being drained before shutting down.
+func (p *ProviderServer) Draining() bool {
+	select {
+	case <-p.drainCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // calls any required cleanup code
 func (p *ProviderServer) halt() {
 	p.log.Info("Starting graceful shutdown")
@@ -98,6 +149,18 @@ func (p *ProviderServer) halt() {
 	// possibly send "remove presence" message
 
 	close(p.haltedCh)
+	p.scheduler.Stop()
+	p.links.Close()
+	if p.metricsServer != nil {
+		if err := p.metricsServer.Close(); err != nil {
+			p.log.Warnf("Failed to close the metrics server: %v", err)
+		}
+	}
+	if p.adminServer != nil {
+		if err := p.adminServer.Close(); err != nil {
+			p.log.Warnf("Failed to close the admin server: %v", err)
+		}
+	}
 }
 
 // Start creates loggers for capturing info and error logs
@@ -119,6 +182,13 @@ func (p *ProviderServer) run() {
 
 	defer p.listener.Close()
 
+	p.scheduler.Start()
+	if p.metricsServer != nil {
+		p.metricsServer.Start()
+	}
+	if p.adminServer != nil {
+		p.adminServer.Start()
+	}
 	go func() {
 		p.log.Infof("Listening on %s", p.host+":"+p.port)
 		p.listenForIncomingConnections()
@@ -130,6 +200,8 @@ func (p *ProviderServer) run() {
 }
 
 func (p *ProviderServer) convertRecordsToModelData() []models.RegisteredClient {
+	p.clientsMu.RLock()
+	defer p.clientsMu.RUnlock()
 	registeredClients := make([]models.RegisteredClient, 0, len(p.assignedClients))
 	for _, entry := range p.assignedClients {
 		registeredClients = append(registeredClients, models.RegisteredClient{
@@ -139,88 +211,269 @@ func (p *ProviderServer) convertRecordsToModelData() []models.RegisteredClient {
 	return registeredClients
 }
 
+// startSendingPresence announces the presence of the provider. If it rotates its keys,
+// they are updated right before every announcement.
 func (p *ProviderServer) startSendingPresence() {
-	ticker := time.NewTicker(presenceInterval)
+	ticker := time.NewTicker(p.cfg.Debug.PresenceIntervalDuration())
 	for {
 		select {
 		case <-ticker.C:
-			if err := helpers.RegisterMixProviderPresence(p.GetPublicKey(),
-				p.convertRecordsToModelData(),
-				net.JoinHostPort(p.host, p.port),
-			); err != nil {
+			p.updateKeys()
+			if err := p.announcePresence(); err != nil {
 				p.log.Errorf("Failed to register presence: %v", err)
 			}
+		case <-p.drainCh:
+			ticker.Stop()
+			return
 		case <-p.haltedCh:
 			return
 		}
 	}
 }
 
-// Function processes the received sphinx packet, performs the
-// unwrapping operation and checks whether the packet should be
-// forwarded or stored. If the processing was unsuccessful and error is returned.
-func (p *ProviderServer) receivedPacket(packet []byte) error {
-	p.log.Infof("%s: Received new sphinx packet", p.id)
-
-	// process in goroutine so we wouldn't block while executing the required delay
-	go func(packet []byte) {
-		res := p.ProcessPacket(packet)
-		dePacket := res.PacketData()
-		nextHop := res.NextHop()
-		flag := res.Flag()
-		if err := res.Err(); err != nil {
-			p.log.Errorf("error while processing packet: %v", err)
+// announcePresence announces a presence for every key the provider announces, each with its registered clients.
+// The provider rotating its keys announces the keys of the current and the next epoch as two presences
+// with the same host, each carrying its long-term key, by which the clients choose their provider.
+func (p *ProviderServer) announcePresence() error {
+	clients := p.convertRecordsToModelData()
+	for _, key := range helpers.PresenceKeys(p.GetPublicKey(), p.AnnouncedKeys()) {
+		if err := helpers.RegisterMixProviderPresenceAt(p.cfg.Provider.DirectoryServer,
+			key,
+			clients,
+			net.JoinHostPort(p.config.Host, p.config.Port),
+		); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		switch flag {
-		case flags.RelayFlag:
-			if err := p.forwardPacket(dePacket, nextHop.Address); err != nil {
-				p.log.Errorf("error while forwarding packet: %v", err)
-			}
-		case flags.LastHopFlag:
-			tmpMsgID := fmt.Sprintf("TMP_MESSAGE_%v", helpers.RandomString(8))
-			if err := p.storeMessage(dePacket, nextHop.Id, tmpMsgID); err != nil {
-				p.log.Errorf("error while storing packet: %v", err)
+func (p *ProviderServer) updateKeys() {
+	changed, err := p.UpdateKeys()
+	if err != nil {
+		p.log.Errorf("Failed to update the keys: %v", err)
+	} else if changed {
+		p.log.Infof("%s: New key epoch started", p.id)
+	}
+}
+
+// handshakeIdentities returns the identities the provider can be reached with: its long-term one, which the clients
+// know, and the ones of the sphinx keys it currently accepts, which the other nodes know from the sphinx packets.
+// All but the long-term identity have to be erased after the handshake.
+func (p *ProviderServer) handshakeIdentities() ([]*networker.Identity, error) {
+	ids := []*networker.Identity{p.identity}
+	if p.KeyRing() == nil {
+		return ids, nil
+	}
+	var err error
+	p.KeyRing().PrivateKeys(func(keys []*sphinx.PrivateKey) {
+		for _, key := range keys {
+			var id *networker.Identity
+			if id, err = networker.NewIdentity(key.Bytes()); err != nil {
+				return
 			}
-		default:
-			p.log.Info("Sphinx packet flag not recognised")
+			ids = append(ids, id)
 		}
-	}(packet)
+	})
+	if err != nil {
+		eraseIdentities(ids[1:])
+		return nil, err
+	}
+	return ids, nil
+}
 
-	return nil
+func eraseIdentities(ids []*networker.Identity) {
+	for _, id := range ids {
+		id.Erase()
+	}
+}
+
+// Function schedules the received sphinx packet for the unwrapping operation,
+// after which handleProcessedPacket checks whether the packet should be
+// forwarded or stored. If the packet could not be scheduled an error is returned.
+func (p *ProviderServer) receivedPacket(packet []byte) error {
+	p.log.Infof("%s: Received new sphinx packet", p.id)
+	p.packets.Received.Inc()
+
+	// the scheduler processes and delays the packet so we wouldn't block while executing the required delay
+	err := p.scheduler.Schedule(packet)
+	p.packets.Scheduled(err)
+	return err
 }
 
-func (p *ProviderServer) forwardPacket(sphinxPacket []byte, address string) error {
-	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
+// relayReply relays the reply packet, sent by the client using a single-use reply block, to the first hop
+// of the reply block. The provider cannot process the packet, as its header is meant for the first hop,
+// so it only passes it on, in the same way the client would otherwise have to do itself, revealing its address.
+func (p *ProviderServer) relayReply(frame networker.Frame) error {
+	firstHop, packet, err := networker.DecodeReplyFrame(frame)
 	if err != nil {
 		return err
 	}
-	p.log.Infof("%s: Going to forward the sphinx packet", p.id)
-	err = p.send(packetBytes, address)
-	if err != nil {
+	p.log.Infof("%s: Received new reply packet", p.id)
+	p.packets.Received.Inc()
+	if p.Draining() {
+		p.packets.Dropped.With(metrics.DropDraining).Inc()
+		return node.ErrSchedulerDraining
+	}
+	if err := p.forwardPacket(packet, firstHop); err != nil {
+		p.packets.Dropped.With(metrics.DropForwardFailed).Inc()
 		return err
 	}
-	p.log.Infof("%s: Forwarded sphinx packet", p.id)
+	p.packets.Forwarded.Inc()
 	return nil
 }
 
-// Function opens a connection with selected network address
-// and send the passed packet. If connection failed or
-// the packet could not be send, an error is returned
-func (p *ProviderServer) send(packet []byte, address string) error {
-	p.log.Debugf("%s: Dialling", p.id)
-	conn, err := net.Dial("tcp", address)
+// handleProcessedPacket acts on the packet released by the scheduler once its delay elapsed,
+// or straight away if its processing failed.
+func (p *ProviderServer) handleProcessedPacket(res *node.PacketProcessingResult) {
+	dePacket := res.PacketData()
+	nextHop := res.NextHop()
+	commands := res.Commands()
+	p.packets.Processed(res)
+	if err := res.Err(); err != nil {
+		if err == sphinx.ErrReplayedPacket {
+			p.log.Warnf("%s: Replayed packet detected. Packet dropped (total replays: %v)",
+				p.id,
+				p.ReplayedPackets(),
+			)
+		} else if err == sphinx.ErrUnsupportedVersion {
+			p.log.Warnf("%s: Packet version not accepted (accepted: %v). Packet dropped (total rejected: %v)",
+				p.id,
+				sphinx.FormatVersions(p.AcceptedVersions()),
+				p.UnsupportedVersionPackets(),
+			)
+		} else {
+			p.log.Errorf("error while processing packet: %v", err)
+		}
+		return
+	}
+
+	switch commands.Action().(type) {
+	case sphinx.RelayCommand:
+		if err := p.forwardPacket(dePacket, nextHop); err != nil {
+			p.packets.Dropped.With(metrics.DropForwardFailed).Inc()
+			p.log.Errorf("error while forwarding packet: %v", err)
+			return
+		}
+		p.packets.Forwarded.Inc()
+	case sphinx.DeliverCommand, sphinx.DeliverSURBAckCommand:
+		tmpMsgID := fmt.Sprintf("TMP_MESSAGE_%v", helpers.RandomString(8))
+		if err := p.storeMessage(dePacket, nextHop.Id, tmpMsgID); err != nil {
+			p.packets.Dropped.With(metrics.DropStoreFailed).Inc()
+			p.log.Errorf("error while storing packet: %v", err)
+		}
+	case sphinx.DropCommand:
+		p.packets.Dropped.With(metrics.DropCover).Inc()
+		p.log.Debugf("%s: Cover packet dropped", p.id)
+	default:
+		p.packets.Dropped.With(metrics.DropUnknownCommand).Inc()
+		p.log.Infof("Sphinx packet commands %v not recognised. Packet dropped", commands)
+	}
+}
+
+// QueueDepth returns the number of the processed packets waiting for their delays to elapse.
+func (p *ProviderServer) QueueDepth() int {
+	return p.scheduler.QueueDepth()
+}
+
+// Status returns the current status of the provider reported over the admin API.
+func (p *ProviderServer) Status() admin.Status {
+	clients := p.registeredClients()
+	return admin.Status{Type: "provider",
+		ID:                p.id,
+		PublicKey:         base64.URLEncoding.EncodeToString(p.GetPublicKey().Bytes()),
+		Address:           net.JoinHostPort(p.config.Host, p.config.Port),
+		LogLevel:          p.baseLogger.Level(),
+		StartedAt:         p.startedAt,
+		Uptime:            time.Since(p.startedAt).Seconds(),
+		Draining:          p.Draining(),
+		QueueDepth:        p.QueueDepth(),
+		Peers:             admin.Peers(p.links.Links()),
+		RegisteredClients: &clients,
+	}
+}
+
+// SetLogLevel changes the level of all the loggers of the provider.
+func (p *ProviderServer) SetLogLevel(level string) error {
+	return p.baseLogger.SetLevel(level)
+}
+
+// DrainTimeout returns the drain timeout the provider is configured with.
+func (p *ProviderServer) DrainTimeout() time.Duration {
+	return p.cfg.Debug.DrainTimeoutDuration()
+}
+
+// RefreshPresence announces the presence of the provider, together with its registered clients,
+// to the directory server straight away. The draining provider no longer announces its presence.
+func (p *ProviderServer) RefreshPresence() error {
+	if p.Draining() {
+		return errors.New("the provider is draining")
+	}
+	p.updateKeys()
+	return p.announcePresence()
+}
+
+// registerMetrics creates the local metrics of the provider, which are served over HTTP
+// if the metrics address is configured.
+func (p *ProviderServer) registerMetrics() {
+	p.registry = metrics.NewRegistry()
+	p.packets = metrics.NewPacketMetrics(p.registry, p)
+	p.registrations = p.registry.NewCounter("nym_provider_registrations_total",
+		"Number of the registration requests of the clients handled.",
+	)
+	p.registry.NewGaugeFunc("nym_provider_registered_clients", "Number of the clients registered with the provider.",
+		func() float64 { return float64(p.registeredClients()) },
+	)
+	p.stored = p.registry.NewCounter("nym_provider_messages_stored_total",
+		"Number of the messages stored in the inboxes of the clients.",
+	)
+	p.pulled = p.registry.NewCounter("nym_provider_messages_pulled_total",
+		"Number of the messages pulled by the clients from their inboxes.",
+	)
+	p.registry.NewGaugeFunc("nym_provider_inbox_messages", "Number of the messages waiting in the inboxes.",
+		func() float64 { return float64(p.inboxMessages()) },
+	)
+}
+
+// Metrics writes the local metrics of the provider in the Prometheus text format.
+func (p *ProviderServer) Metrics(w io.Writer) error {
+	_, err := p.registry.WriteTo(w)
+	return err
+}
+
+func (p *ProviderServer) registeredClients() int {
+	p.clientsMu.RLock()
+	defer p.clientsMu.RUnlock()
+	return len(p.assignedClients)
+}
+
+// inboxMessages counts the messages in all the inboxes. The inboxes which cannot be read are skipped.
+func (p *ProviderServer) inboxMessages() int {
+	inboxes, err := ioutil.ReadDir(p.inboxDirectory)
 	if err != nil {
-		return err
+		return 0
 	}
-	defer conn.Close()
-	p.log.Debugf("%s: Writing", p.id)
+	count := 0
+	for _, inbox := range inboxes {
+		if !inbox.IsDir() {
+			continue
+		}
+		messages, err := ioutil.ReadDir(filepath.Join(p.inboxDirectory, inbox.Name()))
+		if err != nil {
+			continue
+		}
+		count += len(messages)
+	}
+	return count
+}
 
-	if _, err := conn.Write(packet); err != nil {
+func (p *ProviderServer) forwardPacket(sphinxPacket []byte, nextHop sphinx.Hop) error {
+	p.log.Infof("%s: Going to forward the sphinx packet", p.id)
+	// the packet is sent over the long-lived link to the next hop, which is shared with all the other packets
+	if err := p.links.Send(nextHop.Address, nextHop.PubKey, flags.CommFlag, sphinxPacket); err != nil {
 		return err
 	}
-	p.log.Debugf("%s: Returning", p.id)
-
+	p.log.Infof("%s: Forwarded sphinx packet", p.id)
 	return nil
 }
 
@@ -233,6 +486,10 @@ func (p *ProviderServer) listenForIncomingConnections() {
 	for {
 		conn, err := p.listener.Accept()
 		if err != nil {
+			// the listener is closed when the provider is drained or shut down
+			if p.Draining() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			p.log.Errorf("Error when listening for incoming connection: %v", err)
 		} else {
 			p.log.Infof("Received connection from %s", conn.RemoteAddr())
@@ -250,20 +507,16 @@ func (p *ProviderServer) replyToClient(data []byte, conn net.Conn) {
 	}
 }
 
+// createClientResponse encodes the response carrying the given packets into a frame,
+// so the client checks its version and length like those of any other frame.
 func (p *ProviderServer) createClientResponse(marshalledPackets ...[]byte) ([]byte, error) {
-	response := &config.ProviderResponse{
-		NumberOfPackets: uint64(len(marshalledPackets)),
-		Packets:         marshalledPackets,
-	}
-	mBytes, err := proto.Marshal(response)
-	if err != nil {
-		return nil, err
-	}
-	return mBytes, nil
+	return networker.EncodeProviderResponse(marshalledPackets...)
 }
 
-// HandleConnection handles the received packets; it checks the flag of the
-// packet and schedules a corresponding process function and returns an error.
+// HandleConnection performs the handshake and reads the frames from the connection until it is closed.
+// It checks the flag of every frame and schedules a corresponding process function. The links of the other nodes
+// carry many sphinx packets, while the clients, which remain anonymous in the handshake,
+// send a single request per connection and wait for the reply.
 func (p *ProviderServer) handleConnection(conn net.Conn) {
 	defer func() {
 		p.log.Debugf("Closing Connection to %v", conn.RemoteAddr())
@@ -272,22 +525,36 @@ func (p *ProviderServer) handleConnection(conn net.Conn) {
 		}
 	}()
 
-	buff := make([]byte, 2048)
-	reqLen, err := conn.Read(buff)
+	ids, err := p.handshakeIdentities()
 	if err != nil {
-		p.log.Errorf("Error while reading from the connection: %v", err)
+		p.log.Errorf("Failed to prepare the handshake with %v: %v", conn.RemoteAddr(), err)
 		return
 	}
-
-	var packet config.GeneralPacket
-	if err = proto.Unmarshal(buff[:reqLen], &packet); err != nil {
-		p.log.Errorf("Error while unmarshalling received packet: %v", err)
+	sconn, err := networker.ServerHandshake(conn, ids...)
+	eraseIdentities(ids[1:])
+	if err != nil {
+		p.log.Errorf("Handshake with %v failed: %v", conn.RemoteAddr(), err)
 		return
 	}
 
-	switch flags.PacketTypeFlagFromBytes(packet.Flag) {
+	r := bufio.NewReader(sconn)
+	for {
+		frame, err := networker.ReadFrame(r)
+		if err != nil {
+			// after any error other than the end of the stream, the rest of it can no longer be split into frames
+			if err != io.EOF {
+				p.log.Errorf("Error while reading from the connection: %v", err)
+			}
+			return
+		}
+		p.handleFrame(frame, sconn)
+	}
+}
+
+func (p *ProviderServer) handleFrame(frame networker.Frame, conn net.Conn) {
+	switch frame.Flag {
 	case flags.AssignFlag:
-		tokenBytes, err := p.handleAssignRequest(packet.Data)
+		tokenBytes, err := p.handleAssignRequest(frame.Body)
 		if err != nil {
 			p.log.Errorf("Error while handling token request: %v", err)
 			return
@@ -300,13 +567,19 @@ func (p *ProviderServer) handleConnection(conn net.Conn) {
 		p.replyToClient(clientResponse, conn)
 
 	case flags.CommFlag:
-		if err := p.receivedPacket(packet.Data); err != nil {
+		if err := p.receivedPacket(frame.Body); err != nil {
 			p.log.Errorf("Error while handling received packet: %v", err)
 			return
 		}
 
+	case flags.ReplyFlag:
+		if err := p.relayReply(frame); err != nil {
+			p.log.Errorf("Error while relaying reply packet: %v", err)
+			return
+		}
+
 	case flags.PullFlag:
-		messagesBytes, err := p.handlePullRequest(packet.Data)
+		messagesBytes, err := p.handlePullRequest(frame.Body)
 		if err != nil {
 			p.log.Errorf("Error while handling pull request: %v", err)
 			return
@@ -320,7 +593,7 @@ func (p *ProviderServer) handleConnection(conn net.Conn) {
 		p.replyToClient(clientResponse, conn)
 
 	default:
-		p.log.Info(packet.Flag)
+		p.log.Info(frame.Flag)
 		p.log.Info("Packet flag not recognised. Packet dropped")
 
 	}
@@ -348,9 +621,12 @@ func (p *ProviderServer) registerNewClient(clientBytes []byte) ([]byte, error) {
 		pubKey: clientConf.PubKey,
 		token:  token,
 	}
+	p.clientsMu.Lock()
 	p.assignedClients[clientID] = record
+	p.clientsMu.Unlock()
+	p.registrations.Inc()
 
-	path := fmt.Sprintf("./inboxes/%s", clientID)
+	path := filepath.Join(p.inboxDirectory, clientID)
 	exists, err := helpers.DirExists(path)
 	if err != nil {
 		return nil, err
@@ -375,7 +651,7 @@ func (p *ProviderServer) handleAssignRequest(packet []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return config.WrapWithFlag(flags.TokenFlag, token)
+	return networker.EncodeFrame(flags.TokenFlag, token)
 }
 
 // Function is responsible for handling the pull request received from the client.
@@ -417,12 +693,15 @@ func (p *ProviderServer) handlePullRequest(rqsBytes []byte) ([][]byte, error) {
 func (p *ProviderServer) authenticateUser(clientKey, clientToken []byte) bool {
 
 	clientID := base64.URLEncoding.EncodeToString(clientKey)
-	if bytes.Equal(p.assignedClients[clientID].token, clientToken) &&
-		bytes.Equal(p.assignedClients[clientID].pubKey, clientKey) {
+	p.clientsMu.RLock()
+	record := p.assignedClients[clientID]
+	p.clientsMu.RUnlock()
+	if bytes.Equal(record.token, clientToken) &&
+		bytes.Equal(record.pubKey, clientKey) {
 		// && signature check on message to make sure client actually owns this ID
 		return true
 	}
-	p.log.Warnf("Non matching token: %s, %s", p.assignedClients[clientID].token, clientToken)
+	p.log.Warnf("Non matching token: %s, %s", record.token, clientToken)
 	return false
 }
 
@@ -434,7 +713,7 @@ func (p *ProviderServer) authenticateUser(clientKey, clientToken []byte) bool {
 // (SI) messages were send to the client; and an error.
 func (p *ProviderServer) fetchMessages(clientID string) (string, [][]byte, error) {
 
-	path := fmt.Sprintf("./inboxes/%s", clientID)
+	path := filepath.Join(p.inboxDirectory, clientID)
 	exist, err := helpers.DirExists(path)
 	if err != nil {
 		return "", nil, err
@@ -450,8 +729,10 @@ func (p *ProviderServer) fetchMessages(clientID string) (string, [][]byte, error
 		return "EI", nil, nil
 	}
 
-	messagesBytes := make([][]byte, len(files))
-	for i, f := range files {
+	// the messages which do not fit in a single response are left in the inbox for the next pull
+	messagesBytes := make([][]byte, 0, len(files))
+	size := 0
+	for _, f := range files {
 		fullPath := filepath.Join(path, f.Name())
 		dat, err := ioutil.ReadFile(fullPath)
 		if err != nil {
@@ -460,11 +741,15 @@ func (p *ProviderServer) fetchMessages(clientID string) (string, [][]byte, error
 
 		p.log.Infof("Found stored message for %s", clientID)
 		p.log.Infof("Messages data: %v", string(dat))
-		msgBytes, err := config.WrapWithFlag(flags.CommFlag, dat)
+		msgBytes, err := networker.EncodeFrame(flags.CommFlag, dat)
 		if err != nil {
 			return "", nil, err
 		}
-		messagesBytes[i] = msgBytes
+		if size += networker.ProviderResponseSize(msgBytes); size > networker.MaxProviderResponsePackets {
+			break
+		}
+		messagesBytes = append(messagesBytes, msgBytes)
+		p.pulled.Inc()
 
 		if err := os.Remove(fullPath); err != nil {
 			p.log.Errorf("Failed to remove %v: %v", f, err)
@@ -478,8 +763,7 @@ func (p *ProviderServer) fetchMessages(clientID string) (string, [][]byte, error
 // If the inbox address does not exist or writing into the inbox was unsuccessful
 // the function returns an error
 func (p *ProviderServer) storeMessage(message []byte, inboxID string, messageID string) error {
-	path := fmt.Sprintf("./inboxes/%s", inboxID)
-	fileName := path + "/" + messageID + ".txt"
+	fileName := filepath.Join(p.inboxDirectory, inboxID, messageID+".txt")
 
 	file, err := os.Create(fileName)
 	if err != nil {
@@ -491,6 +775,7 @@ func (p *ProviderServer) storeMessage(message []byte, inboxID string, messageID
 	if err != nil {
 		return err
 	}
+	p.stored.Inc()
 
 	p.log.Infof("Stored message for %s", inboxID)
 	p.log.Infof("Stored message content: %v", string(message))
@@ -499,39 +784,78 @@ func (p *ProviderServer) storeMessage(message []byte, inboxID string, messageID
 
 // NewProviderServer constructs a new provider object.
 // NewProviderServer returns a new provider object and an error.
-// TODO: same case as 'NewClient'
-func NewProviderServer(id string,
-	host string,
-	port string,
+// The provider only accepts packets in the given versions of the packet format.
+// The keys of the provider are expected to be already loaded from the files specified in the config.
+func NewProviderServer(cfg *serverConfig.Config,
 	prvKey *sphinx.PrivateKey,
 	pubKey *sphinx.PublicKey,
+	versions []sphinx.Version,
 ) (*ProviderServer, error) {
-	baseLogger, err := logger.New(defaultLogFileLocation, defaultLogLevel, false)
+	if cfg.Provider == nil {
+		return nil, errors.New("the config does not contain the Provider block")
+	}
+	id := cfg.Provider.ID
+	host := cfg.Provider.Host
+	port := cfg.Provider.Port
+
+	announceHost, announcePort, err := cfg.Provider.AnnounceHostPort()
+	if err != nil {
+		return nil, err
+	}
+
+	baseLogger, err := logger.New(cfg.Logging.File, cfg.Logging.Level, cfg.Logging.Disable)
 	if err != nil {
 		return nil, err
 	}
 
 	log := baseLogger.GetLogger(id)
 
-	node := node.NewMix(prvKey, pubKey)
+	mixNode, err := node.NewMixWithVersions(prvKey, pubKey, versions)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Debug.KeyEpochDuration > 0 {
+		keys, err := node.NewKeyRing(cfg.Debug.KeyEpochDurationDuration(),
+			cfg.Debug.KeyGracePeriodDuration(),
+			node.SystemClock,
+		)
+		if err != nil {
+			return nil, err
+		}
+		mixNode.UseKeyRing(keys)
+	}
 	providerServer := ProviderServer{id: id,
-		host:     host,
-		port:     port,
-		Mix:      node,
-		listener: nil,
-		haltedCh: make(chan struct{}),
-		log:      log,
+		host:           host,
+		port:           port,
+		Mix:            mixNode,
+		listener:       nil,
+		cfg:            cfg,
+		inboxDirectory: cfg.Provider.FullInboxDir(),
+		drainCh:        make(chan struct{}),
+		haltedCh:       make(chan struct{}),
+		startedAt:      time.Now(),
+		log:            log,
+		baseLogger:     baseLogger,
+	}
+	providerServer.scheduler = node.NewScheduler(mixNode, providerServer.handleProcessedPacket, node.SchedulerConfig{
+		MaxDelay: cfg.Debug.MaxPacketDelayDuration(),
+	})
+	providerServer.registerMetrics()
+	providerServer.identity, err = networker.NewIdentity(prvKey.Bytes())
+	if err != nil {
+		return nil, err
 	}
+	providerServer.links = networker.NewLinkManager(baseLogger.GetLogger("links "+id),
+		providerServer.identity,
+		networker.LinkConfig{},
+	)
 	providerServer.config = config.MixConfig{Id: providerServer.id,
-		Host:   providerServer.host,
-		Port:   providerServer.port,
+		Host:   announceHost,
+		Port:   announcePort,
 		PubKey: providerServer.GetPublicKey().Bytes()}
 	providerServer.assignedClients = make(map[string]ClientRecord)
 
-	if err := helpers.RegisterMixProviderPresence(providerServer.GetPublicKey(),
-		providerServer.convertRecordsToModelData(),
-		net.JoinHostPort(host, port),
-	); err != nil {
+	if err := providerServer.announcePresence(); err != nil {
 		return nil, err
 	}
 
@@ -541,6 +865,31 @@ func NewProviderServer(id string,
 		return nil, err
 	}
 
+	if len(cfg.Provider.MetricsAddress) > 0 {
+		providerServer.metricsServer, err = metrics.NewServer(cfg.Provider.MetricsAddress,
+			providerServer.registry,
+			baseLogger.GetLogger("metrics server "+id),
+		)
+		if err != nil {
+			providerServer.listener.Close()
+			return nil, err
+		}
+	}
+
+	if len(cfg.Provider.AdminSocket) > 0 {
+		providerServer.adminServer, err = admin.NewServer(cfg.Provider.AdminSocketFile(),
+			&providerServer,
+			baseLogger.GetLogger("admin "+id),
+		)
+		if err != nil {
+			providerServer.listener.Close()
+			if providerServer.metricsServer != nil {
+				providerServer.metricsServer.Close()
+			}
+			return nil, err
+		}
+	}
+
 	return &providerServer, nil
 }
 
@@ -549,15 +898,36 @@ func CreateTestProvider() (*ProviderServer, error) {
 	if err != nil {
 		return nil, err
 	}
-	baseDisabledLogger, err := logger.New(defaultLogFileLocation, defaultLogLevel, true)
+	cfg, err := serverConfig.DefaultProviderConfig("test")
+	if err != nil {
+		return nil, err
+	}
+	baseDisabledLogger, err := logger.New(cfg.Logging.File, cfg.Logging.Level, true)
 	if err != nil {
 		return nil, err
 	}
 	// this logger can be shared as it will be disabled anyway
 	disabledLog := baseDisabledLogger.GetLogger("test")
 
-	node := node.NewMix(priv, pub)
-	provider := ProviderServer{host: "localhost", port: "9999", Mix: node, log: disabledLog}
+	mixNode := node.NewMix(priv, pub)
+	provider := ProviderServer{host: "localhost",
+		port:           "9999",
+		Mix:            mixNode,
+		cfg:            cfg,
+		inboxDirectory: "./inboxes",
+		drainCh:        make(chan struct{}),
+		haltedCh:       make(chan struct{}),
+		startedAt:      time.Now(),
+		log:            disabledLog,
+		baseLogger:     baseDisabledLogger,
+	}
+	provider.scheduler = node.NewScheduler(mixNode, provider.handleProcessedPacket, node.SchedulerConfig{})
+	provider.registerMetrics()
+	provider.identity, err = networker.NewIdentity(priv.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	provider.links = networker.NewLinkManager(disabledLog, provider.identity, networker.LinkConfig{})
 	provider.config = config.MixConfig{Id: provider.id,
 		Host:   provider.host,
 		Port:   provider.port,
@@ -15,6 +15,7 @@
 package provider
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
@@ -22,10 +23,13 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
 	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/networker"
 	"github.com/nymtech/nym-mixnet/server/mixnode"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/stretchr/testify/assert"
@@ -154,6 +158,31 @@ func TestProviderServer_StoreMessage(t *testing.T) {
 
 }
 
+func TestProviderServer_FetchMessagesFitInResponse(t *testing.T) {
+	inboxID := "FullInbox"
+	if err := os.MkdirAll(filepath.Join("./inboxes", inboxID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Join("./inboxes", inboxID))
+	const stored = 40
+	for i := 0; i < stored; i++ {
+		message := bytes.Repeat([]byte{byte(i)}, sphinx.PayloadSize)
+		if err := providerServer.storeMessage(message, inboxID, fmt.Sprintf("%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the messages which do not fit in a single response are left for the next pull
+	_, first, err := providerServer.fetchMessages(inboxID)
+	assert.Nil(t, err)
+	assert.True(t, len(first) < stored)
+	_, err = providerServer.createClientResponse(first...)
+	assert.Nil(t, err)
+	_, rest, err := providerServer.fetchMessages(inboxID)
+	assert.Nil(t, err)
+	assert.Len(t, rest, stored-len(first))
+}
+
 func createTestPacket(t *testing.T) *sphinx.SphinxPacket {
 	path := config.E2EPath{IngressProvider: providerServer.config,
 		Mixes:          []config.MixConfig{mixServer.GetConfig()},
@@ -169,7 +198,7 @@ func createTestPacket(t *testing.T) *sphinx.SphinxPacket {
 
 func TestProviderServer_ReceivedPacket(t *testing.T) {
 	sphinxPacket := createTestPacket(t)
-	bSphinxPacket, err := proto.Marshal(sphinxPacket)
+	bSphinxPacket, err := sphinxPacket.MarshalBinary()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -178,3 +207,119 @@ func TestProviderServer_ReceivedPacket(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestProviderServer_HandleConnection_AssignRequest(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		providerServer.handleConnection(conn)
+	}()
+
+	clientConf := config.ClientConfig{Id: "Client", Host: "localhost", Port: "9999", PubKey: []byte("ClientPubKey")}
+	confBytes, err := proto.Marshal(&clientConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameBytes, err := networker.EncodeFrame(flags.AssignFlag, confBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawConn.Close()
+	// the client remains anonymous, but the provider has to prove it owns its key
+	conn, err := networker.ClientHandshake(rawConn, nil, providerServer.GetPublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the frame arrives in two parts, which the provider has to put back together
+	_, err = conn.Write(frameBytes[:networker.FrameHeaderSize+1])
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = conn.Write(frameBytes[networker.FrameHeaderSize+1:])
+	assert.Nil(t, err)
+	assert.Nil(t, conn.CloseWrite())
+
+	// the response is a frame of its own, whose version and length the client checks
+	frames, err := networker.ReadProviderResponse(conn)
+	assert.Nil(t, err)
+	assert.Len(t, frames, 1)
+	assert.Equal(t, flags.TokenFlag, frames[0].Flag)
+
+	clientID := base64.URLEncoding.EncodeToString(clientConf.PubKey)
+	assert.Equal(t, providerServer.assignedClients[clientID].token, frames[0].Body)
+}
+
+func TestProviderServer_Metrics(t *testing.T) {
+	clientConf := config.ClientConfig{Id: "MetricsClient", PubKey: []byte("MetricsClientPubKey")}
+	confBytes, err := proto.Marshal(&clientConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registrations := providerServer.registrations.Value()
+	stored := providerServer.stored.Value()
+	pulled := providerServer.pulled.Value()
+
+	_, err = providerServer.registerNewClient(confBytes)
+	assert.Nil(t, err)
+	clientID := base64.URLEncoding.EncodeToString(clientConf.PubKey)
+	assert.Nil(t, providerServer.storeMessage([]byte("Hello"), clientID, "1"))
+	assert.Nil(t, providerServer.storeMessage([]byte("world"), clientID, "2"))
+
+	var b bytes.Buffer
+	assert.Nil(t, providerServer.Metrics(&b))
+	assert.Contains(t, b.String(), fmt.Sprintf("nym_provider_registered_clients %d\n", providerServer.registeredClients()))
+	assert.Contains(t, b.String(), "nym_provider_inbox_messages")
+	assert.Contains(t, b.String(), `nym_packets_dropped_total{reason="store_failed"} 0`)
+
+	inboxMessages := providerServer.inboxMessages()
+	_, messages, err := providerServer.fetchMessages(clientID)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, inboxMessages-2, providerServer.inboxMessages())
+	assert.Equal(t, registrations+1, providerServer.registrations.Value())
+	assert.Equal(t, stored+2, providerServer.stored.Value())
+	assert.Equal(t, pulled+2, providerServer.pulled.Value())
+}
+
+func TestProviderServer_Status(t *testing.T) {
+	status := providerServer.Status()
+	assert.Equal(t, "provider", status.Type)
+	assert.Equal(t, "localhost:9999", status.Address)
+	assert.Equal(t, base64.URLEncoding.EncodeToString(providerServer.GetPublicKey().Bytes()), status.PublicKey)
+	assert.Equal(t, 0, status.Layer)
+	assert.Equal(t, providerServer.registeredClients(), *status.RegisteredClients)
+	assert.False(t, status.Draining)
+	assert.Equal(t, providerServer.QueueDepth(), status.QueueDepth)
+	assert.Equal(t, providerServer.cfg.Debug.DrainTimeoutDuration(), providerServer.DrainTimeout())
+}
+
+func TestProviderServer_RelayReply(t *testing.T) {
+	forwarded := providerServer.packets.Forwarded.Value()
+	mix := mixServer.GetConfig()
+	firstHop := sphinx.Hop{Id: mix.Id, Address: mix.Host + ":" + mix.Port, PubKey: mix.PubKey}
+	frameBytes, err := networker.EncodeReplyFrame(firstHop, []byte("reply packet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := networker.DecodeFrame(frameBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the packet is passed on to the first hop of the reply block without being processed
+	assert.Nil(t, providerServer.relayReply(frame))
+	assert.Equal(t, forwarded+1, providerServer.packets.Forwarded.Value())
+
+	assert.Equal(t, networker.ErrInvalidReplyFrame, providerServer.relayReply(networker.Frame{Flag: flags.ReplyFlag}))
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// BatchPacket is a single packet of the batch processed by ProcessSphinxPacketBatch,
+// together with the result of its processing.
+type BatchPacket struct {
+	// Packet is the encoded packet to be processed.
+	Packet []byte
+	// Out receives the processed packet. If its capacity suffices, the packet is written into its
+	// underlying array, so that the same buffers can be reused across the batches.
+	// It must not overlap with Packet.
+	Out []byte
+	// Hop is where the processed packet should be sent next.
+	Hop Hop
+	// Commands are the routing commands of the processing node.
+	Commands CommandSet
+	// Err is the error of processing the packet, in which case Out is empty.
+	Err error
+}
+
+// ProcessSphinxPacketBatch processes all the packets of the batch using the given private key, like ProcessSphinxPacket
+// does for a single packet, and stores the results in the batch. The packets are processed in parallel
+// by the given number of workers, or by runtime.GOMAXPROCS(0) workers if it is not positive.
+// replays, unless it is nil, has to be safe for the concurrent use by the workers.
+func (p Params) ProcessSphinxPacketBatch(batch []BatchPacket, privKey *PrivateKey, replays ReplayChecker, workers int) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(batch) {
+		workers = len(batch)
+	}
+
+	process := func(packet *BatchPacket) {
+		var out []byte
+		packet.Hop, packet.Commands, out, packet.Err = p.processSphinxPacket(packet.Packet, privKey, replays, packet.Out)
+		if packet.Err != nil {
+			out = packet.Out[:0]
+		}
+		packet.Out = out
+	}
+
+	if workers <= 1 {
+		for i := range batch {
+			process(&batch[i])
+		}
+		return
+	}
+
+	// the workers pick the packets one at a time rather than in fixed chunks,
+	// so that all of them stay busy until the whole batch is processed
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(batch) {
+					return
+				}
+				process(&batch[i])
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,292 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// The routing commands tell the hop what to do with the packet. They are encoded in the routing block
+// as a list of entries, each consisting of the type, the length of the value and the value itself:
+//
+//	commands     = command || command || ... || zero padding up to CommandsSize bytes
+//	command      = 1 byte type || 1 byte length || value
+//
+// As every command carries its length, the hops can decode the commands of types they do not know,
+// which are returned as UnknownCommand, so that new commands can be added without changing the encoding.
+const (
+	// CommandsSize defines the length of the encoded routing commands of a single hop.
+	CommandsSize = 24
+
+	commandHeaderSize = 2
+)
+
+// CommandType identifies the type of the routing command.
+type CommandType byte
+
+const (
+	// DelayCommandType identifies DelayCommand.
+	DelayCommandType CommandType = 0x01
+	// RelayCommandType identifies RelayCommand.
+	RelayCommandType CommandType = 0x02
+	// DeliverCommandType identifies DeliverCommand.
+	DeliverCommandType CommandType = 0x03
+	// DeliverSURBAckCommandType identifies DeliverSURBAckCommand.
+	DeliverSURBAckCommandType CommandType = 0x04
+	// DropCommandType identifies DropCommand.
+	DropCommandType CommandType = 0x05
+	// LoopBackCommandType identifies LoopBackCommand.
+	LoopBackCommandType CommandType = 0x06
+
+	// paddingCommandType marks the end of the encoded commands.
+	paddingCommandType CommandType = 0x00
+)
+
+var (
+	// ErrCommandsTooLong is returned when the encoded routing commands do not fit in the routing block.
+	ErrCommandsTooLong = errors.New("routing commands are too long")
+	// ErrInvalidCommand is returned when the routing command cannot be decoded.
+	ErrInvalidCommand = errors.New("invalid routing command")
+)
+
+// String returns the name of the command type.
+func (t CommandType) String() string {
+	switch t {
+	case DelayCommandType:
+		return "delay"
+	case RelayCommandType:
+		return "relay"
+	case DeliverCommandType:
+		return "deliver"
+	case DeliverSURBAckCommandType:
+		return "deliver-surb-ack"
+	case DropCommandType:
+		return "drop"
+	case LoopBackCommandType:
+		return "loop-back"
+	default:
+		return fmt.Sprintf("unknown(0x%02x)", byte(t))
+	}
+}
+
+// Command is a single routing command for the hop processing the packet.
+type Command interface {
+	// Type returns the type of the command.
+	Type() CommandType
+	// value returns the encoded value of the command.
+	value() []byte
+}
+
+// DelayCommand instructs the hop to delay the packet before acting on it.
+// It is combined with any of the other commands, e.g. RelayCommand for relaying with the delay.
+type DelayCommand struct {
+	// Delay is expressed in seconds.
+	Delay float64
+}
+
+// RelayCommand instructs the hop to relay the packet to the next hop.
+type RelayCommand struct{}
+
+// DeliverCommand instructs the hop, which is the final hop of the packet, to deliver it
+// to the mailbox of the recipient identified by the next hop.
+type DeliverCommand struct{}
+
+// DeliverSURBAckCommand instructs the hop, which is the final hop of the packet sent using
+// a single-use reply block, e.g. a reply or an acknowledgement, to deliver it to the mailbox of its recipient.
+// Unlike with DeliverCommand, the hop cannot verify the integrity of the payload
+// as it is only decrypted by the recipient.
+type DeliverSURBAckCommand struct{}
+
+// DropCommand instructs the hop, which is the final hop of the packet, to drop it.
+// It is used by the cover traffic.
+type DropCommand struct{}
+
+// LoopBackCommand marks the packet which, after traversing the network, came back to the hop that created it.
+// It is used by the loop cover traffic.
+type LoopBackCommand struct{}
+
+// UnknownCommand holds the command of a type unknown to this implementation.
+type UnknownCommand struct {
+	CommandType CommandType
+	Value       []byte
+}
+
+// Type returns the type of the command.
+func (DelayCommand) Type() CommandType { return DelayCommandType }
+
+// Type returns the type of the command.
+func (RelayCommand) Type() CommandType { return RelayCommandType }
+
+// Type returns the type of the command.
+func (DeliverCommand) Type() CommandType { return DeliverCommandType }
+
+// Type returns the type of the command.
+func (DeliverSURBAckCommand) Type() CommandType { return DeliverSURBAckCommandType }
+
+// Type returns the type of the command.
+func (DropCommand) Type() CommandType { return DropCommandType }
+
+// Type returns the type of the command.
+func (LoopBackCommand) Type() CommandType { return LoopBackCommandType }
+
+// Type returns the type of the command.
+func (c UnknownCommand) Type() CommandType { return c.CommandType }
+
+func (c DelayCommand) value() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(c.Delay))
+	return b
+}
+
+func (RelayCommand) value() []byte          { return nil }
+func (DeliverCommand) value() []byte        { return nil }
+func (DeliverSURBAckCommand) value() []byte { return nil }
+func (DropCommand) value() []byte           { return nil }
+func (LoopBackCommand) value() []byte       { return nil }
+func (c UnknownCommand) value() []byte      { return c.Value }
+
+// CommandSet is the list of routing commands of a single hop. It normally consists of a DelayCommand
+// and a single action, i.e. any other command telling the hop what to do with the packet.
+type CommandSet []Command
+
+// Delay returns the delay of the packet, or zero if the set contains no DelayCommand.
+func (cs CommandSet) Delay() float64 {
+	for _, c := range cs {
+		if d, ok := c.(DelayCommand); ok {
+			return d.Delay
+		}
+	}
+	return 0
+}
+
+// Action returns the first command other than DelayCommand, or nil if there is none.
+func (cs CommandSet) Action() Command {
+	for _, c := range cs {
+		if _, ok := c.(DelayCommand); !ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// String returns the names of the commands.
+func (cs CommandSet) String() string {
+	s := "["
+	for i, c := range cs {
+		if i > 0 {
+			s += " "
+		}
+		s += c.Type().String()
+	}
+	return s + "]"
+}
+
+// relayCommands returns the commands of a hop relaying the packet after the delay.
+func relayCommands(delay float64) CommandSet {
+	return CommandSet{DelayCommand{Delay: delay}, RelayCommand{}}
+}
+
+// finalCommands returns the commands of the final hop, which acts on the packet after the delay.
+func finalCommands(delay float64, action Command) CommandSet {
+	return CommandSet{DelayCommand{Delay: delay}, action}
+}
+
+// encodeCommands encodes the commands into CommandsSize bytes.
+func encodeCommands(cs CommandSet) ([]byte, error) {
+	b := make([]byte, 0, CommandsSize)
+	for _, c := range cs {
+		if c.Type() == paddingCommandType {
+			return nil, ErrInvalidCommand
+		}
+		v := c.value()
+		if len(b)+commandHeaderSize+len(v) > CommandsSize {
+			return nil, ErrCommandsTooLong
+		}
+		b = append(b, byte(c.Type()), byte(len(v)))
+		b = append(b, v...)
+	}
+	return append(b, make([]byte, CommandsSize-len(b))...), nil
+}
+
+// decodeCommands decodes the commands encoded with encodeCommands.
+func decodeCommands(b []byte) (CommandSet, error) {
+	if len(b) != CommandsSize {
+		return nil, ErrInvalidCommand
+	}
+	var cs CommandSet
+	offset := 0
+	for offset < len(b) && CommandType(b[offset]) != paddingCommandType {
+		if offset+commandHeaderSize > len(b) {
+			return nil, ErrInvalidCommand
+		}
+		t := CommandType(b[offset])
+		length := int(b[offset+1])
+		offset += commandHeaderSize
+		if offset+length > len(b) {
+			return nil, ErrInvalidCommand
+		}
+		c, err := decodeCommand(t, b[offset:offset+length])
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+		offset += length
+	}
+	return cs, nil
+}
+
+func decodeCommand(t CommandType, v []byte) (Command, error) {
+	switch t {
+	case DelayCommandType:
+		if len(v) != 8 {
+			return nil, ErrInvalidCommand
+		}
+		return DelayCommand{Delay: math.Float64frombits(binary.BigEndian.Uint64(v))}, nil
+	case RelayCommandType:
+		return RelayCommand{}, nil
+	case DeliverCommandType:
+		return DeliverCommand{}, nil
+	case DeliverSURBAckCommandType:
+		return DeliverSURBAckCommand{}, nil
+	case DropCommandType:
+		return DropCommand{}, nil
+	case LoopBackCommandType:
+		return LoopBackCommand{}, nil
+	default:
+		value := make([]byte, len(v))
+		copy(value, v)
+		return UnknownCommand{CommandType: t, Value: value}, nil
+	}
+}
+
+// legacyCommandSet converts the commands of the legacy packets, consisting of the delay and the flag,
+// into the command set. The flags unknown to this implementation result in the set without any action.
+func legacyCommandSet(c Commands) CommandSet {
+	cs := CommandSet{DelayCommand{Delay: c.Delay}}
+	switch flags.SphinxFlagFromBytes(c.Flag) {
+	case flags.RelayFlag:
+		cs = append(cs, RelayCommand{})
+	case flags.LastHopFlag:
+		cs = append(cs, DeliverCommand{})
+	case flags.ReplyLastHopFlag:
+		cs = append(cs, DeliverSURBAckCommand{})
+	}
+	return cs
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandsEncoding(t *testing.T) {
+	for _, cs := range []CommandSet{
+		relayCommands(0.25),
+		finalCommands(1.5, DeliverCommand{}),
+		finalCommands(0, DeliverSURBAckCommand{}),
+		{DropCommand{}},
+		{LoopBackCommand{}, DelayCommand{Delay: 3}},
+		{UnknownCommand{CommandType: 0x42, Value: []byte{1, 2, 3}}, RelayCommand{}},
+	} {
+		b, err := encodeCommands(cs)
+		assert.Nil(t, err)
+		assert.Len(t, b, CommandsSize)
+
+		decoded, err := decodeCommands(b)
+		assert.Nil(t, err)
+		assert.Equal(t, cs, decoded)
+	}
+
+	empty, err := encodeCommands(nil)
+	assert.Nil(t, err)
+	decoded, err := decodeCommands(empty)
+	assert.Nil(t, err)
+	assert.Empty(t, decoded)
+	assert.Nil(t, decoded.Action())
+}
+
+func TestCommandsEncodingInvalid(t *testing.T) {
+	_, err := encodeCommands(CommandSet{DelayCommand{}, DelayCommand{}, DelayCommand{}})
+	assert.Equal(t, ErrCommandsTooLong, err)
+	_, err = encodeCommands(CommandSet{UnknownCommand{CommandType: paddingCommandType}})
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	b := make([]byte, CommandsSize)
+	b[0] = byte(DelayCommandType)
+	b[1] = 4
+	_, err = decodeCommands(b)
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	b[1] = CommandsSize
+	_, err = decodeCommands(b)
+	assert.Equal(t, ErrInvalidCommand, err)
+}
+
+func TestCommandSetAccessors(t *testing.T) {
+	cs := finalCommands(0.5, LoopBackCommand{})
+	assert.Equal(t, 0.5, cs.Delay())
+	assert.Equal(t, LoopBackCommand{}, cs.Action())
+	assert.Equal(t, "[delay loop-back]", cs.String())
+	assert.Equal(t, "unknown(0x42)", CommandType(0x42).String())
+}
+
+func TestLegacyCommandSet(t *testing.T) {
+	assert.Equal(t, relayCommands(0.1), legacyCommandSet(Commands{Delay: 0.1, Flag: flags.RelayFlag.Bytes()}))
+	assert.Equal(t, DeliverCommand{}, legacyCommandSet(Commands{Flag: flags.LastHopFlag.Bytes()}).Action())
+	assert.Equal(t, DeliverSURBAckCommand{}, legacyCommandSet(Commands{Flag: flags.ReplyLastHopFlag.Bytes()}).Action())
+	assert.Nil(t, legacyCommandSet(Commands{Flag: []byte{0x42}}).Action())
+}
+
+func TestPackMessageWithCommand(t *testing.T) {
+	privs, nodes := createTestNodes(t, 3)
+	delays := []float64{0.1, 0.2, 0.3}
+
+	for _, command := range []Command{DropCommand{}, LoopBackCommand{}} {
+		packet, err := DefaultParams.PackMessageWithCommand(rand.Reader, nodes, delays, testDestination(), []byte("Cover"), command)
+		assert.Nil(t, err)
+		packetBytes, err := packet.MarshalBinary()
+		assert.Nil(t, err)
+
+		var commands CommandSet
+		for _, priv := range privs {
+			_, commands, packetBytes, err = ProcessSphinxPacket(packetBytes, priv, nil)
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, command, commands.Action())
+		assert.Equal(t, 0.3, commands.Delay())
+	}
+
+	_, err := DefaultParams.PackMessageWithCommand(rand.Reader, nodes, delays[:2], testDestination(), nil, DropCommand{})
+	assert.Error(t, err)
+}
@@ -21,7 +21,9 @@ import (
 	"crypto/sha256"
 )
 
-// AesCtr returns AES XOR ciphertext in counter mode for the given key and plaintext
+// AesCtr returns AES XOR ciphertext in counter mode for the given key and plaintext.
+// It uses a constant IV, so it is only used for processing packets in the legacy encoding,
+// where every key is used for encrypting a single message.
 func AesCtr(key, plaintext []byte) ([]byte, error) {
 
 	ciphertext := make([]byte, len(plaintext))
@@ -60,7 +62,8 @@ func Hmac(key, message []byte) ([]byte, error) {
 	return mac.Sum(nil), nil
 }
 
-// KDF returns the hash of K for a given key
+// KDF returns the hash of K for a given key. The keys of the current packet format are
+// derived with HKDF instead, KDF is only used for processing packets in the legacy encoding.
 func KDF(key []byte) ([]byte, error) {
 	b, err := hash(key)
 	if err != nil {
@@ -99,6 +99,13 @@ func (pk *PrivateKey) ToFieldElement() *FieldElement {
 	return BytesToFieldElement(pk.Bytes())
 }
 
+// Erase overwrites the private key with zeros, so that it could no longer be recovered from the memory.
+func (pk *PrivateKey) Erase() {
+	for i := range pk.bytes {
+		pk.bytes[i] = 0
+	}
+}
+
 func BytesToPublicKey(b []byte) *PublicKey {
 	if len(b) > PublicKeySize {
 		panic("The byte slice is larger than the field element")
@@ -118,9 +125,14 @@ func (pub *PublicKey) ToFieldElement() *FieldElement {
 
 // GenerateKeyPair returns public and private keypair bytes for Curve25519 elliptic curve, or an error.
 func GenerateKeyPair() (*PrivateKey, *PublicKey, error) {
+	return GenerateKeyPairFrom(rand.Reader)
+}
+
+// GenerateKeyPairFrom returns the keypair for Curve25519 elliptic curve with the private key read from rng.
+func GenerateKeyPairFrom(rng io.Reader) (*PrivateKey, *PublicKey, error) {
 	priv := new(PrivateKey)
 	pub := new(PublicKey)
-	if _, err := io.ReadFull(rand.Reader, priv.Bytes()); err != nil {
+	if _, err := io.ReadFull(rng, priv.Bytes()); err != nil {
 		return nil, nil, err
 	}
 	curve25519.ScalarBaseMult(&pub.bytes, &priv.bytes)
@@ -132,8 +144,13 @@ func CompareElements(e1, e2 CryptoElement) bool {
 }
 
 func RandomElement() (*FieldElement, error) {
+	return RandomElementFrom(rand.Reader)
+}
+
+// RandomElementFrom returns the field element read from rng.
+func RandomElementFrom(rng io.Reader) (*FieldElement, error) {
 	b := [32]byte{}
-	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+	if _, err := io.ReadFull(rng, b[:]); err != nil {
 		return nil, err
 	}
 	return &FieldElement{
@@ -165,6 +182,45 @@ func expoGroupBase(exp []*FieldElement) *FieldElement {
 	return res
 }
 
+// x25519Suite implements the Suite on Curve25519, using the Montgomery ladder over the u-coordinates.
+// Any 32 bytes are accepted as the element and as the scalar, which gets clamped by the multiplication.
+type x25519Suite struct{}
+
+func (x25519Suite) Name() string {
+	return "x25519"
+}
+
+func (x25519Suite) GenerateKeyPair(rng io.Reader) (*PrivateKey, *PublicKey, error) {
+	return GenerateKeyPairFrom(rng)
+}
+
+func (x25519Suite) RandomScalar(rng io.Reader) (*FieldElement, error) {
+	return RandomElementFrom(rng)
+}
+
+func (x25519Suite) DecodeElement(b []byte) (*FieldElement, error) {
+	if len(b) != FieldElementSize {
+		return nil, ErrInvalidElement
+	}
+	return BytesToFieldElement(b), nil
+}
+
+func (x25519Suite) ScalarBaseMult(scalar *FieldElement) (*FieldElement, error) {
+	return expoGroupBase([]*FieldElement{scalar}), nil
+}
+
+func (x25519Suite) ScalarMult(scalar, element *FieldElement) (*FieldElement, error) {
+	return expo(element, []*FieldElement{scalar}), nil
+}
+
+func (x25519Suite) DeriveBlindingFactor(secretHash []byte) (*FieldElement, error) {
+	blinding, err := hkdfExpand(secretHash, blindingLabel, FieldElementSize)
+	if err != nil {
+		return nil, err
+	}
+	return BytesToFieldElement(blinding), nil
+}
+
 //////////////////////////////////////////////
 // REFERENCE
 //////////////////////////////////////////////
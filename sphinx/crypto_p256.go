@@ -0,0 +1,120 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/elliptic"
+	"io"
+	"math/big"
+)
+
+const (
+	// p256ExtraScalarBytes is the number of additional bytes reduced into every P-256 scalar,
+	// which makes the bias of the reduction negligible, as in FIPS 186-4, B.4.1.
+	p256ExtraScalarBytes = 8
+)
+
+// p256Suite implements the Suite on the NIST P-256 curve.
+// The elements are encoded as their 32 byte, big endian x-coordinates. As the x-coordinate of k*P
+// is the same as the one of k*(-P), the y-coordinate makes no difference to neither the shared secrets
+// nor the blinded elements, so the elements are always decoded to the point with the even y-coordinate.
+// The scalars are encoded as 32 byte big endian integers in the range [1, n-1].
+type p256Suite struct{}
+
+func (p256Suite) Name() string {
+	return "p256"
+}
+
+func (s p256Suite) GenerateKeyPair(rng io.Reader) (*PrivateKey, *PublicKey, error) {
+	scalar, err := s.RandomScalar(rng)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := s.ScalarBaseMult(scalar)
+	if err != nil {
+		return nil, nil, err
+	}
+	return BytesToPrivateKey(scalar.Bytes()), BytesToPublicKey(pub.Bytes()), nil
+}
+
+func (p256Suite) RandomScalar(rng io.Reader) (*FieldElement, error) {
+	b := make([]byte, FieldElementSize+p256ExtraScalarBytes)
+	if _, err := io.ReadFull(rng, b); err != nil {
+		return nil, err
+	}
+	return p256ReduceScalar(b), nil
+}
+
+func (p256Suite) DecodeElement(b []byte) (*FieldElement, error) {
+	if _, _, err := p256DecodePoint(b); err != nil {
+		return nil, err
+	}
+	return BytesToFieldElement(b), nil
+}
+
+func (p256Suite) ScalarBaseMult(scalar *FieldElement) (*FieldElement, error) {
+	return p256EncodePoint(elliptic.P256().ScalarBaseMult(scalar.Bytes()))
+}
+
+func (p256Suite) ScalarMult(scalar, element *FieldElement) (*FieldElement, error) {
+	x, y, err := p256DecodePoint(element.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return p256EncodePoint(elliptic.P256().ScalarMult(x, y, scalar.Bytes()))
+}
+
+func (p256Suite) DeriveBlindingFactor(secretHash []byte) (*FieldElement, error) {
+	b, err := hkdfExpand(secretHash, blindingLabel, FieldElementSize+p256ExtraScalarBytes)
+	if err != nil {
+		return nil, err
+	}
+	return p256ReduceScalar(b), nil
+}
+
+// p256ReduceScalar maps the bytes to the scalar in the range [1, n-1] by computing (b mod (n-1)) + 1.
+func p256ReduceScalar(b []byte) *FieldElement {
+	nMinusOne := new(big.Int).Sub(elliptic.P256().Params().N, big.NewInt(1))
+	k := new(big.Int).SetBytes(b)
+	k.Mod(k, nMinusOne)
+	k.Add(k, big.NewInt(1))
+
+	fe := new(FieldElement)
+	k.FillBytes(fe.bytes[:])
+	return fe
+}
+
+// p256DecodePoint decodes the x-coordinate into the point on the curve with the even y-coordinate.
+func p256DecodePoint(b []byte) (*big.Int, *big.Int, error) {
+	if len(b) != FieldElementSize {
+		return nil, nil, ErrInvalidElement
+	}
+	compressed := append([]byte{0x02}, b...)
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), compressed)
+	if x == nil {
+		return nil, nil, ErrInvalidElement
+	}
+	return x, y, nil
+}
+
+// p256EncodePoint encodes the point as its x-coordinate. The point at infinity has no encoding.
+func p256EncodePoint(x, y *big.Int) (*FieldElement, error) {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, ErrInvalidElement
+	}
+	fe := new(FieldElement)
+	x.FillBytes(fe.bytes[:])
+	return fe, nil
+}
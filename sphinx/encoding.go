@@ -0,0 +1,301 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// The packet is encoded as a fixed length sequence of bytes, so that all packets on the wire
+// look alike regardless of their path, destination or the length of the message:
+//
+//	packet       = version || alpha || beta || mac || payload
+//	version      = 1 byte, Version1 for this encoding
+//	alpha        = FieldElementSize bytes, the public element encoded by the Params.Suite
+//	beta         = Params.MaxPathLength routing blocks, each routingBlockSize bytes
+//	mac          = MacSize bytes
+//	payload      = PayloadSize bytes, encrypted with the Lioness wide-block cipher
+//
+// For a path of n hops the sender builds beta from the last hop backwards. The routing block of the last hop
+// is followed by random bytes and by the filler, i.e. the (n-1) routing blocks of pseudo-random data
+// which the preceding hops are going to append when processing the packet.
+// Every other hop i prepends its own routing block, containing the MAC of the header for hop i+1,
+// drops the last routing block of beta and encrypts the result with its stream.
+// A hop, after verifying the MAC over the whole beta, decrypts it and reads its own routing block.
+// The remaining blocks, followed by a routing block of its filler stream, form the beta of the next hop.
+// The filler stream blocks, encrypted by the subsequent hops, are exactly the filler predicted by the sender,
+// so the MAC of the next hop is valid, while the header keeps the same length at every hop.
+//
+// Each routing block contains the information for a single hop:
+//
+//	commands     = CommandsSize bytes of the routing commands, as described in commands.go
+//	address      = 1 byte length || MaxAddressLength bytes, zero padded
+//	id           = 1 byte length || MaxIDLength bytes, zero padded
+//	public key   = PublicKeySize bytes encoded by the Params.Suite, all zeroes if not present
+//	mac          = MacSize bytes of the MAC of the header for the next hop
+//
+// Once decrypted by the final hop, the payload has the following form:
+//
+//	payload      = integrity tag || message || 0x01 || zero padding
+//	integrity tag = PayloadTagSize zero bytes
+const (
+	// MaxAddressLength defines the maximum length of the address of the next hop.
+	MaxAddressLength = 47
+	// MaxIDLength defines the maximum length of the identifier of the next hop.
+	MaxIDLength = 47
+	// MacSize defines the length of message authentication codes in the header.
+	MacSize = K
+	// PayloadSize defines the network-wide length of the packet payload.
+	// It is large enough to carry a single-use reply block alongside the message.
+	PayloadSize = 2048
+	// PayloadTagSize defines the length of the zero tag that allows the final hop to verify the payload integrity.
+	PayloadTagSize = K
+	// MaxMessageLength defines the maximum length of a message that fits in the payload.
+	MaxMessageLength = PayloadSize - PayloadTagSize - 1
+
+	versionSize      = 1
+	addressFieldSize = 1 + MaxAddressLength
+	idFieldSize      = 1 + MaxIDLength
+	routingBlockSize = CommandsSize + addressFieldSize + idFieldSize + PublicKeySize + MacSize
+
+	// paddingMarker separates the message from the zero padding in the payload.
+	paddingMarker = 0x01
+)
+
+var (
+	// ErrInvalidPacketLength is returned when the encoded packet does not have the expected length.
+	ErrInvalidPacketLength = errors.New("invalid sphinx packet length")
+	// ErrMessageTooLong is returned when the message does not fit in the packet payload.
+	ErrMessageTooLong = errors.New("message is too long to fit in the sphinx packet payload")
+	// ErrInvalidPadding is returned when the padding of the payload is malformed.
+	ErrInvalidPadding = errors.New("invalid payload padding")
+	// ErrPayloadIntegrity is returned when the payload reaching the final hop has been tampered with.
+	ErrPayloadIntegrity = errors.New("packet processing error: payload integrity check failed")
+)
+
+// MarshalBinary is an implementation of a method on the
+// BinaryMarshaler interface defined in https://golang.org/pkg/encoding/
+func (p *SphinxPacket) MarshalBinary() ([]byte, error) {
+	if p.Hdr == nil {
+		return nil, errors.New("packet has no header")
+	}
+	if !isValidHeader(p.Hdr) {
+		return nil, errors.New("packet header has invalid length")
+	}
+	if len(p.Pld) != PayloadSize {
+		return nil, errors.New("packet payload has invalid length")
+	}
+
+	b := make([]byte, 0, versionSize+FieldElementSize+len(p.Hdr.Beta)+MacSize+PayloadSize)
+	b = append(b, byte(Version1))
+	b = append(b, p.Hdr.Alpha...)
+	b = append(b, p.Hdr.Beta...)
+	b = append(b, p.Hdr.Mac...)
+	b = append(b, p.Pld...)
+	return b, nil
+}
+
+// UnmarshalBinary is an implementation of a method on the
+// BinaryUnmarshaler interface defined in https://golang.org/pkg/encoding/
+// The length of the routing information is inferred from the length of the data.
+// ErrUnsupportedVersion is returned if the data is not encoded in Version1.
+func (p *SphinxPacket) UnmarshalBinary(data []byte) error {
+	if len(data) < versionSize+PayloadSize {
+		return ErrInvalidPacketLength
+	}
+	if Version(data[0]) != Version1 {
+		return ErrUnsupportedVersion
+	}
+	header, err := decodeHeader(data[versionSize : len(data)-PayloadSize])
+	if err != nil {
+		return ErrInvalidPacketLength
+	}
+	payload := make([]byte, PayloadSize)
+	copy(payload, data[len(data)-PayloadSize:])
+
+	p.Hdr = &header
+	p.Pld = payload
+	return nil
+}
+
+// isValidHeader checks whether all parts of the header have valid lengths.
+func isValidHeader(h *Header) bool {
+	return len(h.Alpha) == FieldElementSize && isValidBetaSize(len(h.Beta)) && len(h.Mac) == MacSize
+}
+
+// decodeHeader decodes the header, inferring the length of the routing information from the length of the data.
+func decodeHeader(data []byte) (Header, error) {
+	betaSize, ok := betaSizeFromHeaderSize(len(data))
+	if !ok {
+		return Header{}, errors.New("invalid header length")
+	}
+	b := make([]byte, len(data))
+	copy(b, data)
+
+	return Header{
+		Alpha: b[:FieldElementSize],
+		Beta:  b[FieldElementSize : FieldElementSize+betaSize],
+		Mac:   b[FieldElementSize+betaSize:],
+	}, nil
+}
+
+// routingBlock is the routing information of a single hop.
+type routingBlock struct {
+	// nextHop is the hop the packet should be sent to, or its recipient.
+	nextHop Hop
+	// commands tell the hop what to do with the packet.
+	commands CommandSet
+	// mac is the MAC of the header for the next hop.
+	mac []byte
+}
+
+// encodeRoutingBlock encodes the routing information of a single hop into a fixed length routing block.
+func encodeRoutingBlock(routing routingBlock) ([]byte, error) {
+	hop := routing.nextHop
+
+	if len(hop.Address) > MaxAddressLength {
+		return nil, fmt.Errorf("address %v is longer than %v bytes", hop.Address, MaxAddressLength)
+	}
+	if len(hop.Id) > MaxIDLength {
+		return nil, fmt.Errorf("id %v is longer than %v bytes", hop.Id, MaxIDLength)
+	}
+	if len(hop.PubKey) != 0 && len(hop.PubKey) != PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length: %v", len(hop.PubKey))
+	}
+	if len(routing.mac) != 0 && len(routing.mac) != MacSize {
+		return nil, fmt.Errorf("invalid mac length: %v", len(routing.mac))
+	}
+	commands, err := encodeCommands(routing.commands)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, routingBlockSize)
+	offset := 0
+
+	copy(b[offset:], commands)
+	offset += CommandsSize
+
+	b[offset] = byte(len(hop.Address))
+	copy(b[offset+1:], hop.Address)
+	offset += addressFieldSize
+
+	b[offset] = byte(len(hop.Id))
+	copy(b[offset+1:], hop.Id)
+	offset += idFieldSize
+
+	copy(b[offset:], hop.PubKey)
+	offset += PublicKeySize
+
+	copy(b[offset:], routing.mac)
+	return b, nil
+}
+
+// decodeRoutingBlock decodes the routing information of a single hop from the routing block.
+func decodeRoutingBlock(b []byte) (routingBlock, error) {
+	if len(b) != routingBlockSize {
+		return routingBlock{}, errors.New("invalid routing block length")
+	}
+	offset := 0
+
+	commands, err := decodeCommands(b[offset : offset+CommandsSize])
+	if err != nil {
+		return routingBlock{}, err
+	}
+	offset += CommandsSize
+
+	addressLength := int(b[offset])
+	if addressLength > MaxAddressLength {
+		return routingBlock{}, errors.New("invalid address length")
+	}
+	address := string(b[offset+1 : offset+1+addressLength])
+	offset += addressFieldSize
+
+	idLength := int(b[offset])
+	if idLength > MaxIDLength {
+		return routingBlock{}, errors.New("invalid id length")
+	}
+	id := string(b[offset+1 : offset+1+idLength])
+	offset += idFieldSize
+
+	var pubKey []byte
+	if !isZero(b[offset : offset+PublicKeySize]) {
+		pubKey = make([]byte, PublicKeySize)
+		copy(pubKey, b[offset:offset+PublicKeySize])
+	}
+	offset += PublicKeySize
+
+	mac := make([]byte, MacSize)
+	copy(mac, b[offset:offset+MacSize])
+
+	return routingBlock{
+		nextHop:  Hop{Id: id, Address: address, PubKey: pubKey},
+		commands: commands,
+		mac:      mac,
+	}, nil
+}
+
+// padPayload pads the message to the length of the packet payload.
+// The message is preceded by the zero integrity tag and followed by a single marker byte and then by zeroes.
+func padPayload(message []byte) ([]byte, error) {
+	if len(message) > MaxMessageLength {
+		return nil, ErrMessageTooLong
+	}
+	payload := make([]byte, PayloadSize)
+	copy(payload[PayloadTagSize:], message)
+	payload[PayloadTagSize+len(message)] = paddingMarker
+	return payload, nil
+}
+
+// unpadPayload removes the integrity tag and the padding added by padPayload.
+// It does not verify the integrity tag, which should be done with checkPayloadIntegrity beforehand.
+func unpadPayload(payload []byte) ([]byte, error) {
+	if len(payload) < PayloadTagSize {
+		return nil, ErrInvalidPadding
+	}
+	payload = payload[PayloadTagSize:]
+	for i := len(payload) - 1; i >= 0; i-- {
+		switch payload[i] {
+		case 0x00:
+			continue
+		case paddingMarker:
+			return payload[:i], nil
+		default:
+			return nil, ErrInvalidPadding
+		}
+	}
+	return nil, ErrInvalidPadding
+}
+
+// checkPayloadIntegrity verifies, in constant time, that the decrypted payload starts with the zero integrity tag.
+// As the payload is encrypted with a wide-block cipher, any modification of the payload by the intermediate hops
+// turns the whole decrypted payload, including the tag, into garbage.
+func checkPayloadIntegrity(payload []byte) bool {
+	if len(payload) < PayloadTagSize {
+		return false
+	}
+	return subtle.ConstantTimeCompare(payload[:PayloadTagSize], make([]byte, PayloadTagSize)) == 1
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingBlockEncoding(t *testing.T) {
+	_, pub, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	routing := routingBlock{nextHop: Hop{Id: "Node2",
+		Address: "localhost:3332",
+		PubKey:  pub.Bytes(),
+	}, commands: relayCommands(0.25),
+		mac: make([]byte, MacSize),
+	}
+	routing.mac[0] = 42
+
+	b, err := encodeRoutingBlock(routing)
+	assert.Nil(t, err)
+	assert.Len(t, b, routingBlockSize)
+
+	decoded, err := decodeRoutingBlock(b)
+	assert.Nil(t, err)
+	assert.Equal(t, routing, decoded)
+}
+
+func TestRoutingBlockEncodingNoPublicKey(t *testing.T) {
+	routing := routingBlock{nextHop: Hop{Id: "DestinationId", Address: "DestinationAddress"},
+		commands: finalCommands(1.10, DeliverCommand{}),
+	}
+
+	b, err := encodeRoutingBlock(routing)
+	assert.Nil(t, err)
+
+	decoded, err := decodeRoutingBlock(b)
+	assert.Nil(t, err)
+	assert.Nil(t, decoded.nextHop.PubKey)
+	assert.Equal(t, make([]byte, MacSize), decoded.mac)
+}
+
+func TestRoutingBlockEncodingTooLongAddress(t *testing.T) {
+	routing := routingBlock{nextHop: Hop{Id: "Node2", Address: strings.Repeat("a", MaxAddressLength+1)}}
+	_, err := encodeRoutingBlock(routing)
+	assert.Error(t, err)
+}
+
+func TestPayloadPadding(t *testing.T) {
+	for _, message := range [][]byte{{}, []byte("Plaintext message"), make([]byte, MaxMessageLength)} {
+		payload, err := padPayload(message)
+		assert.Nil(t, err)
+		assert.Len(t, payload, PayloadSize)
+
+		unpadded, err := unpadPayload(payload)
+		assert.Nil(t, err)
+		assert.Equal(t, message, unpadded)
+	}
+
+	_, err := padPayload(make([]byte, MaxMessageLength+1))
+	assert.Equal(t, ErrMessageTooLong, err)
+
+	_, err = unpadPayload(make([]byte, PayloadSize))
+	assert.Equal(t, ErrInvalidPadding, err)
+}
+
+func versionOnePacketBytes(length int) []byte {
+	b := make([]byte, length)
+	b[0] = byte(Version1)
+	return b
+}
+
+func TestSphinxPacketUnmarshalInvalidLength(t *testing.T) {
+	var packet SphinxPacket
+	assert.Equal(t, ErrInvalidPacketLength, packet.UnmarshalBinary(versionOnePacketBytes(1+PayloadSize+FieldElementSize+MacSize)))
+	assert.Equal(t, ErrInvalidPacketLength, packet.UnmarshalBinary(versionOnePacketBytes(DefaultParams.PacketSize()+1)))
+	assert.Equal(t, ErrInvalidPacketLength, packet.UnmarshalBinary(nil))
+}
+
+func TestSphinxPacketUnmarshalUnknownVersion(t *testing.T) {
+	var packet SphinxPacket
+	b := versionOnePacketBytes(DefaultParams.PacketSize())
+	assert.Nil(t, packet.UnmarshalBinary(b))
+
+	b[0] = 0x02
+	assert.Equal(t, ErrUnsupportedVersion, packet.UnmarshalBinary(b))
+}
+
+func TestSphinxPacketMarshalInvalidLength(t *testing.T) {
+	packet := SphinxPacket{Hdr: &Header{Alpha: make([]byte, FieldElementSize),
+		Beta: make([]byte, DefaultParams.BetaSize()-1),
+		Mac:  make([]byte, MacSize),
+	}, Pld: make([]byte, PayloadSize)}
+	_, err := packet.MarshalBinary()
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// All the keys used for processing a packet at a single hop are derived from the shared secret of that hop
+// using HKDF (RFC 5869) with HMAC-SHA256. The shared secret is first extracted into a pseudo-random key,
+// which is then expanded into a separate key for each purpose, identified by its label.
+const (
+	// kdfSalt is the salt of the extract step, which separates the derived keys from any other use of the secrets.
+	kdfSalt = "nym-sphinx-kdf-v1"
+
+	headerEncryptionLabel = "header-encryption"
+	headerMacLabel        = "header-mac"
+	payloadLabel          = "payload"
+	blindingLabel         = "blinding"
+	fillerLabel           = "filler"
+	replayTagLabel        = "replay-tag"
+	surbPayloadLabel      = "surb-payload"
+
+	// streamKeySize defines the length of the keys, followed by their IVs, used for generating AES_CTR streams.
+	streamKeySize = K + aes.BlockSize
+	// macKeySize defines the length of the key used for computing the header MACs.
+	macKeySize = sha256.Size
+)
+
+var (
+	// ErrInvalidKeyLength is returned when the key cannot be derived with the requested length.
+	ErrInvalidKeyLength = errors.New("invalid derived key length")
+)
+
+// hkdfExtract performs the extract step of HKDF, turning the secret into a pseudo-random key.
+func hkdfExtract(salt, secret []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	// writes to hash.Hash never return an error
+	_, _ = mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand performs the expand step of HKDF, deriving the key with the given label from the pseudo-random key.
+func hkdfExpand(prk []byte, label string, length int) ([]byte, error) {
+	if length <= 0 || length > 255*sha256.Size {
+		return nil, ErrInvalidKeyLength
+	}
+
+	okm := make([]byte, 0, length+sha256.Size)
+	var t []byte
+	for i := byte(1); len(okm) < length; i++ {
+		mac := hmac.New(sha256.New, prk)
+		_, _ = mac.Write(t)
+		_, _ = mac.Write([]byte(label))
+		_, _ = mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		okm = append(okm, t...)
+	}
+	return okm[:length], nil
+}
+
+// deriveSecretHash extracts the pseudo-random key, from which all the keys of the hop are expanded,
+// out of the shared secret.
+func deriveSecretHash(sharedSecret []byte) []byte {
+	return hkdfExtract([]byte(kdfSalt), sharedSecret)
+}
+
+// hopKeys holds all the keys used for processing a packet by a single hop.
+type hopKeys struct {
+	// headerEncryption is the key, followed by the IV, of the stream encrypting the routing information.
+	headerEncryption []byte
+	// headerMac is the key of the MAC of the header.
+	headerMac []byte
+	// payload is the Lioness key encrypting the payload.
+	payload []byte
+	// filler is the key, followed by the IV, of the stream padding the routing information after it gets shifted.
+	filler []byte
+}
+
+// deriveHopKeys derives all the symmetric keys of a single hop from the hashed shared secret.
+// The blinding factor is derived by the Suite, as its encoding depends on the group.
+func deriveHopKeys(secretHash []byte) (hopKeys, error) {
+	var keys hopKeys
+	var err error
+
+	if keys.headerEncryption, err = hkdfExpand(secretHash, headerEncryptionLabel, streamKeySize); err != nil {
+		return hopKeys{}, err
+	}
+	if keys.headerMac, err = hkdfExpand(secretHash, headerMacLabel, macKeySize); err != nil {
+		return hopKeys{}, err
+	}
+	if keys.payload, err = hkdfExpand(secretHash, payloadLabel, LionessKeySize); err != nil {
+		return hopKeys{}, err
+	}
+	if keys.filler, err = hkdfExpand(secretHash, fillerLabel, streamKeySize); err != nil {
+		return hopKeys{}, err
+	}
+	return keys, nil
+}
+
+// headerStream generates the pseudo-random stream used for encrypting the routing information.
+func (k hopKeys) headerStream(betaSize int) ([]byte, error) {
+	return keyStream(k.headerEncryption, betaSize)
+}
+
+// fillerStream generates the pseudo-random routing block appended by the hop to the shifted routing information.
+func (k hopKeys) fillerStream() ([]byte, error) {
+	return keyStream(k.filler, routingBlockSize)
+}
+
+// keyStream generates the AES_CTR stream of the given length. The key is followed by the IV.
+func keyStream(keyAndIV []byte, length int) ([]byte, error) {
+	ctr, err := newKeyStreamCipher(keyAndIV)
+	if err != nil {
+		return nil, err
+	}
+	stream := make([]byte, length)
+	ctr.XORKeyStream(stream, stream)
+	return stream, nil
+}
+
+// newKeyStreamCipher returns the AES_CTR cipher generating the stream of keyStream, which allows to xor the stream
+// into the existing buffers rather than allocating it. The key is followed by the IV.
+func newKeyStreamCipher(keyAndIV []byte) (cipher.Stream, error) {
+	if len(keyAndIV) != streamKeySize {
+		return nil, ErrInvalidKeyLength
+	}
+	block, err := aes.NewCipher(keyAndIV[:K])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(block, keyAndIV[K:]), nil
+}
+
+// computeReplayTag derives the tag used for replay detection from the hashed shared secret of the hop.
+func computeReplayTag(secretHash []byte) ([]byte, error) {
+	return hkdfExpand(secretHash, replayTagLabel, ReplayTagSize)
+}
+
+// computeSURBPayloadKey derives the Lioness key used by the replier from the key included in the SURB.
+func computeSURBPayloadKey(surbKey []byte) ([]byte, error) {
+	return hkdfExpand(deriveSecretHash(surbKey), surbPayloadLabel, LionessKeySize)
+}
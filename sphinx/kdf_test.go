@@ -0,0 +1,107 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.Nil(t, err)
+	return b
+}
+
+func TestHKDFKnownAnswer(t *testing.T) {
+	// test case 1 of RFC 5869
+	ikm := make([]byte, 22)
+	for i := range ikm {
+		ikm[i] = 0x0b
+	}
+	salt := sequentialBytes(13, 1, 0)
+	info := sequentialBytes(10, 1, 0xf0)
+
+	okm, err := hkdfExpand(hkdfExtract(salt, ikm), string(info), 42)
+	assert.Nil(t, err)
+	assert.Equal(t, decodeHex(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"), okm)
+}
+
+func TestHKDFInvalidLength(t *testing.T) {
+	_, err := hkdfExpand(make([]byte, 32), payloadLabel, 0)
+	assert.Equal(t, ErrInvalidKeyLength, err)
+
+	_, err = hkdfExpand(make([]byte, 32), payloadLabel, 255*32+1)
+	assert.Equal(t, ErrInvalidKeyLength, err)
+}
+
+func TestDeriveHopKeysVectors(t *testing.T) {
+	secretHash := deriveSecretHash(sequentialBytes(32, 1, 0))
+	assert.Equal(t, decodeHex(t, "63d9f3f4ad7b6cf48907da9780fae976727d0b86b026f59ab317ff4cf3ad3e13"), secretHash)
+
+	keys, err := deriveHopKeys(secretHash)
+	assert.Nil(t, err)
+
+	assert.Equal(t, decodeHex(t, "c74be321c0694562a67b7626a4c55d6f124f7066f424e0601149ce85a68a15c9"), keys.headerEncryption)
+	assert.Equal(t, decodeHex(t, "a3ee96405da1dfa31f690651d8ecacb3d5763cd33aa8312c5e7be9abf6f19679"), keys.headerMac)
+	assert.Equal(t, decodeHex(t, "29f64b213d3f062a4fae6c9c75395eea67bdcc151fff0e4391702221411b94f0"+
+		"a07762424b839bf5afe3db4e4b5219bf77cdf82cda8a0513cc12e781eebc961e"+
+		"459fb3e37b4163d406e40f14a75b316f40658ef6aecc38e2a5e81c24174f300a"+
+		"80ea15cf27f6d99f99de1b24b2f21ecfde6e4e09e63428a7a21ae8f5cb5edea3"), keys.payload)
+	blinding, err := X25519.DeriveBlindingFactor(secretHash)
+	assert.Nil(t, err)
+	assert.Equal(t, decodeHex(t, "e229c5d315e65b8b6df3841d5b7badbc0ecf85ed0695eb0359fdc683f165baa8"), blinding.Bytes())
+	assert.Equal(t, decodeHex(t, "92452866c7e42ba52c82a7692b5134385ad6612aba99d920612cc95e9bfdd6c5"), keys.filler)
+
+	tag, err := computeReplayTag(secretHash)
+	assert.Nil(t, err)
+	assert.Equal(t, decodeHex(t, "121221872b5bf8a83a96ffda8b74f8a13d1a1a352edec48a5156a38c11aa6ba4"), tag)
+
+	payloadKey, err := computePayloadKey(secretHash)
+	assert.Nil(t, err)
+	assert.Equal(t, keys.payload, payloadKey)
+
+	surbKey, err := computeSURBPayloadKey(sequentialBytes(SURBKeySize, 1, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, decodeHex(t, "5eb89c166acde338a7abeef2c5867da795b424b6152fc96869504797f79ec1a3"+
+		"264454855bfffb5e2d2ea98cd47dde5eefc9d25eb712e9ab8f9004474fb4ac4c"+
+		"37ece4490c7ed76310cb5157cd34b00acd63d1ab6d5684a6e29ae28822267a8e"+
+		"a4f9adb3291c39141e8837d034b5eb4e3340229c290a5c7c385330e9d8904039"), surbKey)
+}
+
+func TestDerivedKeysAreDistinct(t *testing.T) {
+	secretHash := deriveSecretHash(sequentialBytes(32, 1, 0))
+	keys, err := deriveHopKeys(secretHash)
+	assert.Nil(t, err)
+	blinding, err := X25519.DeriveBlindingFactor(secretHash)
+	assert.Nil(t, err)
+
+	// none of the keys should share a prefix, as they are all derived under distinct labels
+	derived := [][]byte{keys.headerEncryption, keys.headerMac, keys.payload, blinding.Bytes(), keys.filler}
+	for i := range derived {
+		for j := i + 1; j < len(derived); j++ {
+			assert.NotEqual(t, derived[i][:K], derived[j][:K])
+		}
+	}
+
+	// so do the streams encrypting the header and padding it
+	headerStream, err := keys.headerStream(routingBlockSize)
+	assert.Nil(t, err)
+	fillerStream, err := keys.fillerStream()
+	assert.Nil(t, err)
+	assert.NotEqual(t, headerStream, fillerStream)
+}
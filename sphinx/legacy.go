@@ -0,0 +1,221 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+// This file contains the processing of packets in the legacy protobuf encoding, in which
+// the routing information of each hop is nested inside the routing information of the previous one.
+// As a result the length of those packets varies with the path and the message.
+// It is only kept so that the nodes could still process packets of the clients that were not yet updated.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/curve25519"
+)
+
+// processLegacySphinxPacket processes the sphinx packet encoded in the legacy protobuf format.
+// The returned packet is encoded in the same legacy format and the legacy flag is converted into the routing command.
+func processLegacySphinxPacket(packetBytes []byte,
+	privKey *PrivateKey,
+	replays ReplayChecker,
+) (Hop, CommandSet, []byte, error) {
+	var packet SphinxPacket
+	err := proto.Unmarshal(packetBytes, &packet)
+
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - unmarshal of packet failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
+
+	if packet.Hdr == nil {
+		return Hop{}, nil, nil, errors.New("error in ProcessSphinxPacket - packet has no header")
+	}
+
+	hop, commands, newHeader, err := processLegacySphinxHeader(*packet.Hdr, privKey, replays)
+	if err == ErrReplayedPacket {
+		return Hop{}, nil, nil, err
+	}
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - ProcessSphinxHeader failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
+
+	newPayload, err := processLegacySphinxPayload(packet.Hdr.Alpha, packet.Pld, privKey)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - ProcessSphinxPayload failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
+
+	newPacket := SphinxPacket{Hdr: &newHeader, Pld: newPayload}
+	newPacketBytes, err := proto.Marshal(&newPacket)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - marshal of packet failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
+
+	return hop, legacyCommandSet(commands), newPacketBytes, nil
+}
+
+// processLegacySphinxHeader unwraps one layer of encryption from the header encoded in the legacy format.
+func processLegacySphinxHeader(packet Header, privKey *PrivateKey, replays ReplayChecker) (Hop, Commands, Header, error) {
+	if len(packet.Alpha) > FieldElementSize {
+		return Hop{}, Commands{}, Header{}, errors.New("packet processing error: invalid alpha length")
+	}
+
+	alpha := BytesToFieldElement(packet.Alpha)
+	beta := packet.Beta
+	mac := packet.Mac
+
+	sharedSecret := new(FieldElement)
+	curve25519.ScalarMult(sharedSecret.el(), privKey.ToFieldElement().el(), alpha.el())
+
+	aesS, err := KDF(sharedSecret.Bytes())
+	if err != nil {
+		return Hop{}, Commands{}, Header{}, err
+	}
+	encKey, err := KDF(aesS)
+	if err != nil {
+		return Hop{}, Commands{}, Header{}, err
+	}
+
+	recomputedMac, err := computeMac(encKey, beta)
+	if err != nil {
+		return Hop{}, Commands{}, Header{}, err
+	}
+
+	if !hmac.Equal(recomputedMac, mac) {
+		return Hop{}, Commands{}, Header{}, ErrInvalidMac
+	}
+
+	if replays != nil {
+		tag, err := computeReplayTag(deriveSecretHash(sharedSecret.Bytes()))
+		if err != nil {
+			return Hop{}, Commands{}, Header{}, err
+		}
+		if replays.CheckAndRecord(tag) {
+			return Hop{}, Commands{}, Header{}, ErrReplayedPacket
+		}
+	}
+
+	blinder, err := computeLegacyBlindingFactor(aesS)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - computeBlindingFactor failed: %v", err)
+		return Hop{}, Commands{}, Header{}, errMsg
+	}
+
+	newAlpha := new(FieldElement)
+	curve25519.ScalarMult(newAlpha.el(), blinder.el(), alpha.el())
+
+	decBeta, err := AesCtr(encKey, beta)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - AES_CTR failed: %v", err)
+		return Hop{}, Commands{}, Header{}, errMsg
+	}
+
+	var routingInfo RoutingInfo
+	err = proto.Unmarshal(decBeta, &routingInfo)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - unmarshal of beta failed: %v", err)
+		return Hop{}, Commands{}, Header{}, errMsg
+	}
+	if routingInfo.NextHop == nil || routingInfo.RoutingCommands == nil {
+		return Hop{}, Commands{}, Header{}, errors.New("packet processing error: incomplete routing information")
+	}
+	nextHop, commands, nextBeta, nextMac := readBeta(routingInfo)
+
+	return nextHop, commands, Header{Alpha: newAlpha.Bytes(), Beta: nextBeta, Mac: nextMac}, nil
+}
+
+// processLegacySphinxPayload unwraps a single layer of the AES_CTR encryption from the legacy packet payload.
+// Note that, unlike the Lioness encryption, it offers no protection against tagging of the payload.
+func processLegacySphinxPayload(alpha []byte, payload []byte, privKey *PrivateKey) ([]byte, error) {
+	sharedSecret := new(FieldElement)
+	curve25519.ScalarMult(sharedSecret.el(), privKey.ToFieldElement().el(), BytesToFieldElement(alpha).el())
+
+	aesS, err := KDF(sharedSecret.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	decKey, err := KDF(aesS)
+	if err != nil {
+		return nil, err
+	}
+
+	decPayload, err := AesCtr(decKey, payload)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPayload - AES_CTR decryption failed: %v", err)
+		return nil, errMsg
+	}
+
+	return decPayload, nil
+}
+
+// computeLegacyBlindingFactor computes the blinding factor of the legacy packets by encrypting
+// a constant block with the hashed shared secret under a constant IV.
+func computeLegacyBlindingFactor(key []byte) (*FieldElement, error) {
+	iv := []byte("initialvector000")
+	blinderBytes, err := computeSharedSecretHash(key, iv)
+
+	if err != nil {
+		errMsg := fmt.Errorf("error in computeBlindingFactor - computeSharedSecretHash failed: %v", err)
+		return nil, errMsg
+	}
+
+	return BytesToFieldElement(blinderBytes), nil
+}
+
+// computeSharedSecretHash computes the hash value of the shared secret key
+// using AES_CTR.
+func computeSharedSecretHash(key []byte, iv []byte) ([]byte, error) {
+	aesCipher, err := aes.NewCipher(key)
+
+	if err != nil {
+		errMsg := fmt.Errorf("error in computeSharedSecretHash - creating new AES cipher failed: %v", err)
+		return nil, errMsg
+	}
+
+	stream := cipher.NewCTR(aesCipher, iv)
+	plaintext := []byte("0000000000000000")
+
+	ciphertext := make([]byte, len(plaintext))
+	stream.XORKeyStream(ciphertext, plaintext)
+
+	return ciphertext, nil
+}
+
+// readBeta extracts all the fields from the RoutingInfo structure
+func readBeta(beta RoutingInfo) (Hop, Commands, []byte, []byte) {
+	nextHop := *beta.NextHop
+	commands := *beta.RoutingCommands
+	nextBeta := beta.NextHopMetaData
+	nextMac := beta.Mac
+
+	return nextHop, commands, nextBeta, nextMac
+}
+
+// extractLegacyMessage recovers the message from the fully processed packet encoded in the legacy format.
+func extractLegacyMessage(packetBytes []byte) ([]byte, error) {
+	var packet SphinxPacket
+	if err := proto.Unmarshal(packetBytes, &packet); err != nil {
+		return nil, err
+	}
+	return packet.Pld, nil
+}
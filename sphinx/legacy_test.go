@@ -0,0 +1,211 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+// getLegacySharedSecrets computes the HeaderInitials in the way the legacy clients did,
+// i.e. with the shared secrets hashed by KDF and the blinding factors computed with a constant IV.
+func getLegacySharedSecrets(nodes []config.MixConfig, initialVal *FieldElement) ([]HeaderInitials, error) {
+	blindFactors := []*FieldElement{initialVal}
+	tuples := make([]HeaderInitials, len(nodes))
+	for i, n := range nodes {
+		alpha := expoGroupBase(blindFactors)
+		s := expo(BytesToPublicKey(n.PubKey).ToFieldElement(), blindFactors)
+
+		aesS, err := KDF(s.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		blinder, err := computeLegacyBlindingFactor(aesS)
+		if err != nil {
+			return nil, err
+		}
+
+		blindFactors = append(blindFactors, blinder)
+		tuples[i] = HeaderInitials{Alpha: alpha.Bytes(), Secret: s.Bytes(), Blinder: blinder.Bytes(), SecretHash: aesS}
+	}
+	return tuples, nil
+}
+
+func TestComputeLegacyBlindingFactor(t *testing.T) {
+	// basePoint is the x coordinate of the generator of the curve.
+	// So it's as good point as any for the computation
+	basePoint := [32]byte{9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	key, err := hash(basePoint[:])
+	assert.Nil(t, err)
+	b, err := computeLegacyBlindingFactor(key)
+	assert.Nil(t, err)
+
+	expected := [32]byte{0xd, 0xe6, 0xd2, 0x55, 0xc7, 0xde, 0x9a, 0x67, 0x16, 0x92, 0x2f, 0x5d, 0xe9, 0xee,
+		0x69, 0x44, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
+
+	assert.Equal(t, expected, b.bytes)
+}
+
+func TestProcessLegacySphinxHeader(t *testing.T) {
+	priv1, pub1, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	_, pub2, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	_, pub3, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	c1 := Commands{Delay: 0.34}
+	c2 := Commands{Delay: 0.25}
+	c3 := Commands{Delay: 1.10}
+
+	m1 := config.NewMixConfig("Node1", "localhost", "3331", pub1.Bytes(), 1)
+	m2 := config.NewMixConfig("Node2", "localhost", "3332", pub2.Bytes(), 2)
+	m3 := config.NewMixConfig("Node3", "localhost", "3333", pub3.Bytes(), 3)
+
+	nodes := []config.MixConfig{m1, m2, m3}
+
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	sharedSecrets, err := getLegacySharedSecrets(nodes, x)
+	assert.Nil(t, err)
+
+	// Intermediate steps, which are needed to check whether the processing of the header was correct
+	routing1 := RoutingInfo{NextHop: &Hop{Id: "DestinationId",
+		Address: "DestinationAddress", PubKey: []byte{},
+	}, RoutingCommands: &c3,
+		NextHopMetaData: []byte{},
+		Mac:             []byte{},
+	}
+
+	routing1Bytes, err := proto.Marshal(&routing1)
+	assert.Nil(t, err)
+
+	kdfRes, err := KDF(sharedSecrets[2].SecretHash)
+	assert.Nil(t, err)
+
+	encRouting1, err := AesCtr(kdfRes, routing1Bytes)
+	assert.Nil(t, err)
+
+	mac1, err := computeMac(kdfRes, encRouting1)
+	assert.Nil(t, err)
+
+	routing2 := RoutingInfo{NextHop: &Hop{Id: "Node3",
+		Address: "localhost:3333",
+		PubKey:  pub3.Bytes(),
+	}, RoutingCommands: &c2,
+		NextHopMetaData: encRouting1,
+		Mac:             mac1,
+	}
+
+	routing2Bytes, err := proto.Marshal(&routing2)
+	assert.Nil(t, err)
+
+	kdfRes, err = KDF(sharedSecrets[1].SecretHash)
+	assert.Nil(t, err)
+
+	encRouting2, err := AesCtr(kdfRes, routing2Bytes)
+	assert.Nil(t, err)
+
+	mac2, err := computeMac(kdfRes, encRouting2)
+	assert.Nil(t, err)
+
+	routing3 := RoutingInfo{NextHop: &Hop{Id: "Node2",
+		Address: "localhost:3332",
+		PubKey:  pub2.Bytes(),
+	}, RoutingCommands: &c1,
+		NextHopMetaData: encRouting2,
+		Mac:             mac2,
+	}
+
+	routing3Bytes, err := proto.Marshal(&routing3)
+	assert.Nil(t, err)
+
+	kdfRes, err = KDF(sharedSecrets[0].SecretHash)
+	assert.Nil(t, err)
+
+	encExpectedRouting, err := AesCtr(kdfRes, routing3Bytes)
+	assert.Nil(t, err)
+
+	mac3, err := computeMac(kdfRes, encExpectedRouting)
+	assert.Nil(t, err)
+
+	header := Header{Alpha: sharedSecrets[0].Alpha,
+		Beta: encExpectedRouting,
+		Mac:  mac3,
+	}
+
+	nextHop, newCommands, newHeader, err := processLegacySphinxHeader(header, priv1, nil)
+
+	assert.Nil(t, err)
+
+	assert.True(t, proto.Equal(&nextHop, &Hop{Id: "Node2", Address: "localhost:3332", PubKey: pub2.Bytes()}))
+	assert.True(t, proto.Equal(&newCommands, &c1))
+	assert.True(t, proto.Equal(&newHeader, &Header{Alpha: sharedSecrets[1].Alpha, Beta: encRouting2, Mac: mac2}))
+
+}
+
+func TestProcessSphinxPacketLegacyEncoding(t *testing.T) {
+	priv1, pub1, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	m1 := config.NewMixConfig("Node1", "localhost", "3331", pub1.Bytes(), 1)
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	sharedSecrets, err := getLegacySharedSecrets([]config.MixConfig{m1}, x)
+	assert.Nil(t, err)
+
+	c1 := Commands{Delay: 0.34, Flag: flags.LastHopFlag.Bytes()}
+	routing := RoutingInfo{NextHop: &Hop{Id: "DestinationId",
+		Address: "DestinationAddress", PubKey: []byte{},
+	}, RoutingCommands: &c1,
+		NextHopMetaData: []byte{},
+		Mac:             []byte{},
+	}
+	routingBytes, err := proto.Marshal(&routing)
+	assert.Nil(t, err)
+
+	kdfRes, err := KDF(sharedSecrets[0].SecretHash)
+	assert.Nil(t, err)
+	encRouting, err := AesCtr(kdfRes, routingBytes)
+	assert.Nil(t, err)
+	mac, err := computeMac(kdfRes, encRouting)
+	assert.Nil(t, err)
+
+	message := []byte("Plaintext message")
+	payload, err := AesCtr(kdfRes, message)
+	assert.Nil(t, err)
+
+	packet := SphinxPacket{Hdr: &Header{Alpha: sharedSecrets[0].Alpha, Beta: encRouting, Mac: mac}, Pld: payload}
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+	assert.NotEqual(t, DefaultParams.PacketSize(), len(packetBytes))
+
+	hop, commands, newPacketBytes, err := ProcessSphinxPacket(packetBytes, priv1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "DestinationId", hop.Id)
+	assert.Equal(t, c1.Delay, commands.Delay())
+	assert.Equal(t, DeliverCommand{}, commands.Action())
+
+	extracted, err := ExtractMessage(newPacketBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, message, extracted)
+}
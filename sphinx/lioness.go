@@ -0,0 +1,137 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// Lioness is a wide-block cipher built out of a stream cipher and a keyed hash function
+// (Anderson and Biham, "Two Practical and Provably Secure Block Ciphers: BEAR and LION").
+// Here the stream cipher is AES-256 in counter mode and the keyed hash is HMAC-SHA256.
+// Changing any bit of the ciphertext results in the entire block being decrypted to unpredictable garbage,
+// which is what makes the sphinx payload non-malleable.
+//
+// The block is split into the left part L of lionessHashSize bytes and the remaining right part R:
+//
+//	R = R ^ S(L ^ K1)
+//	L = L ^ H(K2, R)
+//	R = R ^ S(L ^ K3)
+//	L = L ^ H(K4, R)
+const (
+	// lionessHashSize defines the length of the output of the keyed hash and of the stream cipher key.
+	lionessHashSize = sha256.Size
+	// LionessKeySize defines the length of the key used by the Lioness cipher, i.e. its four round keys.
+	LionessKeySize = 4 * lionessHashSize
+	// LionessMinBlockSize defines the length of the shortest block that can be encrypted with Lioness.
+	LionessMinBlockSize = lionessHashSize + 1
+)
+
+var (
+	// ErrInvalidLionessKey is returned when the Lioness key does not have the expected length.
+	ErrInvalidLionessKey = errors.New("invalid lioness key length")
+	// ErrLionessBlockTooShort is returned when the block is too short to be encrypted with Lioness.
+	ErrLionessBlockTooShort = errors.New("block is too short for lioness")
+)
+
+// LionessEncrypt encrypts the given block using the Lioness wide-block cipher.
+// The returned ciphertext has the same length as the block.
+func LionessEncrypt(key, block []byte) ([]byte, error) {
+	if err := checkLionessInput(key, block); err != nil {
+		return nil, err
+	}
+	k1, k2, k3, k4 := splitLionessKey(key)
+
+	out := make([]byte, len(block))
+	copy(out, block)
+	l, r := out[:lionessHashSize], out[lionessHashSize:]
+
+	if err := lionessStreamRound(l, r, k1); err != nil {
+		return nil, err
+	}
+	lionessHashRound(l, r, k2)
+	if err := lionessStreamRound(l, r, k3); err != nil {
+		return nil, err
+	}
+	lionessHashRound(l, r, k4)
+	return out, nil
+}
+
+// LionessDecrypt decrypts the given block using the Lioness wide-block cipher.
+// The returned plaintext has the same length as the block.
+func LionessDecrypt(key, block []byte) ([]byte, error) {
+	out := make([]byte, len(block))
+	copy(out, block)
+	if err := lionessDecryptInPlace(key, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// lionessDecryptInPlace decrypts the given block using the Lioness wide-block cipher, overwriting it with the plaintext.
+func lionessDecryptInPlace(key, block []byte) error {
+	if err := checkLionessInput(key, block); err != nil {
+		return err
+	}
+	k1, k2, k3, k4 := splitLionessKey(key)
+	l, r := block[:lionessHashSize], block[lionessHashSize:]
+
+	lionessHashRound(l, r, k4)
+	if err := lionessStreamRound(l, r, k3); err != nil {
+		return err
+	}
+	lionessHashRound(l, r, k2)
+	return lionessStreamRound(l, r, k1)
+}
+
+func checkLionessInput(key, block []byte) error {
+	if len(key) != LionessKeySize {
+		return ErrInvalidLionessKey
+	}
+	if len(block) < LionessMinBlockSize {
+		return ErrLionessBlockTooShort
+	}
+	return nil
+}
+
+func splitLionessKey(key []byte) ([]byte, []byte, []byte, []byte) {
+	return key[:lionessHashSize],
+		key[lionessHashSize : 2*lionessHashSize],
+		key[2*lionessHashSize : 3*lionessHashSize],
+		key[3*lionessHashSize:]
+}
+
+// lionessStreamRound encrypts r in place with the stream keyed by l ^ k.
+func lionessStreamRound(l, r, k []byte) error {
+	block, err := aes.NewCipher(XorBytes(l, k))
+	if err != nil {
+		return err
+	}
+	stream := cipher.NewCTR(block, make([]byte, aes.BlockSize))
+	stream.XORKeyStream(r, r)
+	return nil
+}
+
+// lionessHashRound xors l in place with the keyed hash of r.
+func lionessHashRound(l, r, k []byte) {
+	mac := hmac.New(sha256.New, k)
+	// writes to hash.Hash never return an error
+	_, _ = mac.Write(r)
+	copy(l, XorBytes(l, mac.Sum(nil)))
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sequentialBytes(n int, mul, add byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)*mul + add
+	}
+	return b
+}
+
+func TestLionessKnownAnswers(t *testing.T) {
+	nymHash := sha256.Sum256([]byte("nym"))
+
+	vectors := []struct {
+		key        []byte
+		plaintext  []byte
+		ciphertext string
+	}{
+		{
+			key:        sequentialBytes(LionessKeySize, 1, 0),
+			plaintext:  sequentialBytes(33, 7, 3),
+			ciphertext: "eb1e8e82a8c91a0eafc72c3bca694c29bbe1f426a50babbaa5bd33dff5b0291046",
+		},
+		{
+			key:       sequentialBytes(LionessKeySize, 1, 0),
+			plaintext: sequentialBytes(64, 7, 3),
+			ciphertext: "3995cf99d23acaefe98a2450ab70d4c00901fb13fce727f31dcb199d16c7f96c" +
+				"e1f0943ff9f936c1fc4002872a36bd49da3423c8106f6ef2ed0c85c115ef4f48",
+		},
+		{
+			key:       sequentialBytes(LionessKeySize, 1, 0),
+			plaintext: sequentialBytes(100, 7, 3),
+			ciphertext: "e48236893bb490b345ae5ea1f4a225f6f5411298693d1cd7ac8994c1ce3e878e" +
+				"306a563d01dfbf3e0dd8871b7d7af9f9e6b9b3be3d5f0a9c64ce210097e40b93" +
+				"82d86334598d472098522be333e3dfe516a5c164dc06c1fa83c865f2f87ae6a9" +
+				"d4a69ee0",
+		},
+		{
+			key:        bytes.Repeat(nymHash[:], 4),
+			plaintext:  []byte("The quick brown fox jumps over the lazy dog"),
+			ciphertext: "b3afbbe3382ba0e21a9727ff8d3a0946aadffa9747fa12cd412ecdacf5866f0734b5ceaa25edbb02b5dda6",
+		},
+	}
+
+	for _, v := range vectors {
+		expected, err := hex.DecodeString(v.ciphertext)
+		assert.Nil(t, err)
+
+		ciphertext, err := LionessEncrypt(v.key, v.plaintext)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, ciphertext)
+
+		plaintext, err := LionessDecrypt(v.key, ciphertext)
+		assert.Nil(t, err)
+		assert.Equal(t, v.plaintext, plaintext)
+	}
+}
+
+func TestLionessDoesNotModifyInput(t *testing.T) {
+	key := sequentialBytes(LionessKeySize, 1, 0)
+	plaintext := sequentialBytes(PayloadSize, 7, 3)
+	plaintextCopy := append([]byte{}, plaintext...)
+
+	_, err := LionessEncrypt(key, plaintext)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintextCopy, plaintext)
+}
+
+func TestLionessBitFlipGarblesBlock(t *testing.T) {
+	key := sequentialBytes(LionessKeySize, 1, 0)
+	plaintext := make([]byte, PayloadSize)
+
+	ciphertext, err := LionessEncrypt(key, plaintext)
+	assert.Nil(t, err)
+
+	// flipping a single bit anywhere in the ciphertext should affect both halves of the decrypted block
+	for _, i := range []int{0, lionessHashSize, PayloadSize - 1} {
+		tampered := append([]byte{}, ciphertext...)
+		tampered[i] ^= 0x01
+
+		decrypted, err := LionessDecrypt(key, tampered)
+		assert.Nil(t, err)
+		assert.False(t, isZero(decrypted[:PayloadTagSize]))
+		assert.False(t, isZero(decrypted[PayloadSize-PayloadTagSize:]))
+	}
+}
+
+func TestLionessInvalidInput(t *testing.T) {
+	_, err := LionessEncrypt(make([]byte, LionessKeySize-1), make([]byte, PayloadSize))
+	assert.Equal(t, ErrInvalidLionessKey, err)
+
+	_, err = LionessDecrypt(make([]byte, LionessKeySize), make([]byte, LionessMinBlockSize-1))
+	assert.Equal(t, ErrLionessBlockTooShort, err)
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import "errors"
+
+const (
+	// DefaultMaxPathLength defines the default maximum number of hops that can be encoded in the header.
+	DefaultMaxPathLength = 5
+	// MaxPathLengthLimit defines the upper bound on the configurable maximum path length.
+	MaxPathLengthLimit = 16
+)
+
+var (
+	// ErrInvalidParams is returned when the packet format parameters are invalid.
+	ErrInvalidParams = errors.New("invalid sphinx parameters")
+
+	// DefaultParams are the packet format parameters used by the network unless configured otherwise.
+	//nolint: gochecknoglobals
+	DefaultParams = Params{MaxPathLength: DefaultMaxPathLength, Suite: X25519}
+)
+
+// Params defines the parameters of the packet format, which have to be shared by all the participants
+// of the network, as they determine the length of the packets.
+type Params struct {
+	// MaxPathLength defines the maximum number of hops that can be encoded in the header.
+	// Packets with any path length, from a single hop up to MaxPathLength hops, have the same length.
+	MaxPathLength int
+	// Suite defines the group in which the public key operations are performed. X25519 is used if it is nil.
+	Suite Suite
+}
+
+// CryptoSuite returns the suite of the parameters.
+func (p Params) CryptoSuite() Suite {
+	if p.Suite == nil {
+		return X25519
+	}
+	return p.Suite
+}
+
+// Validate checks whether the parameters are valid.
+func (p Params) Validate() error {
+	if p.MaxPathLength < 1 || p.MaxPathLength > MaxPathLengthLimit {
+		return ErrInvalidParams
+	}
+	return nil
+}
+
+// BetaSize returns the length of the encrypted routing information in the header.
+func (p Params) BetaSize() int {
+	return p.MaxPathLength * routingBlockSize
+}
+
+// HeaderSize returns the length of the encoded header.
+func (p Params) HeaderSize() int {
+	return FieldElementSize + p.BetaSize() + MacSize
+}
+
+// PacketSize returns the length of the encoded packet.
+func (p Params) PacketSize() int {
+	return versionSize + p.HeaderSize() + PayloadSize
+}
+
+// SURBSize returns the length of the encoded single-use reply block.
+func (p Params) SURBSize() int {
+	return routingBlockSize + p.HeaderSize() + SURBKeySize
+}
+
+// ParamsForPacketLength returns the parameters with the given suite, whose packets have the given length.
+// It returns ErrInvalidPacketLength if the length does not correspond to any valid maximum path length.
+func ParamsForPacketLength(length int, suite Suite) (Params, error) {
+	betaSize, ok := betaSizeFromHeaderSize(length - versionSize - PayloadSize)
+	if !ok {
+		return Params{}, ErrInvalidPacketLength
+	}
+	return Params{MaxPathLength: betaSize / routingBlockSize, Suite: suite}, nil
+}
+
+// betaSizeFromHeaderSize recovers the length of the routing information from the length of the encoded header.
+// It returns false if the header length does not correspond to any valid parameters.
+func betaSizeFromHeaderSize(headerSize int) (int, bool) {
+	betaSize := headerSize - FieldElementSize - MacSize
+	if !isValidBetaSize(betaSize) {
+		return 0, false
+	}
+	return betaSize, true
+}
+
+func isValidBetaSize(betaSize int) bool {
+	return betaSize > 0 &&
+		betaSize%routingBlockSize == 0 &&
+		betaSize/routingBlockSize <= MaxPathLengthLimit
+}
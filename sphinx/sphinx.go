@@ -20,45 +20,111 @@
 package sphinx
 
 import (
-	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
 	"errors"
 	"fmt"
-	"strings"
+	"io"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
-	"github.com/nymtech/nym-mixnet/flags"
-	"golang.org/x/crypto/curve25519"
 )
 
 const (
-	// K TODO: document padding-related Sphinx parameter
-	K            = 16
-	headerLength = 192
+	// K is the security parameter of the packet format, expressed in bytes.
+	// It defines the length of the symmetric keys and of the message authentication codes.
+	K = 16
+
+	// ReplayTagSize defines the length of the tag used for detecting replayed packets.
+	ReplayTagSize = 32
+)
+
+var (
+	// ErrReplayedPacket is returned when the processed packet was already seen by the node.
+	ErrReplayedPacket = errors.New("packet processing error: replayed packet detected")
+	// ErrInvalidPathLength is returned when the path is empty or longer than the maximum path length.
+	ErrInvalidPathLength = errors.New("invalid path length")
+	// ErrInvalidMac is returned when the MAC of the header does not match, i.e. the header was tampered with
+	// or the packet was processed with the key of another node.
+	ErrInvalidMac = errors.New("packet processing error: MACs are not matching")
 )
 
+// ReplayChecker is used during packet processing to detect packets that have already been processed.
+type ReplayChecker interface {
+	// CheckAndRecord reports whether the given tag was seen before. If it was not, it gets recorded.
+	CheckAndRecord(tag []byte) bool
+}
+
 // PackForwardMessage encapsulates the given message into the cryptographic Sphinx packet format.
 // As arguments the function takes the path, consisting of the sequence of nodes the packet should traverse
 // and the destination of the message, a set of delays and the information about the curve used to perform cryptographic
 // operations.
 // In order to encapsulate the message PackForwardMessage computes two parts of the packet - the header and
 // the encrypted payload. If creating of any of the packet block failed, an error is returned. Otherwise,
-// a Sphinx packet format is returned.
+// a Sphinx packet format is returned. PackForwardMessage uses the DefaultParams and crypto/rand.
 func PackForwardMessage(path config.E2EPath, delays []float64, message []byte) (SphinxPacket, error) {
-	nodes := []config.MixConfig{path.IngressProvider}
-	nodes = append(nodes, path.Mixes...)
-	nodes = append(nodes, path.EgressProvider)
-	dest := path.Recipient
+	return DefaultParams.PackForwardMessage(rand.Reader, path, delays, message)
+}
+
+// PackForwardMessage encapsulates the given message into the cryptographic Sphinx packet format
+// with the header sized according to the parameters. All the randomness used for creating the packet
+// is read from rng, so given the same random bytes the packet is reproduced byte for byte.
+func (p Params) PackForwardMessage(rng io.Reader,
+	path config.E2EPath,
+	delays []float64,
+	message []byte,
+) (SphinxPacket, error) {
+	nodes := path.Nodes()
+
+	if len(delays) < len(nodes) {
+		return SphinxPacket{}, fmt.Errorf("error in PackForwardMessage - expected %v delays, got %v",
+			len(nodes),
+			len(delays),
+		)
+	}
+	return p.packForwardMessage(rng, nodes, delays, path.Recipient, message, DeliverCommand{})
+}
 
-	headerInitials, header, err := createHeader(nodes, delays, dest)
+// PackMessageWithCommand encapsulates the given message into the cryptographic Sphinx packet format
+// like PackForwardMessage, but instead of delivering the message, the final hop gets the given command,
+// e.g. DropCommand for the cover traffic or LoopBackCommand for the loop cover traffic.
+func (p Params) PackMessageWithCommand(rng io.Reader,
+	nodes []config.MixConfig,
+	delays []float64,
+	dest config.ClientConfig,
+	message []byte,
+	finalCommand Command,
+) (SphinxPacket, error) {
+	if len(delays) < len(nodes) {
+		return SphinxPacket{}, fmt.Errorf("error in PackMessageWithCommand - expected %v delays, got %v",
+			len(nodes),
+			len(delays),
+		)
+	}
+	return p.packForwardMessage(rng, nodes, delays, dest, message, finalCommand)
+}
+
+// packForwardMessage encapsulates the message for the given sequence of nodes, which unlike the E2EPath
+// may consist of any number of nodes allowed by the parameters. The final hop gets the given command.
+func (p Params) packForwardMessage(rng io.Reader,
+	nodes []config.MixConfig,
+	delays []float64,
+	dest config.ClientConfig,
+	message []byte,
+	finalCommand Command,
+) (SphinxPacket, error) {
+	paddedMessage, err := padPayload(message)
+	if err != nil {
+		errMsg := fmt.Errorf("error in PackForwardMessage - padPayload failed: %v", err)
+		return SphinxPacket{}, errMsg
+	}
+
+	headerInitials, header, err := p.createHeader(rng, nodes, delays, dest, finalCommand)
 	if err != nil {
 		errMsg := fmt.Errorf("error in PackForwardMessage - createHeader failed: %v", err)
 		return SphinxPacket{}, errMsg
 	}
 
-	payload, err := encapsulateContent(headerInitials, message)
+	payload, err := encapsulateContent(headerInitials, paddedMessage)
 	if err != nil {
 		errMsg := fmt.Errorf("error in PackForwardMessage - encapsulateContent failed: %v", err)
 		return SphinxPacket{}, errMsg
@@ -73,19 +139,28 @@ func PackForwardMessage(path config.E2EPath, delays []float64, message []byte) (
 // and if relevant additional auxiliary information. The message authentication code allows to detect tagging attacks.
 // createHeader computes the secret shared key between sender and the nodes and destination,
 // which are used as keys for encryption.
+// The final node on the path receives the given finalCommand. The initial secret value, followed by the padding
+// of the routing information, is read from rng.
 // createHeader returns the header and a list of the initial elements, used for creating the header.
 // If any operation was unsuccessful createHeader returns an error.
-func createHeader(nodes []config.MixConfig,
+func (p Params) createHeader(rng io.Reader,
+	nodes []config.MixConfig,
 	delays []float64,
 	dest config.ClientConfig,
+	finalCommand Command,
 ) ([]HeaderInitials, Header, error) {
-	x, err := RandomElement()
+	if len(nodes) == 0 || len(nodes) > p.MaxPathLength {
+		return nil, Header{}, ErrInvalidPathLength
+	}
+
+	suite := p.CryptoSuite()
+	x, err := suite.RandomScalar(rng)
 	if err != nil {
 		errMsg := fmt.Errorf("error in createHeader - Random failed: %v", err)
 		return nil, Header{}, errMsg
 	}
 
-	headerInitials, err := getSharedSecrets(nodes, x)
+	headerInitials, err := getSharedSecrets(suite, nodes, x)
 	if err != nil {
 		errMsg := fmt.Errorf("error in createHeader - getSharedSecrets failed: %v", err)
 		return nil, Header{}, errMsg
@@ -96,18 +171,16 @@ func createHeader(nodes []config.MixConfig,
 		return nil, Header{}, errMsg
 	}
 
-	commands := make([]Commands, len(nodes))
+	commands := make([]CommandSet, len(nodes))
 	for i := range nodes {
-		var c Commands
 		if i == len(nodes)-1 {
-			c = Commands{Delay: delays[i], Flag: flags.LastHopFlag.Bytes()}
+			commands[i] = finalCommands(delays[i], finalCommand)
 		} else {
-			c = Commands{Delay: delays[i], Flag: flags.RelayFlag.Bytes()}
+			commands[i] = relayCommands(delays[i])
 		}
-		commands[i] = c
 	}
 
-	header, err := encapsulateHeader(headerInitials, nodes, commands, dest)
+	header, err := p.encapsulateHeader(rng, headerInitials, nodes, commands, dest)
 	if err != nil {
 		errMsg := fmt.Errorf("error in createHeader - encapsulateHeader failed: %v", err)
 		return nil, Header{}, errMsg
@@ -119,100 +192,127 @@ func createHeader(nodes []config.MixConfig,
 // encapsulateHeader layer encrypts the meta-data of the packet, containing information about the
 // sequence of nodes the packet should traverse before reaching the destination, and message authentication codes,
 // given the pre-computed shared keys which are used for encryption.
+// The routing information is encrypted such that each node, after removing its layer of encryption and
+// reading its own routing block, shifts the remaining information and pads it back to the constant length.
+// The padding appended by the nodes is predicted by the sender in the form of the filler,
+// so that the message authentication codes cover the entire routing information at every hop.
+// The random padding of the routing information is read from rng.
 // encapsulateHeader returns the Header, or an error if any internal cryptographic of parsing operation failed.
-func encapsulateHeader(headerInitials []HeaderInitials,
+func (p Params) encapsulateHeader(rng io.Reader,
+	headerInitials []HeaderInitials,
 	nodes []config.MixConfig,
-	commands []Commands,
+	commands []CommandSet,
 	destination config.ClientConfig,
 ) (Header, error) {
-	finalHop := RoutingInfo{NextHop: &Hop{Id: destination.Id,
+	if err := p.Validate(); err != nil {
+		return Header{}, err
+	}
+	if len(nodes) == 0 || len(nodes) > p.MaxPathLength {
+		return Header{}, ErrInvalidPathLength
+	}
+	if len(headerInitials) != len(nodes) || len(commands) != len(nodes) {
+		return Header{}, errors.New("error in encapsulateHeader - inconsistent number of hops")
+	}
+
+	betaSize := p.BetaSize()
+	filler, err := computeFiller(headerInitials, betaSize)
+	if err != nil {
+		errMsg := fmt.Errorf("error in encapsulateHeader - computeFiller failed: %v", err)
+		return Header{}, errMsg
+	}
+
+	finalHop := routingBlock{nextHop: Hop{Id: destination.Id,
 		Address: destination.Host + ":" + destination.Port,
 		PubKey:  []byte{},
-	}, RoutingCommands: &commands[len(commands)-1],
-		NextHopMetaData: []byte{},
-		Mac:             []byte{},
+	}, commands: commands[len(commands)-1],
+		mac: []byte{},
 	}
 
-	finalHopBytes, err := proto.Marshal(&finalHop)
+	finalHopBlock, err := encodeRoutingBlock(finalHop)
 	if err != nil {
+		errMsg := fmt.Errorf("error in encapsulateHeader - encoding final hop failed: %v", err)
+		return Header{}, errMsg
+	}
+
+	// the unused part of the routing information is filled with random bytes
+	// so that the final hop could not learn the length of the path
+	padding := make([]byte, betaSize-routingBlockSize-len(filler))
+	if _, err := io.ReadFull(rng, padding); err != nil {
 		return Header{}, err
 	}
 
-	kdfRes, err := KDF(headerInitials[len(headerInitials)-1].SecretHash)
+	keys, err := deriveHopKeys(headerInitials[len(headerInitials)-1].SecretHash)
 	if err != nil {
 		return Header{}, err
 	}
 
-	encFinalHop, err := AesCtr(kdfRes, finalHopBytes)
+	stream, err := keys.headerStream(betaSize)
 	if err != nil {
 		errMsg := fmt.Errorf("error in encapsulateHeader - AES_CTR encryption failed: %v", err)
 		return Header{}, errMsg
 	}
 
-	mac, err := computeMac(kdfRes, encFinalHop)
+	beta := XorBytes(append(finalHopBlock, padding...), stream[:betaSize-len(filler)])
+	beta = append(beta, filler...)
+
+	mac, err := computeHeaderMac(keys.headerMac, beta)
 	if err != nil {
 		return Header{}, err
 	}
 
-	routingCommands := [][]byte{encFinalHop}
-
-	var encRouting []byte
 	for i := len(nodes) - 2; i >= 0; i-- {
 		nextNode := nodes[i+1]
-		routing := RoutingInfo{NextHop: &Hop{Id: nextNode.Id,
+		routing := routingBlock{nextHop: Hop{Id: nextNode.Id,
 			Address: nextNode.Host + ":" + nextNode.Port,
-			PubKey:  nodes[i+1].PubKey,
-		}, RoutingCommands: &commands[i],
-			NextHopMetaData: routingCommands[len(routingCommands)-1],
-			Mac:             mac,
+			PubKey:  nextNode.PubKey,
+		}, commands: commands[i],
+			mac: mac,
 		}
 
-		encKey, err := KDF(headerInitials[i].SecretHash)
+		block, err := encodeRoutingBlock(routing)
 		if err != nil {
-			return Header{}, err
+			errMsg := fmt.Errorf("error in encapsulateHeader - encoding hop %v failed: %v", i, err)
+			return Header{}, errMsg
 		}
 
-		routingBytes, err := proto.Marshal(&routing)
+		keys, err := deriveHopKeys(headerInitials[i].SecretHash)
 		if err != nil {
 			return Header{}, err
 		}
 
-		encRouting, err = AesCtr(encKey, routingBytes)
+		stream, err := keys.headerStream(betaSize)
 		if err != nil {
 			return Header{}, err
 		}
 
-		routingCommands = append(routingCommands, encRouting)
-		kdfResL, err := KDF(headerInitials[i].SecretHash)
-		if err != nil {
-			return Header{}, nil
-		}
-		mac, err = computeMac(kdfResL, encRouting)
+		beta = XorBytes(append(block, beta[:betaSize-routingBlockSize]...), stream)
+
+		mac, err = computeHeaderMac(keys.headerMac, beta)
 		if err != nil {
 			return Header{}, err
 		}
-
 	}
-	return Header{Alpha: headerInitials[0].Alpha, Beta: encRouting, Mac: mac}, nil
-
+	return Header{Alpha: headerInitials[0].Alpha, Beta: beta, Mac: mac}, nil
 }
 
-// encapsulateContent layer encrypts the given messages using a set of shared keys
-// and the AES_CTR encryption.
-// encapsulateContent returns the encrypted payload in byte representation. If the AES_CTR
+// encapsulateContent layer encrypts the given padded message using a set of shared keys
+// and the Lioness wide-block cipher.
+// encapsulateContent returns the encrypted payload in byte representation. If the
 // encryption failed encapsulateContent returns an error.
 func encapsulateContent(headerInitials []HeaderInitials, message []byte) ([]byte, error) {
 
 	enc := message
 
 	for i := len(headerInitials) - 1; i >= 0; i-- {
-		sharedKey, err := KDF(headerInitials[i].SecretHash)
+		payloadKey, err := computePayloadKey(headerInitials[i].SecretHash)
 		if err != nil {
 			return nil, err
 		}
-		enc, err = AesCtr(sharedKey, enc)
+		// the nodes remove the layers of encryption by decrypting the payload,
+		// so the sender has to apply the inverse operation
+		enc, err = LionessEncrypt(payloadKey, enc)
 		if err != nil {
-			errMsg := fmt.Errorf("error in encapsulateContent - AES_CTR encryption failed: %v", err)
+			errMsg := fmt.Errorf("error in encapsulateContent - Lioness encryption failed: %v", err)
 			return nil, errMsg
 		}
 
@@ -220,11 +320,16 @@ func encapsulateContent(headerInitials []HeaderInitials, message []byte) ([]byte
 	return enc, nil
 }
 
+// computePayloadKey derives the Lioness key used for the payload encryption from the hashed shared secret.
+func computePayloadKey(secretHash []byte) ([]byte, error) {
+	return hkdfExpand(secretHash, payloadLabel, LionessKeySize)
+}
+
 // getSharedSecrets computes a sequence of HeaderInitial values, containing the initial elements,
-// shared secrets and blinding factors for each node on the path. As input getSharedSecrets takes the initial
-// secret value, the list of nodes, and the curve in which the cryptographic operations are performed.
+// shared secrets and blinding factors for each node on the path. As input getSharedSecrets takes the suite
+// in which the cryptographic operations are performed, the list of nodes, and the initial secret value.
 // getSharedSecrets returns the list of computed HeaderInitials or an error.
-func getSharedSecrets(nodes []config.MixConfig, initialVal *FieldElement) ([]HeaderInitials, error) {
+func getSharedSecrets(suite Suite, nodes []config.MixConfig, initialVal *FieldElement) ([]HeaderInitials, error) {
 
 	blindFactors := []*FieldElement{initialVal}
 	tuples := make([]HeaderInitials, len(nodes))
@@ -241,9 +346,14 @@ func getSharedSecrets(nodes []config.MixConfig, initialVal *FieldElement) ([]Hea
 		// tmp2 := tmp1^x2
 		// ...
 		// return tmp{n-1}^xn
-		alpha := expoGroupBase(blindFactors)
+		alpha, err := suiteExpoGroupBase(suite, blindFactors)
+		if err != nil {
+			errMsg := fmt.Errorf("error in getSharedSecrets - computing alpha failed: %v", err)
+			return nil, errMsg
+		}
 
-		if len(n.PubKey) != PublicKeySize {
+		pubKey, err := suite.DecodeElement(n.PubKey)
+		if err != nil {
 			errMsg := fmt.Errorf("invalid public key provided for node %v", i)
 			return nil, errMsg
 		}
@@ -259,126 +369,242 @@ func getSharedSecrets(nodes []config.MixConfig, initialVal *FieldElement) ([]Hea
 		// tmp2 := tmp1^x2
 		// ...
 		// return tmpn-1^xn
-		s := expo(BytesToPublicKey(n.PubKey).ToFieldElement(), blindFactors)
-
-		// TODO: move to the other crypto file?
-		aesS, err := KDF(s.Bytes())
+		s, err := suiteExpo(suite, pubKey, blindFactors)
 		if err != nil {
-			return nil, err
+			errMsg := fmt.Errorf("error in getSharedSecrets - computing the shared secret failed: %v", err)
+			return nil, errMsg
 		}
 
-		blinder, err := computeBlindingFactor(aesS)
+		secretHash := deriveSecretHash(s.Bytes())
+		blinder, err := suite.DeriveBlindingFactor(secretHash)
 		if err != nil {
-			errMsg := fmt.Errorf("error in getSharedSecrets - computeBlindingFactor failed: %v", err)
+			errMsg := fmt.Errorf("error in getSharedSecrets - DeriveBlindingFactor failed: %v", err)
 			return nil, errMsg
 		}
 
 		blindFactors = append(blindFactors, blinder)
-		tuples[i] = HeaderInitials{Alpha: alpha.Bytes(), Secret: s.Bytes(), Blinder: blinder.Bytes(), SecretHash: aesS}
+		tuples[i] = HeaderInitials{Alpha: alpha.Bytes(), Secret: s.Bytes(), Blinder: blinder.Bytes(), SecretHash: secretHash}
 	}
 	return tuples, nil
 
 }
 
-// TODO: computeFillers needs to be fixed
-func computeFillers(nodes []config.MixConfig, tuples []HeaderInitials) (string, error) {
-
-	filler := ""
-	minLen := headerLength - 32
-	for i := 1; i < len(nodes); i++ {
-		base := filler + strings.Repeat("\x00", K)
-		kx, err := computeSharedSecretHash(tuples[i-1].SecretHash, []byte("hrhohrhohrhohrho"))
+// computeFiller computes the filler, i.e. the bytes that are going to be appended to the routing information
+// by all but the last node on the path when they shift the routing information after reading their own routing block.
+// The filler has to be known by the sender in order to compute message authentication codes for the subsequent hops.
+// Each node appends a block of its own filler stream, which is then encrypted by all the subsequent nodes.
+func computeFiller(headerInitials []HeaderInitials, betaSize int) ([]byte, error) {
+	filler := []byte{}
+	for i := 0; i < len(headerInitials)-1; i++ {
+		keys, err := deriveHopKeys(headerInitials[i].SecretHash)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		mx := strings.Repeat("\x00", minLen) + base
-
-		xorVal, err := AesCtr(kx, []byte(mx))
+		stream, err := keys.headerStream(betaSize)
 		if err != nil {
-			errMsg := fmt.Errorf("error in computeFillers - AES_CTR failed: %v", err)
-			return "", errMsg
+			errMsg := fmt.Errorf("error in computeFiller - AES_CTR failed: %v", err)
+			return nil, errMsg
+		}
+		block, err := keys.fillerStream()
+		if err != nil {
+			errMsg := fmt.Errorf("error in computeFiller - AES_CTR failed: %v", err)
+			return nil, errMsg
 		}
 
-		filler = BytesToString(xorVal)
-		filler = filler[minLen:]
-
-		minLen -= K
+		filler = XorBytes(filler, stream[betaSize-len(filler):])
+		filler = append(filler, block...)
 	}
-
 	return filler, nil
-
 }
 
-// computeBlindingFactor computes the blinding factor extracted from the
-// shared secrets. Blinding factors allow both the sender and intermediate nodes
-// recompute the shared keys used at each hop of the message processing.
-// computeBlindingFactor returns a value of a blinding factor or an error.
-func computeBlindingFactor(key []byte) (*FieldElement, error) {
-	iv := []byte("initialvector000")
-	blinderBytes, err := computeSharedSecretHash(key, iv)
-
+// computeHeaderMac computes the message authentication code of the routing information.
+func computeHeaderMac(key, beta []byte) ([]byte, error) {
+	mac, err := computeMac(key, beta)
 	if err != nil {
-		errMsg := fmt.Errorf("error in computeBlindingFactor - computeSharedSecretHash failed: %v", err)
-		return nil, errMsg
+		return nil, err
 	}
+	return mac[:MacSize], nil
+}
 
-	return BytesToFieldElement(blinderBytes), nil
+// ProcessSphinxPacket processes the sphinx packet using the given private key.
+// ProcessSphinxPacket unwraps one layer of both the header and the payload encryption.
+// ProcessSphinxPacket returns a new packet and the routing information which should
+// be used by the processing node. If any cryptographic or parsing operation failed ProcessSphinxPacket
+// returns an error.
+// If the node is the final hop of the packet, the integrity of the decrypted payload is verified
+// and ErrPayloadIntegrity is returned if it was tampered with.
+// If replays is not nil, the replay tag of the verified packet is checked against it
+// and ErrReplayedPacket is returned if the packet has already been processed.
+// ProcessSphinxPacket accepts all the versions of the packet format registered in the DefaultRegistry
+// and returns ErrUnsupportedVersion for any other packets. The returned packet is encoded in the same version.
+func ProcessSphinxPacket(packetBytes []byte, privKey *PrivateKey, replays ReplayChecker) (Hop, CommandSet, []byte, error) {
+	return DefaultRegistry.ProcessPacket(packetBytes, privKey, replays)
 }
 
-// computeSharedSecretHash computes the hash value of the shared secret key
-// using AES_CTR.
-func computeSharedSecretHash(key []byte, iv []byte) ([]byte, error) {
-	aesCipher, err := aes.NewCipher(key)
+// ProcessSphinxPacket processes the sphinx packet using the given private key,
+// only accepting Version1 packets sized according to the parameters.
+func (p Params) ProcessSphinxPacket(packetBytes []byte,
+	privKey *PrivateKey,
+	replays ReplayChecker,
+) (Hop, CommandSet, []byte, error) {
+	return p.processSphinxPacket(packetBytes, privKey, replays, nil)
+}
 
+// processSphinxPacket performs the actual work of ProcessSphinxPacket. Unlike processing the header
+// and the payload separately, it computes the shared secret and derives the keys of the hop only once,
+// and it works directly on the encoded packet instead of unmarshalling it and marshalling the result.
+// The processed packet is written into out if its capacity suffices, otherwise a new slice is allocated.
+// out must not overlap with packetBytes.
+func (p Params) processSphinxPacket(packetBytes []byte,
+	privKey *PrivateKey,
+	replays ReplayChecker,
+	out []byte,
+) (Hop, CommandSet, []byte, error) {
+	if len(packetBytes) > 0 && Version(packetBytes[0]) != Version1 {
+		return Hop{}, nil, nil, ErrUnsupportedVersion
+	}
+	if len(packetBytes) != p.PacketSize() {
+		return Hop{}, nil, nil, ErrInvalidPacketLength
+	}
+
+	betaSize := p.BetaSize()
+	alphaOffset := versionSize
+	betaOffset := alphaOffset + FieldElementSize
+	macOffset := betaOffset + betaSize
+	payloadOffset := macOffset + MacSize
+	beta := packetBytes[betaOffset:macOffset]
+
+	suite := p.CryptoSuite()
+	alpha, err := suite.DecodeElement(packetBytes[alphaOffset:betaOffset])
 	if err != nil {
-		errMsg := fmt.Errorf("error in computeSharedSecretHash - creating new AES cipher failed: %v", err)
-		return nil, errMsg
+		return Hop{}, nil, nil, errors.New("packet processing error: invalid public element")
 	}
 
-	stream := cipher.NewCTR(aesCipher, iv)
-	plaintext := []byte("0000000000000000")
+	sharedSecret, err := suite.ScalarMult(privKey.ToFieldElement(), alpha)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - computing the shared secret failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
 
-	ciphertext := make([]byte, len(plaintext))
-	stream.XORKeyStream(ciphertext, plaintext)
+	secretHash := deriveSecretHash(sharedSecret.Bytes())
+	keys, err := deriveHopKeys(secretHash)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - deriveHopKeys failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
 
-	return ciphertext, nil
-}
+	recomputedMac, err := computeHeaderMac(keys.headerMac, beta)
+	if err != nil {
+		return Hop{}, nil, nil, err
+	}
+	if !hmac.Equal(recomputedMac, packetBytes[macOffset:payloadOffset]) {
+		return Hop{}, nil, nil, ErrInvalidMac
+	}
 
-// ProcessSphinxPacket processes the sphinx packet using the given private key.
-// ProcessSphinxPacket unwraps one layer of both the header and the payload encryption.
-// ProcessSphinxPacket returns a new packet and the routing information which should
-// be used by the processing node. If any cryptographic or parsing operation failed ProcessSphinxPacket
-// returns an error.
-func ProcessSphinxPacket(packetBytes []byte, privKey *PrivateKey) (Hop, Commands, []byte, error) {
+	blinder, err := suite.DeriveBlindingFactor(secretHash)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - DeriveBlindingFactor failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
+	newAlpha, err := suite.ScalarMult(blinder, alpha)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - blinding of alpha failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
 
-	var packet SphinxPacket
-	err := proto.Unmarshal(packetBytes, &packet)
+	if cap(out) < len(packetBytes) {
+		out = make([]byte, len(packetBytes))
+	}
+	out = out[:len(packetBytes)]
 
+	// the routing block of the hop is decrypted aside, while the remaining routing information
+	// is decrypted straight into its shifted position in the new header
+	headerCipher, err := newKeyStreamCipher(keys.headerEncryption)
 	if err != nil {
-		errMsg := fmt.Errorf("error in ProcessSphinxPacket - unmarshal of packet failed: %v", err)
-		return Hop{}, Commands{}, nil, errMsg
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - AES_CTR failed: %v", err)
+		return Hop{}, nil, nil, errMsg
 	}
+	var routingBytes [routingBlockSize]byte
+	headerCipher.XORKeyStream(routingBytes[:], beta[:routingBlockSize])
+	nextBeta := out[betaOffset:macOffset]
+	headerCipher.XORKeyStream(nextBeta[:betaSize-routingBlockSize], beta[routingBlockSize:])
 
-	hop, commands, newHeader, err := ProcessSphinxHeader(*packet.Hdr, privKey)
+	routing, err := decodeRoutingBlock(routingBytes[:])
 	if err != nil {
-		errMsg := fmt.Errorf("error in ProcessSphinxPacket - ProcessSphinxHeader failed: %v", err)
-		return Hop{}, Commands{}, nil, errMsg
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - decoding of routing block failed: %v", err)
+		return Hop{}, nil, nil, errMsg
 	}
 
-	newPayload, err := ProcessSphinxPayload(packet.Hdr.Alpha, packet.Pld, privKey)
+	// the shifted routing information is padded back to the constant length with the block
+	// of the filler stream, which the sender predicted when computing the filler
+	fillerCipher, err := newKeyStreamCipher(keys.filler)
 	if err != nil {
-		errMsg := fmt.Errorf("error in ProcessSphinxPacket - ProcessSphinxPayload failed: %v", err)
-		return Hop{}, Commands{}, nil, errMsg
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - AES_CTR failed: %v", err)
+		return Hop{}, nil, nil, errMsg
 	}
+	fillerBlock := nextBeta[betaSize-routingBlockSize:]
+	for i := range fillerBlock {
+		fillerBlock[i] = 0
+	}
+	fillerCipher.XORKeyStream(fillerBlock, fillerBlock)
 
-	newPacket := SphinxPacket{Hdr: &newHeader, Pld: newPayload}
-	newPacketBytes, err := proto.Marshal(&newPacket)
-	if err != nil {
-		errMsg := fmt.Errorf("error in ProcessSphinxPacket - marshal of packet failed: %v", err)
-		return Hop{}, Commands{}, nil, errMsg
+	newPayload := out[payloadOffset:]
+	copy(newPayload, packetBytes[payloadOffset:])
+	if err := lionessDecryptInPlace(keys.payload, newPayload); err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPacket - Lioness decryption failed: %v", err)
+		return Hop{}, nil, nil, errMsg
+	}
+	if verifiesPayload(routing.commands.Action()) && !checkPayloadIntegrity(newPayload) {
+		return Hop{}, nil, nil, ErrPayloadIntegrity
+	}
+
+	// the tag is only recorded once the packet was verified, so that neither garbage packets could fill
+	// the checker nor a copy with a tampered payload could burn the tag of the genuine packet
+	if replays != nil {
+		tag, err := computeReplayTag(secretHash)
+		if err != nil {
+			return Hop{}, nil, nil, err
+		}
+		if replays.CheckAndRecord(tag) {
+			return Hop{}, nil, nil, ErrReplayedPacket
+		}
 	}
 
-	return hop, commands, newPacketBytes, nil
+	out[0] = byte(Version1)
+	copy(out[alphaOffset:betaOffset], newAlpha.Bytes())
+	copy(out[macOffset:payloadOffset], routing.mac)
+
+	return routing.nextHop, routing.commands, out, nil
+}
+
+// verifiesPayload reports whether the final hop receiving the command decrypts the whole payload
+// and so can verify its integrity.
+func verifiesPayload(action Command) bool {
+	switch action.(type) {
+	case DeliverCommand, DropCommand, LoopBackCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExtractMessage recovers the message from the packet that has been processed by its final hop,
+// i.e. once all layers of the payload encryption have been removed.
+// ExtractMessage accepts all the versions of the packet format registered in the DefaultRegistry.
+func ExtractMessage(packetBytes []byte) ([]byte, error) {
+	return DefaultRegistry.ExtractMessage(packetBytes)
+}
+
+// extractMessage recovers the message from the fully processed Version1 packet.
+func extractMessage(packetBytes []byte) ([]byte, error) {
+	var packet SphinxPacket
+	if err := packet.UnmarshalBinary(packetBytes); err != nil {
+		return nil, err
+	}
+	if !checkPayloadIntegrity(packet.Pld) {
+		return nil, ErrPayloadIntegrity
+	}
+	return unpadPayload(packet.Pld)
 }
 
 // ProcessSphinxHeader unwraps one layer of encryption from the header of a sphinx packet.
@@ -388,88 +614,129 @@ func ProcessSphinxPacket(packetBytes []byte, privKey *PrivateKey) (Hop, Commands
 // Next, ProcessSphinxHeader extracts the routing information from the decrypted packet and returns it,
 // together with the updated init public element.
 // If any crypto or parsing operation failed ProcessSphinxHeader returns an error.
-func ProcessSphinxHeader(packet Header, privKey *PrivateKey) (Hop, Commands, Header, error) {
-	alpha := BytesToFieldElement(packet.Alpha)
+// ProcessSphinxHeader performs the operations in the X25519 suite.
+func ProcessSphinxHeader(packet Header, privKey *PrivateKey) (Hop, CommandSet, Header, error) {
+	return processSphinxHeader(X25519, packet, privKey, nil)
+}
+
+// processSphinxHeader performs the actual work of ProcessSphinxHeader in the given suite. Once the MAC is verified,
+// the replay tag of the header is checked against the provided ReplayChecker, unless it is nil.
+func processSphinxHeader(suite Suite,
+	packet Header,
+	privKey *PrivateKey,
+	replays ReplayChecker,
+) (Hop, CommandSet, Header, error) {
+	if !isValidHeader(&packet) {
+		return Hop{}, nil, Header{}, errors.New("packet processing error: invalid header length")
+	}
+	betaSize := len(packet.Beta)
+
+	alpha, err := suite.DecodeElement(packet.Alpha)
+	if err != nil {
+		return Hop{}, nil, Header{}, errors.New("packet processing error: invalid public element")
+	}
 	beta := packet.Beta
 	mac := packet.Mac
 
-	sharedSecret := new(FieldElement)
-	curve25519.ScalarMult(sharedSecret.el(), privKey.ToFieldElement().el(), alpha.el())
-
-	aesS, err := KDF(sharedSecret.Bytes())
+	sharedSecret, err := suite.ScalarMult(privKey.ToFieldElement(), alpha)
 	if err != nil {
-		return Hop{}, Commands{}, Header{}, err
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - computing the shared secret failed: %v", err)
+		return Hop{}, nil, Header{}, errMsg
 	}
-	encKey, err := KDF(aesS)
+
+	secretHash := deriveSecretHash(sharedSecret.Bytes())
+	keys, err := deriveHopKeys(secretHash)
 	if err != nil {
-		return Hop{}, Commands{}, Header{}, err
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - deriveHopKeys failed: %v", err)
+		return Hop{}, nil, Header{}, errMsg
 	}
 
-	recomputedMac, err := computeMac(encKey, beta)
+	recomputedMac, err := computeHeaderMac(keys.headerMac, beta)
 	if err != nil {
-		return Hop{}, Commands{}, Header{}, err
+		return Hop{}, nil, Header{}, err
 	}
 
-	if !bytes.Equal(recomputedMac, mac) {
-		return Hop{}, Commands{}, Header{}, errors.New("packet processing error: MACs are not matching")
+	if !hmac.Equal(recomputedMac, mac) {
+		return Hop{}, nil, Header{}, ErrInvalidMac
 	}
 
-	blinder, err := computeBlindingFactor(aesS)
-	if err != nil {
-		errMsg := fmt.Errorf("error in ProcessSphinxHeader - computeBlindingFactor failed: %v", err)
-		return Hop{}, Commands{}, Header{}, errMsg
+	// the tag is only recorded after the MAC was verified so that garbage packets could not fill the checker
+	if replays != nil {
+		tag, err := computeReplayTag(secretHash)
+		if err != nil {
+			return Hop{}, nil, Header{}, err
+		}
+		if replays.CheckAndRecord(tag) {
+			return Hop{}, nil, Header{}, ErrReplayedPacket
+		}
 	}
 
-	newAlpha := new(FieldElement)
-	curve25519.ScalarMult(newAlpha.el(), blinder.el(), alpha.el())
+	blinder, err := suite.DeriveBlindingFactor(secretHash)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - DeriveBlindingFactor failed: %v", err)
+		return Hop{}, nil, Header{}, errMsg
+	}
+	newAlpha, err := suite.ScalarMult(blinder, alpha)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - blinding of alpha failed: %v", err)
+		return Hop{}, nil, Header{}, errMsg
+	}
 
-	decBeta, err := AesCtr(encKey, beta)
+	stream, err := keys.headerStream(betaSize)
 	if err != nil {
 		errMsg := fmt.Errorf("error in ProcessSphinxHeader - AES_CTR failed: %v", err)
-		return Hop{}, Commands{}, Header{}, errMsg
+		return Hop{}, nil, Header{}, errMsg
 	}
+	decBeta := XorBytes(beta, stream)
 
-	var routingInfo RoutingInfo
-	err = proto.Unmarshal(decBeta, &routingInfo)
+	routing, err := decodeRoutingBlock(decBeta[:routingBlockSize])
 	if err != nil {
-		errMsg := fmt.Errorf("error in ProcessSphinxHeader - unmarshal of beta failed: %v", err)
-		return Hop{}, Commands{}, Header{}, errMsg
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - decoding of routing block failed: %v", err)
+		return Hop{}, nil, Header{}, errMsg
 	}
-	nextHop, commands, nextBeta, nextMac := readBeta(routingInfo)
 
-	return nextHop, commands, Header{Alpha: newAlpha.Bytes(), Beta: nextBeta, Mac: nextMac}, nil
-}
+	// the shifted routing information is padded back to the constant length with the block
+	// of the filler stream, which the sender predicted when computing the filler
+	fillerBlock, err := keys.fillerStream()
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxHeader - AES_CTR failed: %v", err)
+		return Hop{}, nil, Header{}, errMsg
+	}
 
-// readBeta extracts all the fields from the RoutingInfo structure
-func readBeta(beta RoutingInfo) (Hop, Commands, []byte, []byte) {
-	nextHop := *beta.NextHop
-	commands := *beta.RoutingCommands
-	nextBeta := beta.NextHopMetaData
-	nextMac := beta.Mac
+	nextBeta := append(decBeta[routingBlockSize:], fillerBlock...)
 
-	return nextHop, commands, nextBeta, nextMac
+	return routing.nextHop, routing.commands, Header{Alpha: newAlpha.Bytes(), Beta: nextBeta, Mac: routing.mac}, nil
 }
 
 // ProcessSphinxPayload unwraps a single layer of the encryption from the sphinx packet payload.
-// ProcessSphinxPayload first recomputes the shared secret which is used to perform the AES_CTR decryption.
+// ProcessSphinxPayload first recomputes the shared secret which is used to derive the key
+// for the Lioness decryption.
 // ProcessSphinxPayload returns the new packet payload or an error if the decryption failed.
+// ProcessSphinxPayload performs the operations in the X25519 suite.
 func ProcessSphinxPayload(alpha []byte, payload []byte, privKey *PrivateKey) ([]byte, error) {
-	sharedSecret := new(FieldElement)
-	curve25519.ScalarMult(sharedSecret.el(), privKey.ToFieldElement().el(), BytesToFieldElement(alpha).el())
+	return processSphinxPayload(X25519, alpha, payload, privKey)
+}
 
-	aesS, err := KDF(sharedSecret.Bytes())
+// processSphinxPayload performs the actual work of ProcessSphinxPayload in the given suite.
+func processSphinxPayload(suite Suite, alpha []byte, payload []byte, privKey *PrivateKey) ([]byte, error) {
+	alphaElement, err := suite.DecodeElement(alpha)
 	if err != nil {
 		return nil, err
 	}
+	sharedSecret, err := suite.ScalarMult(privKey.ToFieldElement(), alphaElement)
+	if err != nil {
+		errMsg := fmt.Errorf("error in ProcessSphinxPayload - computing the shared secret failed: %v", err)
+		return nil, errMsg
+	}
 
-	decKey, err := KDF(aesS)
+	payloadKey, err := computePayloadKey(deriveSecretHash(sharedSecret.Bytes()))
 	if err != nil {
 		return nil, err
 	}
 
-	decPayload, err := AesCtr(decKey, payload)
+	decPayload, err := LionessDecrypt(payloadKey, payload)
 	if err != nil {
-		errMsg := fmt.Errorf("error in ProcessSphinxPayload - AES_CTR decryption failed: %v", err)
+		errMsg := fmt.Errorf("error in ProcessSphinxPayload - Lioness decryption failed: %v", err)
 		return nil, errMsg
 	}
 
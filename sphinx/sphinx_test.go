@@ -15,12 +15,15 @@
 package sphinx
 
 import (
+	"bytes"
 	"crypto/aes"
+	"crypto/rand"
 	"fmt"
 	"os"
+	"runtime"
+	"sync"
 	"testing"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/curve25519"
@@ -86,26 +89,6 @@ func TestGetAESKey(t *testing.T) {
 
 }
 
-func TestComputeBlindingFactor(t *testing.T) {
-	// TODO: FIXME: currently I cannot test whether what we produce is actually the expected value
-	// as I don't have any reference output for any input on this curve
-
-	// basePoint is the x coordinate of the generator of the curve.
-	// So it's as good point as any for the computation
-	basePoint := [32]byte{9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-
-	key, err := hash(basePoint[:])
-	assert.Nil(t, err)
-	b, err := computeBlindingFactor(key)
-	assert.Nil(t, err)
-
-	// I'M NOT SURE OF THAT EXPECTED VALUE
-	expected := [32]byte{0xd, 0xe6, 0xd2, 0x55, 0xc7, 0xde, 0x9a, 0x67, 0x16, 0x92, 0x2f, 0x5d, 0xe9, 0xee,
-		0x69, 0x44, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
-
-	assert.Equal(t, expected, b.bytes)
-}
-
 func TestGetSharedSecrets(t *testing.T) {
 	_, pub1, err := GenerateKeyPair()
 	assert.Nil(t, err)
@@ -127,7 +110,7 @@ func TestGetSharedSecrets(t *testing.T) {
 	x, err := RandomElement()
 	assert.Nil(t, err)
 
-	result, err := getSharedSecrets(nodes, x)
+	result, err := getSharedSecrets(X25519, nodes, x)
 	assert.Nil(t, err)
 
 	var expected []HeaderInitials
@@ -137,9 +120,8 @@ func TestGetSharedSecrets(t *testing.T) {
 	alpha0 := new(FieldElement)
 	curve25519.ScalarBaseMult(alpha0.el(), v.el()) // alpha0 = g^x
 	s0 := expo(pubs[0].ToFieldElement(), blindFactors)
-	aesS0, err := KDF(s0.Bytes())
-	assert.Nil(t, err)
-	b0, err := computeBlindingFactor(aesS0)
+	aesS0 := deriveSecretHash(s0.Bytes())
+	b0, err := X25519.DeriveBlindingFactor(aesS0)
 	assert.Nil(t, err)
 
 	expected = append(expected, HeaderInitials{Alpha: alpha0.Bytes(),
@@ -152,9 +134,8 @@ func TestGetSharedSecrets(t *testing.T) {
 	alpha1 := new(FieldElement)
 	curve25519.ScalarMult(alpha1.el(), b0.el(), alpha0.el()) // alpha1 = g^(x * b0)
 	s1 := expo(pubs[1].ToFieldElement(), blindFactors)
-	aesS1, err := KDF(s1.Bytes())
-	assert.Nil(t, err)
-	b1, err := computeBlindingFactor(aesS1)
+	aesS1 := deriveSecretHash(s1.Bytes())
+	b1, err := X25519.DeriveBlindingFactor(aesS1)
 	assert.Nil(t, err)
 
 	expected = append(expected, HeaderInitials{Alpha: alpha1.Bytes(),
@@ -167,9 +148,8 @@ func TestGetSharedSecrets(t *testing.T) {
 	alpha2 := new(FieldElement)
 	curve25519.ScalarMult(alpha2.el(), b1.el(), alpha1.el()) // alpha2 = g^(x * b0 * b1)
 	s2 := expo(pubs[2].ToFieldElement(), blindFactors)
-	aesS2, err := KDF(s2.Bytes())
-	assert.Nil(t, err)
-	b2, err := computeBlindingFactor(aesS2)
+	aesS2 := deriveSecretHash(s2.Bytes())
+	b2, err := X25519.DeriveBlindingFactor(aesS2)
 	assert.Nil(t, err)
 
 	expected = append(expected, HeaderInitials{Alpha: alpha2.Bytes(),
@@ -183,32 +163,41 @@ func TestGetSharedSecrets(t *testing.T) {
 	assert.Equal(t, expected, result)
 }
 
-func TestComputeFillers(t *testing.T) {
-	basePoint := [32]byte{9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+func TestComputeFiller(t *testing.T) {
+	privs, nodes := createTestNodes(t, DefaultMaxPathLength)
 
-	h1 := HeaderInitials{Alpha: []byte{}, Secret: basePoint[:], Blinder: []byte{}, SecretHash: []byte("1111111111111111")}
-	h2 := HeaderInitials{Alpha: []byte{}, Secret: basePoint[:], Blinder: []byte{}, SecretHash: []byte("1111111111111111")}
-	h3 := HeaderInitials{Alpha: []byte{}, Secret: basePoint[:], Blinder: []byte{}, SecretHash: []byte("1111111111111111")}
-	tuples := []HeaderInitials{h1, h2, h3}
-
-	_, pub1, err := GenerateKeyPair()
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	headerInitials, err := getSharedSecrets(X25519, nodes, x)
 	assert.Nil(t, err)
 
-	_, pub2, err := GenerateKeyPair()
+	filler, err := computeFiller(headerInitials, DefaultParams.BetaSize())
 	assert.Nil(t, err)
+	assert.Len(t, filler, (len(nodes)-1)*routingBlockSize)
 
-	_, pub3, err := GenerateKeyPair()
+	commands := make([]CommandSet, len(nodes))
+	header, err := DefaultParams.encapsulateHeader(rand.Reader, headerInitials, nodes, commands, testDestination())
 	assert.Nil(t, err)
 
-	m1 := config.MixConfig{Id: "", Host: "", Port: "", PubKey: pub1.Bytes()}
-	m2 := config.MixConfig{Id: "", Host: "", Port: "", PubKey: pub2.Bytes()}
-	m3 := config.MixConfig{Id: "", Host: "", Port: "", PubKey: pub3.Bytes()}
+	// after being processed by all but the last node, the routing information should end with the filler
+	for i := 0; i < len(nodes)-1; i++ {
+		_, _, header, err = ProcessSphinxHeader(header, privs[i])
+		assert.Nil(t, err)
+	}
+	assert.True(t, bytes.HasSuffix(header.Beta, filler))
+}
 
-	fillers, err := computeFillers([]config.MixConfig{m1, m2, m3}, tuples)
-	assert.Nil(t, err)
+func TestComputeFillerSingleHop(t *testing.T) {
+	_, nodes := createTestNodes(t, 1)
 
-	fmt.Println("FILLER: ", fillers)
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	headerInitials, err := getSharedSecrets(X25519, nodes, x)
+	assert.Nil(t, err)
 
+	filler, err := computeFiller(headerInitials, DefaultParams.BetaSize())
+	assert.Nil(t, err)
+	assert.Empty(t, filler)
 }
 
 func TestXorBytesPass(t *testing.T) {
@@ -221,121 +210,194 @@ func TestXorBytesFail(t *testing.T) {
 	assert.NotEqual(t, []byte("00000"), result)
 }
 
+func createTestNodes(t testing.TB, n int) ([]*PrivateKey, []config.MixConfig) {
+	privs := make([]*PrivateKey, n)
+	nodes := make([]config.MixConfig, n)
+	for i := 0; i < n; i++ {
+		priv, pub, err := GenerateKeyPair()
+		assert.Nil(t, err)
+		privs[i] = priv
+		nodes[i] = config.NewMixConfig(fmt.Sprintf("Node%d", i+1),
+			"localhost",
+			fmt.Sprintf("%d", 3331+i),
+			pub.Bytes(),
+			uint(i+1),
+		)
+	}
+	return privs, nodes
+}
+
+func testDestination() config.ClientConfig {
+	return config.ClientConfig{Id: "DestinationId", Host: "DestinationAddress", Port: "9998"}
+}
+
 func TestEncapsulateHeader(t *testing.T) {
-	_, pub1, err := GenerateKeyPair()
-	assert.Nil(t, err)
+	_, nodes := createTestNodes(t, 3)
 
-	_, pub2, err := GenerateKeyPair()
-	assert.Nil(t, err)
+	c1 := relayCommands(0.34)
+	c2 := relayCommands(0.25)
+	c3 := finalCommands(1.10, DropCommand{})
+	commands := []CommandSet{c1, c2, c3}
 
-	_, pub3, err := GenerateKeyPair()
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	sharedSecrets, err := getSharedSecrets(X25519, nodes, x)
 	assert.Nil(t, err)
 
-	_, pubD, err := GenerateKeyPair()
+	actualHeader, err := DefaultParams.encapsulateHeader(rand.Reader, sharedSecrets, nodes, commands, testDestination())
 	assert.Nil(t, err)
 
-	m1 := config.NewMixConfig("Node1", "localhost", "3331", pub1.Bytes(), 1)
-	m2 := config.NewMixConfig("Node2", "localhost", "3332", pub2.Bytes(), 2)
-	m3 := config.NewMixConfig("Node3", "localhost", "3333", pub3.Bytes(), 3)
+	assert.Equal(t, sharedSecrets[0].Alpha, actualHeader.Alpha)
+	assert.Len(t, actualHeader.Beta, DefaultParams.BetaSize())
+	assert.Len(t, actualHeader.Mac, MacSize)
 
-	nodes := []config.MixConfig{m1, m2, m3}
+	keys, err := deriveHopKeys(sharedSecrets[0].SecretHash)
+	assert.Nil(t, err)
+	expectedMac, err := computeHeaderMac(keys.headerMac, actualHeader.Beta)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedMac, actualHeader.Mac)
 
-	c1 := Commands{Delay: 0.34, Flag: []byte("0")}
-	c2 := Commands{Delay: 0.25, Flag: []byte("1")}
-	c3 := Commands{Delay: 1.10, Flag: []byte("1")}
-	commands := []Commands{c1, c2, c3}
+	// the first routing block should contain the information about the second node
+	stream, err := keys.headerStream(DefaultParams.BetaSize())
+	assert.Nil(t, err)
+	firstBlock := XorBytes(actualHeader.Beta[:routingBlockSize], stream[:routingBlockSize])
+	routing, err := decodeRoutingBlock(firstBlock)
+	assert.Nil(t, err)
+	assert.Equal(t, Hop{Id: "Node2", Address: "localhost:3332", PubKey: nodes[1].PubKey}, routing.nextHop)
+	assert.Equal(t, c1, routing.commands)
+}
+
+func TestEncapsulateHeaderInvalidPathLength(t *testing.T) {
+	_, nodes := createTestNodes(t, DefaultMaxPathLength+1)
 
 	x, err := RandomElement()
 	assert.Nil(t, err)
-	sharedSecrets, err := getSharedSecrets(nodes, x)
+	sharedSecrets, err := getSharedSecrets(X25519, nodes, x)
 	assert.Nil(t, err)
 
-	actualHeader, err := encapsulateHeader(sharedSecrets, nodes, commands,
-		config.ClientConfig{Id: "DestinationId", Host: "DestinationAddress", Port: "9998", PubKey: pubD.Bytes()})
+	commands := make([]CommandSet, len(nodes))
+	_, err = DefaultParams.encapsulateHeader(rand.Reader, sharedSecrets, nodes, commands, testDestination())
+	assert.Equal(t, ErrInvalidPathLength, err)
 
-	assert.Nil(t, err)
+	_, err = DefaultParams.encapsulateHeader(rand.Reader, nil, nil, nil, testDestination())
+	assert.Equal(t, ErrInvalidPathLength, err)
+}
 
-	routing1 := RoutingInfo{NextHop: &Hop{Id: "DestinationId",
-		Address: "DestinationAddress:9998",
-		PubKey:  []byte{},
-	}, RoutingCommands: &c3,
-		NextHopMetaData: []byte{},
-		Mac:             []byte{},
-	}
+func TestProcessSphinxHeader(t *testing.T) {
+	privs, nodes := createTestNodes(t, 3)
 
-	routing1Bytes, err := proto.Marshal(&routing1)
-	assert.Nil(t, err)
+	c1 := relayCommands(0.34)
+	c2 := relayCommands(0.25)
+	c3 := finalCommands(1.10, DeliverCommand{})
+	commands := []CommandSet{c1, c2, c3}
 
-	kdfRes, err := KDF(sharedSecrets[2].SecretHash)
+	x, err := RandomElement()
 	assert.Nil(t, err)
-	encRouting1, err := AesCtr(kdfRes, routing1Bytes)
+	sharedSecrets, err := getSharedSecrets(X25519, nodes, x)
 	assert.Nil(t, err)
 
-	mac1, err := computeMac(kdfRes, encRouting1)
+	header, err := DefaultParams.encapsulateHeader(rand.Reader, sharedSecrets, nodes, commands, testDestination())
 	assert.Nil(t, err)
 
-	routing2 := RoutingInfo{NextHop: &Hop{Id: "Node3",
-		Address: "localhost:3333",
-		PubKey:  pub3.Bytes(),
-	}, RoutingCommands: &c2,
-		NextHopMetaData: encRouting1,
-		Mac:             mac1,
+	expectedHops := []Hop{
+		{Id: "Node2", Address: "localhost:3332", PubKey: nodes[1].PubKey},
+		{Id: "Node3", Address: "localhost:3333", PubKey: nodes[2].PubKey},
+		{Id: "DestinationId", Address: "DestinationAddress:9998"},
 	}
 
-	routing2Bytes, err := proto.Marshal(&routing2)
-	assert.Nil(t, err)
+	for i := range nodes {
+		nextHop, newCommands, newHeader, err := ProcessSphinxHeader(header, privs[i])
+		assert.Nil(t, err)
 
-	kdfRes, err = KDF(sharedSecrets[1].SecretHash)
-	assert.Nil(t, err)
+		assert.Equal(t, expectedHops[i], nextHop)
+		assert.Equal(t, commands[i], newCommands)
+
+		// the header keeps its size at every hop
+		assert.Len(t, newHeader.Alpha, FieldElementSize)
+		assert.Len(t, newHeader.Beta, DefaultParams.BetaSize())
+		assert.Len(t, newHeader.Mac, MacSize)
+		if i < len(nodes)-1 {
+			assert.Equal(t, sharedSecrets[i+1].Alpha, newHeader.Alpha)
+		}
+		header = newHeader
+	}
+}
+
+func TestProcessSphinxHeaderInvalidMac(t *testing.T) {
+	privs, nodes := createTestNodes(t, 3)
 
-	encRouting2, err := AesCtr(kdfRes, routing2Bytes)
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	sharedSecrets, err := getSharedSecrets(X25519, nodes, x)
 	assert.Nil(t, err)
 
-	mac2, err := computeMac(kdfRes, encRouting2)
+	header, err := DefaultParams.encapsulateHeader(rand.Reader, sharedSecrets, nodes, make([]CommandSet, 3), testDestination())
 	assert.Nil(t, err)
 
-	expectedRouting := RoutingInfo{NextHop: &Hop{Id: "Node2",
-		Address: "localhost:3332",
-		PubKey:  pub2.Bytes(),
-	}, RoutingCommands: &c1,
-		NextHopMetaData: encRouting2,
-		Mac:             mac2,
-	}
+	header.Beta[42] ^= 0x01
+	_, _, _, err = ProcessSphinxHeader(header, privs[0])
+	assert.Equal(t, ErrInvalidMac, err)
+}
 
-	expectedRoutingBytes, err := proto.Marshal(&expectedRouting)
-	assert.Nil(t, err)
+func TestPackAndProcessSphinxPacket(t *testing.T) {
+	privs, nodes := createTestNodes(t, 5)
+	message := []byte("Plaintext message")
 
-	kdfRes, err = KDF(sharedSecrets[0].SecretHash)
+	path := config.E2EPath{IngressProvider: nodes[0],
+		Mixes:          nodes[1:4],
+		EgressProvider: nodes[4],
+		Recipient:      testDestination(),
+	}
+	packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3, 0.4, 0.5}, message)
 	assert.Nil(t, err)
 
-	encExpectedRouting, err := AesCtr(kdfRes, expectedRoutingBytes)
+	packetBytes, err := packet.MarshalBinary()
 	assert.Nil(t, err)
+	assert.Len(t, packetBytes, DefaultParams.PacketSize())
+
+	for i, priv := range privs {
+		hop, commands, newPacketBytes, err := ProcessSphinxPacket(packetBytes, priv, nil)
+		assert.Nil(t, err)
+		assert.Len(t, newPacketBytes, DefaultParams.PacketSize())
+		if i < len(privs)-1 {
+			assert.Equal(t, nodes[i+1].Id, hop.Id)
+			assert.Equal(t, RelayCommand{}, commands.Action())
+		} else {
+			assert.Equal(t, "DestinationId", hop.Id)
+			assert.Equal(t, DeliverCommand{}, commands.Action())
+		}
+		packetBytes = newPacketBytes
+	}
 
-	mac3, err := computeMac(kdfRes, encExpectedRouting)
+	extracted, err := ExtractMessage(packetBytes)
 	assert.Nil(t, err)
+	assert.Equal(t, message, extracted)
+}
 
-	expectedHeader := Header{Alpha: sharedSecrets[0].Alpha,
-		Beta: encExpectedRouting,
-		Mac:  mac3,
+func TestPackForwardMessageTooLong(t *testing.T) {
+	_, nodes := createTestNodes(t, 3)
+	path := config.E2EPath{IngressProvider: nodes[0],
+		Mixes:          nodes[1:2],
+		EgressProvider: nodes[2],
+		Recipient:      testDestination(),
 	}
-
-	assert.Equal(t, expectedHeader, actualHeader)
+	_, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, make([]byte, MaxMessageLength+1))
+	assert.Error(t, err)
 }
 
-func TestProcessSphinxHeader(t *testing.T) {
+func TestProcessSphinxPayload(t *testing.T) {
+
+	message := []byte("Plaintext message")
+
 	priv1, pub1, err := GenerateKeyPair()
 	assert.Nil(t, err)
 
-	_, pub2, err := GenerateKeyPair()
+	priv2, pub2, err := GenerateKeyPair()
 	assert.Nil(t, err)
 
-	_, pub3, err := GenerateKeyPair()
+	priv3, pub3, err := GenerateKeyPair()
 	assert.Nil(t, err)
 
-	c1 := Commands{Delay: 0.34}
-	c2 := Commands{Delay: 0.25}
-	c3 := Commands{Delay: 1.10}
-
 	m1 := config.NewMixConfig("Node1", "localhost", "3331", pub1.Bytes(), 1)
 	m2 := config.NewMixConfig("Node2", "localhost", "3332", pub2.Bytes(), 2)
 	m3 := config.NewMixConfig("Node3", "localhost", "3333", pub3.Bytes(), 3)
@@ -344,118 +406,301 @@ func TestProcessSphinxHeader(t *testing.T) {
 
 	x, err := RandomElement()
 	assert.Nil(t, err)
-	sharedSecrets, err := getSharedSecrets(nodes, x)
+	headerInitials, err := getSharedSecrets(X25519, nodes, x)
 	assert.Nil(t, err)
 
-	// Intermediate steps, which are needed to check whether the processing of the header was correct
-	routing1 := RoutingInfo{NextHop: &Hop{Id: "DestinationId",
-		Address: "DestinationAddress", PubKey: []byte{},
-	}, RoutingCommands: &c3,
-		NextHopMetaData: []byte{},
-		Mac:             []byte{},
-	}
-
-	routing1Bytes, err := proto.Marshal(&routing1)
+	paddedMessage, err := padPayload(message)
 	assert.Nil(t, err)
 
-	kdfRes, err := KDF(sharedSecrets[2].SecretHash)
+	encMsg, err := encapsulateContent(headerInitials, paddedMessage)
 	assert.Nil(t, err)
 
-	encRouting1, err := AesCtr(kdfRes, routing1Bytes)
+	decMsg := encMsg
+	privs := []*PrivateKey{priv1, priv2, priv3}
+	for i, v := range privs {
+		decMsg, err = ProcessSphinxPayload(headerInitials[i].Alpha, decMsg, v)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	assert.Equal(t, paddedMessage, decMsg)
+	assert.True(t, checkPayloadIntegrity(decMsg))
+}
+
+func TestProcessSphinxPacketTamperedPayload(t *testing.T) {
+	privs, nodes := createTestNodes(t, 3)
+
+	path := config.E2EPath{IngressProvider: nodes[0],
+		Mixes:          nodes[1:2],
+		EgressProvider: nodes[2],
+		Recipient:      testDestination(),
+	}
+	packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Plaintext message"))
 	assert.Nil(t, err)
 
-	mac1, err := computeMac(kdfRes, encRouting1)
+	packetBytes, err := packet.MarshalBinary()
 	assert.Nil(t, err)
 
-	routing2 := RoutingInfo{NextHop: &Hop{Id: "Node3",
-		Address: "localhost:3333",
-		PubKey:  pub3.Bytes(),
-	}, RoutingCommands: &c2,
-		NextHopMetaData: encRouting1,
-		Mac:             mac1,
+	// the payload is modified after the first hop, which should only be detected by the final hop
+	for i, priv := range privs {
+		if i == 1 {
+			packetBytes[DefaultParams.HeaderSize()+42] ^= 0x01
+		}
+		_, _, packetBytes, err = ProcessSphinxPacket(packetBytes, priv, nil)
+		if i < len(privs)-1 {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, ErrPayloadIntegrity, err)
+		}
 	}
+}
 
-	routing2Bytes, err := proto.Marshal(&routing2)
-	assert.Nil(t, err)
+func TestProcessSphinxPacketTamperedCopyDoesNotBurnReplayTag(t *testing.T) {
+	privs, nodes := createTestNodes(t, 1)
+	packetBytes := packTestPacket(t, DefaultParams, nodes, []byte("Plaintext message"))
+	replays := make(mapReplayChecker)
 
-	kdfRes, err = KDF(sharedSecrets[1].SecretHash)
-	assert.Nil(t, err)
+	// a tampered copy of the packet reaching the final hop first must not prevent the genuine one from being processed
+	tampered := make([]byte, len(packetBytes))
+	copy(tampered, packetBytes)
+	tampered[DefaultParams.HeaderSize()+42] ^= 0x01
 
-	encRouting2, err := AesCtr(kdfRes, routing2Bytes)
-	assert.Nil(t, err)
+	_, _, _, err := ProcessSphinxPacket(tampered, privs[0], replays)
+	assert.Equal(t, ErrPayloadIntegrity, err)
 
-	mac2, err := computeMac(kdfRes, encRouting2)
+	_, _, _, err = ProcessSphinxPacket(packetBytes, privs[0], replays)
 	assert.Nil(t, err)
 
-	routing3 := RoutingInfo{NextHop: &Hop{Id: "Node2",
-		Address: "localhost:3332",
-		PubKey:  pub2.Bytes(),
-	}, RoutingCommands: &c1,
-		NextHopMetaData: encRouting2,
-		Mac:             mac2,
-	}
+	_, _, _, err = ProcessSphinxPacket(packetBytes, privs[0], replays)
+	assert.Equal(t, ErrReplayedPacket, err)
+}
 
-	routing3Bytes, err := proto.Marshal(&routing3)
+// packTestPacket creates the packet for the given sequence of nodes, which may be shorter than any valid E2EPath.
+func packTestPacket(t testing.TB, params Params, nodes []config.MixConfig, message []byte) []byte {
+	delays := make([]float64, len(nodes))
+	headerInitials, header, err := params.createHeader(rand.Reader, nodes, delays, testDestination(), DeliverCommand{})
 	assert.Nil(t, err)
 
-	kdfRes, err = KDF(sharedSecrets[0].SecretHash)
+	paddedMessage, err := padPayload(message)
 	assert.Nil(t, err)
-
-	encExpectedRouting, err := AesCtr(kdfRes, routing3Bytes)
+	payload, err := encapsulateContent(headerInitials, paddedMessage)
 	assert.Nil(t, err)
 
-	mac3, err := computeMac(kdfRes, encExpectedRouting)
+	packet := SphinxPacket{Hdr: &header, Pld: payload}
+	packetBytes, err := packet.MarshalBinary()
 	assert.Nil(t, err)
+	return packetBytes
+}
 
-	header := Header{Alpha: sharedSecrets[0].Alpha,
-		Beta: encExpectedRouting,
-		Mac:  mac3,
+func TestHeaderLengthInvariance(t *testing.T) {
+	message := []byte("Plaintext message")
+
+	for _, params := range []Params{DefaultParams, {MaxPathLength: 1}, {MaxPathLength: 8}} {
+		for n := 1; n <= params.MaxPathLength; n++ {
+			privs, nodes := createTestNodes(t, n)
+			packetBytes := packTestPacket(t, params, nodes, message)
+			assert.Len(t, packetBytes, params.PacketSize())
+
+			for i, priv := range privs {
+				hop, commands, newPacketBytes, err := params.ProcessSphinxPacket(packetBytes, priv, nil)
+				assert.Nil(t, err, "path length %v, hop %v", n, i)
+				assert.Len(t, newPacketBytes, params.PacketSize(), "path length %v, hop %v", n, i)
+
+				if i < n-1 {
+					assert.Equal(t, nodes[i+1].Id, hop.Id)
+					assert.Equal(t, RelayCommand{}, commands.Action())
+				} else {
+					assert.Equal(t, "DestinationId", hop.Id)
+					assert.Equal(t, DeliverCommand{}, commands.Action())
+				}
+				packetBytes = newPacketBytes
+			}
+
+			extracted, err := ExtractMessage(packetBytes)
+			assert.Nil(t, err, "path length %v", n)
+			assert.Equal(t, message, extracted)
+		}
 	}
+}
 
-	nextHop, newCommands, newHeader, err := ProcessSphinxHeader(header, priv1)
+func TestPathLongerThanMaximum(t *testing.T) {
+	params := Params{MaxPathLength: 3}
+	_, nodes := createTestNodes(t, 4)
 
-	assert.Nil(t, err)
+	_, _, err := params.createHeader(rand.Reader, nodes, make([]float64, 4), testDestination(), DeliverCommand{})
+	assert.Equal(t, ErrInvalidPathLength, err)
+}
 
-	assert.True(t, proto.Equal(&nextHop, &Hop{Id: "Node2", Address: "localhost:3332", PubKey: pub2.Bytes()}))
-	assert.True(t, proto.Equal(&newCommands, &c1))
-	assert.True(t, proto.Equal(&newHeader, &Header{Alpha: sharedSecrets[1].Alpha, Beta: encRouting2, Mac: mac2}))
+func TestParamsValidate(t *testing.T) {
+	assert.Nil(t, DefaultParams.Validate())
+	assert.Equal(t, ErrInvalidParams, Params{MaxPathLength: 0}.Validate())
+	assert.Equal(t, ErrInvalidParams, Params{MaxPathLength: MaxPathLengthLimit + 1}.Validate())
+}
 
+func TestParamsForPacketLength(t *testing.T) {
+	for _, maxPathLength := range []int{1, DefaultMaxPathLength, MaxPathLengthLimit} {
+		params := Params{MaxPathLength: maxPathLength, Suite: P256}
+		inferred, err := ParamsForPacketLength(params.PacketSize(), P256)
+		assert.Nil(t, err)
+		assert.Equal(t, params, inferred)
+	}
+	_, err := ParamsForPacketLength(DefaultParams.PacketSize()+1, X25519)
+	assert.Equal(t, ErrInvalidPacketLength, err)
 }
 
-func TestProcessSphinxPayload(t *testing.T) {
+// lockedReplayChecker makes mapReplayChecker safe for the concurrent use.
+type lockedReplayChecker struct {
+	sync.Mutex
+	seen mapReplayChecker
+}
 
-	message := []byte("Plaintext message")
+func (l *lockedReplayChecker) CheckAndRecord(tag []byte) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.seen.CheckAndRecord(tag)
+}
 
-	priv1, pub1, err := GenerateKeyPair()
-	assert.Nil(t, err)
+func createTestBatch(t testing.TB, nodes []config.MixConfig, n int) []BatchPacket {
+	batch := make([]BatchPacket, n)
+	for i := range batch {
+		batch[i].Packet = packTestPacket(t, DefaultParams, nodes, []byte(fmt.Sprintf("Plaintext message %d", i)))
+	}
+	return batch
+}
 
-	priv2, pub2, err := GenerateKeyPair()
-	assert.Nil(t, err)
+func TestProcessSphinxPacketBatch(t *testing.T) {
+	privs, nodes := createTestNodes(t, 3)
+	batch := createTestBatch(t, nodes, 16)
+	batch[3].Packet[42] ^= 0x01
+	batch[5].Packet = batch[5].Packet[:100]
+	batch[7].Packet = batch[6].Packet
+
+	replays := &lockedReplayChecker{seen: make(mapReplayChecker)}
+	DefaultParams.ProcessSphinxPacketBatch(batch, privs[0], replays, 4)
+
+	for i, packet := range batch {
+		switch i {
+		case 3:
+			assert.Equal(t, ErrInvalidMac, packet.Err)
+			assert.Empty(t, packet.Out)
+		case 5:
+			assert.Equal(t, ErrInvalidPacketLength, packet.Err)
+		case 6, 7:
+			// only one of the two identical packets gets processed, the other one is replayed
+			if packet.Err != nil {
+				assert.Equal(t, ErrReplayedPacket, packet.Err)
+			}
+		default:
+			assert.Nil(t, packet.Err)
+			hop, commands, newPacketBytes, err := DefaultParams.ProcessSphinxPacket(packet.Packet, privs[0], nil)
+			assert.Nil(t, err)
+			assert.Equal(t, hop, packet.Hop)
+			assert.Equal(t, commands, packet.Commands)
+			assert.Equal(t, newPacketBytes, packet.Out)
+		}
+	}
+	assert.True(t, (batch[6].Err == nil) != (batch[7].Err == nil))
+}
 
-	priv3, pub3, err := GenerateKeyPair()
-	assert.Nil(t, err)
+func TestProcessSphinxPacketBatchReusesBuffers(t *testing.T) {
+	privs, nodes := createTestNodes(t, 2)
+	batch := createTestBatch(t, nodes, 8)
+	DefaultParams.ProcessSphinxPacketBatch(batch, privs[0], nil, 0)
 
-	m1 := config.NewMixConfig("Node1", "localhost", "3331", pub1.Bytes(), 1)
-	m2 := config.NewMixConfig("Node2", "localhost", "3332", pub2.Bytes(), 2)
-	m3 := config.NewMixConfig("Node3", "localhost", "3333", pub3.Bytes(), 3)
+	// the processed packets are processed by the next hop into the buffers of the original packets
+	next := make([]BatchPacket, len(batch))
+	for i := range batch {
+		assert.Nil(t, batch[i].Err)
+		next[i] = BatchPacket{Packet: batch[i].Out, Out: batch[i].Packet}
+	}
+	DefaultParams.ProcessSphinxPacketBatch(next, privs[1], nil, 0)
 
-	nodes := []config.MixConfig{m1, m2, m3}
+	for i := range next {
+		assert.Nil(t, next[i].Err)
+		assert.Equal(t, DeliverCommand{}, next[i].Commands.Action())
+		assert.True(t, &next[i].Out[0] == &batch[i].Packet[0])
 
-	x, err := RandomElement()
-	assert.Nil(t, err)
-	headerInitials, err := getSharedSecrets(nodes, x)
-	assert.Nil(t, err)
+		message, err := ExtractMessage(next[i].Out)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("Plaintext message %d", i)), message)
+	}
+}
 
-	encMsg, err := encapsulateContent(headerInitials, message)
-	assert.Nil(t, err)
+const benchmarkBatchSize = 64
 
-	decMsg := encMsg
-	privs := []*PrivateKey{priv1, priv2, priv3}
-	for i, v := range privs {
-		decMsg, err = ProcessSphinxPayload(headerInitials[i].Alpha, decMsg, v)
-		if err != nil {
-			t.Error(err)
+// processSphinxPacketSeparately processes the packet the way it was processed before ProcessSphinxPacket
+// derived the keys of the hop only once, i.e. with the header and the payload processed independently.
+func processSphinxPacketSeparately(packetBytes []byte, privKey *PrivateKey) ([]byte, error) {
+	var packet SphinxPacket
+	if err := packet.UnmarshalBinary(packetBytes); err != nil {
+		return nil, err
+	}
+	_, _, newHeader, err := ProcessSphinxHeader(*packet.Hdr, privKey)
+	if err != nil {
+		return nil, err
+	}
+	newPayload, err := ProcessSphinxPayload(packet.Hdr.Alpha, packet.Pld, privKey)
+	if err != nil {
+		return nil, err
+	}
+	newPacket := SphinxPacket{Hdr: &newHeader, Pld: newPayload}
+	return newPacket.MarshalBinary()
+}
+
+func BenchmarkProcessSphinxPacketSeparately(b *testing.B) {
+	privs, nodes := createTestNodes(b, 3)
+	batch := createTestBatch(b, nodes, benchmarkBatchSize)
+	b.SetBytes(int64(DefaultParams.PacketSize()))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := processSphinxPacketSeparately(batch[i%len(batch)].Packet, privs[0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessSphinxPacket(b *testing.B) {
+	privs, nodes := createTestNodes(b, 3)
+	batch := createTestBatch(b, nodes, benchmarkBatchSize)
+	b.SetBytes(int64(DefaultParams.PacketSize()))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := DefaultParams.ProcessSphinxPacket(batch[i%len(batch)].Packet, privs[0], nil); err != nil {
+			b.Fatal(err)
 		}
 	}
-	assert.Equal(t, []byte(message), decMsg)
+}
+
+func BenchmarkProcessSphinxPacketBatch(b *testing.B) {
+	workerCounts := []int{1}
+	if n := runtime.GOMAXPROCS(0); n > 1 {
+		workerCounts = append(workerCounts, n)
+	}
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			privs, nodes := createTestNodes(b, 3)
+			batch := createTestBatch(b, nodes, benchmarkBatchSize)
+			b.SetBytes(int64(DefaultParams.PacketSize()))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			// b.N counts the packets rather than the batches, so that the results compare with the other benchmarks
+			for done := 0; done < b.N; done += len(batch) {
+				n := len(batch)
+				if b.N-done < n {
+					n = b.N - done
+				}
+				DefaultParams.ProcessSphinxPacketBatch(batch[:n], privs[0], nil, workers)
+				for i := range batch[:n] {
+					if batch[i].Err != nil {
+						b.Fatal(batch[i].Err)
+					}
+				}
+			}
+		})
+	}
 }
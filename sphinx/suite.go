@@ -0,0 +1,86 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrInvalidElement is returned when the encoded group element is not valid in the suite.
+	ErrInvalidElement = errors.New("invalid group element")
+
+	// X25519 is the default suite, performing the operations on Curve25519.
+	//nolint: gochecknoglobals
+	X25519 Suite = x25519Suite{}
+	// P256 is the suite performing the operations on the NIST P-256 curve.
+	//nolint: gochecknoglobals
+	P256 Suite = p256Suite{}
+)
+
+// Suite is the group in which the public key operations of the packet format are performed.
+// The header is built and processed the same way for every suite, only the operations on the group
+// elements and the scalars differ. Both the elements and the scalars of every suite are encoded
+// in FieldElementSize bytes, so that the length of the packets does not depend on the suite.
+type Suite interface {
+	// Name identifies the suite, as accepted by ParseSuite.
+	Name() string
+	// GenerateKeyPair generates the key pair of a node, reading all its randomness from rng.
+	GenerateKeyPair(rng io.Reader) (*PrivateKey, *PublicKey, error)
+	// RandomScalar generates the initial secret of the header, reading all its randomness from rng.
+	RandomScalar(rng io.Reader) (*FieldElement, error)
+	// DecodeElement checks whether the bytes encode a valid group element and returns it.
+	DecodeElement(b []byte) (*FieldElement, error)
+	// ScalarBaseMult multiplies the generator of the group by the scalar.
+	ScalarBaseMult(scalar *FieldElement) (*FieldElement, error)
+	// ScalarMult multiplies the group element by the scalar.
+	ScalarMult(scalar, element *FieldElement) (*FieldElement, error)
+	// DeriveBlindingFactor derives the scalar blinding the public element of the header
+	// from the hashed shared secret of the hop.
+	DeriveBlindingFactor(secretHash []byte) (*FieldElement, error)
+}
+
+// ParseSuite returns the suite with the given name.
+func ParseSuite(name string) (Suite, error) {
+	for _, s := range []Suite{X25519, P256} {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown sphinx suite: %q", name)
+}
+
+// suiteExpo multiplies the element by all the scalars in turn.
+func suiteExpo(suite Suite, base *FieldElement, exp []*FieldElement) (*FieldElement, error) {
+	res := base
+	for _, val := range exp {
+		var err error
+		if res, err = suite.ScalarMult(val, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// suiteExpoGroupBase multiplies the generator of the group by all the scalars in turn.
+func suiteExpoGroupBase(suite Suite, exp []*FieldElement) (*FieldElement, error) {
+	res, err := suite.ScalarBaseMult(exp[0])
+	if err != nil {
+		return nil, err
+	}
+	return suiteExpo(suite, res, exp[1:])
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// nolint: gochecknoglobals
+var testSuites = []Suite{X25519, P256}
+
+func createSuiteTestPath(t *testing.T, suite Suite) ([]*PrivateKey, config.E2EPath) {
+	privs := make([]*PrivateKey, 4)
+	nodes := make([]config.MixConfig, 4)
+	for i := range nodes {
+		priv, pub, err := suite.GenerateKeyPair(rand.Reader)
+		assert.Nil(t, err)
+		privs[i] = priv
+		nodes[i] = config.NewMixConfig(fmt.Sprintf("Node%d", i+1), "localhost", fmt.Sprintf("%d", 3331+i), pub.Bytes(), uint(i+1))
+	}
+	path := config.E2EPath{IngressProvider: nodes[0],
+		Mixes:          nodes[1:3],
+		EgressProvider: nodes[3],
+		Recipient:      testDestination(),
+	}
+	return privs, path
+}
+
+func TestParseSuite(t *testing.T) {
+	for _, suite := range testSuites {
+		parsed, err := ParseSuite(suite.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, suite, parsed)
+	}
+	_, err := ParseSuite("p384")
+	assert.Error(t, err)
+
+	assert.Equal(t, X25519, Params{MaxPathLength: 3}.CryptoSuite())
+}
+
+func TestSuiteKeyAgreement(t *testing.T) {
+	for _, suite := range testSuites {
+		priv1, pub1, err := suite.GenerateKeyPair(rand.Reader)
+		assert.Nil(t, err)
+		priv2, pub2, err := suite.GenerateKeyPair(rand.Reader)
+		assert.Nil(t, err)
+
+		derivedPub, err := suite.ScalarBaseMult(priv1.ToFieldElement())
+		assert.Nil(t, err)
+		assert.Equal(t, pub1.Bytes(), derivedPub.Bytes(), suite.Name())
+
+		// (g^x1)^x2 == (g^x2)^x1
+		s1, err := suite.ScalarMult(priv2.ToFieldElement(), pub1.ToFieldElement())
+		assert.Nil(t, err)
+		s2, err := suite.ScalarMult(priv1.ToFieldElement(), pub2.ToFieldElement())
+		assert.Nil(t, err)
+		assert.Equal(t, s1, s2, suite.Name())
+	}
+}
+
+func TestP256RejectsInvalidElements(t *testing.T) {
+	// roughly half of all the x-coordinates are not on the curve
+	invalid := 0
+	for i := byte(1); i < 32; i++ {
+		b := make([]byte, FieldElementSize)
+		b[FieldElementSize-1] = i
+		if _, err := P256.DecodeElement(b); err != nil {
+			assert.Equal(t, ErrInvalidElement, err)
+			invalid++
+		}
+	}
+	assert.NotZero(t, invalid)
+
+	_, err := P256.DecodeElement(make([]byte, FieldElementSize+1))
+	assert.Equal(t, ErrInvalidElement, err)
+}
+
+func TestPackAndProcessWithSuites(t *testing.T) {
+	for _, suite := range testSuites {
+		params := Params{MaxPathLength: DefaultMaxPathLength, Suite: suite}
+		privs, path := createSuiteTestPath(t, suite)
+		message := []byte("Plaintext message")
+
+		packet, err := params.PackForwardMessage(rand.Reader, path, []float64{0.1, 0.2, 0.3, 0.4}, message)
+		assert.Nil(t, err)
+		packetBytes, err := packet.MarshalBinary()
+		assert.Nil(t, err)
+		assert.Len(t, packetBytes, params.PacketSize())
+
+		for i, priv := range privs {
+			hop, commands, newPacketBytes, err := params.ProcessSphinxPacket(packetBytes, priv, nil)
+			assert.Nil(t, err, suite.Name())
+			if i < len(privs)-1 {
+				assert.Equal(t, path.Nodes()[i+1].Id, hop.Id)
+				assert.Equal(t, RelayCommand{}, commands.Action())
+			} else {
+				assert.Equal(t, "DestinationId", hop.Id)
+				assert.Equal(t, DeliverCommand{}, commands.Action())
+			}
+			packetBytes = newPacketBytes
+		}
+
+		extracted, err := ExtractMessage(packetBytes)
+		assert.Nil(t, err, suite.Name())
+		assert.Equal(t, message, extracted)
+	}
+}
+
+func TestSendReplyWithSuites(t *testing.T) {
+	for _, suite := range testSuites {
+		params := Params{MaxPathLength: DefaultMaxPathLength, Suite: suite}
+		privs, path := createSuiteTestPath(t, suite)
+
+		surb, keys, err := params.CreateSURB(rand.Reader, path, []float64{0.1, 0.2, 0.3, 0.4})
+		assert.Nil(t, err)
+
+		message := []byte("Reply message")
+		packet, err := PackReplyMessage(surb, message)
+		assert.Nil(t, err)
+		packetBytes, err := packet.MarshalBinary()
+		assert.Nil(t, err)
+
+		registry := NewStandardRegistry(params)
+		for _, priv := range privs {
+			_, _, packetBytes, err = registry.ProcessPacket(packetBytes, priv, nil)
+			assert.Nil(t, err, suite.Name())
+		}
+
+		id, err := ReplyID(packetBytes)
+		assert.Nil(t, err)
+		assert.Equal(t, keys.ID, id)
+
+		reply, err := UnwrapReplyMessage(keys, packetBytes)
+		assert.Nil(t, err, suite.Name())
+		assert.Equal(t, message, reply)
+	}
+}
+
+func TestProcessWithMismatchedSuiteFails(t *testing.T) {
+	params := Params{MaxPathLength: DefaultMaxPathLength, Suite: P256}
+	privs, path := createSuiteTestPath(t, P256)
+
+	packet, err := params.PackForwardMessage(rand.Reader, path, []float64{0.1, 0.2, 0.3, 0.4}, []byte("Plaintext message"))
+	assert.Nil(t, err)
+	packetBytes, err := packet.MarshalBinary()
+	assert.Nil(t, err)
+
+	_, _, _, err = DefaultParams.ProcessSphinxPacket(packetBytes, privs[0], nil)
+	assert.Error(t, err)
+	_, _, _, err = params.ProcessSphinxPacket(packetBytes, privs[0], nil)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,242 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+const (
+	// SURBKeySize defines the length of the key used by the replier to encrypt the reply payload.
+	SURBKeySize = K
+)
+
+var (
+	// ErrInvalidSURB is returned when the single-use reply block cannot be decoded.
+	ErrInvalidSURB = errors.New("invalid single-use reply block")
+)
+
+// SURB is a single-use reply block. It is created by the sender for a path leading back to itself
+// and allows the recipient to send a reply without learning anything about the sender.
+// As the nodes on the path detect replayed packets, each SURB can only be used for a single reply.
+type SURB struct {
+	// FirstHop is the node the reply packet should be sent to.
+	FirstHop Hop
+	// Header is the pre-computed header of the reply packet.
+	Header Header
+	// Key is used by the replier to encrypt the reply payload.
+	Key []byte
+}
+
+// ReplyKeys holds the key material, kept by the creator of the SURB, required to decrypt the reply.
+type ReplyKeys struct {
+	// ID identifies the reply packets sent using the corresponding SURB.
+	// It is the public element of the header, as it is going to look like after being processed by the final hop.
+	ID []byte
+	// Key is the key used by the replier to encrypt the reply payload.
+	Key []byte
+	// PayloadKeys are the Lioness keys used by the subsequent hops to process the reply payload.
+	PayloadKeys [][]byte
+}
+
+// CreateSURB creates a single-use reply block for the given path, whose recipient should be the creator itself.
+// CreateSURB returns the SURB, which should be given to the replier, together with the keys
+// which should be kept by the creator in order to decrypt the reply. CreateSURB uses the DefaultParams and crypto/rand.
+func CreateSURB(path config.E2EPath, delays []float64) (SURB, ReplyKeys, error) {
+	return DefaultParams.CreateSURB(rand.Reader, path, delays)
+}
+
+// CreateSURB creates a single-use reply block for the given path with the header sized according to the parameters.
+// The randomness of the header, followed by the SURB key, is read from rng.
+func (p Params) CreateSURB(rng io.Reader, path config.E2EPath, delays []float64) (SURB, ReplyKeys, error) {
+	nodes := path.Nodes()
+
+	if len(delays) < len(nodes) {
+		return SURB{}, ReplyKeys{}, fmt.Errorf("error in CreateSURB - expected %v delays, got %v",
+			len(nodes),
+			len(delays),
+		)
+	}
+
+	headerInitials, header, err := p.createHeader(rng, nodes, delays, path.Recipient, DeliverSURBAckCommand{})
+	if err != nil {
+		errMsg := fmt.Errorf("error in CreateSURB - createHeader failed: %v", err)
+		return SURB{}, ReplyKeys{}, errMsg
+	}
+
+	key := make([]byte, SURBKeySize)
+	if _, err := io.ReadFull(rng, key); err != nil {
+		return SURB{}, ReplyKeys{}, err
+	}
+
+	payloadKeys := make([][]byte, len(headerInitials))
+	for i := range headerInitials {
+		payloadKeys[i], err = computePayloadKey(headerInitials[i].SecretHash)
+		if err != nil {
+			return SURB{}, ReplyKeys{}, err
+		}
+	}
+
+	last := headerInitials[len(headerInitials)-1]
+	id, err := p.CryptoSuite().ScalarMult(BytesToFieldElement(last.Blinder), BytesToFieldElement(last.Alpha))
+	if err != nil {
+		errMsg := fmt.Errorf("error in CreateSURB - computing the reply id failed: %v", err)
+		return SURB{}, ReplyKeys{}, errMsg
+	}
+
+	surb := SURB{
+		FirstHop: Hop{Id: path.IngressProvider.Id,
+			Address: path.IngressProvider.Host + ":" + path.IngressProvider.Port,
+			PubKey:  path.IngressProvider.PubKey,
+		},
+		Header: header,
+		Key:    key,
+	}
+	return surb, ReplyKeys{ID: id.Bytes(), Key: key, PayloadKeys: payloadKeys}, nil
+}
+
+// PackReplyMessage encapsulates the given reply message into a sphinx packet using the single-use reply block.
+// The packet should be sent to surb.FirstHop.
+func PackReplyMessage(surb SURB, message []byte) (SphinxPacket, error) {
+	if len(surb.Key) != SURBKeySize {
+		return SphinxPacket{}, ErrInvalidSURB
+	}
+
+	paddedMessage, err := padPayload(message)
+	if err != nil {
+		errMsg := fmt.Errorf("error in PackReplyMessage - padPayload failed: %v", err)
+		return SphinxPacket{}, errMsg
+	}
+
+	payloadKey, err := computeSURBPayloadKey(surb.Key)
+	if err != nil {
+		return SphinxPacket{}, err
+	}
+
+	payload, err := LionessEncrypt(payloadKey, paddedMessage)
+	if err != nil {
+		errMsg := fmt.Errorf("error in PackReplyMessage - Lioness encryption failed: %v", err)
+		return SphinxPacket{}, errMsg
+	}
+
+	header := Header{
+		Alpha: append([]byte{}, surb.Header.Alpha...),
+		Beta:  append([]byte{}, surb.Header.Beta...),
+		Mac:   append([]byte{}, surb.Header.Mac...),
+	}
+	return SphinxPacket{Hdr: &header, Pld: payload}, nil
+}
+
+// ReplyID returns the identifier of the reply packet that has been processed by its final hop.
+// It can be matched against the ID of ReplyKeys in order to find the keys required to decrypt the reply.
+func ReplyID(packetBytes []byte) ([]byte, error) {
+	var packet SphinxPacket
+	if err := packet.UnmarshalBinary(packetBytes); err != nil {
+		return nil, err
+	}
+	return packet.Hdr.Alpha, nil
+}
+
+// UnwrapReplyMessage recovers the reply message from the packet that has been processed by its final hop,
+// using the keys created alongside the SURB. UnwrapReplyMessage returns ErrPayloadIntegrity
+// if the payload has been tampered with.
+func UnwrapReplyMessage(keys ReplyKeys, packetBytes []byte) ([]byte, error) {
+	var packet SphinxPacket
+	if err := packet.UnmarshalBinary(packetBytes); err != nil {
+		return nil, err
+	}
+
+	// each hop decrypted the payload, so the same operations are undone by encrypting it in the reverse order
+	payload := packet.Pld
+	for i := len(keys.PayloadKeys) - 1; i >= 0; i-- {
+		var err error
+		payload, err = LionessEncrypt(keys.PayloadKeys[i], payload)
+		if err != nil {
+			errMsg := fmt.Errorf("error in UnwrapReplyMessage - Lioness encryption failed: %v", err)
+			return nil, errMsg
+		}
+	}
+
+	payloadKey, err := computeSURBPayloadKey(keys.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err = LionessDecrypt(payloadKey, payload)
+	if err != nil {
+		errMsg := fmt.Errorf("error in UnwrapReplyMessage - Lioness decryption failed: %v", err)
+		return nil, errMsg
+	}
+
+	if !checkPayloadIntegrity(payload) {
+		return nil, ErrPayloadIntegrity
+	}
+	return unpadPayload(payload)
+}
+
+// MarshalBinary is an implementation of a method on the
+// BinaryMarshaler interface defined in https://golang.org/pkg/encoding/
+func (s *SURB) MarshalBinary() ([]byte, error) {
+	if !isValidHeader(&s.Header) {
+		return nil, ErrInvalidSURB
+	}
+	if len(s.Key) != SURBKeySize {
+		return nil, ErrInvalidSURB
+	}
+
+	// the first hop is encoded in the same way as the routing information in the header
+	firstHop, err := encodeRoutingBlock(routingBlock{nextHop: s.FirstHop})
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, routingBlockSize+FieldElementSize+len(s.Header.Beta)+MacSize+SURBKeySize)
+	b = append(b, firstHop...)
+	b = append(b, s.Header.Alpha...)
+	b = append(b, s.Header.Beta...)
+	b = append(b, s.Header.Mac...)
+	b = append(b, s.Key...)
+	return b, nil
+}
+
+// UnmarshalBinary is an implementation of a method on the
+// BinaryUnmarshaler interface defined in https://golang.org/pkg/encoding/
+// The length of the routing information is inferred from the length of the data.
+func (s *SURB) UnmarshalBinary(data []byte) error {
+	if len(data) < routingBlockSize+SURBKeySize {
+		return ErrInvalidSURB
+	}
+
+	firstHop, err := decodeRoutingBlock(data[:routingBlockSize])
+	if err != nil {
+		return ErrInvalidSURB
+	}
+	header, err := decodeHeader(data[routingBlockSize : len(data)-SURBKeySize])
+	if err != nil {
+		return ErrInvalidSURB
+	}
+	key := make([]byte, SURBKeySize)
+	copy(key, data[len(data)-SURBKeySize:])
+
+	s.FirstHop = firstHop.nextHop
+	s.Header = header
+	s.Key = key
+	return nil
+}
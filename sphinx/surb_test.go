@@ -0,0 +1,130 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type mapReplayChecker map[string]bool
+
+func (m mapReplayChecker) CheckAndRecord(tag []byte) bool {
+	if m[string(tag)] {
+		return true
+	}
+	m[string(tag)] = true
+	return false
+}
+
+func createTestSURB(t *testing.T) ([]*PrivateKey, SURB, ReplyKeys) {
+	privs, nodes := createTestNodes(t, 4)
+	path := config.E2EPath{IngressProvider: nodes[0],
+		Mixes:          nodes[1:3],
+		EgressProvider: nodes[3],
+		Recipient:      testDestination(),
+	}
+	surb, keys, err := CreateSURB(path, []float64{0.1, 0.2, 0.3, 0.4})
+	assert.Nil(t, err)
+	return privs, surb, keys
+}
+
+func TestSURBEncoding(t *testing.T) {
+	_, surb, _ := createTestSURB(t)
+
+	b, err := surb.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Len(t, b, DefaultParams.SURBSize())
+
+	var decoded SURB
+	assert.Nil(t, decoded.UnmarshalBinary(b))
+	assert.Equal(t, surb, decoded)
+
+	assert.Equal(t, ErrInvalidSURB, decoded.UnmarshalBinary(b[1:]))
+}
+
+func TestSendReplyUsingSURB(t *testing.T) {
+	privs, surb, keys := createTestSURB(t)
+	assert.Equal(t, "Node1", surb.FirstHop.Id)
+	assert.Equal(t, "localhost:3331", surb.FirstHop.Address)
+
+	message := []byte("Reply message")
+	packet, err := PackReplyMessage(surb, message)
+	assert.Nil(t, err)
+
+	packetBytes, err := packet.MarshalBinary()
+	assert.Nil(t, err)
+
+	for i, priv := range privs {
+		hop, commands, newPacketBytes, err := ProcessSphinxPacket(packetBytes, priv, nil)
+		assert.Nil(t, err)
+		if i < len(privs)-1 {
+			assert.Equal(t, RelayCommand{}, commands.Action())
+		} else {
+			assert.Equal(t, "DestinationId", hop.Id)
+			assert.Equal(t, DeliverSURBAckCommand{}, commands.Action())
+		}
+		packetBytes = newPacketBytes
+	}
+
+	id, err := ReplyID(packetBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, keys.ID, id)
+
+	reply, err := UnwrapReplyMessage(keys, packetBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, message, reply)
+}
+
+func TestSendReplyUsingSURBTamperedPayload(t *testing.T) {
+	privs, surb, keys := createTestSURB(t)
+
+	packet, err := PackReplyMessage(surb, []byte("Reply message"))
+	assert.Nil(t, err)
+	packetBytes, err := packet.MarshalBinary()
+	assert.Nil(t, err)
+
+	for i, priv := range privs {
+		if i == 2 {
+			packetBytes[len(packetBytes)-1] ^= 0x01
+		}
+		_, _, packetBytes, err = ProcessSphinxPacket(packetBytes, priv, nil)
+		assert.Nil(t, err)
+	}
+
+	_, err = UnwrapReplyMessage(keys, packetBytes)
+	assert.Equal(t, ErrPayloadIntegrity, err)
+}
+
+func TestSURBCanOnlyBeUsedOnce(t *testing.T) {
+	privs, surb, _ := createTestSURB(t)
+	replays := make(mapReplayChecker)
+
+	for i, message := range []string{"First reply", "Second reply"} {
+		packet, err := PackReplyMessage(surb, []byte(message))
+		assert.Nil(t, err)
+		packetBytes, err := packet.MarshalBinary()
+		assert.Nil(t, err)
+
+		_, _, _, err = ProcessSphinxPacket(packetBytes, privs[0], replays)
+		if i == 0 {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, ErrReplayedPacket, err)
+		}
+	}
+}
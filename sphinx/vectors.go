@@ -0,0 +1,226 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+// This file contains the test vectors, which allow other implementations of the packet format
+// to check that they construct and process the packets exactly the same way.
+// All the binary values are hex encoded. The randomness is the exact sequence of bytes read
+// while creating the packet: the initial secret value followed by the padding of the routing information.
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+var (
+	// ErrTestVectorMismatch is returned when the output recomputed from the test vector does not match the stored one.
+	ErrTestVectorMismatch = errors.New("test vector mismatch")
+)
+
+// TestVector describes the construction of a single packet and its processing by all the nodes on its path.
+type TestVector struct {
+	Description   string           `json:"description"`
+	Version       int              `json:"version"`
+	Suite         string           `json:"suite"`
+	MaxPathLength int              `json:"max_path_length"`
+	Nodes         []TestVectorNode `json:"nodes"`
+	Recipient     TestVectorNode   `json:"recipient"`
+	Delays        []float64        `json:"delays"`
+	Message       string           `json:"message"`
+	Randomness    string           `json:"randomness"`
+	Packet        string           `json:"packet"`
+	Hops          []TestVectorHop  `json:"hops"`
+}
+
+// TestVectorNode describes a node on the path of the packet or its recipient, which has no keys.
+type TestVectorNode struct {
+	ID         string `json:"id"`
+	Host       string `json:"host"`
+	Port       string `json:"port"`
+	PrivateKey string `json:"private_key,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+}
+
+// TestVectorHop describes the output of processing the packet by a single node.
+// The routing commands are encoded in the same way as in the routing block.
+type TestVectorHop struct {
+	NextHopID      string `json:"next_hop_id"`
+	NextHopAddress string `json:"next_hop_address"`
+	Commands       string `json:"commands"`
+	Packet         string `json:"packet"`
+}
+
+func newTestVectorHop(hop Hop, commands CommandSet, packetBytes []byte) (TestVectorHop, error) {
+	encodedCommands, err := encodeCommands(commands)
+	if err != nil {
+		return TestVectorHop{}, err
+	}
+	return TestVectorHop{NextHopID: hop.Id,
+		NextHopAddress: hop.Address,
+		Commands:       hex.EncodeToString(encodedCommands),
+		Packet:         hex.EncodeToString(packetBytes),
+	}, nil
+}
+
+// GenerateTestVector creates the packet carrying the message through the given nodes to the recipient
+// and processes it by every node, recording all the inputs and outputs. The randomness is read from rng.
+func GenerateTestVector(p Params,
+	description string,
+	privKeys []*PrivateKey,
+	nodes []config.MixConfig,
+	recipient config.ClientConfig,
+	delays []float64,
+	message []byte,
+	rng io.Reader,
+) (TestVector, error) {
+	if len(privKeys) != len(nodes) || len(delays) != len(nodes) {
+		return TestVector{}, errors.New("error in GenerateTestVector - inconsistent number of hops")
+	}
+
+	var randomness bytes.Buffer
+	packet, err := p.packForwardMessage(io.TeeReader(rng, &randomness), nodes, delays, recipient, message, DeliverCommand{})
+	if err != nil {
+		errMsg := fmt.Errorf("error in GenerateTestVector - packForwardMessage failed: %v", err)
+		return TestVector{}, errMsg
+	}
+	packetBytes, err := packet.MarshalBinary()
+	if err != nil {
+		return TestVector{}, err
+	}
+
+	vector := TestVector{Description: description,
+		Version:       int(Version1),
+		Suite:         p.CryptoSuite().Name(),
+		MaxPathLength: p.MaxPathLength,
+		Nodes:         make([]TestVectorNode, len(nodes)),
+		Recipient:     TestVectorNode{ID: recipient.Id, Host: recipient.Host, Port: recipient.Port},
+		Delays:        delays,
+		Message:       hex.EncodeToString(message),
+		Randomness:    hex.EncodeToString(randomness.Bytes()),
+		Packet:        hex.EncodeToString(packetBytes),
+		Hops:          make([]TestVectorHop, len(nodes)),
+	}
+
+	for i, node := range nodes {
+		vector.Nodes[i] = TestVectorNode{ID: node.Id,
+			Host:       node.Host,
+			Port:       node.Port,
+			PrivateKey: hex.EncodeToString(privKeys[i].Bytes()),
+			PublicKey:  hex.EncodeToString(node.PubKey),
+		}
+
+		hop, commands, newPacketBytes, err := p.ProcessSphinxPacket(packetBytes, privKeys[i], nil)
+		if err != nil {
+			errMsg := fmt.Errorf("error in GenerateTestVector - processing by node %v failed: %v", i, err)
+			return TestVector{}, errMsg
+		}
+		vector.Hops[i], err = newTestVectorHop(hop, commands, newPacketBytes)
+		if err != nil {
+			return TestVector{}, err
+		}
+		packetBytes = newPacketBytes
+	}
+	return vector, nil
+}
+
+// Verify recreates the packet from the inputs of the test vector and processes it by all the nodes.
+// Verify returns ErrTestVectorMismatch if any of the outputs differs from the one stored in the vector.
+func (v TestVector) Verify() error {
+	if v.Version != int(Version1) {
+		return ErrUnsupportedVersion
+	}
+	suite, err := ParseSuite(v.Suite)
+	if err != nil {
+		return err
+	}
+	p := Params{MaxPathLength: v.MaxPathLength, Suite: suite}
+	if len(v.Delays) != len(v.Nodes) {
+		return ErrTestVectorMismatch
+	}
+
+	privKeys := make([]*PrivateKey, len(v.Nodes))
+	nodes := make([]config.MixConfig, len(v.Nodes))
+	for i, node := range v.Nodes {
+		priv, err := hex.DecodeString(node.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if len(priv) != PrivateKeySize {
+			return ErrTestVectorMismatch
+		}
+		pub, err := hex.DecodeString(node.PublicKey)
+		if err != nil {
+			return err
+		}
+		privKeys[i] = BytesToPrivateKey(priv)
+		nodes[i] = config.MixConfig{Id: node.ID, Host: node.Host, Port: node.Port, PubKey: pub}
+	}
+	recipient := config.ClientConfig{Id: v.Recipient.ID, Host: v.Recipient.Host, Port: v.Recipient.Port}
+
+	message, err := hex.DecodeString(v.Message)
+	if err != nil {
+		return err
+	}
+	randomness, err := hex.DecodeString(v.Randomness)
+	if err != nil {
+		return err
+	}
+	rng := bytes.NewReader(randomness)
+
+	packet, err := p.packForwardMessage(rng, nodes, v.Delays, recipient, message, DeliverCommand{})
+	if err != nil {
+		return err
+	}
+	// all the randomness has to be used, otherwise the implementations differ in what they read
+	if rng.Len() != 0 {
+		return ErrTestVectorMismatch
+	}
+	packetBytes, err := packet.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(packetBytes) != v.Packet || len(v.Hops) != len(nodes) {
+		return ErrTestVectorMismatch
+	}
+
+	for i, expected := range v.Hops {
+		hop, commands, newPacketBytes, err := p.ProcessSphinxPacket(packetBytes, privKeys[i], nil)
+		if err != nil {
+			return err
+		}
+		actual, err := newTestVectorHop(hop, commands, newPacketBytes)
+		if err != nil {
+			return err
+		}
+		if actual != expected {
+			return ErrTestVectorMismatch
+		}
+		packetBytes = newPacketBytes
+	}
+
+	extracted, err := ExtractMessage(packetBytes)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(extracted, message) {
+		return ErrTestVectorMismatch
+	}
+	return nil
+}
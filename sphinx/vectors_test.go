@@ -0,0 +1,77 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoredVectors(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/vectors.json")
+	assert.Nil(t, err)
+
+	var vectors []TestVector
+	assert.Nil(t, json.Unmarshal(b, &vectors))
+	assert.NotEmpty(t, vectors)
+
+	for _, v := range vectors {
+		assert.Nil(t, v.Verify(), v.Description)
+	}
+}
+
+func TestTamperedVectorFails(t *testing.T) {
+	privs, nodes := createTestNodes(t, 3)
+	v, err := GenerateTestVector(DefaultParams, "", privs, nodes, testDestination(), []float64{0.1, 0.2, 0.3},
+		[]byte("Plaintext message"), rand.Reader)
+	assert.Nil(t, err)
+	assert.Nil(t, v.Verify())
+
+	tampered := v
+	tampered.Hops = append([]TestVectorHop{}, v.Hops...)
+	tampered.Hops[1].NextHopID = "Node42"
+	assert.Equal(t, ErrTestVectorMismatch, tampered.Verify())
+
+	tampered = v
+	tampered.Randomness = v.Randomness + "00"
+	assert.Equal(t, ErrTestVectorMismatch, tampered.Verify())
+}
+
+func TestPackForwardMessageIsDeterministic(t *testing.T) {
+	_, nodes := createTestNodes(t, 3)
+	randomness := make([]byte, FieldElementSize+DefaultParams.BetaSize())
+	_, err := rand.Read(randomness)
+	assert.Nil(t, err)
+
+	pack := func() []byte {
+		packet, err := DefaultParams.packForwardMessage(bytes.NewReader(randomness),
+			nodes,
+			[]float64{0.1, 0.2, 0.3},
+			testDestination(),
+			[]byte("Plaintext message"),
+			DeliverCommand{},
+		)
+		assert.Nil(t, err)
+		b, err := packet.MarshalBinary()
+		assert.Nil(t, err)
+		return b
+	}
+	assert.Equal(t, pack(), pack())
+}
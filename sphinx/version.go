@@ -0,0 +1,289 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// Version identifies the version of the packet format. It is the first byte of every encoded packet,
+// so that the cryptography of the packet format could be changed while nodes still process the older packets.
+type Version byte
+
+const (
+	// Version1 is the constant length encoding of the packet with the keys derived with HKDF
+	// and the payload encrypted with Lioness.
+	Version1 Version = 0x01
+	// VersionLegacy identifies the packets in the legacy protobuf encoding, which had no explicit version.
+	// All of them start with the protobuf tag of the header field, which is therefore reserved for them.
+	VersionLegacy Version = 0x0a
+
+	// CurrentVersion is the version of the packets created by the clients.
+	CurrentVersion = Version1
+
+	legacyVersionName = "legacy"
+)
+
+var (
+	// ErrUnsupportedVersion is returned when the packet version is not known or not accepted.
+	ErrUnsupportedVersion = errors.New("packet processing error: unsupported packet version")
+	// ErrDuplicateVersion is returned when registering a version that is already registered.
+	ErrDuplicateVersion = errors.New("packet version is already registered")
+
+	// DefaultRegistry contains all the versions of the packet format known to this implementation,
+	// with Version1 using the DefaultParams.
+	//nolint: gochecknoglobals
+	DefaultRegistry = NewStandardRegistry(DefaultParams)
+)
+
+// String returns the name of the version, as accepted by ParseVersion.
+func (v Version) String() string {
+	if v == VersionLegacy {
+		return legacyVersionName
+	}
+	return strconv.Itoa(int(v))
+}
+
+// ParseVersion parses the name of the version, i.e. either its number or "legacy".
+func ParseVersion(s string) (Version, error) {
+	s = strings.TrimSpace(s)
+	if s == legacyVersionName {
+		return VersionLegacy, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || Version(v) == VersionLegacy {
+		return 0, fmt.Errorf("invalid packet version: %q", s)
+	}
+	return Version(v), nil
+}
+
+// ParseVersions parses the comma separated list of version names.
+func ParseVersions(s string) ([]Version, error) {
+	var versions []Version
+	for _, name := range strings.Split(s, ",") {
+		v, err := ParseVersion(name)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// FormatVersions returns the comma separated list of version names, as accepted by ParseVersions.
+func FormatVersions(versions []Version) string {
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		names[i] = v.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// PacketVersion returns the version of the encoded packet.
+func PacketVersion(packetBytes []byte) (Version, error) {
+	if len(packetBytes) == 0 {
+		return 0, ErrInvalidPacketLength
+	}
+	return Version(packetBytes[0]), nil
+}
+
+// Encoder creates packets in a single version of the packet format.
+type Encoder interface {
+	// PackForwardMessage encapsulates the message into the encoded packet, reading all its randomness from rng.
+	PackForwardMessage(rng io.Reader, path config.E2EPath, delays []float64, message []byte) ([]byte, error)
+}
+
+// Processor processes packets in a single version of the packet format.
+type Processor interface {
+	// ProcessPacket unwraps a single layer of the packet, returning the routing information of the hop
+	// and the packet, encoded in the same version, which should be passed to the next hop.
+	ProcessPacket(packetBytes []byte, privKey *PrivateKey, replays ReplayChecker) (Hop, CommandSet, []byte, error)
+	// ExtractMessage recovers the message from the packet that has been processed by its final hop.
+	ExtractMessage(packetBytes []byte) ([]byte, error)
+}
+
+// Registry maps the versions of the packet format to their implementations.
+// Registering versions is not safe for concurrent use with processing packets,
+// so the registry should be fully set up before it is used.
+type Registry struct {
+	encoders   map[Version]Encoder
+	processors map[Version]Processor
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		encoders:   make(map[Version]Encoder),
+		processors: make(map[Version]Processor),
+	}
+}
+
+// NewStandardRegistry creates the registry containing all the versions of the packet format
+// known to this implementation, with Version1 using the given parameters.
+func NewStandardRegistry(p Params) *Registry {
+	r := NewRegistry()
+	v1 := versionOneFormat{params: p}
+	// registering distinct versions in an empty registry cannot fail
+	_ = r.Register(Version1, v1, v1)
+	_ = r.Register(VersionLegacy, nil, legacyFormat{})
+	return r
+}
+
+// Register adds the implementation of the given version. The encoder can be nil for versions
+// that are still processed, but no longer created. ErrDuplicateVersion is returned
+// if the version is already registered.
+func (r *Registry) Register(version Version, encoder Encoder, processor Processor) error {
+	if processor == nil {
+		return errors.New("packet version has to have a processor")
+	}
+	if _, ok := r.processors[version]; ok {
+		return ErrDuplicateVersion
+	}
+	if encoder != nil {
+		r.encoders[version] = encoder
+	}
+	r.processors[version] = processor
+	return nil
+}
+
+// Versions returns all the registered versions in ascending order.
+func (r *Registry) Versions() []Version {
+	versions := make([]Version, 0, len(r.processors))
+	for v := range r.processors {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// Supports reports whether the version is registered.
+func (r *Registry) Supports(version Version) bool {
+	_, ok := r.processors[version]
+	return ok
+}
+
+// Restrict creates a new registry containing only the given versions, for example those accepted by a node.
+// It returns ErrUnsupportedVersion if any of the versions is not registered.
+func (r *Registry) Restrict(versions []Version) (*Registry, error) {
+	restricted := NewRegistry()
+	for _, v := range versions {
+		processor, ok := r.processors[v]
+		if !ok {
+			return nil, ErrUnsupportedVersion
+		}
+		if err := restricted.Register(v, r.encoders[v], processor); err != nil {
+			return nil, err
+		}
+	}
+	return restricted, nil
+}
+
+// Encoder returns the encoder of the version, or ErrUnsupportedVersion if the packets of the version cannot be created.
+func (r *Registry) Encoder(version Version) (Encoder, error) {
+	encoder, ok := r.encoders[version]
+	if !ok {
+		return nil, ErrUnsupportedVersion
+	}
+	return encoder, nil
+}
+
+// Processor returns the processor of the version, or ErrUnsupportedVersion if the version is not registered.
+func (r *Registry) Processor(version Version) (Processor, error) {
+	processor, ok := r.processors[version]
+	if !ok {
+		return nil, ErrUnsupportedVersion
+	}
+	return processor, nil
+}
+
+// ProcessPacket processes the packet using the processor of its version.
+// It returns ErrUnsupportedVersion if the version of the packet is not registered.
+func (r *Registry) ProcessPacket(packetBytes []byte,
+	privKey *PrivateKey,
+	replays ReplayChecker,
+) (Hop, CommandSet, []byte, error) {
+	processor, err := r.packetProcessor(packetBytes)
+	if err != nil {
+		return Hop{}, nil, nil, err
+	}
+	return processor.ProcessPacket(packetBytes, privKey, replays)
+}
+
+// ExtractMessage recovers the message from the fully processed packet using the processor of its version.
+// It returns ErrUnsupportedVersion if the version of the packet is not registered.
+func (r *Registry) ExtractMessage(packetBytes []byte) ([]byte, error) {
+	processor, err := r.packetProcessor(packetBytes)
+	if err != nil {
+		return nil, err
+	}
+	return processor.ExtractMessage(packetBytes)
+}
+
+func (r *Registry) packetProcessor(packetBytes []byte) (Processor, error) {
+	version, err := PacketVersion(packetBytes)
+	if err != nil {
+		return nil, err
+	}
+	return r.Processor(version)
+}
+
+// versionOneFormat implements Version1 of the packet format for the given parameters.
+type versionOneFormat struct {
+	params Params
+}
+
+func (f versionOneFormat) PackForwardMessage(rng io.Reader,
+	path config.E2EPath,
+	delays []float64,
+	message []byte,
+) ([]byte, error) {
+	packet, err := f.params.PackForwardMessage(rng, path, delays, message)
+	if err != nil {
+		return nil, err
+	}
+	return packet.MarshalBinary()
+}
+
+func (f versionOneFormat) ProcessPacket(packetBytes []byte,
+	privKey *PrivateKey,
+	replays ReplayChecker,
+) (Hop, CommandSet, []byte, error) {
+	return f.params.ProcessSphinxPacket(packetBytes, privKey, replays)
+}
+
+func (f versionOneFormat) ExtractMessage(packetBytes []byte) ([]byte, error) {
+	return extractMessage(packetBytes)
+}
+
+// legacyFormat processes the packets in the legacy protobuf encoding.
+type legacyFormat struct{}
+
+func (legacyFormat) ProcessPacket(packetBytes []byte,
+	privKey *PrivateKey,
+	replays ReplayChecker,
+) (Hop, CommandSet, []byte, error) {
+	return processLegacySphinxPacket(packetBytes, privKey, replays)
+}
+
+func (legacyFormat) ExtractMessage(packetBytes []byte) ([]byte, error) {
+	return extractLegacyMessage(packetBytes)
+}
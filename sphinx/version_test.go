@@ -0,0 +1,115 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	for _, v := range []Version{Version1, VersionLegacy, 42} {
+		parsed, err := ParseVersion(v.String())
+		assert.Nil(t, err)
+		assert.Equal(t, v, parsed)
+	}
+
+	versions, err := ParseVersions("1, legacy")
+	assert.Nil(t, err)
+	assert.Equal(t, []Version{Version1, VersionLegacy}, versions)
+	assert.Equal(t, "1,legacy", FormatVersions(versions))
+
+	for _, s := range []string{"", "v1", "256", "10"} {
+		_, err := ParseVersion(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRegistryVersions(t *testing.T) {
+	assert.Equal(t, []Version{Version1, VersionLegacy}, DefaultRegistry.Versions())
+	assert.True(t, DefaultRegistry.Supports(CurrentVersion))
+	assert.False(t, DefaultRegistry.Supports(0x02))
+
+	_, err := DefaultRegistry.Encoder(CurrentVersion)
+	assert.Nil(t, err)
+	// the legacy packets can only be processed
+	_, err = DefaultRegistry.Encoder(VersionLegacy)
+	assert.Equal(t, ErrUnsupportedVersion, err)
+	_, err = DefaultRegistry.Processor(VersionLegacy)
+	assert.Nil(t, err)
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	assert.Nil(t, r.Register(Version1, nil, legacyFormat{}))
+	assert.Equal(t, ErrDuplicateVersion, r.Register(Version1, nil, legacyFormat{}))
+	assert.Error(t, r.Register(0x02, nil, nil))
+}
+
+func TestRegistryRestrict(t *testing.T) {
+	restricted, err := DefaultRegistry.Restrict([]Version{Version1})
+	assert.Nil(t, err)
+	assert.Equal(t, []Version{Version1}, restricted.Versions())
+
+	_, err = DefaultRegistry.Restrict([]Version{Version1, 0x02})
+	assert.Equal(t, ErrUnsupportedVersion, err)
+}
+
+func TestRegistryProcessPacket(t *testing.T) {
+	privs, nodes := createTestNodes(t, 3)
+	path := config.E2EPath{IngressProvider: nodes[0],
+		Mixes:          nodes[1:2],
+		EgressProvider: nodes[2],
+		Recipient:      testDestination(),
+	}
+	message := []byte("Plaintext message")
+
+	encoder, err := DefaultRegistry.Encoder(CurrentVersion)
+	assert.Nil(t, err)
+	packetBytes, err := encoder.PackForwardMessage(rand.Reader, path, []float64{0.1, 0.2, 0.3}, message)
+	assert.Nil(t, err)
+
+	version, err := PacketVersion(packetBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentVersion, version)
+
+	for _, priv := range privs {
+		_, _, packetBytes, err = DefaultRegistry.ProcessPacket(packetBytes, priv, nil)
+		assert.Nil(t, err)
+	}
+	extracted, err := DefaultRegistry.ExtractMessage(packetBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, message, extracted)
+}
+
+func TestRegistryRejectsUnsupportedVersion(t *testing.T) {
+	privs, nodes := createTestNodes(t, 1)
+	packetBytes := packTestPacket(t, DefaultParams, nodes, []byte("Plaintext message"))
+
+	legacyOnly, err := DefaultRegistry.Restrict([]Version{VersionLegacy})
+	assert.Nil(t, err)
+	_, _, _, err = legacyOnly.ProcessPacket(packetBytes, privs[0], nil)
+	assert.Equal(t, ErrUnsupportedVersion, err)
+
+	packetBytes[0] = 0x02
+	_, _, _, err = DefaultRegistry.ProcessPacket(packetBytes, privs[0], nil)
+	assert.Equal(t, ErrUnsupportedVersion, err)
+
+	_, _, _, err = DefaultRegistry.ProcessPacket(nil, privs[0], nil)
+	assert.Equal(t, ErrInvalidPacketLength, err)
+}